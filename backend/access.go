@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ============ ДОСТУП К ЗДАНИЯМ ============
+//
+// Управлять устройствами, сценами, расписаниями и режимами здания может
+// администратор и пользователь, у которого в здании есть устройства — та
+// же область, что у потока событий (newStreamClient) и заявок
+// (ticketActor). Роль берётся из БД, а не из заголовков клиента.
+
+type buildingUser struct {
+	userID int
+	admin  bool
+}
+
+// buildingUserFromRequest проверяет токен и загружает роль. При ошибке
+// ответ уже отправлен.
+func buildingUserFromRequest(w http.ResponseWriter, r *http.Request) (buildingUser, bool) {
+	userID, _, ok := userFromRequest(r)
+	if !ok {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return buildingUser{}, false
+	}
+	var role string
+	if err := psqlConn.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role); err != nil {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return buildingUser{}, false
+	}
+	return buildingUser{userID: userID, admin: role == "admin"}, true
+}
+
+// buildings — здания, где у пользователя есть устройства. Для
+// администратора не вызывается: ему доступны все.
+func (u buildingUser) buildings() (map[int]bool, error) {
+	rows, err := psqlConn.Query(`SELECT DISTINCT r.building_id FROM user_devices ud
+		JOIN device d ON d.id = ud.device_id
+		JOIN room r ON r.id = d.room_id
+		WHERE ud.user_id = $1`, u.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	allowed := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			allowed[id] = true
+		}
+	}
+	return allowed, rows.Err()
+}
+
+func (u buildingUser) canAccess(buildingID int) (bool, error) {
+	if u.admin {
+		return true, nil
+	}
+	allowed, err := u.buildings()
+	return allowed[buildingID], err
+}
+
+// requireBuilding отвечает 403, если здание недоступно пользователю.
+func (u buildingUser) requireBuilding(w http.ResponseWriter, buildingID int) bool {
+	ok, err := u.canAccess(buildingID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireDevice пропускает администратора, владельца устройства и
+// пользователя с доступом к зданию устройства.
+func (u buildingUser) requireDevice(w http.ResponseWriter, deviceID int) bool {
+	if u.admin {
+		return true
+	}
+	var owned bool
+	if err := psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM user_devices WHERE user_id = $1 AND device_id = $2)",
+		u.userID, deviceID).Scan(&owned); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if owned {
+		return true
+	}
+	info, ok := devices.get(deviceID)
+	if !ok || info.BuildingID == 0 {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return false
+	}
+	return u.requireBuilding(w, info.BuildingID)
+}
+
+// requireScene проверяет доступ к зданию сцены; 404, если сцены нет.
+func (u buildingUser) requireScene(w http.ResponseWriter, sceneID int) bool {
+	var buildingID int
+	if err := psqlConn.QueryRow("SELECT building_id FROM scenes WHERE id = $1", sceneID).Scan(&buildingID); err != nil {
+		http.Error(w, "Сцена не найдена", http.StatusNotFound)
+		return false
+	}
+	return u.requireBuilding(w, buildingID)
+}
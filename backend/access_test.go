@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ============ ДОСТУП К ЗДАНИЯМ ============
+
+func TestActuationRequiresAuth(t *testing.T) {
+	for _, c := range []struct {
+		handler      http.HandlerFunc
+		method, path string
+	}{
+		{runSceneHandler, http.MethodPost, "/api/scenes/run?id=1"},
+		{sendDeviceCommand, http.MethodPost, "/api/devices/command"},
+		{getSchedules, http.MethodGet, "/api/schedules"},
+		{createSchedule, http.MethodPost, "/api/schedules"},
+		{updateSchedule, http.MethodPut, "/api/schedules?id=1"},
+		{deleteSchedule, http.MethodDelete, "/api/schedules?id=1"},
+		{getUpcomingRuns, http.MethodGet, "/api/schedules/upcoming"},
+		{getScheduleRuns, http.MethodGet, "/api/schedules/runs?schedule_id=1"},
+		{switchModeHandler, http.MethodPost, "/api/modes/switch"},
+	} {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(`{"device_id": 1, "command": "on"}`))
+		req.Header.Set("X-User-Role", "admin")
+		w := httptest.NewRecorder()
+		c.handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s без токена: %d, ожидалось 401", c.method, c.path, w.Code)
+		}
+	}
+}
+
+func TestScheduleBuildingAccess(t *testing.T) {
+	testDB(t)
+	admin, stranger := createTicketUser(t, "admin", "admin"), createTicketUser(t, "stranger", "user")
+	var buildingID, sceneID int
+	if err := psqlConn.QueryRow("INSERT INTO building (name) VALUES ('Офис') RETURNING id").Scan(&buildingID); err != nil {
+		t.Fatal(err)
+	}
+	if err := psqlConn.QueryRow("INSERT INTO scenes (building_id, name) VALUES ($1, 'Свет') RETURNING id", buildingID).Scan(&sceneID); err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{"building_id": buildingID, "name": "Утро", "kind": scheduleKindCron,
+		"expression": "0 7 * * *", "scene_id": sceneID, "missed_policy": missedSkip}
+	if w := ticketRequest(t, createSchedule, http.MethodPost, "/api/schedules", stranger, body); w.Code != http.StatusForbidden {
+		t.Errorf("расписание в чужом здании: %d, ожидалось 403", w.Code)
+	}
+	if w := ticketRequest(t, runSceneHandler, http.MethodPost, "/api/scenes/run?id="+strconv.Itoa(sceneID), stranger, nil); w.Code != http.StatusForbidden {
+		t.Errorf("запуск чужой сцены: %d", w.Code)
+	}
+	if w := ticketRequest(t, createSchedule, http.MethodPost, "/api/schedules", admin, body); w.Code != http.StatusCreated {
+		t.Fatalf("расписание администратора: %d %s", w.Code, w.Body)
+	}
+	var schedules []Schedule
+	w := ticketRequest(t, getSchedules, http.MethodGet, "/api/schedules", stranger, nil)
+	if err := json.NewDecoder(w.Body).Decode(&schedules); err != nil || len(schedules) != 0 {
+		t.Errorf("посторонний видит расписания: %d %v", len(schedules), err)
+	}
+}
+
+func TestCreateRuleUnknownBuilding(t *testing.T) {
+	testDB(t)
+	admin := createTicketUser(t, "admin", "admin")
+	body := map[string]interface{}{
+		"building_id": 999999, "name": "Свет по движению", "scene_id": 1,
+		"trigger": map[string]interface{}{"type": "sensor", "sensor_id": "motion-1", "operator": ">", "threshold": 0},
+	}
+	if w := ticketRequest(t, createRule, http.MethodPost, "/api/rules", admin, body); w.Code != http.StatusNotFound {
+		t.Errorf("правило для несуществующего здания: %d %s, ожидалось 404", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============ ОПОВЕЩЕНИЯ ============
+//
+// Правило оповещения: датчик, оператор (>, >=, <, <=), порог, гистерезис и
+// длительность. Оповещение срабатывает, когда условие держится не меньше
+// for_seconds, и снимается, только когда значение вернётся за порог с
+// запасом hysteresis (для ">" — ниже threshold - hysteresis). Состояния
+// firing/resolved хранятся в alerts; ожидание длительности — только в памяти.
+
+const (
+	alertStateFiring   = "firing"
+	alertStateResolved = "resolved"
+)
+
+type AlertRule struct {
+	ID            int                   `json:"id"`
+	BuildingID    int                   `json:"building_id"`
+	Name          string                `json:"name"`
+	SensorID      string                `json:"sensor_id"`
+	Operator      string                `json:"operator"`
+	Threshold     float64               `json:"threshold"`
+	Hysteresis    float64               `json:"hysteresis"`
+	ForSeconds    int                   `json:"for_seconds"`
+	Severity      string                `json:"severity"`
+	Channels      []NotificationChannel `json:"channels"`
+	Enabled       bool                  `json:"enabled"`
+	SilencedUntil *time.Time            `json:"silenced_until,omitempty"`
+}
+
+type Alert struct {
+	ID             int        `json:"id"`
+	RuleID         int        `json:"rule_id"`
+	RuleName       string     `json:"rule_name"`
+	BuildingID     int        `json:"building_id"`
+	SensorID       string     `json:"sensor_id"`
+	Severity       string     `json:"severity"`
+	State          string     `json:"state"`
+	Value          float64    `json:"value"`
+	StartedAt      time.Time  `json:"started_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	AcknowledgedBy *int       `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+type alertRuleState struct {
+	pendingSince time.Time
+	firingID     int // alertWriting — запись в alerts ещё идёт
+	firingSince  time.Time
+	firingValue  float64
+}
+
+// alertWriting — firingID оповещения, INSERT которого ещё не завершён.
+const alertWriting = -1
+
+type alertEvaluator struct {
+	mu       sync.Mutex
+	rules    []AlertRule
+	loadedAt time.Time
+	version  int // растёт при каждом invalidate/forgetRule
+	states   map[int]*alertRuleState
+}
+
+// alertTransition — переход, вычисленный под e.mu. Запись в БД и
+// уведомления выполняются уже без блокировки, чтобы медленная БД не
+// задерживала обработку остальных показаний.
+type alertTransition struct {
+	rule  AlertRule
+	st    *alertRuleState
+	prev  alertRuleState // состояние до перехода — на случай ошибки записи
+	state string
+	value float64
+}
+
+var alerting = &alertEvaluator{states: map[int]*alertRuleState{}}
+
+func (r *AlertRule) validate() error {
+	if r.Name == "" || r.BuildingID == 0 || r.SensorID == "" {
+		return fmt.Errorf("name, building_id и sensor_id обязательны")
+	}
+	switch r.Operator {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("operator должен быть >, >=, < или <=")
+	}
+	if r.Hysteresis < 0 || r.ForSeconds < 0 {
+		return fmt.Errorf("hysteresis и for_seconds не могут быть отрицательными")
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	for _, ch := range r.Channels {
+		if _, err := notifierFor(ch); err != nil {
+			return err
+		}
+	}
+	if r.Channels == nil {
+		r.Channels = []NotificationChannel{}
+	}
+	return nil
+}
+
+// cleared — значение вернулось в норму с учётом гистерезиса.
+func (r *AlertRule) cleared(value float64) bool {
+	switch r.Operator {
+	case ">", ">=":
+		return value < r.Threshold-r.Hysteresis
+	default:
+		return value > r.Threshold+r.Hysteresis
+	}
+}
+
+func (r *AlertRule) silenced(now time.Time) bool {
+	return r.SilencedUntil != nil && now.Before(*r.SilencedUntil)
+}
+
+// initAlerts восстанавливает незакрытые оповещения, чтобы после
+// перезапуска не открыть дубликаты. Правила загружаются первыми: без них
+// метрика не знает важности восстановленных оповещений.
+func initAlerts() {
+	loaded, rulesErr := loadAlertRules("WHERE enabled")
+	if rulesErr != nil {
+		logger("alerts").Error("Ошибка загрузки правил", "error", rulesErr)
+	}
+
+	rows, err := psqlConn.Query("SELECT id, rule_id, value, started_at FROM alerts WHERE state = $1", alertStateFiring)
+	if err != nil {
+		logger("alerts").Error("Ошибка загрузки активных оповещений", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	alerting.mu.Lock()
+	defer alerting.mu.Unlock()
+	if rulesErr == nil {
+		alerting.rules, alerting.loadedAt = loaded, time.Now()
+	}
+	for rows.Next() {
+		var st alertRuleState
+		var ruleID int
+		if err := rows.Scan(&st.firingID, &ruleID, &st.firingValue, &st.firingSince); err != nil {
+			continue
+		}
+		alerting.states[ruleID] = &st
+	}
+	alerting.updateGaugeLocked()
+	logger("alerts").Info("Оповещения инициализированы", "firing", len(alerting.states))
+}
+
+func (e *alertEvaluator) invalidate() {
+	e.mu.Lock()
+	e.loadedAt = time.Time{}
+	e.version++
+	e.mu.Unlock()
+}
+
+// reloadIfStale перечитывает правила без удержания e.mu. Если за время
+// запроса правила изменились (invalidate), следующее показание прочитает
+// их снова.
+func (e *alertEvaluator) reloadIfStale() {
+	e.mu.Lock()
+	stale, version := time.Since(e.loadedAt) > rulesReloadInterval, e.version
+	e.mu.Unlock()
+	if !stale {
+		return
+	}
+
+	loaded, err := loadAlertRules("WHERE enabled")
+	if err != nil {
+		logger("alerts").Error("Ошибка загрузки правил", "error", err)
+		return
+	}
+	e.mu.Lock()
+	e.rules = loaded
+	if e.version == version {
+		e.loadedAt = time.Now()
+	}
+	e.mu.Unlock()
+}
+
+// evaluate — обработчик показаний датчиков (см. readingHandlers).
+func (e *alertEvaluator) evaluate(reading SensorReading) {
+	if !reading.HasValue {
+		return
+	}
+	e.reloadIfStale()
+
+	now := reading.Time
+	var transitions []alertTransition
+
+	e.mu.Lock()
+	for i := range e.rules {
+		rule := e.rules[i]
+		if rule.SensorID != reading.SensorID {
+			continue
+		}
+
+		st, ok := e.states[rule.ID]
+		if !ok {
+			st = &alertRuleState{}
+			e.states[rule.ID] = st
+		}
+
+		if st.firingID != 0 {
+			// Пока оповещение записывается, снимать его нечего.
+			if st.firingID != alertWriting && rule.cleared(reading.Value) {
+				transitions = append(transitions, alertTransition{rule: rule, st: st, prev: *st, state: alertStateResolved, value: reading.Value})
+				*st = alertRuleState{}
+			}
+			continue
+		}
+
+		if !compare(reading.Value, rule.Operator, rule.Threshold) {
+			st.pendingSince = time.Time{}
+			continue
+		}
+		if st.pendingSince.IsZero() {
+			st.pendingSince = now
+		}
+		if now.Sub(st.pendingSince) >= time.Duration(rule.ForSeconds)*time.Second {
+			transitions = append(transitions, alertTransition{rule: rule, st: st, prev: *st, state: alertStateFiring, value: reading.Value})
+			*st = alertRuleState{firingID: alertWriting, firingSince: st.pendingSince, firingValue: reading.Value}
+		}
+	}
+	if len(transitions) > 0 {
+		e.updateGaugeLocked()
+	}
+	e.mu.Unlock()
+
+	for _, tr := range transitions {
+		if tr.state == alertStateFiring {
+			e.fire(tr, now)
+		} else {
+			e.resolve(tr, now)
+		}
+	}
+}
+
+// restore возвращает состояние правила после неудачной записи, если его
+// никто не успел изменить: переход повторится на следующем показании.
+func (e *alertEvaluator) restore(tr alertTransition, current alertRuleState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.states[tr.rule.ID] == tr.st && *tr.st == current {
+		*tr.st = tr.prev
+		e.updateGaugeLocked()
+	}
+}
+
+func (e *alertEvaluator) fire(tr alertTransition, now time.Time) {
+	since := tr.prev.pendingSince
+	var alertID int
+	err := psqlConn.QueryRow(
+		"INSERT INTO alerts (rule_id, state, value, started_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		tr.rule.ID, alertStateFiring, tr.value, since,
+	).Scan(&alertID)
+	if err != nil {
+		logger("alerts").Error("Ошибка записи оповещения", "rule_id", tr.rule.ID, "error", err)
+		e.restore(tr, alertRuleState{firingID: alertWriting, firingSince: since, firingValue: tr.value})
+		return
+	}
+
+	e.mu.Lock()
+	current := e.states[tr.rule.ID] == tr.st && tr.st.firingID == alertWriting
+	if current {
+		tr.st.firingID = alertID
+	}
+	e.mu.Unlock()
+	if !current {
+		// Правило выключили, пока шла запись: оповещение сразу снимается.
+		if _, err := psqlConn.Exec("UPDATE alerts SET state = $1, resolved_at = NOW() WHERE id = $2",
+			alertStateResolved, alertID); err != nil {
+			logger("alerts").Error("Ошибка снятия оповещения", "alert_id", alertID, "error", err)
+		}
+		return
+	}
+
+	logger("alerts").Warn("Оповещение FIRING", "rule", tr.rule.Name, "topic", "sensors/"+tr.rule.SensorID, "sensor_id", tr.rule.SensorID, "value", tr.value)
+	e.notify(&tr.rule, alertID, alertStateFiring, tr.value, since, nil, now)
+}
+
+func (e *alertEvaluator) resolve(tr alertTransition, now time.Time) {
+	alertID, since := tr.prev.firingID, tr.prev.firingSince
+	if _, err := psqlConn.Exec(
+		"UPDATE alerts SET state = $1, resolved_at = $2, value = $3 WHERE id = $4",
+		alertStateResolved, now, tr.value, alertID,
+	); err != nil {
+		logger("alerts").Error("Ошибка снятия оповещения", "alert_id", alertID, "error", err)
+		e.restore(tr, alertRuleState{})
+		return
+	}
+
+	logger("alerts").Info("Оповещение RESOLVED", "rule", tr.rule.Name, "topic", "sensors/"+tr.rule.SensorID, "sensor_id", tr.rule.SensorID, "value", tr.value)
+	e.notify(&tr.rule, alertID, alertStateResolved, tr.value, since, &now, now)
+}
+
+func (e *alertEvaluator) notify(rule *AlertRule, alertID int, state string, value float64, since time.Time, resolvedAt *time.Time, now time.Time) {
+	n := AlertNotification{
+		AlertID:    alertID,
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		BuildingID: rule.BuildingID,
+		SensorID:   rule.SensorID,
+		State:      state,
+		Severity:   rule.Severity,
+		Value:      value,
+		Operator:   rule.Operator,
+		Threshold:  rule.Threshold,
+		StartedAt:  since,
+		ResolvedAt: resolvedAt,
+	}
+	hub.publish(StreamEvent{Type: "alert", BuildingID: rule.BuildingID, SensorID: rule.SensorID, Data: n, Time: now})
+
+	if rule.silenced(now) {
+		return
+	}
+	go dispatchNotification(rule.Channels, n)
+}
+
+func (e *alertEvaluator) updateGaugeLocked() {
+	severities := map[string]float64{}
+	for ruleID, st := range e.states {
+		if st.firingID == 0 {
+			continue
+		}
+		severity := "warning"
+		for _, r := range e.rules {
+			if r.ID == ruleID {
+				severity = r.Severity
+			}
+		}
+		severities[severity]++
+	}
+	alertsActive.Reset()
+	for severity, n := range severities {
+		alertsActive.WithLabelValues(severity).Set(n)
+	}
+}
+
+// forgetRule сбрасывает состояние удалённого или выключенного правила.
+func (e *alertEvaluator) forgetRule(ruleID int) {
+	e.mu.Lock()
+	delete(e.states, ruleID)
+	e.loadedAt = time.Time{}
+	e.version++
+	e.updateGaugeLocked()
+	e.mu.Unlock()
+}
+
+// ============ ХРАНЕНИЕ ============
+
+const alertRuleColumns = `id, building_id, name, sensor_id, operator, threshold, hysteresis,
+	for_seconds, severity, channels, enabled, silenced_until`
+
+func scanAlertRule(row interface{ Scan(...interface{}) error }) (AlertRule, error) {
+	var r AlertRule
+	var channels []byte
+	var silenced sql.NullTime
+	err := row.Scan(&r.ID, &r.BuildingID, &r.Name, &r.SensorID, &r.Operator, &r.Threshold, &r.Hysteresis,
+		&r.ForSeconds, &r.Severity, &channels, &r.Enabled, &silenced)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(channels, &r.Channels); err != nil {
+		return r, err
+	}
+	if silenced.Valid {
+		r.SilencedUntil = &silenced.Time
+	}
+	return r, nil
+}
+
+func loadAlertRules(where string, args ...interface{}) ([]AlertRule, error) {
+	rows, err := psqlConn.Query("SELECT "+alertRuleColumns+" FROM alert_rules "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			logger("alerts").Warn("Пропущено правило", "error", err)
+			continue
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ============ REST API HANDLERS - ALERTS ============
+
+func getAlertRules(w http.ResponseWriter, r *http.Request) {
+	var list []AlertRule
+	var err error
+	if buildingID := r.URL.Query().Get("building_id"); buildingID != "" {
+		list, err = loadAlertRules("WHERE building_id = $1", buildingID)
+	} else {
+		list, err = loadAlertRules("")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func createAlertRule(w http.ResponseWriter, r *http.Request) {
+	rule := AlertRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, release, ok := checkPlanLimit(w, r, "alert_rules")
+	if !ok {
+		return
+	}
+	defer release()
+
+	channels, _ := json.Marshal(rule.Channels)
+	err := psqlConn.QueryRow(`INSERT INTO alert_rules
+		(building_id, name, sensor_id, operator, threshold, hysteresis, for_seconds, severity, channels, enabled, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		rule.BuildingID, rule.Name, rule.SensorID, rule.Operator, rule.Threshold, rule.Hysteresis,
+		rule.ForSeconds, rule.Severity, channels, rule.Enabled, userID,
+	).Scan(&rule.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	alerting.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func updateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var id int
+	if _, err := fmt.Sscan(r.URL.Query().Get("id"), &id); err != nil {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	res, err := psqlConn.Exec("UPDATE alert_rules SET enabled = $1 WHERE id = $2", req.Enabled, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Правило не найдено", http.StatusNotFound)
+		return
+	}
+	if !req.Enabled {
+		if _, err := psqlConn.Exec("UPDATE alerts SET state = $1, resolved_at = NOW() WHERE rule_id = $2 AND state = $3",
+			alertStateResolved, id, alertStateFiring); err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		alerting.forgetRule(id)
+	} else {
+		alerting.invalidate()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	var id int
+	if _, err := fmt.Sscan(r.URL.Query().Get("id"), &id); err != nil {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := psqlConn.Exec("DELETE FROM alert_rules WHERE id = $1", id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	alerting.forgetRule(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getAlerts — список оповещений (?state=firing|resolved, ?building_id=).
+func getAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := `SELECT a.id, a.rule_id, ar.name, ar.building_id, ar.sensor_id, ar.severity, a.state, a.value,
+		a.started_at, a.resolved_at, a.acknowledged_by, a.acknowledged_at
+		FROM alerts a JOIN alert_rules ar ON ar.id = a.rule_id
+		WHERE ($1 = '' OR a.state = $1) AND ($2 = 0 OR ar.building_id = $2)
+		ORDER BY a.started_at DESC LIMIT 200`
+
+	var buildingID int
+	fmt.Sscan(r.URL.Query().Get("building_id"), &buildingID)
+
+	rows, err := psqlConn.Query(query, r.URL.Query().Get("state"), buildingID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var resolved, ackAt sql.NullTime
+		var ackBy sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.BuildingID, &a.SensorID, &a.Severity, &a.State, &a.Value,
+			&a.StartedAt, &resolved, &ackBy, &ackAt); err != nil {
+			continue
+		}
+		if resolved.Valid {
+			a.ResolvedAt = &resolved.Time
+		}
+		if ackBy.Valid {
+			id := int(ackBy.Int64)
+			a.AcknowledgedBy = &id
+		}
+		if ackAt.Valid {
+			a.AcknowledgedAt = &ackAt.Time
+		}
+		list = append(list, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func acknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		AlertID int `json:"alert_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AlertID == 0 {
+		http.Error(w, "alert_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	var userID interface{}
+	if id, _, ok := userFromRequest(r); ok {
+		userID = id
+	}
+
+	res, err := psqlConn.Exec(
+		"UPDATE alerts SET acknowledged_by = $1, acknowledged_at = NOW() WHERE id = $2 AND acknowledged_at IS NULL",
+		userID, req.AlertID,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Оповещение не найдено или уже подтверждено", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "acknowledged"})
+}
+
+// silenceAlertRule заглушает уведомления правила на minutes минут
+// (0 — снять заглушку). Срабатывания при этом продолжают записываться.
+func silenceAlertRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RuleID  int `json:"rule_id"`
+		Minutes int `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RuleID == 0 || req.Minutes < 0 {
+		http.Error(w, "rule_id и minutes обязательны", http.StatusBadRequest)
+		return
+	}
+
+	var until interface{}
+	if req.Minutes > 0 {
+		until = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+	}
+
+	res, err := psqlConn.Exec("UPDATE alert_rules SET silenced_until = $1 WHERE id = $2", until, req.RuleID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Правило не найдено", http.StatusNotFound)
+		return
+	}
+	alerting.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "silenced", "silenced_until": until})
+}
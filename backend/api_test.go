@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// ============ REST API НА ХРАНИЛИЩАХ ============
+
+func TestRegisterLoginAndAdmin(t *testing.T) {
+	h := newHarness(t)
+
+	creds := map[string]string{"username": "anna", "email": "anna@example.com", "password": "secret1"}
+	var reg AuthResponse
+	if code := h.call(http.MethodPost, "/api/auth/register", nil, creds, &reg); code != http.StatusCreated {
+		t.Fatalf("регистрация: %d", code)
+	}
+	if reg.Token == "" || reg.ID == 0 {
+		t.Fatalf("регистрация вернула %+v", reg)
+	}
+	if code := h.call(http.MethodPost, "/api/auth/register", nil, creds, nil); code != http.StatusBadRequest {
+		t.Errorf("повторная регистрация: %d, ожидалось 400", code)
+	}
+
+	var login AuthResponse
+	if code := h.call(http.MethodPost, "/api/auth/login", nil, creds, &login); code != http.StatusOK {
+		t.Fatalf("вход: %d", code)
+	}
+	if login.ID != reg.ID || login.Role != "user" {
+		t.Errorf("вход вернул %+v", login)
+	}
+	wrong := map[string]string{"username": "anna", "password": "wrong-password"}
+	if code := h.call(http.MethodPost, "/api/auth/login", nil, wrong, nil); code != http.StatusUnauthorized {
+		t.Errorf("вход с неверным паролем: %d, ожидалось 401", code)
+	}
+
+	if code := h.call(http.MethodGet, "/api/admin/users", nil, nil, nil); code != http.StatusForbidden {
+		t.Errorf("список пользователей без роли admin: %d, ожидалось 403", code)
+	}
+	role := map[string]interface{}{"user_id": reg.ID, "new_role": "worker"}
+	if code := h.call(http.MethodPost, "/api/admin/users/role", asRole("admin"), role, nil); code != http.StatusOK {
+		t.Fatalf("смена роли: %d", code)
+	}
+	var users []User
+	h.call(http.MethodGet, "/api/admin/users", asRole("admin"), nil, &users)
+	if len(users) != 1 || users[0].Role != "worker" {
+		t.Errorf("пользователи после смены роли: %+v", users)
+	}
+
+	path := fmt.Sprintf("/api/admin/users/%d", reg.ID)
+	if code := h.call(http.MethodDelete, path, asRole("admin"), nil, nil); code != http.StatusOK {
+		t.Fatalf("удаление: %d", code)
+	}
+	if code := h.call(http.MethodDelete, path, asRole("admin"), nil, nil); code != http.StatusNotFound {
+		t.Errorf("повторное удаление: %d, ожидалось 404", code)
+	}
+
+	want := []string{"user.role", "user.delete"}
+	if got := h.auditActions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("аудит = %v, ожидалось %v", got, want)
+	}
+}
+
+func TestBuildingsRoomsAndDevices(t *testing.T) {
+	h := newHarness(t)
+
+	var reg AuthResponse
+	h.call(http.MethodPost, "/api/auth/register", nil,
+		map[string]string{"username": "oleg", "email": "oleg@example.com", "password": "secret1"}, &reg)
+
+	lat, lon := 56.3269, 44.0059
+	var building Building
+	if code := h.call(http.MethodPost, "/api/buildings", nil, Building{Name: "Дача", Latitude: &lat, Longitude: &lon}, &building); code != http.StatusCreated {
+		t.Fatalf("создание здания: %d", code)
+	}
+	if building.ID == 0 || building.Timezone != defaultTimezone {
+		t.Errorf("здание создано как %+v", building)
+	}
+	if code := h.call(http.MethodPost, "/api/buildings", nil, Building{Name: "X", Timezone: "Mars/Olympus"}, nil); code != http.StatusBadRequest {
+		t.Errorf("неизвестный часовой пояс: %d, ожидалось 400", code)
+	}
+
+	var room Room
+	if code := h.call(http.MethodPost, "/api/rooms", nil, Room{Name: "Веранда", BuildingID: building.ID}, &room); code != http.StatusCreated {
+		t.Fatalf("создание комнаты: %d", code)
+	}
+	var rooms []Room
+	h.call(http.MethodGet, fmt.Sprintf("/api/rooms?building_id=%d", building.ID), nil, nil, &rooms)
+	if len(rooms) != 1 || rooms[0].Name != "Веранда" {
+		t.Errorf("комнаты здания: %+v", rooms)
+	}
+
+	device := Device{Name: "Обогреватель", RoomID: room.ID, RatedPowerW: 1500}
+	if code := h.call(http.MethodPost, "/api/devices", nil, device, nil); code != http.StatusUnauthorized {
+		t.Errorf("устройство без авторизации: %d, ожидалось 401", code)
+	}
+	if code := h.call(http.MethodPost, "/api/devices", bearer(reg.Token), device, &device); code != http.StatusCreated {
+		t.Fatalf("создание устройства: %d", code)
+	}
+	var devices []Device
+	h.call(http.MethodGet, "/api/devices", nil, nil, &devices)
+	if len(devices) != 1 || devices[0].ID != device.ID || devices[0].RatedPowerW != 1500 {
+		t.Errorf("устройства: %+v", devices)
+	}
+
+	update := Building{Name: "Дача у озера"}
+	path := fmt.Sprintf("/api/buildings?id=%d", building.ID)
+	if code := h.call(http.MethodPut, path, nil, update, nil); code != http.StatusOK {
+		t.Fatalf("обновление здания: %d", code)
+	}
+	var buildings []Building
+	h.call(http.MethodGet, "/api/buildings", nil, nil, &buildings)
+	if len(buildings) != 1 || buildings[0].Name != "Дача у озера" || buildings[0].Timezone != defaultTimezone {
+		t.Errorf("здания после обновления: %+v", buildings)
+	}
+	// PUT без координат не стирает их: от них зависят расписания по солнцу.
+	if b := buildings[0]; b.Latitude == nil || *b.Latitude != lat || b.Longitude == nil || *b.Longitude != lon {
+		t.Errorf("координаты после обновления: %v, %v", b.Latitude, b.Longitude)
+	}
+
+	if code := h.call(http.MethodDelete, path, nil, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("удаление здания: %d", code)
+	}
+	if code := h.call(http.MethodDelete, path, nil, nil, nil); code != http.StatusNotFound {
+		t.Errorf("повторное удаление здания: %d, ожидалось 404", code)
+	}
+	rooms = nil
+	h.call(http.MethodGet, fmt.Sprintf("/api/rooms?building_id=%d", building.ID), nil, nil, &rooms)
+	if len(rooms) != 0 {
+		t.Errorf("комнаты удалённого здания: %+v", rooms)
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	origins := cfg.HTTP.CORSOrigins
+	t.Cleanup(func() { cfg.HTTP.CORSOrigins = origins })
+
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	allow := func(origin string) (string, string) {
+		req := httptest.NewRequest(http.MethodOptions, "/api/buildings", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get("Access-Control-Allow-Origin"), rec.Header().Get("Vary")
+	}
+
+	cfg.HTTP.CORSOrigins = []string{"*"}
+	if got, _ := allow("https://any.example"); got != "*" {
+		t.Errorf("CORS_ORIGINS=*: %q", got)
+	}
+
+	cfg.HTTP.CORSOrigins = []string{"https://app.example", "http://localhost:3000"}
+	if got, vary := allow("http://localhost:3000"); got != "http://localhost:3000" || vary != "Origin" {
+		t.Errorf("разрешённый Origin: %q, Vary %q", got, vary)
+	}
+	if got, _ := allow("https://evil.example"); got != "" {
+		t.Errorf("чужой Origin получил %q", got)
+	}
+}
+
+func TestStreamCheckOrigin(t *testing.T) {
+	origins := cfg.HTTP.CORSOrigins
+	t.Cleanup(func() { cfg.HTTP.CORSOrigins = origins })
+	cfg.HTTP.CORSOrigins = []string{"https://app.example"}
+
+	for origin, want := range map[string]bool{
+		"https://app.example":  true,
+		"https://evil.example": false,
+		"":                     true, // клиент вне браузера
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/stream", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if got := wsUpgrader.CheckOrigin(req); got != want {
+			t.Errorf("Origin %q: %v, ожидалось %v", origin, got, want)
+		}
+	}
+}
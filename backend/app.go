@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"smart-home/internal/store"
+	"smart-home/internal/store/influx"
+	"smart-home/internal/store/postgres"
+)
+
+// ============ ПРИЛОЖЕНИЕ ============
+//
+// App — обработчики пользователей, зданий, устройств и телеметрии,
+// собранные из хранилищ store. В тестах хранилища заменяются на
+// store/memory, а обработчики вызываются через httptest.
+// Остальные подсистемы (правила, биллинг, заявки...) пока работают с
+// глобальными psqlConn / influxClient / mqttClient; то, чем они влияют на
+// обработчики App, вынесено в поля-функции ниже.
+
+type App struct {
+	Users     store.UserStore
+	Buildings store.BuildingStore
+	Devices   store.DeviceStore
+	Telemetry store.TelemetryStore
+	Publisher store.Publisher
+
+	// Readings — обработчики каждого принятого показания.
+	Readings []func(SensorReading)
+	// Audit записывает действие в журнал аудита.
+	Audit func(r *http.Request, action, targetType string, targetID, before, after interface{})
+	// DeviceQuota проверяет лимит устройств тарифа и возвращает владельца
+	// и название тарифа; release вызывается после вставки устройства. При
+	// отказе ответ уже отправлен клиенту.
+	DeviceQuota func(w http.ResponseWriter, r *http.Request) (userID int, plan string, release func(), ok bool)
+	// DeviceCreated сообщает подсистемам о новом устройстве.
+	DeviceCreated func(d Device)
+	// SensorStatus — доступность источника; known == false, если о нём
+	// ещё ничего не известно.
+	SensorStatus func(sensorID string) (online, known bool)
+	// HistoryWindow ограничивает период запроса окном хранения тарифа.
+	HistoryWindow func(w http.ResponseWriter, r *http.Request, from, to time.Time) (time.Time, bool)
+
+	// WriteBuffers — глубина очередей записи подсистем (для отчёта о
+	// здоровье).
+	WriteBuffers func() map[string]int
+
+	// ingest — сообщения MQTT, обработка которых ещё не закончилась
+	// (ждём их при остановке).
+	ingest ingestGate
+
+	// Подписки MQTT: тема → оформлена ли.
+	subMu      sync.Mutex
+	subscribed map[string]bool
+
+	healthOnce sync.Once
+	healthMon  *healthMonitor
+}
+
+// newApp собирает App на глобальных подключениях. Publisher задаёт
+// initMQTT: клиенту MQTT нужен обработчик сообщений App.
+func newApp() *App {
+	return &App{
+		Users:     &postgres.UserStore{DB: psqlConn, OnRoleChange: syncWorkerRecord},
+		Buildings: &postgres.BuildingStore{DB: psqlConn},
+		Devices:   &postgres.DeviceStore{DB: psqlConn},
+		Telemetry: &influx.TelemetryStore{Client: influxClient, Org: cfg.Influx.Org, Bucket: cfg.Influx.Bucket},
+
+		Readings:      readingHandlers,
+		Audit:         auditRequest,
+		DeviceQuota:   deviceQuota,
+		DeviceCreated: deviceCreated,
+		SensorStatus: func(sensorID string) (bool, bool) {
+			availability, known := liveness.status(sensorID)
+			return availability.Online, known
+		},
+		HistoryWindow: historyWindow,
+		WriteBuffers: func() map[string]int {
+			return map[string]int{"energy_pending_devices": energy.pending()}
+		},
+	}
+}
+
+// routes регистрирует обработчики App.
+func (a *App) routes(mux *http.ServeMux) {
+	// Аутентификация
+	mux.HandleFunc("/api/auth/register", a.registerUser)
+	mux.HandleFunc("/api/auth/login", a.loginUser)
+
+	// Админ-панель
+	mux.HandleFunc("/api/admin/users", a.getAllUsers)
+	mux.HandleFunc("/api/admin/users/", a.deleteUser)
+	mux.HandleFunc("/api/admin/users/role", a.changeUserRole)
+	mux.HandleFunc("/api/admin/sensors", a.getAdminSensors)
+	mux.HandleFunc("/api/sensors/data", a.getSensorData)
+	mux.HandleFunc("/api/sensors/history", a.getSensorHistory)
+
+	// Здания
+	mux.HandleFunc("/api/buildings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			a.getBuildings(w, r)
+		case http.MethodPost:
+			a.createBuilding(w, r)
+		case http.MethodPut:
+			a.updateBuilding(w, r)
+		case http.MethodDelete:
+			a.deleteBuilding(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Комнаты
+	mux.HandleFunc("/api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			a.getRooms(w, r)
+		case http.MethodPost:
+			a.createRoom(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Устройства
+	mux.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			a.getDevices(w, r)
+		case http.MethodPost:
+			a.createDevice(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Здоровье сервиса
+	mux.HandleFunc("/livez", getLivez)
+	mux.HandleFunc("/readyz", a.getReadyz)
+	mux.HandleFunc("/api/health", a.getHealth)
+}
+
+// deviceQuota — лимит устройств по тарифу пользователя (checkPlanLimit).
+func deviceQuota(w http.ResponseWriter, r *http.Request) (int, string, func(), bool) {
+	userID, release, ok := checkPlanLimit(w, r, "devices")
+	if !ok {
+		return 0, "", nil, false
+	}
+	plan, _, _ := planOf(userID)
+	return userID, plan.Name, release, true
+}
+
+func deviceCreated(d Device) {
+	devices.invalidate()
+	energy.setRated(d.ID, d.RatedPowerW)
+	logDeviceEvent(d.ID, deviceEventConfig, map[string]interface{}{"change": "created", "device": d})
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============ ЖУРНАЛ АУДИТА ============
+//
+// Каждое административное и управляющее действие пишется в audit_log:
+// кто (actor_id/actor), что (action), над чем (target_type/target_id),
+// значения до и после, IP и идентификатор запроса. Таблица только для
+// добавления: UPDATE, DELETE и TRUNCATE запрещены триггером. Записи
+// сцеплены хэшами: hash = sha256(prev_hash + каноническое представление
+// записи), поэтому изменение или удаление любой строки в обход триггера
+// обнаруживается проверкой цепочки (GET /api/admin/audit/verify).
+// Вставки сериализуются advisory-локом, чтобы цепочка не ветвилась.
+
+// auditLockKey — ключ pg_advisory_xact_lock для вставок в audit_log.
+const auditLockKey = 0x617564697400
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	At         time.Time       `json:"at"`
+	ActorID    *int            `json:"actor_id,omitempty"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// canonicalJSON приводит значение к виду, который не меняется при
+// хранении в JSONB: ключи отсортированы, числа и строки в форме
+// encoding/json.
+func canonicalJSON(raw []byte) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil || v == nil {
+		return nil
+	}
+	out, _ := json.Marshal(v)
+	return out
+}
+
+func (e *AuditEntry) computeHash() string {
+	actorID := ""
+	if e.ActorID != nil {
+		actorID = strconv.Itoa(*e.ActorID)
+	}
+	h := sha256.New()
+	for _, part := range []string{
+		e.PrevHash,
+		e.At.UTC().Format(time.RFC3339Nano),
+		actorID, e.Actor, e.Action, e.TargetType, e.TargetID,
+		string(e.Before), string(e.After), e.IP, e.RequestID,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func marshalAuditValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return canonicalJSON(raw)
+}
+
+// recordAudit добавляет запись в цепочку. Ошибка записи не отменяет уже
+// выполненное действие, но попадает в лог и метрику.
+func recordAudit(e AuditEntry) {
+	// Postgres хранит микросекунды — округляем заранее, иначе хэш не сойдётся.
+	e.At = time.Now().UTC().Truncate(time.Microsecond)
+
+	err := func() error {
+		tx, err := psqlConn.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+			return err
+		}
+		err = tx.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&e.PrevHash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		e.Hash = e.computeHash()
+
+		if _, err := tx.Exec(`INSERT INTO audit_log
+			(at, actor_id, actor, action, target_type, target_id, before, after, ip, request_id, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12)`,
+			e.At, e.ActorID, e.Actor, e.Action, e.TargetType, e.TargetID,
+			nullJSON(e.Before), nullJSON(e.After), e.IP, e.RequestID, e.PrevHash, e.Hash,
+		); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		logger("audit").Error("Ошибка записи", "action", e.Action, "target_type", e.TargetType, "target_id", e.TargetID, "error", err)
+		auditWriteErrors.Inc()
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// auditRequest записывает действие, выполненное через API. Исполнитель
+// берётся из JWT; без токена — anonymous: заголовкам вроде X-User-Role
+// клиент может написать что угодно, в журнал они не попадают.
+func auditRequest(r *http.Request, action, targetType string, targetID interface{}, before, after interface{}) {
+	e := AuditEntry{
+		Actor:      "anonymous",
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		Before:     marshalAuditValue(before),
+		After:      marshalAuditValue(after),
+		IP:         clientIP(r),
+		RequestID:  requestID(r),
+	}
+	if userID, username, ok := userFromRequest(r); ok {
+		e.ActorID, e.Actor = &userID, username
+	}
+	recordAudit(e)
+}
+
+// auditSystem записывает действие, выполненное без запроса: правилом,
+// расписанием, термостатом и т.п. (actor — источник, например "rule:5").
+func auditSystem(actor, action, targetType string, targetID interface{}, before, after interface{}) {
+	recordAudit(AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		Before:     marshalAuditValue(before),
+		After:      marshalAuditValue(after),
+	})
+}
+
+// ============ ИДЕНТИФИКАТОР ЗАПРОСА ============
+
+type requestIDKey struct{}
+
+// requestIDMiddleware принимает X-Request-ID клиента (если он разумной
+// длины) или выдаёт новый, кладёт его в контекст и в ответ.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 || strings.ContainsAny(id, " \t\r\n") {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// clientIP — адрес клиента. X-Forwarded-For учитывается, только если
+// соединение пришло от доверенного прокси (http.trusted_proxies); цепочка
+// читается справа налево до первого адреса, который сам не прокси, —
+// левые элементы клиент мог дописать сам.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range cfg.HTTP.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ============ REST API HANDLERS - AUDIT ============
+
+const auditColumns = `id, at, actor_id, actor, action, target_type, COALESCE(target_id, ''),
+	before, after, COALESCE(ip, ''), COALESCE(request_id, ''), prev_hash, hash`
+
+func scanAuditEntry(row interface{ Scan(...interface{}) error }) (AuditEntry, error) {
+	var e AuditEntry
+	var actorID sql.NullInt64
+	var before, after []byte
+	err := row.Scan(&e.ID, &e.At, &actorID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID,
+		&before, &after, &e.IP, &e.RequestID, &e.PrevHash, &e.Hash)
+	e.ActorID = nullIntPtr(actorID)
+	e.Before, e.After = canonicalJSON(before), canonicalJSON(after)
+	return e, err
+}
+
+// getAuditLog — журнал с фильтрами ?actor=&actor_id=&action=&target_type=
+// &target_id=&from=&to=&limit=; ?format=csv отдаёт CSV.
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	where, args := []string{"TRUE"}, []interface{}{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	for _, f := range []string{"actor", "action", "target_type", "target_id", "request_id"} {
+		if v := q.Get(f); v != "" {
+			add(f+" = $%d", v)
+		}
+	}
+	if v := q.Get("actor_id"); v != "" {
+		add("actor_id = $%d", v)
+	}
+	for _, p := range []struct{ param, cond string }{{"from", "at >= $%d"}, {"to", "at < $%d"}} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.param+": ожидается RFC3339", http.StatusBadRequest)
+				return
+			}
+			add(p.cond, t)
+		}
+	}
+	limit := 500
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 10000 {
+			http.Error(w, "limit: от 1 до 10000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := psqlConn.Query("SELECT "+auditColumns+" FROM audit_log WHERE "+strings.Join(where, " AND ")+
+		fmt.Sprintf(" ORDER BY id DESC LIMIT %d", limit), args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, e)
+	}
+
+	if q.Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "at", "actor_id", "actor", "action", "target_type", "target_id",
+		"before", "after", "ip", "request_id", "prev_hash", "hash"})
+	for _, e := range list {
+		actorID := ""
+		if e.ActorID != nil {
+			actorID = strconv.Itoa(*e.ActorID)
+		}
+		cw.Write([]string{strconv.FormatInt(e.ID, 10), e.At.UTC().Format(time.RFC3339Nano), actorID, e.Actor,
+			e.Action, e.TargetType, e.TargetID, string(e.Before), string(e.After), e.IP, e.RequestID,
+			e.PrevHash, e.Hash})
+	}
+	cw.Flush()
+}
+
+// verifyAuditLog проходит цепочку от начала и сообщает первую запись,
+// на которой она нарушена.
+func verifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := psqlConn.Query("SELECT " + auditColumns + " FROM audit_log ORDER BY id")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	result := map[string]interface{}{"ok": true}
+	checked, prev := 0, ""
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		reason := ""
+		switch {
+		case e.PrevHash != prev:
+			reason = "prev_hash не совпадает с хэшем предыдущей записи"
+		case e.computeHash() != e.Hash:
+			reason = "hash не совпадает с содержимым записи"
+		}
+		if reason != "" {
+			result["ok"], result["broken_at"], result["reason"] = false, e.ID, reason
+			logger("audit").ErrorContext(r.Context(), "Цепочка нарушена", "entry_id", e.ID, "reason", reason)
+			break
+		}
+		prev = e.Hash
+		checked++
+	}
+	result["checked"] = checked
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============ ЖУРНАЛ АУДИТА ============
+
+func TestClientIP(t *testing.T) {
+	proxies := cfg.HTTP.TrustedProxies
+	t.Cleanup(func() { cfg.HTTP.TrustedProxies = proxies })
+	cfg.HTTP.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+
+	for name, c := range map[string]struct {
+		remote, xff, want string
+	}{
+		"без прокси":                      {"203.0.113.7:5000", "", "203.0.113.7"},
+		"XFF от недоверенного клиента":    {"203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},
+		"XFF от доверенного прокси":       {"10.1.2.3:5000", "198.51.100.9", "198.51.100.9"},
+		"клиент дописал адрес слева":      {"10.1.2.3:5000", "1.2.3.4, 198.51.100.9", "198.51.100.9"},
+		"цепочка доверенных прокси":       {"192.168.1.1:5000", "198.51.100.9, 10.9.9.9", "198.51.100.9"},
+		"мусор в XFF":                     {"10.1.2.3:5000", "not-an-ip", "10.1.2.3"},
+		"доверенный прокси без заголовка": {"10.1.2.3:5000", "", "10.1.2.3"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := clientIP(r); got != c.want {
+			t.Errorf("%s: %q, ожидалось %q", name, got, c.want)
+		}
+	}
+}
+
+func TestAuditChainRoundTrip(t *testing.T) {
+	testDB(t)
+
+	// Без токена исполнитель — anonymous, даже если клиент назвался админом.
+	r := httptest.NewRequest(http.MethodPost, "/api/tariffs", nil)
+	r.Header.Set("X-User-Role", "admin")
+	auditRequest(r, "tariff.create", "tariff", 1, nil, map[string]int{"price": 5})
+	auditSystem("rule:5", "device.command", "device", 7, nil, map[string]string{"command": "on"})
+	auditSystem("scheduler", "scene.run", "scene", 3, nil, nil)
+
+	var actor, prevHash string
+	if err := psqlConn.QueryRow("SELECT actor, prev_hash FROM audit_log ORDER BY id LIMIT 1").Scan(&actor, &prevHash); err != nil {
+		t.Fatal(err)
+	}
+	if actor != "anonymous" || prevHash != "" {
+		t.Errorf("первая запись: actor %q, prev_hash %q", actor, prevHash)
+	}
+
+	w := httptest.NewRecorder()
+	verify := httptest.NewRequest(http.MethodGet, "/api/admin/audit/verify", nil)
+	verify.Header.Set("X-User-Role", "admin")
+	verifyAuditLog(w, verify)
+	var result struct {
+		OK      bool `json:"ok"`
+		Checked int  `json:"checked"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("ответ verify (%d): %v", w.Code, err)
+	}
+	if !result.OK || result.Checked != 3 {
+		t.Errorf("проверка цепочки: %+v", result)
+	}
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============ БИЛЛИНГ ============
+//
+// Подписка оплачивается помесячно по факту: период — календарный месяц
+// (в часовом поясе defaultTimezone). Все суммы — в копейках. В журнал
+// billing_ledger пишутся начисления (charge, > 0), зачёты (credit, < 0)
+// и оплаты (payment, < 0). При смене тарифа внутри периода старый тариф
+// начисляется пропорционально использованному времени, новый — с момента
+// смены. В конце периода начисляется последний отрезок, все неучтённые
+// записи собираются в счёт, и счёт оплачивается через PaymentProvider.
+// Неоплаченная подписка переходит в past_due; по истечении billingGrace
+// тариф пользователя считается приостановленным (см. planOf).
+//
+// Перед списанием счёт захватывается (status = processing): параллельные
+// попытки — цикл биллинга и повтор оплаты пользователем — не спишут его
+// дважды. Счета, оставшиеся open после сбоя между закрытием периода и
+// оплатой, и захваты, брошенные упавшим процессом (старше
+// invoiceClaimTimeout), подбирает следующий цикл. Номер счёта передаётся
+// провайдеру как ключ идемпотентности.
+
+const (
+	subscriptionActiveStatus = "active"
+	subscriptionPastDue      = "past_due"
+	subscriptionCanceled     = "canceled"
+
+	invoiceOpen       = "open"
+	invoiceProcessing = "processing"
+	invoicePaid       = "paid"
+	invoiceFailed     = "failed"
+
+	invoiceClaimTimeout  = 10 * time.Minute
+	billingCurrency      = "RUB"
+	billingGrace         = 7 * 24 * time.Hour
+	billingCheckInterval = time.Hour
+)
+
+type Subscription struct {
+	UserID       int        `json:"user_id"`
+	Plan         string     `json:"plan"`
+	Status       string     `json:"status"`
+	PeriodStart  time.Time  `json:"period_start"`
+	PeriodEnd    time.Time  `json:"period_end"`
+	PlanSince    time.Time  `json:"plan_since"`
+	PastDueSince *time.Time `json:"past_due_since,omitempty"`
+	Balance      int64      `json:"balance"`
+}
+
+type LedgerEntry struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Kind        string    `json:"kind"` // charge | credit | payment
+	Amount      int64     `json:"amount"`
+	Description string    `json:"description"`
+	Plan        string    `json:"plan,omitempty"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	InvoiceID   *int      `json:"invoice_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Invoice struct {
+	ID          int           `json:"id"`
+	UserID      int           `json:"user_id"`
+	Number      string        `json:"number"`
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Total       int64         `json:"total"`
+	Currency    string        `json:"currency"`
+	Status      string        `json:"status"`
+	ProviderRef string        `json:"provider_ref,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	PaidAt      *time.Time    `json:"paid_at,omitempty"`
+	Lines       []LedgerEntry `json:"lines,omitempty"`
+}
+
+func subscriptionActive(status string, pastDueSince sql.NullTime) bool {
+	switch status {
+	case subscriptionActiveStatus:
+		return true
+	case subscriptionPastDue:
+		return !pastDueSince.Valid || time.Since(pastDueSince.Time) < billingGrace
+	}
+	return false
+}
+
+func billingLocation() *time.Location {
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// billingPeriod возвращает календарный месяц, содержащий t.
+func billingPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.In(billingLocation())
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 1, 0)
+}
+
+// prorate — доля месячной цены за отрезок [from, to) периода.
+func prorate(price int64, from, to, periodStart, periodEnd time.Time) int64 {
+	if !to.After(from) {
+		return 0
+	}
+	share := to.Sub(from).Seconds() / periodEnd.Sub(periodStart).Seconds()
+	return int64(math.Round(float64(price) * share))
+}
+
+func formatMoney(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, billingCurrency)
+}
+
+// ============ ПЛАТЁЖНЫЙ ПРОВАЙДЕР ============
+
+type PaymentRequest struct {
+	UserID        int    `json:"user_id"`
+	InvoiceNumber string `json:"invoice_number"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+}
+
+// PaymentProvider списывает оплату счёта и возвращает идентификатор
+// операции у провайдера. Повторный запрос с тем же InvoiceNumber не должен
+// списывать деньги второй раз: он возвращает результат первого.
+type PaymentProvider interface {
+	Charge(ctx context.Context, req PaymentRequest) (string, error)
+}
+
+// FakePaymentProvider — провайдер для локальной разработки: принимает все
+// платежи, кроме пользователей из Decline (PAYMENT_FAKE_DECLINE=3,5).
+type FakePaymentProvider struct {
+	Decline map[int]bool
+
+	mu      sync.Mutex
+	charges []PaymentRequest
+	refs    map[string]string // номер счёта → идентификатор операции
+}
+
+func (p *FakePaymentProvider) Charge(ctx context.Context, req PaymentRequest) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Decline[req.UserID] {
+		return "", fmt.Errorf("платёж отклонён (fake)")
+	}
+	if ref, ok := p.refs[req.InvoiceNumber]; ok {
+		return ref, nil
+	}
+	p.charges = append(p.charges, req)
+	ref := fmt.Sprintf("fake-%d-%s", len(p.charges), req.InvoiceNumber)
+	if p.refs == nil {
+		p.refs = map[string]string{}
+	}
+	p.refs[req.InvoiceNumber] = ref
+	return ref, nil
+}
+
+// Charges возвращает принятые платежи.
+func (p *FakePaymentProvider) Charges() []PaymentRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PaymentRequest{}, p.charges...)
+}
+
+var paymentProvider PaymentProvider
+
+func initBilling() {
+	switch provider := cfg.Billing.Provider; provider {
+	case "fake":
+		paymentProvider = &FakePaymentProvider{Decline: toSet(cfg.Billing.FakeDecline)}
+	default:
+		fatal("Неизвестный PAYMENT_PROVIDER", "provider", provider)
+	}
+	logger("billing").Info("Биллинг инициализирован")
+}
+
+// ============ ПОДПИСКИ И СЧЕТА ============
+
+// ensureSubscriptions заводит подписку пользователям, у которых её нет
+// (новые регистрации, пользователи до появления биллинга).
+func ensureSubscriptions() error {
+	start, end := billingPeriod(time.Now())
+	_, err := psqlConn.Exec(`INSERT INTO subscriptions (user_id, plan, status, period_start, period_end, plan_since)
+		SELECT id, COALESCE(payment_type, $1), $2, $3, $4, GREATEST(created_at::timestamptz, $3)
+		FROM users WHERE role <> 'admin'
+		ON CONFLICT (user_id) DO NOTHING`,
+		defaultPlan, subscriptionActiveStatus, start, end)
+	return err
+}
+
+func chargePlanSegment(tx *sql.Tx, userID int, planName string, from, to, periodStart, periodEnd time.Time) error {
+	plan, ok := plans[planName]
+	if !ok {
+		plan = plans[defaultPlan]
+	}
+	amount := prorate(plan.MonthlyPrice, from, to, periodStart, periodEnd)
+	if amount == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, plan, period_from, period_to)
+		VALUES ($1, 'charge', $2, $3, $4, $5, $6)`,
+		userID, amount,
+		fmt.Sprintf("Тариф «%s» %s — %s", plan.Name, from.In(billingLocation()).Format("02.01.2006 15:04"), to.In(billingLocation()).Format("02.01.2006 15:04")),
+		plan.Name, from, to)
+	return err
+}
+
+// changePlan переводит пользователя на другой тариф с пропорциональным
+// начислением за использованную часть периода.
+func changePlan(userID int, plan Plan) (Subscription, error) {
+	if err := ensureSubscriptions(); err != nil {
+		return Subscription{}, err
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	var sub Subscription
+	err = tx.QueryRow(`SELECT plan, period_start, period_end, plan_since FROM subscriptions
+		WHERE user_id = $1 FOR UPDATE`, userID).Scan(&sub.Plan, &sub.PeriodStart, &sub.PeriodEnd, &sub.PlanSince)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	now := time.Now()
+	if sub.Plan != plan.Name {
+		if err := chargePlanSegment(tx, userID, sub.Plan, sub.PlanSince, now, sub.PeriodStart, sub.PeriodEnd); err != nil {
+			return Subscription{}, err
+		}
+		if _, err := tx.Exec("UPDATE subscriptions SET plan = $1, plan_since = $2, updated_at = NOW() WHERE user_id = $3",
+			plan.Name, now, userID); err != nil {
+			return Subscription{}, err
+		}
+		if _, err := tx.Exec("UPDATE users SET payment_type = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+			plan.Name, userID); err != nil {
+			return Subscription{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Subscription{}, err
+	}
+	return loadSubscription(userID)
+}
+
+// closePeriod начисляет последний отрезок периода, собирает неучтённые
+// записи журнала в счёт и открывает следующий период.
+func closePeriod(tx *sql.Tx, userID int) (int, error) {
+	var plan string
+	var start, end, since time.Time
+	err := tx.QueryRow(`SELECT plan, period_start, period_end, plan_since FROM subscriptions
+		WHERE user_id = $1 FOR UPDATE`, userID).Scan(&plan, &start, &end, &since)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := chargePlanSegment(tx, userID, plan, since, end, start, end); err != nil {
+		return 0, err
+	}
+
+	var invoiceID int
+	var total int64
+	err = tx.QueryRow(`INSERT INTO invoices (user_id, number, period_start, period_end, total, status)
+		VALUES ($1, '', $2, $3, 0, $4) RETURNING id`, userID, start, end, invoiceOpen).Scan(&invoiceID)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(`WITH lines AS (
+			UPDATE billing_ledger SET invoice_id = $1 WHERE user_id = $2 AND invoice_id IS NULL RETURNING amount
+		) SELECT COALESCE(SUM(amount), 0) FROM lines`, invoiceID, userID).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	// Зачёты сверх начислений переносятся на следующий период.
+	if total < 0 {
+		if _, err := tx.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to)
+			VALUES ($1, 'credit', $2, 'Перенос остатка зачёта', $3, $3)`, userID, total, end); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to, invoice_id)
+			VALUES ($1, 'charge', $2, 'Остаток зачёта перенесён', $3, $3, $4)`, userID, -total, end, invoiceID); err != nil {
+			return 0, err
+		}
+		total = 0
+	}
+
+	number := fmt.Sprintf("INV-%s-%06d", start.In(billingLocation()).Format("200601"), invoiceID)
+	if _, err := tx.Exec("UPDATE invoices SET number = $1, total = $2 WHERE id = $3", number, total, invoiceID); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE subscriptions SET period_start = period_end, period_end = $1,
+		plan_since = period_end, updated_at = NOW() WHERE user_id = $2`, end.In(billingLocation()).AddDate(0, 1, 0), userID)
+	return invoiceID, err
+}
+
+// errInvoiceBusy — счёт оплачен или его оплата уже выполняется.
+var errInvoiceBusy = errors.New("счёт уже оплачен или оплачивается")
+
+// claimInvoice захватывает счёт для оплаты: open, failed или брошенный
+// захват. Возвращает errInvoiceBusy, если счёт захватить нельзя.
+func claimInvoice(ctx context.Context, invoiceID int) (userID int, number string, total int64, err error) {
+	err = psqlConn.QueryRowContext(ctx, `UPDATE invoices SET status = $1, claimed_at = NOW()
+		WHERE id = $2 AND (status IN ($3, $4) OR (status = $1 AND claimed_at < $5))
+		RETURNING user_id, number, total`,
+		invoiceProcessing, invoiceID, invoiceOpen, invoiceFailed, time.Now().Add(-invoiceClaimTimeout)).
+		Scan(&userID, &number, &total)
+	if err == sql.ErrNoRows {
+		err = errInvoiceBusy
+	}
+	return
+}
+
+// payInvoice пытается оплатить счёт и обновляет статус подписки.
+func payInvoice(ctx context.Context, invoiceID int) error {
+	userID, number, total, err := claimInvoice(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	ref := ""
+	var chargeErr error
+	if total > 0 {
+		ref, chargeErr = paymentProvider.Charge(ctx, PaymentRequest{
+			UserID: userID, InvoiceNumber: number, Amount: total, Currency: billingCurrency,
+		})
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if chargeErr != nil {
+		logger("billing").Warn("Счёт не оплачен", "invoice", number, "total", formatMoney(total), "error", chargeErr)
+		if _, err := tx.Exec("UPDATE invoices SET status = $1, claimed_at = NULL WHERE id = $2", invoiceFailed, invoiceID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE subscriptions SET status = $1, past_due_since = COALESCE(past_due_since, NOW()),
+			updated_at = NOW() WHERE user_id = $2 AND status <> $3`, subscriptionPastDue, userID, subscriptionCanceled); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return chargeErr
+	}
+
+	if _, err := tx.Exec("UPDATE invoices SET status = $1, provider_ref = NULLIF($2, ''), paid_at = NOW(), claimed_at = NULL WHERE id = $3",
+		invoicePaid, ref, invoiceID); err != nil {
+		return err
+	}
+	if total > 0 {
+		if _, err := tx.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to, invoice_id)
+			VALUES ($1, 'payment', $2, $3, NOW(), NOW(), $4)`,
+			userID, -total, "Оплата счёта "+number, invoiceID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE subscriptions SET status = $1, past_due_since = NULL, updated_at = NOW()
+		WHERE user_id = $2 AND status = $3
+		  AND NOT EXISTS (SELECT 1 FROM invoices WHERE user_id = $2 AND status = $4)`,
+		subscriptionActiveStatus, userID, subscriptionPastDue, invoiceFailed); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger("billing").Info("Счёт оплачен", "invoice", number, "total", formatMoney(total))
+	return nil
+}
+
+// runBillingCycle закрывает все истёкшие периоды (в том числе пропущенные,
+// если сервер был остановлен) и оплачивает выставленные счета.
+func runBillingCycle() {
+	if err := ensureSubscriptions(); err != nil {
+		logger("billing").Error("Ошибка создания подписок", "error", err)
+		return
+	}
+	payPendingInvoices()
+
+	for {
+		tx, err := psqlConn.Begin()
+		if err != nil {
+			logger("billing").Error("Ошибка БД", "error", err)
+			return
+		}
+
+		var userID int
+		err = tx.QueryRow(`SELECT user_id FROM subscriptions
+			WHERE period_end <= NOW() AND status <> $1
+			ORDER BY period_end LIMIT 1 FOR UPDATE SKIP LOCKED`, subscriptionCanceled).Scan(&userID)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return
+		}
+		var invoiceID int
+		if err == nil {
+			invoiceID, err = closePeriod(tx, userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			logger("billing").Error("Ошибка закрытия периода", "user_id", userID, "error", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		payInvoice(ctx, invoiceID)
+		cancel()
+	}
+}
+
+// payPendingInvoices оплачивает счета, оставшиеся без попытки оплаты:
+// процесс остановился после закрытия периода или во время списания.
+func payPendingInvoices() {
+	rows, err := psqlConn.Query(`SELECT id FROM invoices
+		WHERE status = $1 OR (status = $2 AND claimed_at < $3) ORDER BY id`,
+		invoiceOpen, invoiceProcessing, time.Now().Add(-invoiceClaimTimeout))
+	if err != nil {
+		logger("billing").Error("Ошибка загрузки неоплаченных счетов", "error", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := payInvoice(ctx, id); err != nil && !errors.Is(err, errInvoiceBusy) {
+			logger("billing").Warn("Повторная оплата счёта не удалась", "invoice_id", id, "error", err)
+		}
+		cancel()
+	}
+}
+
+func startBillingCycle(ctx context.Context) {
+	runBillingCycle()
+
+	ticker := time.NewTicker(billingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runBillingCycle()
+		}
+	}
+}
+
+func loadSubscription(userID int) (Subscription, error) {
+	var sub Subscription
+	var pastDue sql.NullTime
+	err := psqlConn.QueryRow(`SELECT s.user_id, s.plan, s.status, s.period_start, s.period_end, s.plan_since, s.past_due_since,
+			COALESCE((SELECT SUM(amount) FROM billing_ledger WHERE user_id = s.user_id), 0)
+		FROM subscriptions s WHERE s.user_id = $1`, userID).
+		Scan(&sub.UserID, &sub.Plan, &sub.Status, &sub.PeriodStart, &sub.PeriodEnd, &sub.PlanSince, &pastDue, &sub.Balance)
+	if pastDue.Valid {
+		sub.PastDueSince = &pastDue.Time
+	}
+	return sub, err
+}
+
+func queryLedger(where string, args ...interface{}) ([]LedgerEntry, error) {
+	rows, err := psqlConn.Query(`SELECT id, user_id, kind, amount, description, COALESCE(plan, ''),
+		period_from, period_to, invoice_id, created_at FROM billing_ledger `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		var invoiceID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Kind, &e.Amount, &e.Description, &e.Plan,
+			&e.From, &e.To, &invoiceID, &e.CreatedAt); err != nil {
+			continue
+		}
+		e.InvoiceID = nullIntPtr(invoiceID)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+const invoiceColumns = `id, user_id, number, period_start, period_end, total, status,
+	COALESCE(provider_ref, ''), created_at, paid_at`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }) (Invoice, error) {
+	inv := Invoice{Currency: billingCurrency}
+	var paidAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.UserID, &inv.Number, &inv.PeriodStart, &inv.PeriodEnd, &inv.Total, &inv.Status,
+		&inv.ProviderRef, &inv.CreatedAt, &paidAt)
+	if paidAt.Valid {
+		inv.PaidAt = &paidAt.Time
+	}
+	return inv, err
+}
+
+// ============ REST API HANDLERS - BILLING ============
+
+// billingTarget — пользователь, о котором запрос: сам вошедший или, для
+// администратора, ?user_id=. При ошибке ответ уже отправлен.
+func billingTarget(w http.ResponseWriter, r *http.Request) (int, bool, bool) {
+	userID, _, admin, ok := planRequest(w, r)
+	if !ok {
+		return 0, false, false
+	}
+	if v := r.URL.Query().Get("user_id"); v != "" && admin {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Неверный user_id", http.StatusBadRequest)
+			return 0, false, false
+		}
+		return id, admin, true
+	}
+	return userID, admin, true
+}
+
+func getSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, ok := billingTarget(w, r)
+	if !ok {
+		return
+	}
+	if err := ensureSubscriptions(); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := loadSubscription(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Подписка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+// changePlanHandler — POST {"plan": "Максимум"}; администратор может
+// указать ?user_id=.
+func changePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, ok := billingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	plan, found := plans[req.Plan]
+	if !found {
+		for _, p := range plans {
+			if p.Code == req.Plan {
+				plan, found = p, true
+			}
+		}
+	}
+	if !found {
+		http.Error(w, "Неизвестный тариф", http.StatusBadRequest)
+		return
+	}
+
+	before, _, _ := planOf(userID)
+	sub, err := changePlan(userID, plan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Подписка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditRequest(r, "billing.plan", "user", userID,
+		map[string]string{"plan": before.Name}, map[string]string{"plan": plan.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func getLedger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, ok := billingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := queryLedger("WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 500", userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func getInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, ok := billingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := psqlConn.Query("SELECT "+invoiceColumns+" FROM invoices WHERE user_id = $1 ORDER BY period_start DESC", userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invoices := []Invoice{}
+	for rows.Next() {
+		if inv, err := scanInvoice(rows); err == nil {
+			invoices = append(invoices, inv)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoices)
+}
+
+// loadInvoiceFor читает счёт со строками, проверяя, что он принадлежит
+// пользователю запроса (или запрос от администратора).
+func loadInvoiceFor(w http.ResponseWriter, r *http.Request) (Invoice, bool) {
+	userID, _, admin, ok := planRequest(w, r)
+	if !ok {
+		return Invoice{}, false
+	}
+
+	inv, err := scanInvoice(psqlConn.QueryRow("SELECT "+invoiceColumns+" FROM invoices WHERE id = $1", r.URL.Query().Get("id")))
+	if err != nil || (!admin && inv.UserID != userID) {
+		http.Error(w, "Счёт не найден", http.StatusNotFound)
+		return Invoice{}, false
+	}
+
+	inv.Lines, err = queryLedger("WHERE invoice_id = $1 AND kind <> 'payment' ORDER BY period_from, id", inv.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return Invoice{}, false
+	}
+	return inv, true
+}
+
+// getInvoice — счёт в JSON или PDF (?id=, ?format=pdf; PDF — выгрузка,
+// доступна на тарифах с Export).
+func getInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inv, ok := loadInvoiceFor(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("format") != "pdf" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inv)
+		return
+	}
+
+	if _, ok := checkPlanFeature(w, r, "export"); !ok {
+		return
+	}
+
+	var customer, email string
+	psqlConn.QueryRow("SELECT username, email FROM users WHERE id = $1", inv.UserID).Scan(&customer, &email)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.Number))
+	w.Write(renderInvoicePDF(inv, customer, email))
+}
+
+// retryInvoicePayment — повторная попытка оплатить неоплаченный счёт.
+func retryInvoicePayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inv, ok := loadInvoiceFor(w, r)
+	if !ok {
+		return
+	}
+	if inv.Status == invoicePaid {
+		http.Error(w, "Счёт уже оплачен", http.StatusConflict)
+		return
+	}
+
+	err := payInvoice(r.Context(), inv.ID)
+	if errors.Is(err, errInvoiceBusy) {
+		http.Error(w, "Счёт уже оплачен или оплачивается", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Оплата не прошла: %v", err), http.StatusPaymentRequired)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": invoicePaid})
+}
+
+// addBillingCredit — зачёт пользователю (только администратор). Сумма
+// в копейках, попадает в ближайший счёт.
+func addBillingCredit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	// Роль берётся из БД (planRequest), а не из заголовка клиента.
+	_, _, admin, ok := planRequest(w, r)
+	if !ok {
+		return
+	}
+	if !admin {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		UserID      int    `json:"user_id"`
+		Amount      int64  `json:"amount"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 || req.Amount <= 0 {
+		http.Error(w, "user_id и положительная amount обязательны", http.StatusBadRequest)
+		return
+	}
+	if req.Description == "" {
+		req.Description = "Зачёт"
+	}
+
+	e := LedgerEntry{UserID: req.UserID, Kind: "credit", Amount: -req.Amount, Description: req.Description}
+	err := psqlConn.QueryRow(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to)
+		VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id, period_from, period_to, created_at`,
+		e.UserID, e.Kind, e.Amount, e.Description).Scan(&e.ID, &e.From, &e.To, &e.CreatedAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	auditRequest(r, "billing.credit", "user", e.UserID, nil, e)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(e)
+}
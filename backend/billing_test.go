@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============ БИЛЛИНГ ============
+
+func TestBillingPeriod(t *testing.T) {
+	msk := billingLocation()
+	for name, c := range map[string]struct {
+		at         time.Time
+		start, end time.Time
+	}{
+		"середина месяца": {
+			time.Date(2024, 5, 15, 12, 0, 0, 0, msk),
+			time.Date(2024, 5, 1, 0, 0, 0, 0, msk), time.Date(2024, 6, 1, 0, 0, 0, 0, msk),
+		},
+		"первая секунда месяца": {
+			time.Date(2024, 5, 1, 0, 0, 0, 0, msk),
+			time.Date(2024, 5, 1, 0, 0, 0, 0, msk), time.Date(2024, 6, 1, 0, 0, 0, 0, msk),
+		},
+		"декабрь": {
+			time.Date(2024, 12, 31, 23, 59, 0, 0, msk),
+			time.Date(2024, 12, 1, 0, 0, 0, 0, msk), time.Date(2025, 1, 1, 0, 0, 0, 0, msk),
+		},
+		// 22:00 UTC 31 января — уже 1 февраля по Москве.
+		"месяц по часовому поясу биллинга": {
+			time.Date(2024, 1, 31, 22, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 0, 0, 0, 0, msk), time.Date(2024, 3, 1, 0, 0, 0, 0, msk),
+		},
+	} {
+		start, end := billingPeriod(c.at)
+		if !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%s: %s — %s, ожидалось %s — %s", name, start, end, c.start, c.end)
+		}
+	}
+}
+
+func TestProrate(t *testing.T) {
+	start, end := billingPeriod(time.Date(2024, 4, 10, 0, 0, 0, 0, billingLocation())) // 30 дней
+	day := func(n int) time.Time { return start.AddDate(0, 0, n) }
+	for name, c := range map[string]struct {
+		from, to time.Time
+		want     int64
+	}{
+		"весь период":    {start, end, 29900},
+		"половина":       {start, day(15), 14950},
+		"один день":      {day(10), day(11), 997}, // 29900/30 = 996.67
+		"пустой отрезок": {day(5), day(5), 0},
+		"перевёрнутый":   {day(6), day(5), 0},
+	} {
+		if got := prorate(29900, c.from, c.to, start, end); got != c.want {
+			t.Errorf("%s: %d, ожидалось %d", name, got, c.want)
+		}
+	}
+
+	// Части периода в сумме дают месячную цену с точностью до копейки на отрезок.
+	a, b := prorate(99000, start, day(11), start, end), prorate(99000, day(11), end, start, end)
+	if diff := a + b - 99000; diff < -1 || diff > 1 {
+		t.Errorf("сумма частей %d + %d = %d", a, b, a+b)
+	}
+}
+
+// createBillingUser заводит пользователя с подпиской за прошлый месяц.
+func createBillingUser(t *testing.T, name string) (userID int, periodEnd time.Time) {
+	t.Helper()
+	start, end := billingPeriod(time.Now().AddDate(0, -1, 0))
+	if err := psqlConn.QueryRow(`INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.com', 'x')
+		RETURNING id`, name).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	// plan_since = period_end: начислять за период нечего, в счёт идут
+	// только записи журнала теста.
+	if _, err := psqlConn.Exec(`INSERT INTO subscriptions (user_id, plan, status, period_start, period_end, plan_since)
+		VALUES ($1, $2, $3, $4, $5, $5)`, userID, defaultPlan, subscriptionActiveStatus, start, end); err != nil {
+		t.Fatal(err)
+	}
+	return userID, end
+}
+
+func TestClosePeriodCarriesNegativeBalance(t *testing.T) {
+	testDB(t)
+	userID, end := createBillingUser(t, "carry")
+	if _, err := psqlConn.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to)
+		VALUES ($1, 'charge', 10000, 'Начисление', $2, $2), ($1, 'credit', -25000, 'Зачёт', $2, $2)`, userID, end); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoiceID, err := closePeriod(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var total, lines, carried int64
+	psqlConn.QueryRow("SELECT total FROM invoices WHERE id = $1", invoiceID).Scan(&total)
+	psqlConn.QueryRow("SELECT SUM(amount) FROM billing_ledger WHERE invoice_id = $1", invoiceID).Scan(&lines)
+	psqlConn.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM billing_ledger WHERE user_id = $1 AND invoice_id IS NULL", userID).Scan(&carried)
+	if total != 0 || lines != 0 {
+		t.Errorf("счёт: total %d, сумма строк %d; ожидалось 0", total, lines)
+	}
+	if carried != -15000 {
+		t.Errorf("перенесено %d, ожидалось -15000 на следующий период", carried)
+	}
+}
+
+func TestPayInvoiceChargesOnce(t *testing.T) {
+	testDB(t)
+	provider := &FakePaymentProvider{}
+	saved := paymentProvider
+	paymentProvider = provider
+	t.Cleanup(func() { paymentProvider = saved })
+
+	userID, end := createBillingUser(t, "payer")
+	if _, err := psqlConn.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to)
+		VALUES ($1, 'charge', 29900, 'Начисление', $2, $2)`, userID, end); err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := psqlConn.Begin()
+	invoiceID, err := closePeriod(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	// Цикл биллинга и пользователь оплачивают один счёт одновременно.
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = payInvoice(context.Background(), invoiceID)
+		}()
+	}
+	wg.Wait()
+
+	paid := 0
+	for _, err := range errs {
+		if err == nil {
+			paid++
+		} else if err != errInvoiceBusy {
+			t.Errorf("payInvoice: %v", err)
+		}
+	}
+	if charges := provider.Charges(); paid != 1 || len(charges) != 1 {
+		t.Errorf("успешных оплат %d, списаний %d; ожидалось по одной", paid, len(charges))
+	}
+
+	// Счёт, оставшийся open после сбоя, подбирает следующий цикл.
+	if _, err := psqlConn.Exec("UPDATE invoices SET status = $1, paid_at = NULL WHERE id = $2", invoiceOpen, invoiceID); err != nil {
+		t.Fatal(err)
+	}
+	payPendingInvoices()
+	var status string
+	psqlConn.QueryRow("SELECT status FROM invoices WHERE id = $1", invoiceID).Scan(&status)
+	if status != invoicePaid {
+		t.Errorf("статус после цикла: %s", status)
+	}
+	if charges := provider.Charges(); len(charges) != 1 {
+		t.Errorf("повтор с тем же номером счёта списал ещё раз: %d списаний", len(charges))
+	}
+}
+
+func TestInvoicePDFRequiresExport(t *testing.T) {
+	testDB(t)
+	userID, end := createBillingUser(t, "exporter")
+	if _, err := psqlConn.Exec(`INSERT INTO billing_ledger (user_id, kind, amount, description, period_from, period_to)
+		VALUES ($1, 'charge', 29900, 'Начисление', $2, $2)`, userID, end); err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := psqlConn.Begin()
+	invoiceID, err := closePeriod(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	path := fmt.Sprintf("/api/billing/invoice?id=%d&format=pdf", invoiceID)
+	if w := ticketRequest(t, getInvoice, http.MethodGet, path, userID, nil); w.Code != http.StatusForbidden {
+		t.Errorf("PDF на базовом тарифе: %d, ожидалось 403", w.Code)
+	}
+	if w := ticketRequest(t, getInvoice, http.MethodGet, fmt.Sprintf("/api/billing/invoice?id=%d", invoiceID), userID, nil); w.Code != http.StatusOK {
+		t.Errorf("JSON счёта на базовом тарифе: %d", w.Code)
+	}
+
+	psqlConn.Exec("UPDATE users SET payment_type = 'Максимум' WHERE id = $1", userID)
+	w := ticketRequest(t, getInvoice, http.MethodGet, path, userID, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" {
+		t.Errorf("PDF на тарифе «Максимум»: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestBillingCreditNeedsAdminRole(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/billing/credit", strings.NewReader(`{"user_id": 1, "amount": 100}`))
+	req.Header.Set("X-User-Role", "admin")
+	w := httptest.NewRecorder()
+	addBillingCredit(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("зачёт по заголовку X-User-Role без токена: %d, ожидалось 401", w.Code)
+	}
+}
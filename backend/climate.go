@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// ============ МИКРОКЛИМАТ ============
+//
+// По температуре и влажности комнаты (sensors/temperature/...,
+// sensors/humidity/...; комната определяется как в presence.go) на приёме
+// считаются точка росы, индекс жары, абсолютная влажность и оценка
+// комфорта 0–100 относительно целевых диапазонов комнаты (comfort_targets,
+// по умолчанию 20–24 °C и 40–60 %). Результат пишется в InfluxDB
+// (measurement "climate", теги room_id и building_id). Влажность не ниже
+// mould_humidity дольше mould_hours помечает комнату риском плесени;
+// метка снимается, когда влажность опускается на mouldHysteresis ниже
+// порога.
+
+const (
+	climateMeasurement = "climate"
+	// Показание второй величины старше этого в расчёт не берётся.
+	climateMaxAge   = 15 * time.Minute
+	mouldHysteresis = 5.0
+)
+
+// ComfortTargets — целевые диапазоны комнаты.
+type ComfortTargets struct {
+	TempMin       float64 `json:"temp_min"`
+	TempMax       float64 `json:"temp_max"`
+	HumidityMin   float64 `json:"humidity_min"`
+	HumidityMax   float64 `json:"humidity_max"`
+	MouldHumidity float64 `json:"mould_humidity"`
+	MouldHours    float64 `json:"mould_hours"`
+}
+
+var defaultComfortTargets = ComfortTargets{
+	TempMin:       20,
+	TempMax:       24,
+	HumidityMin:   40,
+	HumidityMax:   60,
+	MouldHumidity: 70,
+	MouldHours:    6,
+}
+
+// RoomClimate — последнее рассчитанное состояние комнаты.
+type RoomClimate struct {
+	RoomID            int            `json:"room_id"`
+	BuildingID        int            `json:"building_id"`
+	Temperature       *float64       `json:"temperature,omitempty"`
+	Humidity          *float64       `json:"humidity,omitempty"`
+	DewPoint          *float64       `json:"dew_point,omitempty"`
+	HeatIndex         *float64       `json:"heat_index,omitempty"`
+	AbsoluteHumidity  *float64       `json:"absolute_humidity,omitempty"` // г/м³
+	ComfortScore      *int           `json:"comfort_score,omitempty"`
+	MouldRisk         bool           `json:"mould_risk"`
+	HighHumiditySince *time.Time     `json:"high_humidity_since,omitempty"`
+	Targets           ComfortTargets `json:"targets"`
+	UpdatedAt         time.Time      `json:"updated_at,omitempty"`
+
+	tempAt, humAt time.Time
+}
+
+type climateTracker struct {
+	mu    sync.Mutex
+	rooms map[int]*RoomClimate
+}
+
+var climate = &climateTracker{rooms: map[int]*RoomClimate{}}
+
+func initClimate() {
+	climate.refresh()
+}
+
+// refresh подтягивает комнаты и их целевые диапазоны. Как и у
+// присутствия, вызывается из фонового цикла (startPresenceMonitor) и
+// после изменения диапазонов; запрос к БД идёт без c.mu.
+func (c *climateTracker) refresh() {
+	rows, err := psqlConn.Query(`SELECT r.id, r.building_id,
+			t.temp_min, t.temp_max, t.humidity_min, t.humidity_max, t.mould_humidity, t.mould_hours
+		FROM room r LEFT JOIN comfort_targets t ON t.room_id = r.id`)
+	if err != nil {
+		logger("climate").Error("Ошибка загрузки комнат", "error", err)
+		return
+	}
+	type roomRow struct {
+		id, buildingID int
+		targets        ComfortTargets
+	}
+	var loaded []roomRow
+	for rows.Next() {
+		row := roomRow{targets: defaultComfortTargets}
+		var v [6]sql.NullFloat64
+		if rows.Scan(&row.id, &row.buildingID, &v[0], &v[1], &v[2], &v[3], &v[4], &v[5]) != nil {
+			continue
+		}
+		if v[0].Valid {
+			row.targets = ComfortTargets{v[0].Float64, v[1].Float64, v[2].Float64, v[3].Float64, v[4].Float64, v[5].Float64}
+		}
+		loaded = append(loaded, row)
+	}
+	rows.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, row := range loaded {
+		room, ok := c.rooms[row.id]
+		if !ok {
+			room = &RoomClimate{RoomID: row.id}
+			c.rooms[row.id] = room
+		}
+		room.BuildingID, room.Targets = row.buildingID, row.targets
+	}
+}
+
+// ============ ФОРМУЛЫ ============
+
+// dewPoint — формула Магнуса, °C.
+func dewPoint(t, rh float64) float64 {
+	const a, b = 17.62, 243.12
+	g := math.Log(rh/100) + a*t/(b+t)
+	return b * g / (a - g)
+}
+
+// heatIndex — индекс жары NOAA (регрессия Ротфуса), °C. При невысокой
+// температуре используется упрощённая формула Стедмана.
+func heatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh - 0.22475541*f*rh -
+			0.00683783*f*f - 0.05481717*rh*rh + 0.00122874*f*f*rh +
+			0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// absoluteHumidity — содержание водяного пара, г/м³.
+func absoluteHumidity(t, rh float64) float64 {
+	return 6.112 * math.Exp(17.67*t/(t+243.5)) * rh * 2.1674 / (273.15 + t)
+}
+
+// bandDeviation — насколько value выходит за [min, max].
+func bandDeviation(value, min, max float64) float64 {
+	switch {
+	case value < min:
+		return min - value
+	case value > max:
+		return value - max
+	}
+	return 0
+}
+
+// comfortScore: 100 внутри обоих диапазонов; каждый градус вне диапазона
+// снимает 20 баллов с температурной части, каждый процент влажности — 3
+// с влажностной. Температура весит 60 %, влажность — 40 %.
+func comfortScore(t, rh float64, targets ComfortTargets) int {
+	tempScore := math.Max(0, 100-20*bandDeviation(t, targets.TempMin, targets.TempMax))
+	humScore := math.Max(0, 100-3*bandDeviation(rh, targets.HumidityMin, targets.HumidityMax))
+	return int(math.Round(0.6*tempScore + 0.4*humScore))
+}
+
+func (t ComfortTargets) validate() error {
+	if t.TempMin >= t.TempMax || t.HumidityMin >= t.HumidityMax {
+		return fmt.Errorf("min должен быть меньше max")
+	}
+	if t.HumidityMin < 0 || t.HumidityMax > 100 || t.MouldHumidity <= 0 || t.MouldHumidity > 100 {
+		return fmt.Errorf("влажность задаётся в процентах 0–100")
+	}
+	if t.MouldHours <= 0 {
+		return fmt.Errorf("mould_hours должен быть больше нуля")
+	}
+	return nil
+}
+
+// ============ ОБРАБОТКА ПОКАЗАНИЙ ============
+
+func floatPtr(v float64) *float64 {
+	v = round2(v)
+	return &v
+}
+
+// observe — обработчик показаний датчиков (см. readingHandlers).
+func (c *climateTracker) observe(reading SensorReading) {
+	isTemp := strings.HasPrefix(reading.Topic, "sensors/temperature/")
+	isHum := strings.HasPrefix(reading.Topic, "sensors/humidity/")
+	if !reading.HasValue || (!isTemp && !isHum) {
+		return
+	}
+	if isHum && (reading.Value <= 0 || reading.Value > 100) {
+		return
+	}
+	roomID, ok := roomForTopic(reading.Topic)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	room, ok := c.rooms[roomID]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	if isTemp {
+		room.Temperature, room.tempAt = floatPtr(reading.Value), reading.Time
+	} else {
+		room.Humidity, room.humAt = floatPtr(reading.Value), reading.Time
+	}
+	if room.Temperature == nil || room.Humidity == nil ||
+		reading.Time.Sub(room.tempAt) > climateMaxAge || reading.Time.Sub(room.humAt) > climateMaxAge {
+		c.mu.Unlock()
+		return
+	}
+
+	t, rh := *room.Temperature, *room.Humidity
+	score := comfortScore(t, rh, room.Targets)
+	room.DewPoint = floatPtr(dewPoint(t, rh))
+	room.HeatIndex = floatPtr(heatIndex(t, rh))
+	room.AbsoluteHumidity = floatPtr(absoluteHumidity(t, rh))
+	room.ComfortScore = &score
+	room.UpdatedAt = reading.Time
+
+	mouldChanged := c.trackMouldLocked(room, rh, reading.Time)
+	snapshot := *room
+	c.mu.Unlock()
+
+	writeClimate(snapshot)
+	if mouldChanged {
+		if snapshot.MouldRisk {
+			logger("climate").WarnContext(reading.logContext(), "Риск плесени",
+				"room_id", snapshot.RoomID, "humidity", rh, "since", snapshot.HighHumiditySince)
+		} else {
+			logger("climate").InfoContext(reading.logContext(), "Риск плесени снят", "room_id", snapshot.RoomID)
+		}
+		hub.publish(StreamEvent{Type: "climate", BuildingID: snapshot.BuildingID, RoomID: snapshot.RoomID, Data: snapshot, Time: reading.Time})
+	}
+}
+
+// trackMouldLocked ведёт отсчёт высокой влажности и возвращает true при
+// смене флага риска плесени.
+func (c *climateTracker) trackMouldLocked(room *RoomClimate, rh float64, at time.Time) bool {
+	threshold := room.Targets.MouldHumidity
+	switch {
+	case rh >= threshold:
+		if room.HighHumiditySince == nil {
+			since := at
+			room.HighHumiditySince = &since
+		}
+		hours := time.Duration(room.Targets.MouldHours * float64(time.Hour))
+		if !room.MouldRisk && at.Sub(*room.HighHumiditySince) >= hours {
+			room.MouldRisk = true
+			return true
+		}
+	case rh < threshold-mouldHysteresis || !room.MouldRisk:
+		room.HighHumiditySince = nil
+		if room.MouldRisk {
+			room.MouldRisk = false
+			return true
+		}
+	}
+	return false
+}
+
+func writeClimate(room RoomClimate) {
+	point := influxdb2.NewPointWithMeasurement(climateMeasurement).
+		AddTag("room_id", strconv.Itoa(room.RoomID)).
+		AddTag("building_id", strconv.Itoa(room.BuildingID)).
+		AddField("temperature", *room.Temperature).
+		AddField("humidity", *room.Humidity).
+		AddField("dew_point", *room.DewPoint).
+		AddField("heat_index", *room.HeatIndex).
+		AddField("absolute_humidity", *room.AbsoluteHumidity).
+		AddField("comfort_score", *room.ComfortScore).
+		AddField("mould_risk", room.MouldRisk).
+		SetTime(room.UpdatedAt)
+
+	writeAPI := influxClient.WriteAPIBlocking(cfg.Influx.Org, cfg.Influx.Bucket)
+	if err := writeAPI.WritePoint(context.Background(), point); err != nil {
+		logger("climate").Error("Ошибка записи в InfluxDB", "room_id", room.RoomID, "error", err)
+		influxWriteErrors.WithLabelValues("climate_write_failed").Inc()
+	}
+}
+
+// ============ REST API HANDLERS - CLIMATE ============
+
+// getClimate — текущий микроклимат комнаты (?room_id=) или всех комнат
+// здания (?building_id=).
+func getClimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, roomErr := strconv.Atoi(r.URL.Query().Get("room_id"))
+	buildingID, buildingErr := strconv.Atoi(r.URL.Query().Get("building_id"))
+	if roomErr != nil && buildingErr != nil {
+		http.Error(w, "room_id или building_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	climate.mu.Lock()
+	list := []RoomClimate{}
+	for _, room := range climate.rooms {
+		if (roomErr == nil && room.RoomID == roomID) || (roomErr != nil && room.BuildingID == buildingID) {
+			list = append(list, *room)
+		}
+	}
+	climate.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if roomErr == nil {
+		if len(list) == 0 {
+			http.Error(w, "Комната не найдена", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(list[0])
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// getClimateHistory — производные метрики комнаты из InfluxDB
+// (?room_id=&from=&to=&every=), по ряду на каждое поле.
+func getClimateHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	roomID, err := strconv.Atoi(q.Get("room_id"))
+	if err != nil {
+		http.Error(w, "room_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	every := 15 * time.Minute
+	if v := q.Get("every"); v != "" {
+		if every, err = time.ParseDuration(v); err != nil || every < time.Minute {
+			http.Error(w, "every: длительность не меньше 1m", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "to должен быть позже from", http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if from, ok = historyWindow(w, r, from, to); !ok {
+		return
+	}
+
+	query := fmt.Sprintf(`
+        from(bucket: "%s")
+        |> range(start: %s, stop: %s)
+        |> filter(fn: (r) => r._measurement == "%s" and r.room_id == "%d" and r._field != "mould_risk")
+        |> aggregateWindow(every: %s, fn: mean, createEmpty: false)
+    `, cfg.Influx.Bucket, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), climateMeasurement, roomID, every)
+
+	result, err := influxClient.QueryAPI(cfg.Influx.Org).Query(context.Background(), query)
+	if err != nil {
+		logger("climate").ErrorContext(r.Context(), "Ошибка запроса к InfluxDB", "error", err)
+		http.Error(w, "InfluxDB error", http.StatusInternalServerError)
+		return
+	}
+
+	type HistoryPoint struct {
+		Time  time.Time `json:"time"`
+		Value float64   `json:"value"`
+	}
+	series := map[string][]HistoryPoint{}
+	for result.Next() {
+		if v, ok := numericValue(result.Record().Value()); ok {
+			field := result.Record().Field()
+			series[field] = append(series[field], HistoryPoint{Time: result.Record().Time(), Value: round2(v)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"room_id": roomID,
+		"from":    from,
+		"to":      to,
+		"series":  series,
+	})
+}
+
+// setComfortTargets задаёт целевые диапазоны комнаты (PUT ?room_id=)
+// или сбрасывает их к значениям по умолчанию (DELETE ?room_id=).
+func setComfortTargets(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+	if err != nil {
+		http.Error(w, "room_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		targets := defaultComfortTargets
+		if err := json.NewDecoder(r.Body).Decode(&targets); err != nil {
+			http.Error(w, "Неверный JSON", http.StatusBadRequest)
+			return
+		}
+		if err := targets.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = psqlConn.Exec(`INSERT INTO comfort_targets
+			(room_id, temp_min, temp_max, humidity_min, humidity_max, mould_humidity, mould_hours)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (room_id) DO UPDATE SET temp_min = $2, temp_max = $3,
+				humidity_min = $4, humidity_max = $5, mould_humidity = $6, mould_hours = $7`,
+			roomID, targets.TempMin, targets.TempMax, targets.HumidityMin, targets.HumidityMax,
+			targets.MouldHumidity, targets.MouldHours)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		climate.refresh()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(targets)
+	case http.MethodDelete:
+		if _, err := psqlConn.Exec("DELETE FROM comfort_targets WHERE room_id = $1", roomID); err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		climate.refresh()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
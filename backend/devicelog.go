@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ ЖУРНАЛ УСТРОЙСТВ ============
+//
+// device_logs — история событий устройства, data — JSON. События:
+//   - command  — команда устройству (publishDeviceCommand);
+//   - state    — смена состояния по сообщению устройства;
+//   - online / offline — переходы доступности (liveness.go);
+//   - error    — ошибка отправки команды или поле "error" в сообщении;
+//   - config   — создание устройства и изменение его настроек.
+// Старые строки раз в сутки удаляются по DEVICE_LOG_RETENTION_DAYS; при
+// DEVICE_LOG_ARCHIVE=true они перед удалением выгружаются в хранилище
+// файлов (device-logs/<дата>-<id>.jsonl.gz).
+
+const (
+	deviceEventCommand = "command"
+	deviceEventState   = "state"
+	deviceEventError   = "error"
+	deviceEventConfig  = "config"
+
+	logRetentionInterval = 24 * time.Hour
+	logRetentionBatch    = 5000
+	maxDeviceLogPage     = 500
+)
+
+// logDeviceEvent пишет событие устройства в device_logs. deviceID == 0
+// означает, что топик не удалось сопоставить с устройством; событие всё
+// равно сохраняется, источник виден в data.
+func logDeviceEvent(deviceID int, action string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger("devicelog").Error("Ошибка сериализации события", "action", action, "error", err)
+		return
+	}
+
+	var id interface{}
+	if deviceID > 0 {
+		id = deviceID
+	}
+	if _, err := psqlConn.Exec(
+		"INSERT INTO device_logs (device_id, action, data) VALUES ($1, $2, $3)",
+		id, action, string(payload),
+	); err != nil {
+		logger("devicelog").Error("Ошибка записи события", "action", action, "device_id", deviceID, "error", err)
+	}
+}
+
+// deviceEventRecorder — обработчик показаний: пишет смену состояния
+// (поле "state") и ошибки (поле "error") из сообщений устройств.
+type deviceEventRecorder struct {
+	mu    sync.Mutex
+	state map[int]string
+}
+
+var deviceEvents = &deviceEventRecorder{state: map[int]string{}}
+
+func (d *deviceEventRecorder) observe(reading SensorReading) {
+	info, ok := devices.resolve(reading.Topic)
+	if !ok {
+		return
+	}
+
+	if msg, ok := reading.Payload["error"]; ok && msg != nil && msg != "" && msg != false {
+		logDeviceEvent(info.ID, deviceEventError, map[string]interface{}{
+			"topic":   reading.Topic,
+			"error":   msg,
+			"payload": reading.Payload,
+		})
+	}
+
+	raw, ok := reading.Payload["state"]
+	if !ok || raw == nil {
+		return
+	}
+	state := fmt.Sprint(raw)
+	d.mu.Lock()
+	previous, known := d.state[info.ID]
+	d.state[info.ID] = state
+	d.mu.Unlock()
+	if known && previous == state {
+		return
+	}
+
+	data := map[string]interface{}{"topic": reading.Topic, "state": state}
+	if known {
+		data["previous"] = previous
+	}
+	logDeviceEvent(info.ID, deviceEventState, data)
+}
+
+// ============ ХРАНЕНИЕ ============
+
+// pruneDeviceLogs удаляет строки старше срока хранения пачками по
+// logRetentionBatch. С архивом пачка удаляется в той же транзакции, что
+// и выгружается: если запись архива не удалась, строки остаются.
+func pruneDeviceLogs() {
+	days := cfg.DeviceLog.RetentionDays
+	if days == 0 {
+		return
+	}
+	archive := cfg.DeviceLog.Archive
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	total := 0
+	for {
+		n, err := pruneDeviceLogBatch(cutoff, archive)
+		if err != nil {
+			logger("devicelog").Error("Ошибка очистки", "error", err)
+			break
+		}
+		total += n
+		if n < logRetentionBatch {
+			break
+		}
+	}
+	if total > 0 {
+		logger("devicelog").Info("Удалены старые события", "retention_days", days, "deleted", total, "archive", archive)
+	}
+}
+
+func pruneDeviceLogBatch(cutoff time.Time, archive bool) (int, error) {
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM device_logs WHERE id IN (
+			SELECT id FROM device_logs WHERE timestamp < $1 ORDER BY id LIMIT $2)
+		RETURNING id, device_id, action, timestamp, data`, cutoff, logRetentionBatch)
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	n, lastID := 0, 0
+	for rows.Next() {
+		e, err := scanDeviceLog(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if archive {
+			enc.Encode(e)
+		}
+		n++
+		if e.ID > lastID {
+			lastID = e.ID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if archive {
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+		key := fmt.Sprintf("device-logs/%s-%d.jsonl.gz", time.Now().Format("2006-01-02"), lastID)
+		if _, err := blobs.Put(context.Background(), key, &buf); err != nil {
+			return 0, fmt.Errorf("архив %s: %w", key, err)
+		}
+	}
+	return n, tx.Commit()
+}
+
+func startDeviceLogRetention(ctx context.Context) {
+	// Первый проход — после старта, чтобы не задерживать инициализацию.
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Minute):
+	}
+	pruneDeviceLogs()
+
+	ticker := time.NewTicker(logRetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruneDeviceLogs()
+		}
+	}
+}
+
+// ============ REST API HANDLERS - DEVICE LOGS ============
+
+type DeviceLogEntry struct {
+	ID         int             `json:"id"`
+	DeviceID   *int            `json:"device_id,omitempty"`
+	DeviceName string          `json:"device_name,omitempty"`
+	Action     string          `json:"action"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+func scanDeviceLog(row interface{ Scan(...interface{}) error }, extra ...interface{}) (DeviceLogEntry, error) {
+	var e DeviceLogEntry
+	var deviceID sql.NullInt64
+	var action, data sql.NullString
+	var ts sql.NullTime
+	err := row.Scan(append([]interface{}{&e.ID, &deviceID, &action, &ts, &data}, extra...)...)
+	e.DeviceID = nullIntPtr(deviceID)
+	e.Action, e.Timestamp = action.String, ts.Time
+	if data.Valid && json.Valid([]byte(data.String)) {
+		e.Data = json.RawMessage(data.String)
+	} else if data.Valid {
+		e.Data, _ = json.Marshal(data.String)
+	}
+	return e, err
+}
+
+// getDeviceLogs — события устройства (?device_id=) или всех устройств
+// здания (?building_id=). Фильтры: action (через запятую), from, to.
+// Постраничный вывод от новых к старым: limit и cursor (next_cursor из
+// предыдущего ответа). Период ограничен окном истории тарифа.
+func getDeviceLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	where, args := []string{}, []interface{}{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if v := q.Get("device_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "device_id должен быть числом", http.StatusBadRequest)
+			return
+		}
+		add("l.device_id = $%d", id)
+	} else if v := q.Get("building_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "building_id должен быть числом", http.StatusBadRequest)
+			return
+		}
+		add("r.building_id = $%d", id)
+	} else {
+		http.Error(w, "device_id или building_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	if v := q.Get("action"); v != "" {
+		add("l.action = ANY(string_to_array($%d, ','))", v)
+	}
+
+	to := time.Now()
+	var from time.Time
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	var ok bool
+	if from, ok = historyWindow(w, r, from, to); !ok {
+		return
+	}
+	if !from.IsZero() {
+		add("l.timestamp >= $%d", from)
+	}
+	add("l.timestamp <= $%d", to)
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "cursor должен быть числом", http.StatusBadRequest)
+			return
+		}
+		add("l.id < $%d", cursor)
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxDeviceLogPage {
+			http.Error(w, fmt.Sprintf("limit: от 1 до %d", maxDeviceLogPage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := psqlConn.Query(`SELECT l.id, l.device_id, l.action, l.timestamp, l.data, COALESCE(d.name, '')
+		FROM device_logs l
+		LEFT JOIN device d ON d.id = l.device_id
+		LEFT JOIN room r ON r.id = d.room_id
+		WHERE `+strings.Join(where, " AND ")+fmt.Sprintf(" ORDER BY l.id DESC LIMIT %d", limit), args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []DeviceLogEntry{}
+	for rows.Next() {
+		var name string
+		e, err := scanDeviceLog(rows, &name)
+		if err != nil {
+			continue
+		}
+		e.DeviceName = name
+		items = append(items, e)
+	}
+
+	resp := map[string]interface{}{"items": items}
+	if len(items) == limit {
+		resp["next_cursor"] = items[len(items)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ СПРАВОЧНИК УСТРОЙСТВ ============
+//
+// MQTT-топики ничего не знают о таблице device. Связь задаётся колонкой
+// device.sensor_key (sensor_id из топика, например "temperature/kitchen").
+// Кроме того, понимаются соглашения "…/device_<id>" для датчиков и
+// controllers/status/<id> для контроллеров.
+
+const (
+	directoryRefresh = time.Minute
+	// directoryRetry — пауза перед повтором после ошибки обновления.
+	directoryRetry = 10 * time.Second
+)
+
+type DeviceInfo struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	RoomID     int    `json:"room_id"`
+	BuildingID int    `json:"building_id"`
+	SensorKey  string `json:"sensor_key,omitempty"`
+}
+
+type deviceDirectory struct {
+	mu       sync.RWMutex
+	byID     map[int]DeviceInfo
+	byKey    map[string]int
+	loadedAt time.Time
+}
+
+var devices = &deviceDirectory{byID: map[int]DeviceInfo{}, byKey: map[string]int{}}
+
+func (d *deviceDirectory) refresh() error {
+	rows, err := psqlConn.Query(`SELECT d.id, d.name, COALESCE(d.room_id, 0), COALESCE(r.building_id, 0), COALESCE(d.sensor_key, '')
+		FROM device d LEFT JOIN room r ON r.id = d.room_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := map[int]DeviceInfo{}
+	byKey := map[string]int{}
+	for rows.Next() {
+		var info DeviceInfo
+		if err := rows.Scan(&info.ID, &info.Name, &info.RoomID, &info.BuildingID, &info.SensorKey); err != nil {
+			continue
+		}
+		byID[info.ID] = info
+		if info.SensorKey != "" {
+			byKey[info.SensorKey] = info.ID
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.byID, d.byKey, d.loadedAt = byID, byKey, time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+// ensureFresh обновляет устаревший справочник. Обновление занимает один
+// вызывающий: остальные до его окончания работают со старыми данными, а
+// при ошибке следующая попытка будет не раньше чем через directoryRetry,
+// а не на каждом сообщении MQTT.
+func (d *deviceDirectory) ensureFresh() {
+	d.mu.RLock()
+	stale := time.Since(d.loadedAt) > directoryRefresh
+	d.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	d.mu.Lock()
+	if time.Since(d.loadedAt) <= directoryRefresh {
+		d.mu.Unlock()
+		return
+	}
+	d.loadedAt = time.Now().Add(directoryRetry - directoryRefresh)
+	d.mu.Unlock()
+
+	if err := d.refresh(); err != nil {
+		logger("devices").Error("Ошибка обновления справочника", "error", err, "retry_in", directoryRetry)
+	}
+}
+
+// get возвращает устройство по ID.
+func (d *deviceDirectory) get(id int) (DeviceInfo, bool) {
+	d.ensureFresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	info, ok := d.byID[id]
+	return info, ok
+}
+
+// resolve находит устройство по MQTT-топику.
+func (d *deviceDirectory) resolve(topic string) (DeviceInfo, bool) {
+	d.ensureFresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if rest, ok := strings.CutPrefix(topic, "controllers/status/"); ok {
+		if id, err := strconv.Atoi(rest); err == nil {
+			info, ok := d.byID[id]
+			return info, ok
+		}
+		return DeviceInfo{}, false
+	}
+
+	sensorID := strings.TrimPrefix(topic, "sensors/")
+	if id, ok := d.byKey[sensorID]; ok {
+		return d.byID[id], true
+	}
+	last := sensorID[strings.LastIndex(sensorID, "/")+1:]
+	if id, err := strconv.Atoi(strings.TrimPrefix(last, "device_")); err == nil && strings.HasPrefix(last, "device_") {
+		info, ok := d.byID[id]
+		return info, ok
+	}
+	return DeviceInfo{}, false
+}
+
+// all — снимок справочника.
+func (d *deviceDirectory) all() []DeviceInfo {
+	d.ensureFresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := make([]DeviceInfo, 0, len(d.byID))
+	for _, info := range d.byID {
+		list = append(list, info)
+	}
+	return list
+}
+
+func (d *deviceDirectory) invalidate() {
+	d.mu.Lock()
+	d.loadedAt = time.Time{}
+	d.mu.Unlock()
+}
@@ -0,0 +1,805 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// ============ ЭНЕРГОПОТРЕБЛЕНИЕ ============
+//
+// Потребление считается по каждому устройству и пишется в InfluxDB
+// (measurement "energy", поле wh — прирост в Вт·ч) с тегами device_id,
+// room_id и building_id, так что суммы по комнате и зданию получаются
+// группировкой. Источники, по убыванию точности:
+//   - счётчик: поле "energy" (кВт·ч нарастающим итогом) в сообщении
+//     или value в sensors/energy/...;
+//   - измеренная мощность: поле "power" (Вт) или value в sensors/power/...,
+//     интегрируется по времени;
+//   - оценка: время во включённом состоянии × device.rated_power_w.
+// Для включённых устройств без сообщений прирост дописывается раз в
+// energyFlushInterval, чтобы отчёт не зависел от частоты публикаций.
+
+const (
+	energyMeasurement   = "energy"
+	energyFlushInterval = time.Minute
+	// Измеренная мощность считается действующей не дольше этого после
+	// последнего показания: замолчавшее устройство не начисляется вечно.
+	energyMaxGap = 15 * time.Minute
+)
+
+type deviceEnergy struct {
+	on        bool
+	powerW    float64   // последняя измеренная мощность
+	powerAt   time.Time // время последнего показания мощности
+	measured  bool      // мощность приходит от устройства
+	counter   float64   // последнее показание счётчика, кВт·ч
+	hasMeter  bool
+	accounted time.Time // до какого момента потребление уже записано
+}
+
+type energyTracker struct {
+	mu      sync.Mutex
+	devices map[int]*deviceEnergy
+	rated   map[int]float64
+}
+
+var energy = &energyTracker{devices: map[int]*deviceEnergy{}, rated: map[int]float64{}}
+
+func initEnergy() {
+	rows, err := psqlConn.Query(`SELECT d.id, COALESCE(d.rated_power_w, 0), COALESCE(c.state, '')
+		FROM device d LEFT JOIN controller c ON c.device_id = d.id`)
+	if err != nil {
+		logger("energy").Error("Ошибка загрузки устройств", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	energy.mu.Lock()
+	defer energy.mu.Unlock()
+	for rows.Next() {
+		var id int
+		var rated float64
+		var state string
+		if rows.Scan(&id, &rated, &state) != nil {
+			continue
+		}
+		energy.rated[id] = rated
+		energy.devices[id] = &deviceEnergy{on: state == "on", accounted: now}
+	}
+	logger("energy").Info("Учёт энергии инициализирован")
+}
+
+func (t *energyTracker) entryLocked(deviceID int, now time.Time) *deviceEnergy {
+	e, ok := t.devices[deviceID]
+	if !ok {
+		e = &deviceEnergy{accounted: now}
+		t.devices[deviceID] = e
+	}
+	return e
+}
+
+// accrueLocked возвращает прирост (Вт·ч) с момента последнего учёта по
+// мощности — измеренной или номинальной — и сдвигает отметку учёта.
+// Измеренная мощность интегрируется только до powerAt + energyMaxGap.
+func (t *energyTracker) accrueLocked(deviceID int, e *deviceEnergy, now time.Time) (float64, string) {
+	from := e.accounted
+	e.accounted = now
+	if !now.After(from) || e.hasMeter {
+		return 0, ""
+	}
+	if e.measured {
+		until := now
+		if limit := e.powerAt.Add(energyMaxGap); until.After(limit) {
+			until = limit
+		}
+		if !until.After(from) {
+			return 0, ""
+		}
+		return e.powerW * until.Sub(from).Hours(), "measured"
+	}
+	dt := now.Sub(from)
+	if e.on && t.rated[deviceID] > 0 {
+		return t.rated[deviceID] * dt.Hours(), "estimated"
+	}
+	return 0, ""
+}
+
+func isOnState(v interface{}) (bool, bool) {
+	switch s := v.(type) {
+	case string:
+		switch strings.ToLower(s) {
+		case "on", "вкл", "true", "1":
+			return true, true
+		case "off", "выкл", "false", "0":
+			return false, true
+		}
+	case bool:
+		return s, true
+	case float64:
+		return s > 0, true
+	}
+	return false, false
+}
+
+// observe — обработчик показаний датчиков (см. readingHandlers).
+func (t *energyTracker) observe(reading SensorReading) {
+	info, ok := devices.resolve(reading.Topic)
+	if !ok {
+		return
+	}
+	power, hasPower := numericValue(reading.Payload["power"])
+	meter, hasMeter := numericValue(reading.Payload["energy"])
+	switch _, sensorType := livenessKey(reading.Topic); sensorType {
+	case "power":
+		power, hasPower = reading.Value, reading.HasValue
+	case "energy":
+		meter, hasMeter = reading.Value, reading.HasValue
+	}
+	state, hasState := isOnState(reading.Payload["state"])
+	if !hasPower && !hasMeter && !hasState {
+		return
+	}
+
+	t.mu.Lock()
+	e := t.entryLocked(info.ID, reading.Time)
+	wh, source := t.accrueLocked(info.ID, e, reading.Time)
+	if hasMeter {
+		if e.hasMeter && meter >= e.counter {
+			wh, source = (meter-e.counter)*1000, "meter"
+		}
+		// Сброс счётчика (meter < counter) — начинаем отсчёт заново.
+		e.counter, e.hasMeter = meter, true
+	}
+	if hasPower {
+		e.powerW, e.powerAt, e.measured = power, reading.Time, true
+	}
+	if hasState {
+		e.on = state
+	}
+	t.mu.Unlock()
+
+	writeEnergy(info, wh, power, source, reading.Time)
+}
+
+// setState вызывается при отправке команды устройству: для устройств без
+// обратной связи это единственный источник состояния.
+func (t *energyTracker) setState(deviceID int, command string) {
+	on, ok := isOnState(command)
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	e := t.entryLocked(deviceID, now)
+	wh, source := t.accrueLocked(deviceID, e, now)
+	e.on = on
+	t.mu.Unlock()
+
+	if info, ok := devices.get(deviceID); ok {
+		writeEnergy(info, wh, 0, source, now)
+	}
+}
+
+func (t *energyTracker) setRated(deviceID int, watts float64) {
+	t.mu.Lock()
+	t.rated[deviceID] = watts
+	t.mu.Unlock()
+}
+
+// pending — число устройств, чей прирост копится до следующего flush
+// (для отчёта о здоровье).
+func (t *energyTracker) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, stale := 0, time.Now().Add(-energyMaxGap)
+	for id, e := range t.devices {
+		if !e.hasMeter && ((e.measured && e.powerW > 0 && e.powerAt.After(stale)) || (!e.measured && e.on && t.rated[id] > 0)) {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *energyTracker) flush(now time.Time) {
+	type increment struct {
+		deviceID int
+		wh       float64
+		source   string
+	}
+	var pending []increment
+
+	t.mu.Lock()
+	for id, e := range t.devices {
+		if wh, source := t.accrueLocked(id, e, now); wh > 0 {
+			pending = append(pending, increment{id, wh, source})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, inc := range pending {
+		if info, ok := devices.get(inc.deviceID); ok {
+			writeEnergy(info, inc.wh, 0, inc.source, now)
+		}
+	}
+}
+
+// startEnergyAccounting дописывает прирост раз в energyFlushInterval и
+// последний раз — при остановке, чтобы накопленное не потерялось.
+func startEnergyAccounting(ctx context.Context) {
+	ticker := time.NewTicker(energyFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			energy.flush(time.Now())
+			return
+		case now := <-ticker.C:
+			energy.flush(now)
+		}
+	}
+}
+
+func writeEnergy(info DeviceInfo, wh, powerW float64, source string, at time.Time) {
+	if wh <= 0 {
+		return
+	}
+	point := influxdb2.NewPointWithMeasurement(energyMeasurement).
+		AddTag("device_id", strconv.Itoa(info.ID)).
+		AddTag("room_id", strconv.Itoa(info.RoomID)).
+		AddTag("building_id", strconv.Itoa(info.BuildingID)).
+		AddTag("source", source).
+		AddField("wh", wh).
+		SetTime(at)
+	if powerW > 0 {
+		point.AddField("power_w", powerW)
+	}
+
+	writeAPI := influxClient.WriteAPIBlocking(cfg.Influx.Org, cfg.Influx.Bucket)
+	if err := writeAPI.WritePoint(context.Background(), point); err != nil {
+		logger("energy").Error("Ошибка записи в InfluxDB", "error", err)
+		influxWriteErrors.WithLabelValues("energy_write_failed").Inc()
+	}
+}
+
+// ============ ТАРИФЫ НА ЭЛЕКТРОЭНЕРГИЮ ============
+
+// EnergyTariff — ставка, действующая с ValidFrom. Если задана NightRate,
+// с NightStart до NightEnd (местное время здания) действует она.
+type EnergyTariff struct {
+	ID         int      `json:"id"`
+	BuildingID *int     `json:"building_id,omitempty"` // nil — тариф по умолчанию
+	Name       string   `json:"name"`
+	ValidFrom  string   `json:"valid_from"` // YYYY-MM-DD
+	DayRate    float64  `json:"day_rate"`   // руб. за кВт·ч
+	NightRate  *float64 `json:"night_rate,omitempty"`
+	NightStart string   `json:"night_start,omitempty"` // HH:MM
+	NightEnd   string   `json:"night_end,omitempty"`
+
+	validFrom  time.Time
+	nightStart int // минуты от полуночи
+	nightEnd   int
+}
+
+// clockMinutes — "HH:MM" в минутах от полуночи.
+func clockMinutes(s string) (int, error) {
+	h, m, ok := parseClock(s)
+	if !ok {
+		return 0, fmt.Errorf("время %q: ожидается HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+func (t *EnergyTariff) prepare(loc *time.Location) error {
+	var err error
+	if t.validFrom, err = time.ParseInLocation("2006-01-02", t.ValidFrom, loc); err != nil {
+		return fmt.Errorf("valid_from: ожидается YYYY-MM-DD")
+	}
+	if t.NightRate == nil {
+		return nil
+	}
+	if t.NightStart == "" {
+		t.NightStart = "23:00"
+	}
+	if t.NightEnd == "" {
+		t.NightEnd = "07:00"
+	}
+	if t.nightStart, err = clockMinutes(t.NightStart); err != nil {
+		return err
+	}
+	t.nightEnd, err = clockMinutes(t.NightEnd)
+	return err
+}
+
+// rateAt возвращает ставку и признак ночной зоны для момента at.
+func (t *EnergyTariff) rateAt(at time.Time) (float64, bool) {
+	if t.NightRate == nil {
+		return t.DayRate, false
+	}
+	m := at.Hour()*60 + at.Minute()
+	night := m >= t.nightStart || m < t.nightEnd
+	if t.nightStart < t.nightEnd {
+		night = m >= t.nightStart && m < t.nightEnd
+	}
+	if night {
+		return *t.NightRate, true
+	}
+	return t.DayRate, false
+}
+
+// loadTariffs возвращает тарифы здания (или общие, если своих нет),
+// отсортированные по дате начала действия.
+func loadTariffs(buildingID int, loc *time.Location) ([]EnergyTariff, error) {
+	rows, err := psqlConn.Query(`SELECT id, building_id, name, TO_CHAR(valid_from, 'YYYY-MM-DD'), day_rate, night_rate,
+			COALESCE(TO_CHAR(night_start, 'HH24:MI'), ''), COALESCE(TO_CHAR(night_end, 'HH24:MI'), '')
+		FROM energy_tariffs
+		WHERE building_id = $1 OR (building_id IS NULL AND NOT EXISTS (SELECT 1 FROM energy_tariffs WHERE building_id = $1))
+		ORDER BY valid_from`, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tariffs []EnergyTariff
+	for rows.Next() {
+		var t EnergyTariff
+		var bid sql.NullInt64
+		var night sql.NullFloat64
+		if err := rows.Scan(&t.ID, &bid, &t.Name, &t.ValidFrom, &t.DayRate, &night, &t.NightStart, &t.NightEnd); err != nil {
+			return nil, err
+		}
+		t.BuildingID = nullIntPtr(bid)
+		if night.Valid {
+			t.NightRate = &night.Float64
+		}
+		if err := t.prepare(loc); err != nil {
+			return nil, err
+		}
+		tariffs = append(tariffs, t)
+	}
+	return tariffs, rows.Err()
+}
+
+func tariffAt(tariffs []EnergyTariff, at time.Time) *EnergyTariff {
+	var current *EnergyTariff
+	for i := range tariffs {
+		if !tariffs[i].validFrom.After(at) {
+			current = &tariffs[i]
+		}
+	}
+	return current
+}
+
+// ============ ОТЧЁТЫ ============
+
+type EnergyLine struct {
+	DeviceID int     `json:"device_id,omitempty"`
+	RoomID   int     `json:"room_id,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	KWh      float64 `json:"kwh"`
+	DayKWh   float64 `json:"day_kwh"`
+	NightKWh float64 `json:"night_kwh"`
+	Cost     float64 `json:"cost"`
+}
+
+type EnergyReport struct {
+	BuildingID int          `json:"building_id"`
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	Currency   string       `json:"currency"`
+	KWh        float64      `json:"kwh"`
+	Cost       float64      `json:"cost"`
+	Devices    []EnergyLine `json:"devices"`
+	Rooms      []EnergyLine `json:"rooms"`
+	Unpriced   float64      `json:"unpriced_kwh,omitempty"` // потребление без действующего тарифа
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// buildEnergyReport суммирует 15-минутные окна потребления по устройствам
+// и применяет к каждому окну тариф и зону, действующие в его начале.
+func buildEnergyReport(buildingID int, from, to time.Time) (*EnergyReport, error) {
+	bl, err := loadBuildingLocation(psqlConn, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	loc := bl.Loc
+	tariffs, err := loadTariffs(buildingID, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+        from(bucket: "%s")
+        |> range(start: %s, stop: %s)
+        |> filter(fn: (r) => r._measurement == "%s" and r._field == "wh" and r.building_id == "%d")
+        |> group(columns: ["device_id", "room_id"])
+        |> aggregateWindow(every: 15m, fn: sum, createEmpty: false, timeSrc: "_start")
+    `, cfg.Influx.Bucket, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), energyMeasurement, buildingID)
+
+	result, err := influxClient.QueryAPI(cfg.Influx.Org).Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &EnergyReport{BuildingID: buildingID, From: from, To: to, Currency: billingCurrency}
+	byDevice := map[int]*EnergyLine{}
+	byRoom := map[int]*EnergyLine{}
+	for result.Next() {
+		rec := result.Record()
+		wh, ok := numericValue(rec.Value())
+		if !ok || wh <= 0 {
+			continue
+		}
+		deviceID, _ := strconv.Atoi(fmt.Sprint(rec.ValueByKey("device_id")))
+		roomID, _ := strconv.Atoi(fmt.Sprint(rec.ValueByKey("room_id")))
+		kwh := wh / 1000
+
+		line, ok := byDevice[deviceID]
+		if !ok {
+			info, _ := devices.get(deviceID)
+			line = &EnergyLine{DeviceID: deviceID, RoomID: roomID, Name: info.Name}
+			byDevice[deviceID] = line
+		}
+		room, ok := byRoom[roomID]
+		if !ok {
+			room = &EnergyLine{RoomID: roomID}
+			byRoom[roomID] = room
+		}
+
+		cost, night := 0.0, false
+		if tariff := tariffAt(tariffs, rec.Time().In(loc)); tariff != nil {
+			var rate float64
+			rate, night = tariff.rateAt(rec.Time().In(loc))
+			cost = kwh * rate
+		} else {
+			report.Unpriced += kwh
+		}
+
+		for _, l := range []*EnergyLine{line, room} {
+			l.KWh += kwh
+			l.Cost += cost
+			if night {
+				l.NightKWh += kwh
+			} else {
+				l.DayKWh += kwh
+			}
+		}
+		report.KWh += kwh
+		report.Cost += cost
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	roomNames := map[int]string{}
+	if rows, err := psqlConn.Query("SELECT id, name FROM room WHERE building_id = $1", buildingID); err == nil {
+		for rows.Next() {
+			var id int
+			var name string
+			if rows.Scan(&id, &name) == nil {
+				roomNames[id] = name
+			}
+		}
+		rows.Close()
+	}
+
+	report.Devices, report.Rooms = []EnergyLine{}, []EnergyLine{}
+	for _, l := range byDevice {
+		report.Devices = append(report.Devices, roundLine(*l))
+	}
+	for _, l := range byRoom {
+		l.Name = roomNames[l.RoomID]
+		report.Rooms = append(report.Rooms, roundLine(*l))
+	}
+	sortEnergyLines(report.Devices)
+	sortEnergyLines(report.Rooms)
+	report.KWh, report.Cost, report.Unpriced = round2(report.KWh), round2(report.Cost), round2(report.Unpriced)
+	return report, nil
+}
+
+func roundLine(l EnergyLine) EnergyLine {
+	l.KWh, l.DayKWh, l.NightKWh, l.Cost = round2(l.KWh), round2(l.DayKWh), round2(l.NightKWh), round2(l.Cost)
+	return l
+}
+
+// sortEnergyLines — по убыванию стоимости, затем потребления.
+func sortEnergyLines(lines []EnergyLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Cost != lines[j].Cost {
+			return lines[i].Cost > lines[j].Cost
+		}
+		return lines[i].KWh > lines[j].KWh
+	})
+}
+
+// ============ REST API HANDLERS - ENERGY ============
+
+// getEnergyReport — отчёт о стоимости за месяц (?building_id=, ?month=2026-10,
+// по умолчанию текущий) или за произвольный период (?from=, ?to= в RFC3339).
+func getEnergyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buildingID, err := strconv.Atoi(r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, "building_id parameter required", http.StatusBadRequest)
+		return
+	}
+	bl, err := loadBuildingLocation(psqlConn, buildingID)
+	if err != nil {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
+	loc := bl.Loc
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if v := r.URL.Query().Get("month"); v != "" {
+		if from, err = time.ParseInLocation("2006-01", v, loc); err != nil {
+			http.Error(w, "month: ожидается YYYY-MM", http.StatusBadRequest)
+			return
+		}
+	}
+	to := from.AddDate(0, 1, 0)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+		to = time.Now()
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "to должен быть позже from", http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if from, ok = historyWindow(w, r, from, to); !ok {
+		return
+	}
+
+	report, err := buildEnergyReport(buildingID, from, to)
+	if err != nil {
+		logger("energy").ErrorContext(r.Context(), "Ошибка построения отчёта", "building_id", buildingID, "error", err)
+		http.Error(w, fmt.Sprintf("Ошибка построения отчёта: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// getEnergyUsage — ряд потребления (кВт·ч за окно) по устройству, комнате
+// или зданию (?device_id= | ?room_id= | ?building_id=, ?from=, ?to=, ?every=1h).
+func getEnergyUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var tag, id string
+	for _, t := range []string{"device_id", "room_id", "building_id"} {
+		if v := q.Get(t); v != "" {
+			tag, id = t, v
+			break
+		}
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		http.Error(w, "device_id, room_id или building_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	every := time.Hour
+	if v := q.Get("every"); v != "" {
+		if every, err = time.ParseDuration(v); err != nil || every < 15*time.Minute {
+			http.Error(w, "every: длительность не меньше 15m", http.StatusBadRequest)
+			return
+		}
+	}
+	var ok bool
+	if from, ok = historyWindow(w, r, from, to); !ok {
+		return
+	}
+
+	query := fmt.Sprintf(`
+        from(bucket: "%s")
+        |> range(start: %s, stop: %s)
+        |> filter(fn: (r) => r._measurement == "%s" and r._field == "wh" and r.%s == "%s")
+        |> group()
+        |> aggregateWindow(every: %s, fn: sum, createEmpty: true, timeSrc: "_start")
+    `, cfg.Influx.Bucket, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), energyMeasurement, tag, id, every)
+
+	result, err := influxClient.QueryAPI(cfg.Influx.Org).Query(context.Background(), query)
+	if err != nil {
+		logger("energy").ErrorContext(r.Context(), "Ошибка запроса к InfluxDB", "error", err)
+		http.Error(w, "InfluxDB error", http.StatusInternalServerError)
+		return
+	}
+
+	type UsagePoint struct {
+		Time time.Time `json:"time"`
+		KWh  float64   `json:"kwh"`
+	}
+	points := []UsagePoint{}
+	total := 0.0
+	for result.Next() {
+		wh, _ := numericValue(result.Record().Value())
+		points = append(points, UsagePoint{Time: result.Record().Time(), KWh: round2(wh / 1000)})
+		total += wh / 1000
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		tag:     id,
+		"from":  from,
+		"to":    to,
+		"kwh":   round2(total),
+		"usage": points,
+	})
+}
+
+func getEnergyTariffs(w http.ResponseWriter, r *http.Request) {
+	buildingID, _ := strconv.Atoi(r.URL.Query().Get("building_id"))
+	loc := billingLocation()
+	if buildingID != 0 {
+		if bl, err := loadBuildingLocation(psqlConn, buildingID); err == nil {
+			loc = bl.Loc
+		}
+	}
+
+	tariffs, err := loadTariffs(buildingID, loc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if tariffs == nil {
+		tariffs = []EnergyTariff{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tariffs)
+}
+
+func createEnergyTariff(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var t EnergyTariff
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if t.Name == "" || t.DayRate <= 0 || (t.NightRate != nil && *t.NightRate <= 0) {
+		http.Error(w, "name и положительные day_rate/night_rate обязательны", http.StatusBadRequest)
+		return
+	}
+	if err := t.prepare(time.UTC); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var nightStart, nightEnd interface{}
+	if t.NightRate != nil {
+		nightStart, nightEnd = t.NightStart, t.NightEnd
+	}
+	err := psqlConn.QueryRow(`INSERT INTO energy_tariffs (building_id, name, valid_from, day_rate, night_rate, night_start, night_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		t.BuildingID, t.Name, t.ValidFrom, t.DayRate, t.NightRate, nightStart, nightEnd).Scan(&t.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	auditRequest(r, "tariff.create", "tariff", t.ID, nil, t)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func deleteEnergyTariff(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	err := psqlConn.QueryRow("DELETE FROM energy_tariffs WHERE id = $1 RETURNING name", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Тариф не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditRequest(r, "tariff.delete", "tariff", id, map[string]string{"name": name}, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setDevicePower задаёт номинальную мощность устройства (?id=, {"rated_power_w": 2000}).
+func setDevicePower(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		RatedPowerW float64 `json:"rated_power_w"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RatedPowerW < 0 {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	var previous sql.NullFloat64
+	err = psqlConn.QueryRow(`UPDATE device d SET rated_power_w = NULLIF($1, 0) FROM device old
+		WHERE old.id = d.id AND d.id = $2 RETURNING old.rated_power_w`, req.RatedPowerW, id).Scan(&previous)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Устройство не найдено", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	energy.setRated(id, req.RatedPowerW)
+	logDeviceEvent(id, deviceEventConfig, map[string]interface{}{
+		"change": "rated_power_w", "previous": previous.Float64, "value": req.RatedPowerW,
+	})
+	auditRequest(r, "device.power", "device", id,
+		map[string]float64{"rated_power_w": previous.Float64}, map[string]float64{"rated_power_w": req.RatedPowerW})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "rated_power_w": req.RatedPowerW})
+}
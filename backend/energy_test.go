@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// ============ УЧЁТ ЭНЕРГИИ ============
+
+func TestAccrueStopsAfterPowerGoesStale(t *testing.T) {
+	tr := &energyTracker{devices: map[int]*deviceEnergy{}, rated: map[int]float64{}}
+	start := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	e := &deviceEnergy{accounted: start, powerW: 1200, powerAt: start, measured: true}
+
+	// Ежеминутный flush: первые energyMaxGap начисляются, дальше — нет.
+	total := 0.0
+	for at := start.Add(time.Minute); !at.After(start.Add(2 * time.Hour)); at = at.Add(time.Minute) {
+		wh, _ := tr.accrueLocked(1, e, at)
+		total += wh
+	}
+	if want := 1200 * energyMaxGap.Hours(); math.Abs(total-want) > 1e-9 {
+		t.Errorf("за 2 часа без показаний начислено %.2f Вт·ч, ожидалось %.2f", total, want)
+	}
+
+	// Отрезок, пересекающий границу, обрезается по ней.
+	e = &deviceEnergy{accounted: start.Add(10 * time.Minute), powerW: 600, powerAt: start, measured: true}
+	if wh, source := tr.accrueLocked(1, e, start.Add(20*time.Minute)); math.Abs(wh-50) > 1e-9 || source != "measured" {
+		t.Errorf("отрезок через границу: %.2f Вт·ч (%s), ожидалось 50", wh, source)
+	}
+}
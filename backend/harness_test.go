@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"smart-home/internal/store/memory"
+	"smart-home/internal/store/paho"
+	"smart-home/internal/store/postgres"
+)
+
+// ============ ТЕСТОВЫЙ СТЕНД ============
+//
+// Стенд поднимает в процессе MQTT-брокер (mochi-mqtt), клиента бэкенда и
+// HTTP API на App. Телеметрия хранится в памяти. Пользователи, здания и
+// устройства — тоже в памяти, а если задана TEST_DATABASE_URL, то в этой
+// PostgreSQL: схема накатывается миграциями и откатывается после теста
+// (база должна быть пустой и одноразовой).
+
+func TestMain(m *testing.M) {
+	initMetrics()
+	cfg.Auth.JWTSecret = "test-secret"
+	if os.Getenv("TEST_VERBOSE") == "" {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+type testBroker struct {
+	srv  *mqttserver.Server
+	addr string
+	once sync.Once
+}
+
+// startBroker запускает брокер на addr ("" — на свободном порту).
+func startBroker(t *testing.T, addr string) *testBroker {
+	t.Helper()
+	if addr == "" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("свободный порт: %v", err)
+		}
+		addr = l.Addr().String()
+		l.Close()
+	}
+
+	srv := mqttserver.New(&mqttserver.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("брокер: %v", err)
+	}
+	if err := srv.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatalf("брокер: %v", err)
+	}
+	if err := srv.Serve(); err != nil {
+		t.Fatalf("брокер: %v", err)
+	}
+
+	b := &testBroker{srv: srv, addr: addr}
+	t.Cleanup(b.stop)
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.addr
+}
+
+// publish отправляет сообщение так, как его отправило бы устройство.
+func (b *testBroker) publish(t *testing.T, topic, payload string) {
+	t.Helper()
+	if err := b.srv.Publish(topic, []byte(payload), false, 1); err != nil {
+		t.Fatalf("публикация в %s: %v", topic, err)
+	}
+}
+
+// subscribed — есть ли у брокера подписчик на topic (кроме самого брокера).
+func (b *testBroker) subscribed(topic string) bool {
+	return len(b.srv.Topics.Subscribers(topic).Subscriptions) > 0
+}
+
+func (b *testBroker) stop() {
+	b.once.Do(func() { b.srv.Close() })
+}
+
+type harness struct {
+	t         *testing.T
+	broker    *testBroker
+	app       *App
+	client    mqtt.Client
+	telemetry *memory.TelemetryStore
+	api       *httptest.Server
+
+	mu       sync.Mutex
+	readings []SensorReading
+	audits   []string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	h := &harness{t: t, broker: startBroker(t, ""), telemetry: &memory.TelemetryStore{}}
+
+	h.app = &App{
+		Telemetry: h.telemetry,
+		Readings: []func(SensorReading){func(r SensorReading) {
+			// Собственный статус бэкенда (controllers/status/backend) тоже
+			// приходит по подписке — тестам нужны только показания датчиков.
+			if !strings.HasPrefix(r.Topic, "sensors/") {
+				return
+			}
+			h.mu.Lock()
+			h.readings = append(h.readings, r)
+			h.mu.Unlock()
+		}},
+		Audit: func(r *http.Request, action, targetType string, targetID, before, after interface{}) {
+			h.mu.Lock()
+			h.audits = append(h.audits, action)
+			h.mu.Unlock()
+		},
+		DeviceQuota: func(w http.ResponseWriter, r *http.Request) (int, string, func(), bool) {
+			userID, _, ok := userFromRequest(r)
+			if !ok {
+				http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+			}
+			return userID, "Базовый", func() {}, ok
+		},
+		DeviceCreated: func(Device) {},
+		SensorStatus:  func(string) (bool, bool) { return false, false },
+		HistoryWindow: func(w http.ResponseWriter, r *http.Request, from, to time.Time) (time.Time, bool) {
+			return from, true
+		},
+	}
+	h.useStores()
+
+	h.client = newMQTTClient(h.broker.url(), "smart-home-test", h.app)
+	if token := h.client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("подключение к брокеру: %v", token.Error())
+	}
+	t.Cleanup(func() { h.client.Disconnect(100) })
+	h.app.Publisher = &paho.Publisher{Client: h.client, QoS: 1}
+	h.waitSubscribed()
+
+	mux := http.NewServeMux()
+	h.app.routes(mux)
+	h.api = httptest.NewServer(apiHandler(mux))
+	t.Cleanup(h.api.Close)
+	return h
+}
+
+// useStores подключает хранилища: в памяти или в TEST_DATABASE_URL.
+func (h *harness) useStores() {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		h.app.Users = &memory.UserStore{}
+		h.app.Buildings = &memory.BuildingStore{}
+		h.app.Devices = &memory.DeviceStore{}
+		return
+	}
+
+	db := openTestDB(h.t, dsn)
+	h.app.Users = &postgres.UserStore{DB: db}
+	h.app.Buildings = &postgres.BuildingStore{DB: db}
+	h.app.Devices = &postgres.DeviceStore{DB: db}
+}
+
+// testDB — PostgreSQL из TEST_DATABASE_URL с накатанной схемой (psqlConn
+// указывает на неё); без переменной тест пропускается.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задана")
+	}
+	return openTestDB(t, dsn)
+}
+
+func openTestDB(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_URL: %v", err)
+	}
+	psqlConn = db
+	if err := migrateUp(); err != nil {
+		t.Fatalf("миграции: %v", err)
+	}
+	t.Cleanup(func() {
+		migrations, _ := loadMigrations()
+		if err := migrateDown(len(migrations)); err != nil {
+			t.Errorf("откат миграций: %v", err)
+		}
+		db.Close()
+	})
+	return db
+}
+
+// waitSubscribed ждёт, пока клиент бэкенда подпишется на все темы.
+func (h *harness) waitSubscribed() {
+	h.t.Helper()
+	eventually(h.t, "подписка на темы датчиков", func() bool {
+		for _, topic := range []string{"sensors/temperature/x", "sensors/motion/x", "controllers/status/x"} {
+			if !h.broker.subscribed(topic) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// restartBroker останавливает брокер и поднимает новый на том же адресе
+// (без сохранённых сессий и подписок).
+func (h *harness) restartBroker() {
+	h.t.Helper()
+	h.broker.stop()
+	eventually(h.t, "обрыв соединения", func() bool { return !h.client.IsConnectionOpen() })
+	h.broker = startBroker(h.t, h.broker.addr)
+}
+
+func (h *harness) received() []SensorReading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]SensorReading{}, h.readings...)
+}
+
+func (h *harness) auditActions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{}, h.audits...)
+}
+
+// call выполняет запрос к API стенда (body — JSON или nil) и декодирует
+// успешный ответ в out, если он не nil. Возвращает код ответа.
+func (h *harness) call(method, path string, header http.Header, body, out interface{}) int {
+	h.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatalf("%s %s: %v", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.api.URL+path, reader)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			h.t.Fatalf("%s %s: ответ не JSON: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func asRole(role string) http.Header {
+	return http.Header{"X-User-Role": {role}}
+}
+
+// eventually повторяет cond, пока она не станет истинной (не дольше 10 с).
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============ ЗДОРОВЬЕ СЕРВИСА ============
+//
+// Зависимости (PostgreSQL, InfluxDB, MQTT) проверяются в фоне раз в
+// healthCheckInterval, каждая со своим таймаутом; обработчики отдают
+// последний результат и сами никуда не ходят.
+//   - /livez — процесс жив и отвечает (для перезапуска контейнера);
+//   - /readyz — можно направлять запросы: не идёт остановка и доступна
+//     PostgreSQL, без которой API не работает;
+//   - /api/health — подробный отчёт: задержка, последняя ошибка и время
+//     последней успешной проверки по каждой зависимости, подписки MQTT и
+//     очереди записи. Статус ok — всё доступно, degraded — недоступна
+//     InfluxDB или MQTT (API работает, показания не принимаются),
+//     down — недоступна PostgreSQL.
+
+const (
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 3 * time.Second
+)
+
+const (
+	healthOK           = "ok"
+	healthDegraded     = "degraded"
+	healthDown         = "down"
+	healthShuttingDown = "shutting_down"
+)
+
+// healthProbe — проверка одной зависимости. critical: без неё сервис не
+// готов принимать запросы.
+type healthProbe struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type DependencyHealth struct {
+	Status      string     `json:"status"`
+	Critical    bool       `json:"critical"`
+	LatencyMs   float64    `json:"latency_ms"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	CheckedAt   time.Time  `json:"checked_at"`
+}
+
+type healthMonitor struct {
+	probes []healthProbe
+
+	mu      sync.RWMutex
+	results map[string]*DependencyHealth
+}
+
+func newHealthMonitor(probes ...healthProbe) *healthMonitor {
+	return &healthMonitor{probes: probes, results: map[string]*DependencyHealth{}}
+}
+
+// checkAll проверяет все зависимости параллельно.
+func (m *healthMonitor) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, probe := range m.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.check(ctx, probe)
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *healthMonitor) check(ctx context.Context, probe healthProbe) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	started := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- probe.check(ctx) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// Драйвер может не уважать контекст — не ждём его.
+		err = fmt.Errorf("таймаут проверки (%s)", healthCheckTimeout)
+	}
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dep, ok := m.results[probe.name]
+	if !ok {
+		dep = &DependencyHealth{Critical: probe.critical}
+		m.results[probe.name] = dep
+	}
+	wasUp := dep.Status == healthOK
+	dep.CheckedAt = now
+	dep.LatencyMs = float64(now.Sub(started).Microseconds()) / 1000
+	if err != nil {
+		dep.Status, dep.LastError, dep.LastErrorAt = healthDown, err.Error(), &now
+		if wasUp || !ok {
+			logger("health").Warn("Зависимость недоступна", "dependency", probe.name, "error", err)
+		}
+		return
+	}
+	dep.Status, dep.LastSuccess = healthOK, &now
+	if !wasUp && ok {
+		logger("health").Info("Зависимость снова доступна", "dependency", probe.name)
+	}
+}
+
+// run — фоновая задача (background.run): первая проверка сразу, затем
+// раз в healthCheckInterval.
+func (m *healthMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		m.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot — копия последних результатов. Если проверок ещё не было,
+// выполняет их (отчёт не должен начинаться с пустоты).
+func (m *healthMonitor) snapshot(ctx context.Context) map[string]DependencyHealth {
+	m.mu.RLock()
+	empty := len(m.results) == 0
+	m.mu.RUnlock()
+	if empty {
+		m.checkAll(ctx)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]DependencyHealth, len(m.results))
+	for name, dep := range m.results {
+		out[name] = *dep
+	}
+	return out
+}
+
+// overallHealth сводит результаты в общий статус.
+func overallHealth(deps map[string]DependencyHealth) string {
+	status := healthOK
+	for _, dep := range deps {
+		if dep.Status == healthOK {
+			continue
+		}
+		if dep.Critical {
+			return healthDown
+		}
+		status = healthDegraded
+	}
+	return status
+}
+
+// ============ ЗАВИСИМОСТИ APP ============
+
+// health возвращает монитор зависимостей App (создаётся при первом
+// обращении; фоновые проверки запускает main через background.run).
+func (a *App) health() *healthMonitor {
+	a.healthOnce.Do(func() {
+		a.healthMon = newHealthMonitor(
+			healthProbe{"postgres", true, func(ctx context.Context) error { return a.Users.Ping(ctx) }},
+			healthProbe{"influxdb", false, func(ctx context.Context) error { return a.Telemetry.Ping(ctx) }},
+			healthProbe{"mqtt", false, func(ctx context.Context) error {
+				if a.Publisher == nil || !a.Publisher.Connected() {
+					return fmt.Errorf("нет подключения к брокеру")
+				}
+				if !a.subscribedAll() {
+					return fmt.Errorf("подписка на темы не оформлена")
+				}
+				return nil
+			}},
+		)
+	})
+	return a.healthMon
+}
+
+// setSubscribed отмечает состояние подписки на тему ("" — сбросить все).
+func (a *App) setSubscribed(topic string, ok bool) {
+	a.subMu.Lock()
+	defer a.subMu.Unlock()
+	if topic == "" {
+		a.subscribed = nil
+		return
+	}
+	if a.subscribed == nil {
+		a.subscribed = map[string]bool{}
+	}
+	a.subscribed[topic] = ok
+}
+
+func (a *App) subscriptions() map[string]bool {
+	a.subMu.Lock()
+	defer a.subMu.Unlock()
+	out := make(map[string]bool, len(cfg.MQTT.Topics))
+	for _, topic := range cfg.MQTT.Topics {
+		out[topic] = a.subscribed[topic]
+	}
+	return out
+}
+
+func (a *App) subscribedAll() bool {
+	for _, ok := range a.subscriptions() {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ============ REST API HANDLERS - HEALTH ============
+
+// getLivez отвечает, пока процесс способен обслуживать HTTP.
+func getLivez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (a *App) getReadyz(w http.ResponseWriter, r *http.Request) {
+	status := overallHealth(a.health().snapshot(r.Context()))
+	ready := status != healthDown
+	if shuttingDown.Load() {
+		status, ready = healthShuttingDown, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ready": ready, "status": status})
+}
+
+func (a *App) getHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deps := a.health().snapshot(r.Context())
+	status := overallHealth(deps)
+	if shuttingDown.Load() {
+		status = healthShuttingDown
+	}
+	buffers := map[string]int{"ingest_in_flight": a.ingest.inFlight()}
+	if a.WriteBuffers != nil {
+		for name, n := range a.WriteBuffers() {
+			buffers[name] = n
+		}
+	}
+
+	health := map[string]interface{}{
+		"status":             status,
+		"timestamp":          time.Now(),
+		"dependencies":       deps,
+		"mqtt_subscriptions": a.subscriptions(),
+		"write_buffers":      buffers,
+	}
+	// Краткие флаги — для панели администратора.
+	for name, dep := range deps {
+		health[name] = dep.Status == healthOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == healthDown || status == healthShuttingDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"smart-home/internal/store/memory"
+)
+
+// ============ ЗДОРОВЬЕ СЕРВИСА ============
+
+type healthReport struct {
+	Status            string                      `json:"status"`
+	Dependencies      map[string]DependencyHealth `json:"dependencies"`
+	MQTTSubscriptions map[string]bool             `json:"mqtt_subscriptions"`
+	WriteBuffers      map[string]int              `json:"write_buffers"`
+	Postgres          bool                        `json:"postgres"`
+	InfluxDB          bool                        `json:"influxdb"`
+}
+
+// unreachableUsers — хранилище пользователей с недоступной БД.
+type unreachableUsers struct{ memory.UserStore }
+
+func (*unreachableUsers) Ping(ctx context.Context) error { return errors.New("connection refused") }
+
+func TestHealthDegradedAndRecovered(t *testing.T) {
+	h := newHarness(t)
+
+	var report healthReport
+	if code := h.call(http.MethodGet, "/api/health", nil, nil, &report); code != http.StatusOK {
+		t.Fatalf("health: %d", code)
+	}
+	if report.Status != healthOK || !report.Postgres || !report.InfluxDB {
+		t.Errorf("все зависимости доступны, а отчёт %+v", report)
+	}
+	for topic, ok := range report.MQTTSubscriptions {
+		if !ok {
+			t.Errorf("подписка на %s не отмечена", topic)
+		}
+	}
+	if _, ok := report.WriteBuffers["ingest_in_flight"]; !ok {
+		t.Errorf("нет глубины очереди приёма: %v", report.WriteBuffers)
+	}
+
+	// Отчёт берётся из кэша: падение видно только после фоновой проверки.
+	h.telemetry.Down = true
+	h.app.health().checkAll(context.Background())
+	report = healthReport{}
+	h.call(http.MethodGet, "/api/health", nil, nil, &report)
+	influx := report.Dependencies["influxdb"]
+	if report.Status != healthDegraded || influx.Status != healthDown || influx.LastError == "" || influx.LastSuccess == nil {
+		t.Errorf("InfluxDB недоступна, а отчёт %+v", report)
+	}
+	if code := h.call(http.MethodGet, "/readyz", nil, nil, nil); code != http.StatusOK {
+		t.Errorf("readyz без InfluxDB: %d, ожидалось 200 (API работает)", code)
+	}
+
+	h.telemetry.Down = false
+	h.app.health().checkAll(context.Background())
+	report = healthReport{}
+	h.call(http.MethodGet, "/api/health", nil, nil, &report)
+	if report.Status != healthOK || report.Dependencies["influxdb"].LastError == "" {
+		t.Errorf("после восстановления отчёт %+v (последняя ошибка должна сохраниться)", report)
+	}
+}
+
+func TestHealthDownWithoutPostgres(t *testing.T) {
+	h := newHarness(t)
+	h.app.Users = &unreachableUsers{}
+
+	if code := h.call(http.MethodGet, "/api/health", nil, nil, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("health без PostgreSQL: %d, ожидалось 503", code)
+	}
+	if code := h.call(http.MethodGet, "/readyz", nil, nil, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz без PostgreSQL: %d, ожидалось 503", code)
+	}
+	if code := h.call(http.MethodGet, "/livez", nil, nil, nil); code != http.StatusOK {
+		t.Errorf("livez без PostgreSQL: %d, ожидалось 200", code)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	m := newHealthMonitor(healthProbe{"slow", true, func(ctx context.Context) error {
+		<-release // драйвер, не уважающий контекст
+		return nil
+	}})
+
+	started := time.Now()
+	m.checkAll(context.Background())
+	if elapsed := time.Since(started); elapsed > healthCheckTimeout+time.Second {
+		t.Errorf("проверка заняла %s", elapsed)
+	}
+	if dep := m.snapshot(context.Background())["slow"]; dep.Status != healthDown || dep.LastError == "" {
+		t.Errorf("зависшая проверка: %+v", dep)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// ============ ПРИЁМ ПОКАЗАНИЙ: MQTT → ХРАНИЛИЩЕ → API ============
+
+func TestSensorReadingReachesAPI(t *testing.T) {
+	h := newHarness(t)
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 21.5}`)
+	eventually(t, "показание в хранилище", func() bool {
+		_, ok, _ := h.telemetry.Latest(t.Context(), "temperature/room1", time.Now().Add(-time.Minute))
+		return ok
+	})
+
+	readings := h.received()
+	if len(readings) != 1 {
+		t.Fatalf("обработчики получили %d показаний, ожидалось 1", len(readings))
+	}
+	if r := readings[0]; r.SensorID != "temperature/room1" || !r.HasValue || r.Value != 21.5 {
+		t.Errorf("показание разобрано неверно: %+v", r)
+	}
+
+	var data struct {
+		SensorID string  `json:"sensor_id"`
+		Value    float64 `json:"value"`
+		Unit     string  `json:"unit"`
+		Status   string  `json:"status"`
+	}
+	if code := h.call(http.MethodGet, "/api/sensors/data?sensor_id=temperature/room1", nil, nil, &data); code != http.StatusOK {
+		t.Fatalf("GET /api/sensors/data: %d", code)
+	}
+	if data.Value != 21.5 || data.Unit != "°C" || data.Status != "online" {
+		t.Errorf("GET /api/sensors/data = %+v", data)
+	}
+
+	var history struct {
+		Points []struct {
+			Value float64 `json:"value"`
+		} `json:"points"`
+	}
+	if code := h.call(http.MethodGet, "/api/sensors/history?sensor_id=temperature/room1", nil, nil, &history); code != http.StatusOK {
+		t.Fatalf("GET /api/sensors/history: %d", code)
+	}
+	if len(history.Points) != 1 || history.Points[0].Value != 21.5 {
+		t.Errorf("история = %+v, ожидалась одна точка 21.5", history.Points)
+	}
+}
+
+func TestMotionPayloadIsNumeric(t *testing.T) {
+	h := newHarness(t)
+
+	h.broker.publish(t, "sensors/motion/hall", `{"value": true}`)
+	eventually(t, "показание движения", func() bool { return len(h.received()) == 1 })
+
+	if r := h.received()[0]; !r.HasValue || r.Value != 1 {
+		t.Errorf("движение true должно давать значение 1, получено %+v", r)
+	}
+}
+
+func TestMalformedPayloadIsDropped(t *testing.T) {
+	h := newHarness(t)
+	parseErrors := influxWriteErrors.WithLabelValues("json_parse_error")
+	before := testutil.ToFloat64(parseErrors)
+
+	h.broker.publish(t, "sensors/humidity/room1", `{"value": 40`)
+	h.broker.publish(t, "sensors/humidity/room1", `["value", 40]`)
+	h.broker.publish(t, "sensors/humidity/room1", `{"value": 45}`)
+
+	// Сообщения одного издателя приходят по порядку: когда корректное
+	// записано, испорченные уже обработаны.
+	eventually(t, "корректное показание", func() bool {
+		_, ok, _ := h.telemetry.Latest(t.Context(), "humidity/room1", time.Now().Add(-time.Minute))
+		return ok
+	})
+
+	if got := testutil.ToFloat64(parseErrors) - before; got != 2 {
+		t.Errorf("json_parse_error вырос на %v, ожидалось 2", got)
+	}
+	now := time.Now()
+	points, _ := h.telemetry.History(t.Context(), "humidity/room1", now.Add(-time.Minute), now.Add(time.Minute), time.Hour)
+	if len(points) != 1 || points[0].Value != 45 {
+		t.Errorf("в хранилище %+v, ожидалось одно значение 45", points)
+	}
+	if n := len(h.received()); n != 1 {
+		t.Errorf("обработчики получили %d показаний, ожидалось 1", n)
+	}
+}
+
+func TestTelemetryStoreUnavailable(t *testing.T) {
+	h := newHarness(t)
+	writeErrors := influxWriteErrors.WithLabelValues("write_failed")
+	before := testutil.ToFloat64(writeErrors)
+	h.telemetry.Down = true
+
+	h.broker.publish(t, "sensors/temperature/room2", `{"value": 19}`)
+	eventually(t, "ошибка записи", func() bool { return testutil.ToFloat64(writeErrors) > before })
+
+	// Подсистемы получают показание, даже если записать его не удалось.
+	if n := len(h.received()); n != 1 {
+		t.Errorf("обработчики получили %d показаний, ожидалось 1", n)
+	}
+	if code := h.call(http.MethodGet, "/api/sensors/data?sensor_id=temperature/room2", nil, nil, nil); code != http.StatusInternalServerError {
+		t.Errorf("GET /api/sensors/data при недоступном хранилище: %d, ожидалось 500", code)
+	}
+
+	var health map[string]interface{}
+	h.call(http.MethodGet, "/api/health", nil, nil, &health)
+	if health["influxdb"] != false || health["mqtt"] != true {
+		t.Errorf("health = %v", health)
+	}
+}
+
+func TestReadingsResumeAfterBrokerRestart(t *testing.T) {
+	h := newHarness(t)
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 20}`)
+	eventually(t, "первое показание", func() bool { return len(h.received()) == 1 })
+
+	// Новый брокер ничего не знает о прежних подписках: клиент должен
+	// переподключиться и подписаться заново.
+	h.restartBroker()
+	eventually(t, "переподключение", h.client.IsConnectionOpen)
+	h.waitSubscribed()
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 22}`)
+	eventually(t, "показание после перезапуска", func() bool {
+		sample, _, _ := h.telemetry.Latest(t.Context(), "temperature/room1", time.Now().Add(-time.Minute))
+		return sample.Value == 22
+	})
+	if n := len(h.received()); n != 2 {
+		t.Errorf("обработчики получили %d показаний, ожидалось 2", n)
+	}
+}
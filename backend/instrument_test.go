@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============ МЕТРИКИ И ТРАССИРОВКА HTTP ============
+
+func TestHTTPMetricsByRoute(t *testing.T) {
+	h := newHarness(t)
+
+	cases := []struct{ path, route string }{
+		{"/livez", "/livez"},
+		{"/api/admin/users/42", "/api/admin/users/"},
+		{"/no/such/route", unmatchedRoute},
+	}
+	for _, c := range cases {
+		// Запрос делается дважды: первый узнаёт код ответа.
+		code := strconv.Itoa(h.call(http.MethodGet, c.path, nil, nil, nil))
+		counter := httpRequestsTotal.WithLabelValues(http.MethodGet, c.route, code)
+		before := testutil.ToFloat64(counter)
+		h.call(http.MethodGet, c.path, nil, nil, nil)
+		// Метрики пишутся после отправки ответа.
+		eventually(t, c.path+": запрос учтён", func() bool {
+			return testutil.ToFloat64(counter)-before == 1 &&
+				testutil.ToFloat64(httpRequestsInFlight.WithLabelValues(c.route)) == 0
+		})
+	}
+}
+
+// recordSpans включает трассировку с записью спанов в память. Глобальный
+// провайдер ставится один раз: tracer пакета привязывается к первому.
+var recordSpans = sync.OnceValue(func() *tracetest.SpanRecorder {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return spans
+})
+
+func TestTracingAcrossHTTPAndMQTT(t *testing.T) {
+	spans := recordSpans()
+	h := newHarness(t)
+	logs := captureLogs(t)
+
+	var id trace.TraceID
+	rand.Read(id[:])
+	traceID := id.String()
+	header := http.Header{"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}
+	h.call(http.MethodGet, "/livez", header, nil, nil)
+
+	// Спан и запись журнала завершаются после отправки ответа.
+	eventually(t, "спан HTTP-запроса с trace_id из traceparent", func() bool {
+		for _, s := range spans.Ended() {
+			if s.Name() == "GET /livez" && s.SpanContext().TraceID().String() == traceID {
+				return true
+			}
+		}
+		return false
+	})
+	eventually(t, "trace_id в журнале запроса", func() bool {
+		for _, r := range logs.records(t) {
+			if r["component"] == "http" && r["trace_id"] == traceID {
+				return true
+			}
+		}
+		return false
+	})
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 21}`)
+	eventually(t, "спан обработки сообщения", func() bool {
+		for _, s := range spans.Ended() {
+			if s.Name() != "mqtt process" {
+				continue
+			}
+			for _, a := range s.Attributes() {
+				if a == semconv.MessagingDestinationName("sensors/temperature/room1") {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
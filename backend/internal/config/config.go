@@ -0,0 +1,321 @@
+// Package config — типизированная конфигурация бэкенда.
+//
+// Источники по возрастанию приоритета: значения по умолчанию (Defaults),
+// YAML-файл (флаг -config или CONFIG_FILE), переменные окружения, флаги
+// командной строки. Поле описывается тегами:
+//   - yaml — ключ в файле; путь через точку служит именем флага
+//     (-http.addr, -mqtt.qos);
+//   - env — переменная окружения (прежние имена сохранены: HTTP_PORT,
+//     DATABASE_URL, ...);
+//   - secret — значение не печатается (url — скрывается только пароль);
+//   - usage — описание для -help.
+//
+// Трассировка настраивается стандартными переменными OTEL_*, их читает
+// SDK OpenTelemetry, здесь они не дублируются.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP      HTTP      `yaml:"http"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Auth      Auth      `yaml:"auth"`
+	Postgres  Postgres  `yaml:"postgres"`
+	Influx    Influx    `yaml:"influx"`
+	MQTT      MQTT      `yaml:"mqtt"`
+	Log       Log       `yaml:"log"`
+	Shutdown  Shutdown  `yaml:"shutdown"`
+	Liveness  Liveness  `yaml:"liveness"`
+	Presence  Presence  `yaml:"presence"`
+	DeviceLog DeviceLog `yaml:"device_log"`
+	Billing   Billing   `yaml:"billing"`
+	SMTP      SMTP      `yaml:"smtp"`
+	Blobs     Blobs     `yaml:"blobs"`
+}
+
+type HTTP struct {
+	Addr         string        `yaml:"addr" env:"HTTP_PORT" usage:"адрес REST API: порт или host:port"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT" usage:"таймаут чтения запроса"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" usage:"таймаут записи ответа"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" usage:"таймаут простоя keep-alive соединения"`
+	CORSOrigins  []string      `yaml:"cors_origins" env:"CORS_ORIGINS" usage:"разрешённые Origin через запятую (* — любые)"`
+	// TrustedProxies — адреса обратных прокси, которым доверяется
+	// X-Forwarded-For; от остальных клиентов заголовок игнорируется.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"IP или CIDR доверенных прокси через запятую"`
+}
+
+type Metrics struct {
+	Addr     string `yaml:"addr" env:"METRICS_PORT" usage:"адрес сервера метрик: порт или host:port"`
+	Token    string `yaml:"token" env:"METRICS_TOKEN" secret:"true" usage:"Bearer-токен для /metrics"`
+	User     string `yaml:"user" env:"METRICS_USER" usage:"пользователь Basic-аутентификации /metrics"`
+	Password string `yaml:"password" env:"METRICS_PASSWORD" secret:"true" usage:"пароль Basic-аутентификации /metrics"`
+	Pprof    bool   `yaml:"pprof" env:"METRICS_PPROF" usage:"включить /debug/pprof/ на сервере метрик"`
+}
+
+type Auth struct {
+	JWTSecret string        `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"ключ подписи JWT (не короче 32 символов)"`
+	TokenTTL  time.Duration `yaml:"token_ttl" env:"JWT_TTL" usage:"срок действия выданного токена"`
+}
+
+type Postgres struct {
+	URL string `yaml:"url" env:"DATABASE_URL" secret:"url" usage:"строка подключения к PostgreSQL"`
+}
+
+type Influx struct {
+	URL    string `yaml:"url" env:"INFLUX_URL" secret:"url" usage:"адрес InfluxDB"`
+	Token  string `yaml:"token" env:"INFLUX_TOKEN" secret:"true" usage:"токен InfluxDB"`
+	Org    string `yaml:"org" env:"INFLUX_ORG" usage:"организация InfluxDB"`
+	Bucket string `yaml:"bucket" env:"INFLUX_BUCKET" usage:"bucket для показаний и агрегатов"`
+}
+
+type MQTT struct {
+	Broker         string        `yaml:"broker" env:"MQTT_BROKER" secret:"url" usage:"адрес брокера MQTT (tcp://host:port)"`
+	ClientID       string        `yaml:"client_id" env:"MQTT_CLIENT_ID" usage:"client id бэкенда"`
+	QoS            int           `yaml:"qos" env:"MQTT_QOS" usage:"QoS подписок и публикаций (0, 1, 2)"`
+	Topics         []string      `yaml:"topics" env:"MQTT_TOPICS" usage:"темы подписки через запятую"`
+	PublishTimeout time.Duration `yaml:"publish_timeout" env:"MQTT_PUBLISH_TIMEOUT" usage:"сколько ждать подтверждения публикации"`
+}
+
+type Log struct {
+	Format           string `yaml:"format" env:"LOG_FORMAT" usage:"формат журнала: text или json"`
+	Level            string `yaml:"level" env:"LOG_LEVEL" usage:"уровень журнала: debug, info, warn, error"`
+	SampleFirst      int    `yaml:"sample_first" env:"LOG_SAMPLE_FIRST" usage:"успешных сообщений MQTT в журнал за секунду без прореживания"`
+	SampleThereafter int    `yaml:"sample_thereafter" env:"LOG_SAMPLE_THEREAFTER" usage:"дальше — каждое N-е (0 — ни одного)"`
+}
+
+type Shutdown struct {
+	ReadinessDelay time.Duration `yaml:"readiness_delay" env:"SHUTDOWN_READINESS_DELAY" usage:"пауза между снятием готовности и остановкой HTTP"`
+}
+
+type Liveness struct {
+	Timeouts map[string]time.Duration `yaml:"timeouts" env:"LIVENESS_TIMEOUTS" usage:"таймауты связи по типу устройства: temperature=10m,controller=5m"`
+}
+
+type Presence struct {
+	VacancyTimeout time.Duration `yaml:"vacancy_timeout" env:"PRESENCE_VACANCY_TIMEOUT" usage:"через сколько без движения комната считается пустой"`
+}
+
+type DeviceLog struct {
+	RetentionDays int  `yaml:"retention_days" env:"DEVICE_LOG_RETENTION_DAYS" usage:"срок хранения журнала устройств, дней (0 — бессрочно)"`
+	Archive       bool `yaml:"archive" env:"DEVICE_LOG_ARCHIVE" usage:"выгружать удаляемые события в хранилище файлов"`
+}
+
+type Billing struct {
+	Provider    string `yaml:"provider" env:"PAYMENT_PROVIDER" usage:"платёжный провайдер (fake)"`
+	FakeDecline []int  `yaml:"fake_decline" env:"PAYMENT_FAKE_DECLINE" usage:"ID пользователей, чьи платежи fake-провайдер отклоняет"`
+}
+
+type SMTP struct {
+	Addr     string `yaml:"addr" env:"SMTP_ADDR" usage:"SMTP-сервер host:port (пусто — письма не отправляются)"`
+	User     string `yaml:"user" env:"SMTP_USER" usage:"пользователь SMTP"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true" usage:"пароль SMTP"`
+	From     string `yaml:"from" env:"SMTP_FROM" usage:"адрес отправителя"`
+}
+
+type Blobs struct {
+	Dir string `yaml:"dir" env:"BLOB_DIR" usage:"каталог хранилища файлов"`
+}
+
+// Defaults — значения по умолчанию.
+func Defaults() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Addr:         ":8082",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			CORSOrigins:  []string{"*"},
+		},
+		Metrics: Metrics{Addr: ":2114"},
+		Auth:    Auth{TokenTTL: 24 * time.Hour},
+		Influx:  Influx{Org: "smart_home", Bucket: "sensor_data"},
+		MQTT: MQTT{
+			ClientID: "smart-home-module",
+			QoS:      1,
+			Topics: []string{
+				"sensors/temperature/#",
+				"sensors/humidity/#",
+				"sensors/motion/#",
+				"sensors/power/#",
+				"sensors/energy/#",
+				"controllers/status/#",
+			},
+			PublishTimeout: 5 * time.Second,
+		},
+		Log:       Log{Format: "text", Level: "info", SampleFirst: 10, SampleThereafter: 100},
+		Shutdown:  Shutdown{ReadinessDelay: 5 * time.Second},
+		Presence:  Presence{VacancyTimeout: 15 * time.Minute},
+		DeviceLog: DeviceLog{RetentionDays: 90},
+		Billing:   Billing{Provider: "fake"},
+		SMTP:      SMTP{From: "smart-home@localhost"},
+		Blobs:     Blobs{Dir: "./data/blobs"},
+	}
+}
+
+// ============ ПРОВЕРКА ============
+
+const (
+	minJWTSecretLen     = 32
+	minMetricsSecretLen = 16
+)
+
+// placeholderSecrets — подстроки значений-заглушек из примеров и
+// документации.
+var placeholderSecrets = []string{"your_", "your-", "changeme", "change_me", "change-me", "example"}
+
+// weakSecret возвращает причину, по которой секрет не годится, или "".
+func weakSecret(v string, minLen int) string {
+	lower := strings.ToLower(v)
+	for _, p := range placeholderSecrets {
+		if strings.Contains(lower, p) {
+			return "значение-заглушка"
+		}
+	}
+	if len(v) < minLen {
+		return fmt.Sprintf("короче %d символов", minLen)
+	}
+	return ""
+}
+
+// ValidateDatabase проверяет то, что нужно служебным командам (migrate, seed).
+func (c *Config) ValidateDatabase() error {
+	if c.Postgres.URL == "" {
+		return errors.New("postgres.url (DATABASE_URL) не задан")
+	}
+	return nil
+}
+
+// Validate проверяет конфигурацию сервера и возвращает все найденные
+// ошибки сразу.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	required := func(v, name string) {
+		if strings.TrimSpace(v) == "" {
+			fail("%s не задан", name)
+		}
+	}
+	positive := func(d time.Duration, name string) {
+		if d <= 0 {
+			fail("%s: длительность должна быть положительной, задано %s", name, d)
+		}
+	}
+
+	if err := c.ValidateDatabase(); err != nil {
+		errs = append(errs, err)
+	}
+	required(c.Influx.URL, "influx.url (INFLUX_URL)")
+	required(c.Influx.Token, "influx.token (INFLUX_TOKEN)")
+	required(c.Influx.Org, "influx.org (INFLUX_ORG)")
+	required(c.Influx.Bucket, "influx.bucket (INFLUX_BUCKET)")
+	required(c.MQTT.Broker, "mqtt.broker (MQTT_BROKER)")
+
+	if c.Auth.JWTSecret == "" {
+		fail("auth.jwt_secret (JWT_SECRET) не задан")
+	} else if reason := weakSecret(c.Auth.JWTSecret, minJWTSecretLen); reason != "" {
+		fail("auth.jwt_secret (JWT_SECRET) ненадёжен: %s", reason)
+	}
+	positive(c.Auth.TokenTTL, "auth.token_ttl")
+
+	for _, a := range []struct{ v, name string }{{c.HTTP.Addr, "http.addr"}, {c.Metrics.Addr, "metrics.addr"}} {
+		if _, err := ListenAddr(a.v); err != nil {
+			fail("%s: %v", a.name, err)
+		}
+	}
+	positive(c.HTTP.ReadTimeout, "http.read_timeout")
+	positive(c.HTTP.WriteTimeout, "http.write_timeout")
+	positive(c.HTTP.IdleTimeout, "http.idle_timeout")
+	for _, origin := range c.HTTP.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			fail("http.cors_origins: %q — ожидалось * или scheme://host[:port]", origin)
+		}
+	}
+	for _, proxy := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fail("http.trusted_proxies: %q — ожидался IP или CIDR", proxy)
+		}
+	}
+
+	if c.Metrics.Token != "" {
+		if reason := weakSecret(c.Metrics.Token, minMetricsSecretLen); reason != "" {
+			fail("metrics.token (METRICS_TOKEN) ненадёжен: %s", reason)
+		}
+	}
+	if (c.Metrics.User == "") != (c.Metrics.Password == "") {
+		fail("metrics.user и metrics.password задаются вместе")
+	} else if c.Metrics.Password != "" {
+		if reason := weakSecret(c.Metrics.Password, minMetricsSecretLen); reason != "" {
+			fail("metrics.password (METRICS_PASSWORD) ненадёжен: %s", reason)
+		}
+	}
+
+	if c.MQTT.QoS < 0 || c.MQTT.QoS > 2 {
+		fail("mqtt.qos: допустимы 0, 1, 2, задано %d", c.MQTT.QoS)
+	}
+	if len(c.MQTT.Topics) == 0 {
+		fail("mqtt.topics: нужна хотя бы одна тема")
+	}
+	required(c.MQTT.ClientID, "mqtt.client_id")
+	positive(c.MQTT.PublishTimeout, "mqtt.publish_timeout")
+
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		fail("log.format: %q — ожидалось text или json", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level: неизвестный уровень %q", c.Log.Level)
+	}
+	if c.Log.SampleFirst < 0 || c.Log.SampleThereafter < 0 {
+		fail("log.sample_first и log.sample_thereafter не могут быть отрицательными")
+	}
+
+	if c.Shutdown.ReadinessDelay < 0 {
+		fail("shutdown.readiness_delay не может быть отрицательной")
+	}
+	for kind, d := range c.Liveness.Timeouts {
+		positive(d, "liveness.timeouts."+kind)
+	}
+	positive(c.Presence.VacancyTimeout, "presence.vacancy_timeout")
+	if c.DeviceLog.RetentionDays < 0 {
+		fail("device_log.retention_days не может быть отрицательным")
+	}
+	if c.Billing.Provider != "fake" {
+		fail("billing.provider: неизвестный провайдер %q", c.Billing.Provider)
+	}
+	required(c.Blobs.Dir, "blobs.dir (BLOB_DIR)")
+
+	return errors.Join(errs...)
+}
+
+// ListenAddr приводит адрес из конфигурации к виду для Listen: "8082" и
+// ":8082" — все интерфейсы, "127.0.0.1:8082" или "[::1]:8082" — указанный.
+func ListenAddr(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if _, err := strconv.Atoi(v); err == nil {
+		v = ":" + v
+	}
+	host, port, err := net.SplitHostPort(v)
+	if err != nil {
+		return "", fmt.Errorf("неверный адрес %q: %w", v, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("неверный порт в адресе %q", v)
+	}
+	return net.JoinHostPort(host, port), nil
+}
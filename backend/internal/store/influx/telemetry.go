@@ -0,0 +1,172 @@
+// Package influx — хранилище телеметрии store.TelemetryStore в InfluxDB.
+package influx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"smart-home/internal/store"
+)
+
+// measurement — измерение, в которое пишутся показания датчиков (теги
+// topic и sensor_id, поле value).
+const measurement = "sensor_data"
+
+var _ store.TelemetryStore = (*TelemetryStore)(nil)
+
+// tracer — спаны запросов к InfluxDB (no-op, пока трассировка не включена).
+var tracer = otel.Tracer("smart-home/internal/store/influx")
+
+// startSpan начинает спан операции; возвращённая функция завершает его с
+// ошибкой операции.
+func startSpan(ctx context.Context, op, sensorID string) (context.Context, func(error)) {
+	attrs := []attribute.KeyValue{semconv.DBSystemNameInfluxDB, semconv.DBOperationName(op)}
+	if sensorID != "" {
+		attrs = append(attrs, attribute.String("sensor_id", sensorID))
+	}
+	ctx, span := tracer.Start(ctx, "influxdb "+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+type TelemetryStore struct {
+	Client influxdb2.Client
+	Org    string
+	Bucket string
+}
+
+func (s *TelemetryStore) Write(ctx context.Context, topic, sensorID string, value interface{}, at time.Time) (err error) {
+	ctx, end := startSpan(ctx, "write", sensorID)
+	defer func() { end(err) }()
+	point := influxdb2.NewPointWithMeasurement(measurement).
+		AddField("value", value).
+		AddTag("topic", topic).
+		AddTag("sensor_id", sensorID).
+		SetTime(at)
+	return s.Client.WriteAPIBlocking(s.Org, s.Bucket).WritePoint(ctx, point)
+}
+
+func (s *TelemetryStore) Latest(ctx context.Context, sensorID string, since time.Time) (_ store.Sample, _ bool, err error) {
+	ctx, end := startSpan(ctx, "latest", sensorID)
+	defer func() { end(err) }()
+	query := fmt.Sprintf(`
+        from(bucket: %q)
+        |> range(start: %s)
+        |> filter(fn: (r) => r.sensor_id == %q)
+        |> last()
+    `, s.Bucket, since.UTC().Format(time.RFC3339), sensorID)
+
+	result, err := s.Client.QueryAPI(s.Org).Query(ctx, query)
+	if err != nil {
+		return store.Sample{}, false, err
+	}
+	defer result.Close()
+
+	if !result.Next() {
+		return store.Sample{}, false, result.Err()
+	}
+	rec := result.Record()
+	sample := store.Sample{
+		Topic:    fmt.Sprint(rec.ValueByKey("topic")),
+		SensorID: sensorID,
+		Field:    rec.Field(),
+		Point:    store.Point{Time: rec.Time()},
+	}
+	sample.Value, _ = number(rec.Value())
+	return sample, true, nil
+}
+
+func (s *TelemetryStore) History(ctx context.Context, sensorID string, from, to time.Time, every time.Duration) (_ []store.Point, err error) {
+	ctx, end := startSpan(ctx, "history", sensorID)
+	defer func() { end(err) }()
+	query := fmt.Sprintf(`
+        from(bucket: %q)
+        |> range(start: %s, stop: %s)
+        |> filter(fn: (r) => r.sensor_id == %q and r._field == "value")
+        |> aggregateWindow(every: %s, fn: mean, createEmpty: false)
+    `, s.Bucket, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), sensorID, every)
+
+	result, err := s.Client.QueryAPI(s.Org).Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	points := []store.Point{}
+	for result.Next() {
+		if v, ok := number(result.Record().Value()); ok {
+			points = append(points, store.Point{Time: result.Record().Time(), Value: v})
+		}
+	}
+	return points, result.Err()
+}
+
+func (s *TelemetryStore) Recent(ctx context.Context, since time.Time) (_ []store.Sample, err error) {
+	ctx, end := startSpan(ctx, "recent", "")
+	defer func() { end(err) }()
+	query := fmt.Sprintf(`from(bucket: %q)
+        |> range(start: %s)
+        |> filter(fn: (r) => r["_measurement"] == %q)
+        |> filter(fn: (r) => r["_field"] == "value")`,
+		s.Bucket, since.UTC().Format(time.RFC3339), measurement)
+
+	result, err := s.Client.QueryAPI(s.Org).Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	samples := []store.Sample{}
+	for result.Next() {
+		rec := result.Record()
+		v, ok := number(rec.Value())
+		if !ok {
+			continue
+		}
+		samples = append(samples, store.Sample{
+			Topic:    fmt.Sprint(rec.ValueByKey("topic")),
+			SensorID: fmt.Sprint(rec.ValueByKey("sensor_id")),
+			Field:    rec.Field(),
+			Point:    store.Point{Time: rec.Time(), Value: v},
+		})
+	}
+	return samples, result.Err()
+}
+
+func (s *TelemetryStore) Ping(ctx context.Context) error {
+	ok, err := s.Client.Ping(ctx)
+	if err == nil && !ok {
+		err = fmt.Errorf("InfluxDB не готова")
+	}
+	return err
+}
+
+// number приводит значение из Influx к float64 (bool — 0/1, как у
+// датчиков движения).
+func number(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int64:
+		return float64(val), true
+	case bool:
+		if val {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
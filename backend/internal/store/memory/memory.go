@@ -0,0 +1,380 @@
+// Package memory — хранилища store в памяти для тестов. Все типы
+// безопасны для конкурентного использования; нулевые значения готовы к
+// работе.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"smart-home/internal/store"
+)
+
+var (
+	_ store.UserStore      = (*UserStore)(nil)
+	_ store.BuildingStore  = (*BuildingStore)(nil)
+	_ store.DeviceStore    = (*DeviceStore)(nil)
+	_ store.TelemetryStore = (*TelemetryStore)(nil)
+	_ store.Publisher      = (*Publisher)(nil)
+)
+
+// ============ ПОЛЬЗОВАТЕЛИ ============
+
+type UserStore struct {
+	mu     sync.Mutex
+	nextID int
+	users  []store.Credentials
+}
+
+func (s *UserStore) Create(ctx context.Context, username, email, passwordHash, role string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username || u.Email == email {
+			return 0, fmt.Errorf("пользователь %s или %s уже существует", username, email)
+		}
+	}
+	s.nextID++
+	s.users = append(s.users, store.Credentials{
+		User:         store.User{ID: s.nextID, Username: username, Email: email, Role: role, CreatedAt: time.Now()},
+		PasswordHash: passwordHash,
+	})
+	return s.nextID, nil
+}
+
+func (s *UserStore) ByUsername(ctx context.Context, username string) (store.Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return store.Credentials{}, store.ErrNotFound
+}
+
+func (s *UserStore) List(ctx context.Context) ([]store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]store.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u.User)
+	}
+	return users, nil
+}
+
+func (s *UserStore) Delete(ctx context.Context, id int) (store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, u := range s.users {
+		if u.ID == id {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return u.User, nil
+		}
+	}
+	return store.User{}, store.ErrNotFound
+}
+
+func (s *UserStore) SetRole(ctx context.Context, id int, role string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, u := range s.users {
+		if u.ID == id {
+			s.users[i].Role = role
+			return u.Role, nil
+		}
+	}
+	return "", store.ErrNotFound
+}
+
+func (s *UserStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ============ ЗДАНИЯ И КОМНАТЫ ============
+
+type BuildingStore struct {
+	mu        sync.Mutex
+	nextID    int
+	nextRoom  int
+	buildings []store.Building
+	rooms     []store.Room
+}
+
+func (s *BuildingStore) List(ctx context.Context) ([]store.Building, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Building{}, s.buildings...), nil
+}
+
+func (s *BuildingStore) Create(ctx context.Context, b store.Building) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	b.ID = s.nextID
+	s.buildings = append(s.buildings, b)
+	return b.ID, nil
+}
+
+func (s *BuildingStore) Update(ctx context.Context, id int, b store.Building) (store.Building, store.Building, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, old := range s.buildings {
+		if old.ID != id {
+			continue
+		}
+		b.ID = id
+		if b.Latitude == nil {
+			b.Latitude = old.Latitude
+		}
+		if b.Longitude == nil {
+			b.Longitude = old.Longitude
+		}
+		if b.Timezone == "" {
+			b.Timezone = old.Timezone
+		}
+		s.buildings[i] = b
+		return old, b, nil
+	}
+	return store.Building{}, store.Building{}, store.ErrNotFound
+}
+
+func (s *BuildingStore) Delete(ctx context.Context, id int) (store.Building, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, b := range s.buildings {
+		if b.ID != id {
+			continue
+		}
+		s.buildings = append(s.buildings[:i], s.buildings[i+1:]...)
+		rooms := s.rooms[:0]
+		for _, rm := range s.rooms {
+			if rm.BuildingID != id {
+				rooms = append(rooms, rm)
+			}
+		}
+		s.rooms = rooms
+		return b, nil
+	}
+	return store.Building{}, store.ErrNotFound
+}
+
+func (s *BuildingStore) Rooms(ctx context.Context, buildingID int) ([]store.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := []store.Room{}
+	for _, rm := range s.rooms {
+		if buildingID == 0 || rm.BuildingID == buildingID {
+			rooms = append(rooms, rm)
+		}
+	}
+	return rooms, nil
+}
+
+func (s *BuildingStore) CreateRoom(ctx context.Context, room store.Room) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, b := range s.buildings {
+		found = found || b.ID == room.BuildingID
+	}
+	if !found {
+		return 0, fmt.Errorf("здание %d не существует", room.BuildingID)
+	}
+	s.nextRoom++
+	room.ID = s.nextRoom
+	s.rooms = append(s.rooms, room)
+	return room.ID, nil
+}
+
+// ============ УСТРОЙСТВА ============
+
+type DeviceStore struct {
+	mu      sync.Mutex
+	nextID  int
+	devices []store.Device
+	// Owners — владелец и тариф каждого устройства (аналог user_devices).
+	Owners map[int]Ownership
+}
+
+type Ownership struct {
+	UserID int
+	Plan   string
+}
+
+func (s *DeviceStore) Create(ctx context.Context, d store.Device, ownerID int, plan string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	d.ID = s.nextID
+	s.devices = append(s.devices, d)
+	if s.Owners == nil {
+		s.Owners = map[int]Ownership{}
+	}
+	s.Owners[d.ID] = Ownership{UserID: ownerID, Plan: plan}
+	return d.ID, nil
+}
+
+func (s *DeviceStore) List(ctx context.Context) ([]store.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Device{}, s.devices...), nil
+}
+
+// ============ ТЕЛЕМЕТРИЯ ============
+
+type TelemetryStore struct {
+	mu      sync.Mutex
+	samples []store.Sample
+	// Down имитирует недоступность хранилища: запись и чтение
+	// возвращают ошибку, Ping — false.
+	Down bool
+}
+
+var errDown = fmt.Errorf("хранилище телеметрии недоступно")
+
+func (s *TelemetryStore) Write(ctx context.Context, topic, sensorID string, value interface{}, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Down {
+		return errDown
+	}
+	var v float64
+	switch val := value.(type) {
+	case float64:
+		v = val
+	case int:
+		v = float64(val)
+	case bool:
+		if val {
+			v = 1
+		}
+	default:
+		return fmt.Errorf("неподдерживаемое значение %T", value)
+	}
+	s.samples = append(s.samples, store.Sample{
+		Topic: topic, SensorID: sensorID, Field: "value", Point: store.Point{Time: at, Value: v},
+	})
+	return nil
+}
+
+func (s *TelemetryStore) Latest(ctx context.Context, sensorID string, since time.Time) (store.Sample, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Down {
+		return store.Sample{}, false, errDown
+	}
+	var last store.Sample
+	found := false
+	for _, sm := range s.samples {
+		if sm.SensorID == sensorID && !sm.Time.Before(since) && (!found || sm.Time.After(last.Time)) {
+			last, found = sm, true
+		}
+	}
+	return last, found, nil
+}
+
+// History усредняет значения по окнам every, выровненным по every;
+// время точки — конец окна (как aggregateWindow в InfluxDB).
+func (s *TelemetryStore) History(ctx context.Context, sensorID string, from, to time.Time, every time.Duration) ([]store.Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Down {
+		return nil, errDown
+	}
+	type window struct {
+		sum float64
+		n   int
+	}
+	windows := map[time.Time]*window{}
+	for _, sm := range s.samples {
+		if sm.SensorID != sensorID || sm.Time.Before(from) || !sm.Time.Before(to) {
+			continue
+		}
+		end := sm.Time.Truncate(every).Add(every)
+		if end.After(to) {
+			end = to
+		}
+		w := windows[end]
+		if w == nil {
+			w = &window{}
+			windows[end] = w
+		}
+		w.sum += sm.Value
+		w.n++
+	}
+
+	points := []store.Point{}
+	for end, w := range windows {
+		points = append(points, store.Point{Time: end, Value: w.sum / float64(w.n)})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+func (s *TelemetryStore) Recent(ctx context.Context, since time.Time) ([]store.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Down {
+		return nil, errDown
+	}
+	samples := []store.Sample{}
+	for _, sm := range s.samples {
+		if !sm.Time.Before(since) {
+			samples = append(samples, sm)
+		}
+	}
+	return samples, nil
+}
+
+func (s *TelemetryStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Down {
+		return errDown
+	}
+	return nil
+}
+
+// ============ MQTT ============
+
+// Message — опубликованное сообщение.
+type Message struct {
+	Topic    string
+	Retained bool
+	Payload  []byte
+}
+
+// Publisher запоминает опубликованные сообщения. Disconnected имитирует
+// потерю связи с брокером.
+type Publisher struct {
+	mu           sync.Mutex
+	messages     []Message
+	Disconnected bool
+}
+
+func (p *Publisher) Publish(topic string, retained bool, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Disconnected {
+		return fmt.Errorf("нет подключения к брокеру")
+	}
+	p.messages = append(p.messages, Message{Topic: topic, Retained: retained, Payload: append([]byte{}, payload...)})
+	return nil
+}
+
+func (p *Publisher) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.Disconnected
+}
+
+// Messages — копия всех опубликованных сообщений по порядку.
+func (p *Publisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message{}, p.messages...)
+}
@@ -0,0 +1,40 @@
+// Package paho — store.Publisher поверх клиента Eclipse Paho.
+package paho
+
+import (
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"smart-home/internal/store"
+)
+
+// defaultPublishTimeout — сколько ждать подтверждения публикации брокером,
+// если Timeout не задан.
+const defaultPublishTimeout = 5 * time.Second
+
+var _ store.Publisher = (*Publisher)(nil)
+
+// Publisher публикует сообщения с заданным QoS.
+type Publisher struct {
+	Client  mqtt.Client
+	QoS     byte
+	Timeout time.Duration
+}
+
+func (p *Publisher) Publish(topic string, retained bool, payload []byte) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPublishTimeout
+	}
+	token := p.Client.Publish(topic, p.QoS, retained, payload)
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("таймаут публикации в %s", topic)
+	}
+	return token.Error()
+}
+
+func (p *Publisher) Connected() bool {
+	return p.Client.IsConnected()
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"smart-home/internal/store"
+)
+
+// BuildingStore хранит здания (building) и их комнаты (room).
+type BuildingStore struct {
+	DB *sql.DB
+}
+
+const buildingColumns = "id, name, latitude, longitude, COALESCE(timezone, '')"
+
+func scanBuilding(row interface{ Scan(...interface{}) error }) (store.Building, error) {
+	var b store.Building
+	err := row.Scan(&b.ID, &b.Name, &b.Latitude, &b.Longitude, &b.Timezone)
+	return b, err
+}
+
+func (s *BuildingStore) List(ctx context.Context) ([]store.Building, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+buildingColumns+" FROM building ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buildings := []store.Building{}
+	for rows.Next() {
+		b, err := scanBuilding(rows)
+		if err != nil {
+			return nil, err
+		}
+		buildings = append(buildings, b)
+	}
+	return buildings, rows.Err()
+}
+
+func (s *BuildingStore) Create(ctx context.Context, b store.Building) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx,
+		"INSERT INTO building (name, latitude, longitude, timezone) VALUES ($1, $2, $3, $4) RETURNING id",
+		b.Name, b.Latitude, b.Longitude, b.Timezone,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *BuildingStore) Update(ctx context.Context, id int, b store.Building) (store.Building, store.Building, error) {
+	var before store.Building
+	after := b
+	// Не переданные в теле координаты и часовой пояс остаются прежними.
+	err := s.DB.QueryRowContext(ctx, `UPDATE building b SET name = $1,
+			latitude = COALESCE($2, b.latitude), longitude = COALESCE($3, b.longitude),
+			timezone = COALESCE(NULLIF($4, ''), b.timezone)
+		FROM building old WHERE old.id = b.id AND b.id = $5
+		RETURNING old.id, old.name, old.latitude, old.longitude, COALESCE(old.timezone, ''),
+			b.latitude, b.longitude, COALESCE(b.timezone, '')`,
+		b.Name, b.Latitude, b.Longitude, b.Timezone, id,
+	).Scan(&before.ID, &before.Name, &before.Latitude, &before.Longitude, &before.Timezone,
+		&after.Latitude, &after.Longitude, &after.Timezone)
+	after.ID = before.ID
+	return before, after, notFound(err)
+}
+
+func (s *BuildingStore) Delete(ctx context.Context, id int) (store.Building, error) {
+	b, err := scanBuilding(s.DB.QueryRowContext(ctx,
+		"DELETE FROM building WHERE id = $1 RETURNING "+buildingColumns, id))
+	return b, notFound(err)
+}
+
+func (s *BuildingStore) Rooms(ctx context.Context, buildingID int) ([]store.Room, error) {
+	query := "SELECT id, name, building_id FROM room"
+	args := []interface{}{}
+	if buildingID != 0 {
+		query += " WHERE building_id = $1"
+		args = append(args, buildingID)
+	}
+	rows, err := s.DB.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []store.Room{}
+	for rows.Next() {
+		var rm store.Room
+		if err := rows.Scan(&rm.ID, &rm.Name, &rm.BuildingID); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, rm)
+	}
+	return rooms, rows.Err()
+}
+
+func (s *BuildingStore) CreateRoom(ctx context.Context, room store.Room) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx,
+		"INSERT INTO room (name, building_id) VALUES ($1, $2) RETURNING id",
+		room.Name, room.BuildingID,
+	).Scan(&id)
+	return id, err
+}
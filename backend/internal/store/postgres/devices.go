@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"smart-home/internal/store"
+)
+
+// DeviceStore хранит устройства (device) и их владельцев (user_devices).
+type DeviceStore struct {
+	DB *sql.DB
+}
+
+func (s *DeviceStore) Create(ctx context.Context, d store.Device, ownerID int, plan string) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO device (name, room_id, sensor_key, rated_power_w) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0)) RETURNING id",
+		d.Name, d.RoomID, d.SensorKey, d.RatedPowerW,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	// Устройство засчитывается в лимит создавшего его пользователя.
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO user_devices (user_id, device_id, payment_type) VALUES ($1, $2, $3)",
+		ownerID, id, plan,
+	); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *DeviceStore) List(ctx context.Context) ([]store.Device, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT id, name, COALESCE(room_id, 0), COALESCE(sensor_key, ''), COALESCE(rated_power_w, 0) FROM device ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []store.Device{}
+	for rows.Next() {
+		var d store.Device
+		if err := rows.Scan(&d.ID, &d.Name, &d.RoomID, &d.SensorKey, &d.RatedPowerW); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
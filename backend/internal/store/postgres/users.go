@@ -0,0 +1,101 @@
+// Package postgres — реализации хранилищ store поверх PostgreSQL.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"smart-home/internal/store"
+)
+
+var (
+	_ store.UserStore     = (*UserStore)(nil)
+	_ store.BuildingStore = (*BuildingStore)(nil)
+	_ store.DeviceStore   = (*DeviceStore)(nil)
+)
+
+// UserStore хранит пользователей в таблице users.
+type UserStore struct {
+	DB *sql.DB
+	// OnRoleChange вызывается в транзакции смены роли (например, чтобы
+	// карточка работника следовала за ролью). Необязательно.
+	OnRoleChange func(tx *sql.Tx, userID int, role string) error
+}
+
+func (s *UserStore) Create(ctx context.Context, username, email, passwordHash, role string) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx,
+		"INSERT INTO users (username, email, password, role) VALUES ($1, $2, $3, $4) RETURNING id",
+		username, email, passwordHash, role,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *UserStore) ByUsername(ctx context.Context, username string) (store.Credentials, error) {
+	var c store.Credentials
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT id, username, email, password, COALESCE(role, ''), created_at FROM users WHERE username = $1",
+		username,
+	).Scan(&c.ID, &c.Username, &c.Email, &c.PasswordHash, &c.Role, &c.CreatedAt)
+	return c, notFound(err)
+}
+
+func (s *UserStore) List(ctx context.Context) ([]store.User, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, username, email, COALESCE(role, ''), created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []store.User{}
+	for rows.Next() {
+		var u store.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *UserStore) Delete(ctx context.Context, id int) (store.User, error) {
+	u := store.User{ID: id}
+	err := s.DB.QueryRowContext(ctx,
+		"DELETE FROM users WHERE id = $1 RETURNING username, COALESCE(email, ''), COALESCE(role, ''), created_at", id,
+	).Scan(&u.Username, &u.Email, &u.Role, &u.CreatedAt)
+	return u, notFound(err)
+}
+
+func (s *UserStore) SetRole(ctx context.Context, id int, role string) (string, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var old string
+	err = tx.QueryRowContext(ctx, `UPDATE users u SET role = $1 FROM users old
+		WHERE old.id = u.id AND u.id = $2 RETURNING COALESCE(old.role, '')`, role, id).Scan(&old)
+	if err != nil {
+		return "", notFound(err)
+	}
+	if s.OnRoleChange != nil {
+		if err := s.OnRoleChange(tx, id, role); err != nil {
+			return "", err
+		}
+	}
+	return old, tx.Commit()
+}
+
+func (s *UserStore) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// notFound переводит sql.ErrNoRows в store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+// Package store описывает доступ к данным бэкенда: модели и интерфейсы
+// хранилищ (пользователи, здания, устройства, телеметрия) и публикации в
+// MQTT. Реализации — в подпакетах postgres, influx и paho; memory содержит
+// хранилища в памяти для тестов.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound возвращается, когда запись с указанным ID не существует.
+var ErrNotFound = errors.New("запись не найдена")
+
+// ============ МОДЕЛИ ============
+
+type Building struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Timezone  string   `json:"timezone,omitempty"`
+}
+
+type Room struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	BuildingID int    `json:"building_id"`
+}
+
+type Device struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	RoomID    int    `json:"room_id"`
+	SensorKey string `json:"sensor_key,omitempty"`
+	// Номинальная мощность, Вт — для оценки потребления по времени работы.
+	RatedPowerW float64 `json:"rated_power_w,omitempty"`
+}
+
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Credentials — пользователь вместе с хешем пароля (только для входа).
+type Credentials struct {
+	User
+	PasswordHash string
+}
+
+// Point — одно значение временного ряда.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Sample — последнее значение датчика с его топиком и полем.
+type Sample struct {
+	Topic    string
+	SensorID string
+	Field    string
+	Point
+}
+
+// ============ ИНТЕРФЕЙСЫ ============
+
+type UserStore interface {
+	// Create добавляет пользователя и возвращает его ID.
+	Create(ctx context.Context, username, email, passwordHash, role string) (int, error)
+	ByUsername(ctx context.Context, username string) (Credentials, error)
+	List(ctx context.Context) ([]User, error)
+	// Delete удаляет пользователя и возвращает его прежние данные.
+	Delete(ctx context.Context, id int) (User, error)
+	// SetRole меняет роль и возвращает прежнюю.
+	SetRole(ctx context.Context, id int, role string) (string, error)
+	// Ping проверяет доступность хранилища (для проверки здоровья).
+	Ping(ctx context.Context) error
+}
+
+type BuildingStore interface {
+	List(ctx context.Context) ([]Building, error)
+	Create(ctx context.Context, b Building) (int, error)
+	// Update сохраняет name/координаты (пустой timezone не меняет пояс) и
+	// возвращает прежнее и новое состояние.
+	Update(ctx context.Context, id int, b Building) (before, after Building, err error)
+	Delete(ctx context.Context, id int) (Building, error)
+
+	// Rooms — комнаты здания; buildingID == 0 — все комнаты.
+	Rooms(ctx context.Context, buildingID int) ([]Room, error)
+	CreateRoom(ctx context.Context, room Room) (int, error)
+}
+
+type DeviceStore interface {
+	// Create добавляет устройство и засчитывает его владельцу (user_devices)
+	// по тарифу plan.
+	Create(ctx context.Context, d Device, ownerID int, plan string) (int, error)
+	List(ctx context.Context) ([]Device, error)
+}
+
+type TelemetryStore interface {
+	// Write сохраняет показание датчика. value — значение из сообщения как
+	// есть (число, bool и т.п.).
+	Write(ctx context.Context, topic, sensorID string, value interface{}, at time.Time) error
+	// Latest — последнее значение датчика не старше since; ok == false,
+	// если значений нет.
+	Latest(ctx context.Context, sensorID string, since time.Time) (s Sample, ok bool, err error)
+	// History — средние значения датчика по окнам every в [from, to).
+	History(ctx context.Context, sensorID string, from, to time.Time, every time.Duration) ([]Point, error)
+	// Recent — значения всех датчиков не старше since.
+	Recent(ctx context.Context, since time.Time) ([]Sample, error)
+	Ping(ctx context.Context) error
+}
+
+type Publisher interface {
+	Publish(topic string, retained bool, payload []byte) error
+	Connected() bool
+}
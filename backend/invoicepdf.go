@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ============ PDF СЧЁТА ============
+//
+// Минимальный одностраничный PDF без внешних зависимостей. Стандартный
+// шрифт Helvetica не содержит кириллицы, поэтому текст транслитерируется.
+
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", '«': "\"", '»': "\"", '—': "-", '–': "-", '№': "No.",
+}
+
+// pdfText транслитерирует строку и экранирует её для PDF-литерала.
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		lower := []rune(strings.ToLower(string(r)))[0]
+		if t, ok := translitTable[lower]; ok {
+			if lower != r && t != "" {
+				t = strings.ToUpper(t[:1]) + t[1:]
+			}
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 128:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func renderInvoicePDF(inv Invoice, customer, email string) []byte {
+	loc := billingLocation()
+	var content bytes.Buffer
+	y := 800
+	line := func(size, x int, text string) {
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", size, x, y, pdfText(text))
+	}
+
+	line(18, 50, "Invoice "+inv.Number)
+	y -= 30
+	line(10, 50, fmt.Sprintf("Customer: %s <%s>", customer, email))
+	y -= 15
+	line(10, 50, fmt.Sprintf("Period: %s - %s", inv.PeriodStart.In(loc).Format("02.01.2006"), inv.PeriodEnd.In(loc).Format("02.01.2006")))
+	y -= 15
+	line(10, 50, fmt.Sprintf("Issued: %s   Status: %s", inv.CreatedAt.In(loc).Format("02.01.2006"), inv.Status))
+	if inv.PaidAt != nil {
+		y -= 15
+		line(10, 50, "Paid: "+inv.PaidAt.In(loc).Format("02.01.2006 15:04"))
+	}
+
+	y -= 30
+	line(10, 50, "Description")
+	line(10, 460, "Amount")
+	y -= 5
+	fmt.Fprintf(&content, "50 %d m 545 %d l S\n", y, y)
+	for _, l := range inv.Lines {
+		y -= 15
+		if y < 80 {
+			line(10, 50, "...")
+			break
+		}
+		line(10, 50, l.Description)
+		line(10, 460, formatMoney(l.Amount))
+	}
+	y -= 10
+	fmt.Fprintf(&content, "50 %d m 545 %d l S\n", y, y)
+	y -= 18
+	line(12, 50, "Total")
+	line(12, 460, formatMoney(inv.Total))
+
+	y = 40
+	line(8, 50, "Generated "+time.Now().In(loc).Format(time.RFC3339))
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
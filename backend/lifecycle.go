@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============ ЖИЗНЕННЫЙ ЦИКЛ ============
+//
+// Фоновые задачи (планировщик, мониторы, учёт энергии...) запускаются через
+// background.run и останавливаются отменой общего контекста.
+// По SIGINT/SIGTERM shutdown выполняет шаги по порядку, у каждого свой
+// таймаут; шаг, не уложившийся в него, пропускается с записью в лог:
+//  1. готовность снимается (/readyz отвечает 503) и выдерживается
+//     пауза SHUTDOWN_READINESS_DELAY, чтобы балансировщик перестал
+//     направлять запросы;
+//  2. HTTP-серверы перестают принимать соединения и дожидаются текущих
+//     запросов, потоки событий закрываются;
+//  3. бэкенд отписывается от тем MQTT, перестаёт принимать сообщения,
+//     ещё пришедшие из очереди клиента, и дожидается обработки уже
+//     принятых (в том числе их записи в InfluxDB);
+//  4. фоновые задачи останавливаются, накопленное потребление энергии
+//     дописывается в InfluxDB;
+//  5. MQTT отключается со статусом backend offline, закрываются InfluxDB
+//     и PostgreSQL;
+//  6. накопленные спаны трассировки отправляются экспортёру.
+
+// shuttingDown — идёт остановка: новые запросы лучше направлять другим
+// репликам.
+var shuttingDown atomic.Bool
+
+type backgroundTasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var background = newBackgroundTasks()
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{ctx: ctx, cancel: cancel}
+}
+
+// run запускает задачу в горутине; задача должна вернуться после отмены ctx.
+func (b *backgroundTasks) run(task func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		task(b.ctx)
+	}()
+}
+
+// stop отменяет задачи и ждёт их завершения.
+func (b *backgroundTasks) stop(ctx context.Context) error {
+	b.cancel()
+	return waitGroup(ctx, &b.wg)
+}
+
+// waitGroup ждёт wg, но не дольше ctx.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ingestGate пропускает сообщения MQTT в обработку и при остановке
+// дожидается уже принятых. После drain новые сообщения не принимаются:
+// обработчик вызывается клиентом MQTT конкурентно с остановкой, и
+// счётчик не должен расти, пока его ждут.
+type ingestGate struct {
+	mu      sync.Mutex
+	closing bool
+	active  int
+	idle    chan struct{} // закрывается, когда после drain обработка закончилась
+}
+
+// enter регистрирует сообщение; false — идёт остановка, сообщение
+// нужно отбросить.
+func (g *ingestGate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *ingestGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.closing && g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+func (g *ingestGate) inFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// drain закрывает приём и ждёт обработки принятых сообщений, но не
+// дольше ctx.
+func (g *ingestGate) drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.closing = true
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// runShutdownSteps выполняет шаги по порядку. Шаг, не завершившийся за свой
+// таймаут, продолжает работать в фоне, а остановка переходит к следующему.
+func runShutdownSteps(steps []shutdownStep) {
+	for _, step := range steps {
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+		errc := make(chan error, 1)
+		go func() { errc <- step.run(ctx) }()
+
+		var err error
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			err = fmt.Errorf("не уложились в %s", step.timeout)
+		}
+		cancel()
+		if err != nil {
+			logger("shutdown").Error("Шаг остановки не выполнен", "step", step.name, "error", err)
+		} else {
+			logger("shutdown").Info("Шаг остановки выполнен", "step", step.name, "duration_ms", time.Since(started).Milliseconds())
+		}
+	}
+}
+
+// shutdown останавливает бэкенд (порядок шагов — в начале файла).
+func shutdown(app *App, servers ...*http.Server) {
+	logger("shutdown").Info("Получен сигнал остановки")
+	shuttingDown.Store(true)
+	delay := cfg.Shutdown.ReadinessDelay
+
+	runShutdownSteps([]shutdownStep{
+		{"готовность снята", delay + time.Second, func(ctx context.Context) error {
+			select {
+			case <-time.After(delay):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+		{"HTTP остановлен", 15 * time.Second, func(ctx context.Context) error {
+			var wg sync.WaitGroup
+			errs := make([]error, len(servers))
+			for i, server := range servers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = server.Shutdown(ctx)
+				}()
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"отписка от MQTT, принятые сообщения обработаны", 10 * time.Second, func(ctx context.Context) error {
+			if err := app.unsubscribe(mqttClient); err != nil {
+				logger("shutdown").Warn("Ошибка отписки от MQTT", "error", err)
+			}
+			return app.ingest.drain(ctx)
+		}},
+		{"фоновые задачи остановлены", 10 * time.Second, background.stop},
+		{"MQTT отключён", 5 * time.Second, func(ctx context.Context) error {
+			// Last Will при штатном отключении не отправляется.
+			token := mqttClient.Publish(backendStatusTopic, 1, true, `{"status":"offline"}`)
+			token.WaitTimeout(2 * time.Second)
+			mqttClient.Disconnect(250)
+			return token.Error()
+		}},
+		{"InfluxDB закрыт", 5 * time.Second, func(ctx context.Context) error {
+			influxClient.Close()
+			return nil
+		}},
+		{"PostgreSQL закрыт", 5 * time.Second, func(ctx context.Context) error {
+			return psqlConn.Close()
+		}},
+		{"трассировка выгружена", 5 * time.Second, shutdownTracing},
+	})
+	logger("shutdown").Info("Бэкенд остановлен")
+}
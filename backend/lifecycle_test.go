@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ============ ОСТАНОВКА ============
+
+func TestShutdownStepTimeoutDoesNotBlockLaterSteps(t *testing.T) {
+	var ran []string
+	release := make(chan struct{})
+	defer close(release)
+	started := time.Now()
+	runShutdownSteps([]shutdownStep{
+		{"зависший шаг", 50 * time.Millisecond, func(ctx context.Context) error {
+			<-release // не реагирует на ctx
+			return nil
+		}},
+		{"следующий шаг", time.Second, func(ctx context.Context) error {
+			ran = append(ran, "следующий шаг")
+			return nil
+		}},
+	})
+	if len(ran) != 1 {
+		t.Errorf("шаг после зависшего не выполнен")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("остановка заняла %s, зависший шаг должен был прерваться через 50 мс", elapsed)
+	}
+}
+
+func TestBackgroundTasksStopOnCancel(t *testing.T) {
+	b := newBackgroundTasks()
+	stopped := make(chan struct{})
+	b.run(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("задача не получила отмену")
+	}
+}
+
+func TestHealthReportsShutdown(t *testing.T) {
+	h := newHarness(t)
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	if code := h.call(http.MethodGet, "/readyz", nil, nil, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz во время остановки: %d, ожидалось 503", code)
+	}
+	if code := h.call(http.MethodGet, "/livez", nil, nil, nil); code != http.StatusOK {
+		t.Errorf("livez во время остановки: %d, ожидалось 200", code)
+	}
+}
+
+func TestUnsubscribeBeforeDrain(t *testing.T) {
+	h := newHarness(t)
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 20}`)
+	eventually(t, "показание до отписки", func() bool { return len(h.received()) == 1 })
+
+	if err := h.app.unsubscribe(h.client); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	for _, topic := range []string{"sensors/temperature/x", "controllers/status/x"} {
+		if h.broker.subscribed(topic) {
+			t.Errorf("подписка на %s осталась после отписки", topic)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.app.ingest.drain(ctx); err != nil {
+		t.Errorf("обработка принятых сообщений не завершилась: %v", err)
+	}
+}
+
+func TestIngestGateDrain(t *testing.T) {
+	var g ingestGate
+	if !g.enter() {
+		t.Fatal("сообщение не принято до остановки")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("drain с необработанным сообщением: %v, ожидался таймаут", err)
+	}
+	if g.enter() {
+		t.Error("сообщение принято после начала остановки")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.drain(context.Background()) }()
+	g.leave()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("drain: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain не завершился после обработки сообщения")
+	}
+	if n := g.inFlight(); n != 0 {
+		t.Errorf("в обработке %d, ожидалось 0", n)
+	}
+}
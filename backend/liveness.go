@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ ДОСТУПНОСТЬ УСТРОЙСТВ ============
+//
+// Трекер запоминает время последнего сообщения от каждого источника
+// (sensor_id датчика или controller/<id>) и раз в livenessCheckInterval
+// переводит в offline те, что молчат дольше таймаута своего типа.
+// Контроллеры дополнительно публикуют Last Will {"status":"offline"} в
+// controllers/status/<id> — такое сообщение переводит их в offline сразу.
+// Переходы online/offline пишутся в device_logs.
+//
+// Отслеживаются только источники, найденные в справочнике устройств, и
+// типы, для которых в liveness.timeouts задан собственный таймаут: иначе
+// любой клиент брокера, публикующий в произвольные топики, раздувал бы
+// трекер и метрики.
+
+const (
+	livenessCheckInterval  = 30 * time.Second
+	defaultLivenessTimeout = 10 * time.Minute
+	backendStatusTopic     = "controllers/status/backend"
+)
+
+type DeviceAvailability struct {
+	Key      string    `json:"key"`
+	Type     string    `json:"type"`
+	DeviceID int       `json:"device_id,omitempty"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+	Since    time.Time `json:"since"`
+	Timeout  string    `json:"timeout"`
+}
+
+type livenessTracker struct {
+	mu       sync.Mutex
+	entries  map[string]*DeviceAvailability
+	timeouts map[string]time.Duration
+}
+
+var liveness = &livenessTracker{
+	entries:  map[string]*DeviceAvailability{},
+	timeouts: map[string]time.Duration{},
+}
+
+// livenessKey возвращает ключ источника и тип устройства для топика.
+func livenessKey(topic string) (key, deviceType string) {
+	if rest, ok := strings.CutPrefix(topic, "controllers/status/"); ok {
+		return "controller/" + rest, "controller"
+	}
+	sensorID := strings.TrimPrefix(topic, "sensors/")
+	deviceType = sensorID
+	if i := strings.Index(sensorID, "/"); i >= 0 {
+		deviceType = sensorID[:i]
+	}
+	return sensorID, deviceType
+}
+
+// tracksType — для типа явно настроен таймаут ("default" не в счёт).
+func (t *livenessTracker) tracksType(deviceType string) bool {
+	_, ok := t.timeouts[deviceType]
+	return ok && deviceType != "default"
+}
+
+func (t *livenessTracker) timeoutFor(deviceType string) time.Duration {
+	if d, ok := t.timeouts[deviceType]; ok {
+		return d
+	}
+	if d, ok := t.timeouts["default"]; ok {
+		return d
+	}
+	return defaultLivenessTimeout
+}
+
+// initLiveness загружает таймауты и последнее известное состояние
+// устройств, чтобы после перезапуска не писать повторные переходы.
+func initLiveness() {
+	lastState := map[int]bool{}
+	rows, err := psqlConn.Query(`SELECT DISTINCT ON (device_id) device_id, action FROM device_logs
+		WHERE device_id IS NOT NULL AND action IN ('online', 'offline')
+		ORDER BY device_id, timestamp DESC`)
+	if err != nil {
+		logger("liveness").Error("Ошибка загрузки последних состояний", "error", err)
+	} else {
+		for rows.Next() {
+			var id int
+			var action string
+			if rows.Scan(&id, &action) == nil {
+				lastState[id] = action == "online"
+			}
+		}
+		rows.Close()
+	}
+
+	now := time.Now()
+	liveness.mu.Lock()
+	liveness.timeouts = cfg.Liveness.Timeouts
+	// Устройства с привязкой к топику отслеживаются сразу: если они
+	// не проявятся за таймаут, будут помечены offline.
+	for _, info := range devices.all() {
+		if info.SensorKey == "" {
+			continue
+		}
+		key, deviceType := livenessKey("sensors/" + info.SensorKey)
+		online, known := lastState[info.ID]
+		liveness.entries[key] = &DeviceAvailability{
+			Key:      key,
+			Type:     deviceType,
+			DeviceID: info.ID,
+			Online:   online || !known,
+			LastSeen: now,
+			Since:    now,
+		}
+	}
+	liveness.updateGaugeLocked()
+	liveness.mu.Unlock()
+
+	logger("liveness").Info("Трекер доступности инициализирован", "devices", len(liveness.entries))
+}
+
+// observe — обработчик показаний датчиков (см. readingHandlers).
+func (t *livenessTracker) observe(reading SensorReading) {
+	key, deviceType := livenessKey(reading.Topic)
+	if key == "controller/backend" {
+		return
+	}
+
+	online := true
+	if status, ok := reading.Payload["status"].(string); ok && deviceType == "controller" {
+		online = status != "offline"
+	}
+
+	info, resolved := devices.resolve(reading.Topic)
+
+	t.mu.Lock()
+	entry, ok := t.entries[key]
+	if !ok && !resolved && !t.tracksType(deviceType) {
+		t.mu.Unlock()
+		return
+	}
+	if !ok {
+		entry = &DeviceAvailability{Key: key, Type: deviceType, Since: reading.Time}
+		t.entries[key] = entry
+	}
+	if info.ID != 0 {
+		entry.DeviceID = info.ID
+	}
+	if online {
+		entry.LastSeen = reading.Time
+	}
+	changed := !ok || entry.Online != online
+	if changed {
+		entry.Online = online
+		entry.Since = reading.Time
+		t.updateGaugeLocked()
+	}
+	snapshot := *entry
+	t.mu.Unlock()
+
+	if changed && (ok || online) {
+		t.recordTransition(snapshot, "mqtt")
+	}
+}
+
+// sweep переводит в offline источники, молчащие дольше таймаута.
+func (t *livenessTracker) sweep(now time.Time) {
+	var changed []DeviceAvailability
+
+	t.mu.Lock()
+	for _, entry := range t.entries {
+		if entry.Online && now.Sub(entry.LastSeen) > t.timeoutFor(entry.Type) {
+			entry.Online = false
+			entry.Since = now
+			changed = append(changed, *entry)
+		}
+	}
+	if len(changed) > 0 {
+		t.updateGaugeLocked()
+	}
+	t.mu.Unlock()
+
+	for _, entry := range changed {
+		t.recordTransition(entry, "timeout")
+	}
+}
+
+func (t *livenessTracker) recordTransition(entry DeviceAvailability, reason string) {
+	action := "offline"
+	if entry.Online {
+		action = "online"
+	}
+	logger("liveness").Info("Смена доступности", "key", entry.Key, "device_id", entry.DeviceID, "state", action, "reason", reason)
+
+	info, _ := devices.get(entry.DeviceID)
+	hub.publish(StreamEvent{
+		Type:       "availability",
+		BuildingID: info.BuildingID,
+		RoomID:     info.RoomID,
+		DeviceID:   entry.DeviceID,
+		Data:       entry,
+		Time:       entry.Since,
+	})
+	logDeviceEvent(entry.DeviceID, action, map[string]interface{}{
+		"key":       entry.Key,
+		"type":      entry.Type,
+		"reason":    reason,
+		"last_seen": entry.LastSeen,
+	})
+	if !entry.Online {
+		ticketAutomation.onDeviceOffline(entry)
+	}
+}
+
+func (t *livenessTracker) updateGaugeLocked() {
+	offline := map[string]float64{}
+	for _, entry := range t.entries {
+		if _, ok := offline[entry.Type]; !ok {
+			offline[entry.Type] = 0
+		}
+		if !entry.Online {
+			offline[entry.Type]++
+		}
+	}
+	for deviceType, n := range offline {
+		devicesOffline.WithLabelValues(deviceType).Set(n)
+	}
+}
+
+// status — состояние источника по sensor_id; ok == false, если источник
+// трекеру неизвестен.
+func (t *livenessTracker) status(sensorID string) (DeviceAvailability, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[sensorID]
+	if !ok {
+		return DeviceAvailability{}, false
+	}
+	snapshot := *entry
+	snapshot.Timeout = t.timeoutFor(entry.Type).String()
+	return snapshot, true
+}
+
+func (t *livenessTracker) snapshot() []DeviceAvailability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]DeviceAvailability, 0, len(t.entries))
+	for _, entry := range t.entries {
+		e := *entry
+		e.Timeout = t.timeoutFor(entry.Type).String()
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+func startLivenessMonitor(ctx context.Context) {
+	ticker := time.NewTicker(livenessCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			liveness.sweep(now)
+		}
+	}
+}
+
+// ============ REST API HANDLERS - AVAILABILITY ============
+
+// getDeviceAvailability — доступность всех источников или одного
+// устройства (?device_id=).
+func getDeviceAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := liveness.snapshot()
+	if v := r.URL.Query().Get("device_id"); v != "" {
+		deviceID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Неверный device_id", http.StatusBadRequest)
+			return
+		}
+		filtered := []DeviceAvailability{}
+		for _, entry := range list {
+			if entry.DeviceID == deviceID {
+				filtered = append(filtered, entry)
+			}
+		}
+		list = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
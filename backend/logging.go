@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============ ЖУРНАЛИРОВАНИЕ ============
+//
+// Все сообщения пишутся через log/slog: log.format (LOG_FORMAT) — json или
+// text, log.level (LOG_LEVEL) — debug, info, warn, error. Подсистема —
+// поле component (logger("alerts")). Обработчик дополняет запись полями из
+// контекста: request_id для HTTP-запросов, trace_id/span_id при включённой
+// трассировке и полями, добавленными через withLogAttrs (topic, sensor_id
+// для сообщений MQTT), поэтому записи, сделанные с ...Context(ctx),
+// связываются с запросом или сообщением.
+// Вызовы стандартного log тоже попадают в slog (уровень info).
+
+// initLogging настраивает slog по cfg.Log.
+func initLogging() {
+	ingestLogSampler.first, ingestLogSampler.thereafter = cfg.Log.SampleFirst, cfg.Log.SampleThereafter
+	handler, err := newLogHandler(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+func newLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL: неизвестный уровень %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT: неизвестный формат %q (json или text)", format)
+	}
+	return contextHandler{handler}, nil
+}
+
+// logger — журнал подсистемы.
+func logger(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// fatal записывает ошибку и завершает процесс (замена log.Fatalf).
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// ============ ПОЛЯ ИЗ КОНТЕКСТА ============
+
+type logAttrsKey struct{}
+
+// withLogAttrs добавляет поля ко всем записям, сделанным с этим контекстом.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(append([]slog.Attr{}, prev...), attrs...))
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ============ ПРОРЕЖИВАНИЕ ============
+
+// logSampler прореживает частые однотипные записи (например, об успешной
+// обработке каждого сообщения MQTT): за каждую секунду пишутся первые
+// first записей, дальше — каждая thereafter-я.
+type logSampler struct {
+	first, thereafter int
+
+	mu     sync.Mutex
+	window time.Time
+	count  int
+}
+
+// allow решает, писать ли очередную запись; skipped — сколько записей
+// пропущено с предыдущей записанной.
+func (s *logSampler) allow(now time.Time) (ok bool, skipped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if second := now.Truncate(time.Second); !second.Equal(s.window) {
+		s.window, s.count = second, 0
+	}
+	s.count++
+	if s.count <= s.first {
+		return true, 0
+	}
+	if s.thereafter > 0 && (s.count-s.first)%s.thereafter == 0 {
+		return true, s.thereafter - 1
+	}
+	return false, 0
+}
+
+// ingestLogSampler — записи об успешно обработанных сообщениях MQTT
+// (log.sample_first, log.sample_thereafter); настраивается в initLogging.
+var ingestLogSampler = &logSampler{first: 10, thereafter: 100}
+
+// ============ ЖУРНАЛ HTTP-ЗАПРОСОВ ============
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush и Hijack нужны потокам событий (SSE и WebSocket).
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("соединение не поддерживает Hijack")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLogMiddleware пишет по записи на каждый запрос (после
+// requestIDMiddleware — с его request_id). Пробы /livez и /readyz — на
+// уровне debug.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case r.URL.Path == "/livez" || r.URL.Path == "/readyz":
+			level = slog.LevelDebug
+		}
+		logger("http").Log(r.Context(), level, "HTTP-запрос",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(started).Microseconds())/1000,
+			"remote_ip", clientIP(r),
+		)
+	})
+}
+
+// apiHandler — обработчики API со всеми промежуточными слоями.
+func apiHandler(mux *http.ServeMux) http.Handler {
+	return corsMiddleware(requestIDMiddleware(instrumentMiddleware(mux, accessLogMiddleware(mux))))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============ ЖУРНАЛИРОВАНИЕ ============
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records — записи JSON-журнала.
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("запись журнала не JSON: %q", line)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// captureLogs направляет журнал теста в JSON-буфер.
+func captureLogs(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	handler, err := newLogHandler(buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return buf
+}
+
+func TestLogHandlerConfig(t *testing.T) {
+	for _, c := range []struct{ format, level string }{{"xml", ""}, {"json", "verbose"}} {
+		if _, err := newLogHandler(&bytes.Buffer{}, c.format, c.level); err == nil {
+			t.Errorf("LOG_FORMAT=%q LOG_LEVEL=%q: ожидалась ошибка", c.format, c.level)
+		}
+	}
+
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, "JSON", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(handler)
+	log.Info("не пишется")
+	log.Warn("пишется")
+	if strings.Contains(buf.String(), "не пишется") || !strings.Contains(buf.String(), "пишется") {
+		t.Errorf("уровень warn не соблюдён: %s", buf.String())
+	}
+}
+
+func TestRequestIDInEveryLogLine(t *testing.T) {
+	buf := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/test", func(w http.ResponseWriter, r *http.Request) {
+		logger("test").InfoContext(r.Context(), "внутри обработчика")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	rec := httptest.NewRecorder()
+	apiHandler(mux).ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Request-ID"); got != "req-42" {
+		t.Errorf("X-Request-ID в ответе: %q", got)
+	}
+	records := buf.records(t)
+	if len(records) != 2 {
+		t.Fatalf("записей %d, ожидалось 2 (обработчик и журнал запроса): %v", len(records), records)
+	}
+	for _, r := range records {
+		if r["request_id"] != "req-42" {
+			t.Errorf("запись без request_id: %v", r)
+		}
+	}
+	if access := records[1]; access["component"] != "http" || access["status"] != float64(http.StatusTeapot) || access["path"] != "/api/test" {
+		t.Errorf("журнал запроса: %v", access)
+	}
+}
+
+func TestMQTTLogsCarryTopicAndSensorID(t *testing.T) {
+	h := newHarness(t)
+	buf := captureLogs(t)
+
+	h.broker.publish(t, "sensors/temperature/room1", `{"value": 21}`)
+	h.broker.publish(t, "sensors/temperature/room2", `{"value": `)
+
+	var ok, malformed map[string]interface{}
+	eventually(t, "записи об обработке сообщений", func() bool {
+		for _, r := range buf.records(t) {
+			switch r["msg"] {
+			case "Сообщение обработано":
+				ok = r
+			case "Ошибка парсинга JSON":
+				malformed = r
+			}
+		}
+		return ok != nil && malformed != nil
+	})
+	if ok["topic"] != "sensors/temperature/room1" || ok["sensor_id"] != "temperature/room1" || ok["component"] != "mqtt" {
+		t.Errorf("запись об успешной обработке: %v", ok)
+	}
+	if malformed["topic"] != "sensors/temperature/room2" || malformed["level"] != "WARN" {
+		t.Errorf("запись об испорченном сообщении: %v", malformed)
+	}
+}
+
+func TestLogSampler(t *testing.T) {
+	s := &logSampler{first: 2, thereafter: 3}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var allowed []int
+	var skippedTotal int
+	for i := 1; i <= 8; i++ {
+		if ok, skipped := s.allow(now); ok {
+			allowed = append(allowed, i)
+			skippedTotal += skipped
+		}
+	}
+	if want := []int{1, 2, 5, 8}; !slices.Equal(allowed, want) {
+		t.Errorf("записаны %v, ожидалось %v", allowed, want)
+	}
+	if skippedTotal != 4 {
+		t.Errorf("пропущено %d, ожидалось 4", skippedTotal)
+	}
+
+	// В следующую секунду счёт начинается заново.
+	if ok, _ := s.allow(now.Add(time.Second)); !ok {
+		t.Error("первая запись новой секунды пропущена")
+	}
+}
@@ -3,49 +3,48 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	_ "github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"smart-home/internal/config"
+	"smart-home/internal/store"
+	"smart-home/internal/store/paho"
 )
 
 // ============ СТРУКТУРЫ ДАННЫХ ============
 
-type Building struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-}
-
-type Room struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	BuildingID int    `json:"building_id"`
-}
-
-type Device struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	RoomID int    `json:"room_id"`
-}
-
-type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-}
+// Модели хранилищ (см. internal/store).
+type (
+	Building = store.Building
+	Room     = store.Room
+	Device   = store.Device
+	User     = store.User
+)
 
 type RegisterRequest struct {
 	Username string `json:"username"`
@@ -79,15 +78,21 @@ type AdminSensorData struct {
 	Time  time.Time `json:"time"`
 }
 
-type Config struct {
-	MQTTBroker   string
-	InfluxURL    string
-	InfluxToken  string
-	InfluxOrg    string
-	InfluxBucket string
-	PostgresURL  string
-	HTTPPort     string
-	MetricsPort  string
+// SensorReading — разобранное MQTT-сообщение, которое получают подсистемы
+// (правила, оповещения и т.д.) через readingHandlers.
+type SensorReading struct {
+	Topic    string                 `json:"topic"`
+	SensorID string                 `json:"sensor_id"`
+	Value    float64                `json:"value"`
+	HasValue bool                   `json:"-"`
+	Payload  map[string]interface{} `json:"-"`
+	Time     time.Time              `json:"time"`
+}
+
+// logContext — контекст для записей журнала об обработке показания: они
+// получают поля topic и sensor_id.
+func (r SensorReading) logContext() context.Context {
+	return withLogAttrs(context.Background(), slog.String("topic", r.Topic), slog.String("sensor_id", r.SensorID))
 }
 
 // ============ ГЛОБАЛЬНЫЕ ПЕРЕМЕННЫЕ ============
@@ -96,56 +101,138 @@ var (
 	psqlConn           *sql.DB
 	influxClient       influxdb2.Client
 	mqttClient         mqtt.Client
-	cfg                Config
+	cfg                = config.Defaults()
 	mqttMessagesTotal  *prometheus.CounterVec
 	mqttProcessingTime *prometheus.HistogramVec
 	influxWriteErrors  *prometheus.CounterVec
+	auditWriteErrors   prometheus.Counter
+	alertsActive       *prometheus.GaugeVec
+	devicesOffline     *prometheus.GaugeVec
+
+	thermostatTemperature *prometheus.GaugeVec
+	thermostatSetpoint    *prometheus.GaugeVec
+	thermostatOutput      *prometheus.GaugeVec
+	thermostatActuatorOn  *prometheus.GaugeVec
+	thermostatFailsafe    *prometheus.GaugeVec
+
+	// Обработчики каждого принятого показания, вызываются из onMQTTMessage.
+	readingHandlers = []func(SensorReading){
+		automation.evaluate,
+		alerting.evaluate,
+		liveness.observe,
+		energy.observe,
+		ticketAutomation.checkRange,
+		presence.observe,
+		climate.observe,
+		thermostats.observe,
+		deviceEvents.observe,
+		hub.onReading,
+	}
 )
 
-func getEnvDefault(key, defaultValue string) string {
-    if value := os.Getenv(key); value != "" {
-        return value
-    }
-    return defaultValue
-}
-
 // ============ MAIN ============
 
 func main() {
 	godotenv.Load()
 
-	cfg = Config{
-		MQTTBroker:   os.Getenv("MQTT_BROKER"),
-		InfluxURL:    os.Getenv("INFLUX_URL"),
-		InfluxToken:  os.Getenv("INFLUX_TOKEN"),
-		InfluxOrg:    os.Getenv("INFLUX_ORG"),
-		InfluxBucket: os.Getenv("INFLUX_BUCKET"),
-		PostgresURL:  os.Getenv("DATABASE_URL"),
-		HTTPPort:     getEnvDefault("HTTP_PORT", "8082"),
-		MetricsPort:  getEnvDefault("METRICS_PORT", "2114"),
+	loaded, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return
 	}
-
-	if cfg.PostgresURL == "" {
-		log.Fatal("❌ DATABASE_URL не установлена")
+	if err != nil {
+		// Журнал ещё не настроен: пишем с настройками по умолчанию.
+		initLogging()
+		fatal("Ошибка конфигурации", "error", err)
 	}
+	cfg = loaded
+	initLogging()
 
-	if cfg.InfluxURL == "" || cfg.InfluxToken == "" {
-		log.Fatal("❌ INFLUX_URL/INFLUX_TOKEN не установлены")
+	// Симулятору устройств БД нужна, только если не указан файл сценария.
+	if len(args) > 0 && args[0] == "simulate" {
+		if err := runSimulate(args[1:]); err != nil {
+			fatal("Симулятор остановлен с ошибкой", "error", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfigCommand(args[1:]); err != nil {
+			fatal("Ошибка выполнения команды", "command", "config", "error", err)
+		}
+		return
 	}
 
-	initMetrics()
-	initPostgres(cfg.PostgresURL)
-	defer psqlConn.Close()
-
-	initTables()
+	// Служебные команды (migrate, seed) нужны только PostgreSQL.
+	if len(args) > 0 {
+		if err := cfg.ValidateDatabase(); err != nil {
+			fatal("Ошибка конфигурации", "error", err)
+		}
+		initPostgres(cfg.Postgres.URL)
+		err := runCommand(args)
+		psqlConn.Close()
+		if err != nil {
+			fatal("Ошибка выполнения команды", "command", args[0], "error", err)
+		}
+		return
+	}
 
-	initInfluxDB(cfg.InfluxURL, cfg.InfluxToken)
-	defer influxClient.Close()
+	// Сервер не стартует с неполной конфигурацией или слабыми секретами.
+	if err := cfg.Validate(); err != nil {
+		fatal("Ошибка конфигурации", "error", err)
+	}
 
-	initMQTT(cfg)
+	initMetrics()
+	if err := initTracing(context.Background()); err != nil {
+		fatal("Ошибка настройки трассировки", "error", err)
+	}
+	initPostgres(cfg.Postgres.URL)
+	prometheus.MustRegister(collectors.NewDBStatsCollector(psqlConn, "smart_home"))
+
+	if err := migrateUp(); err != nil {
+		fatal("Ошибка миграций БД", "error", err)
+	}
+	initBlobStore()
+	initBilling()
+	initMailer()
+	initAlerts()
+	initLiveness()
+	initEnergy()
+	initPresence()
+	initClimate()
+
+	initInfluxDB(cfg.Influx.URL, cfg.Influx.Token)
+
+	app := newApp()
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mqtt_messages_in_flight",
+		Help: "Сообщения MQTT в обработке",
+	}, func() float64 { return float64(app.ingest.inFlight()) }))
+	initMQTT(app)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	background.run(startScheduler)
+	background.run(startLivenessMonitor)
+	background.run(startSLAMonitor)
+	background.run(startBillingCycle)
+	background.run(startEnergyAccounting)
+	background.run(startPresenceMonitor)
+	background.run(startThermostats)
+	background.run(startDeviceLogRetention)
+	background.run(app.health().run)
+	metricsServer := startMetricsServer(cfg.Metrics.Addr, metricsOptions{
+		Token:    cfg.Metrics.Token,
+		User:     cfg.Metrics.User,
+		Password: cfg.Metrics.Password,
+		Pprof:    cfg.Metrics.Pprof,
+	})
+	apiServer := startAPIServer(app, cfg.HTTP.Addr)
 
-	go startMetricsServer(cfg.MetricsPort)
-	startAPIServer(cfg.HTTPPort)
+	<-ctx.Done()
+	// Повторный сигнал завершает процесс сразу.
+	stop()
+	shutdown(app, apiServer, metricsServer)
 }
 
 // ============ ИНИЦИАЛИЗАЦИЯ ============
@@ -176,109 +263,165 @@ func initMetrics() {
 		[]string{"reason"},
 	)
 
+	auditWriteErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_write_errors_total",
+			Help: "Количество записей журнала аудита, которые не удалось сохранить",
+		},
+	)
+
+	alertsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "alerts_active",
+			Help: "Количество активных (firing) оповещений",
+		},
+		[]string{"severity"},
+	)
+
 	prometheus.MustRegister(mqttMessagesTotal)
 	prometheus.MustRegister(mqttProcessingTime)
 	prometheus.MustRegister(influxWriteErrors)
+	prometheus.MustRegister(auditWriteErrors)
+	devicesOffline = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "devices_offline",
+			Help: "Количество устройств без связи",
+		},
+		[]string{"type"},
+	)
+
+	prometheus.MustRegister(alertsActive)
+	prometheus.MustRegister(devicesOffline)
+	initHTTPMetrics()
+
+	thermostatGauge := func(name, help string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"thermostat"})
+		prometheus.MustRegister(g)
+		return g
+	}
+	thermostatTemperature = thermostatGauge("thermostat_temperature_celsius", "Температура, измеренная датчиком термостата")
+	thermostatSetpoint = thermostatGauge("thermostat_setpoint_celsius", "Действующая уставка термостата")
+	thermostatOutput = thermostatGauge("thermostat_output", "Выход регулятора термостата (0..1)")
+	thermostatActuatorOn = thermostatGauge("thermostat_actuator_on", "Исполнительное устройство термостата включено")
+	thermostatFailsafe = thermostatGauge("thermostat_failsafe", "Термостат в режиме failsafe (нет показаний)")
 }
 
 func initPostgres(dsn string) {
 	var err error
-	psqlConn, err = sql.Open("postgres", dsn)
+	// Запросы с контекстом HTTP-запроса или сообщения попадают в его трассу.
+	psqlConn, err = otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return hasSpan(ctx)
+			},
+		}))
 	if err != nil {
-		log.Fatalf("Ошибка подключения к PostgreSQL: %v", err)
+		fatal("Ошибка подключения к PostgreSQL", "error", err)
 	}
 
 	_, err = psqlConn.Exec("SET client_encoding = 'UTF8'")
 	if err != nil {
-		log.Printf("Предупреждение: Ошибка установки кодировки: %v", err)
+		slog.Warn("Ошибка установки кодировки", "error", err)
 	}
 
 	if err := psqlConn.Ping(); err != nil {
-		log.Fatalf("Ошибка пинга PostgreSQL: %v", err)
+		fatal("Ошибка пинга PostgreSQL", "error", err)
 	}
 
-	log.Println("✓ Подключение к PostgreSQL успешно")
-}
-
-func initTables() {
-	tables := []string{
-		`CREATE TABLE IF NOT EXISTS user_devices (
-            id SERIAL PRIMARY KEY,
-            user_id INTEGER NOT NULL,
-            device_id INTEGER NOT NULL,
-            payment_type VARCHAR(50) NOT NULL,
-            floorplan TEXT,
-            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-        )`,
-	}
-
-	for _, table := range tables {
-		_, err := psqlConn.Exec(table)
-		if err != nil {
-			log.Printf("Ошибка создания таблицы: %v\n", err)
-		} else {
-			log.Printf("✓ Таблица создана или уже существует")
-		}
-	}
+	slog.Info("Подключение к PostgreSQL успешно")
 }
 
 func initInfluxDB(url, token string) {
 	client := influxdb2.NewClient(url, token)
 	ok, pingErr := client.Ping(context.Background())
 	if !ok || pingErr != nil {
-		log.Fatalf("Ошибка подключения к InfluxDB: %v", pingErr)
+		fatal("Ошибка подключения к InfluxDB", "error", pingErr)
 	}
 
 	influxClient = client
-	log.Println("✓ Подключение к InfluxDB успешно")
+	slog.Info("Подключение к InfluxDB успешно")
 }
 
-func initMQTT(cfg Config) {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(cfg.MQTTBroker)
-	opts.SetClientID("smart-home-module")
-	opts.SetAutoReconnect(true)
-	opts.SetOnConnectHandler(onMQTTConnect)
-	opts.SetConnectionLostHandler(onMQTTConnectionLost)
-	mqttClient = mqtt.NewClient(opts)
+func initMQTT(app *App) {
+	mqttClient = newMQTTClient(cfg.MQTT.Broker, cfg.MQTT.ClientID, app)
 
 	if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("Ошибка подключения к MQTT: %v", token.Error())
-	}
-
-	topics := []string{
-		"sensors/temperature/#",
-		"sensors/humidity/#",
-		"sensors/motion/#",
-		"controllers/status/#",
+		fatal("Ошибка подключения к MQTT", "error", token.Error())
 	}
+	app.Publisher = &paho.Publisher{Client: mqttClient, QoS: byte(cfg.MQTT.QoS), Timeout: cfg.MQTT.PublishTimeout}
+}
 
-	for _, topic := range topics {
-		if token := mqttClient.Subscribe(topic, 1, onMQTTMessage); token.Wait() && token.Error() != nil {
-			log.Printf("Ошибка подписки на тему %s: %v\n", topic, token.Error())
+// newMQTTClient настраивает клиента MQTT. Подписки оформляются при каждом
+// подключении: брокер без сохранённой сессии (перезапуск, clean session)
+// забывает их, и после переподключения сообщения перестали бы приходить.
+func newMQTTClient(broker, clientID string, app *App) mqtt.Client {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		onMQTTConnect(client)
+		// После отписки при остановке переподключение не должно её отменить.
+		if !shuttingDown.Load() {
+			app.subscribe(client)
 		}
-	}
-
-	log.Println("✓ MQTT подписка установлена")
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		onMQTTConnectionLost(client, err)
+		app.setSubscribed("", false)
+	})
+	opts.SetWill(backendStatusTopic, `{"status":"offline"}`, 1, true)
+	return mqtt.NewClient(opts)
 }
 
 // ============ MQTT HANDLERS ============
 
 func onMQTTConnect(client mqtt.Client) {
-	log.Println("[MQTT] Подключение к брокеру установлено")
+	logger("mqtt").Info("Подключение к брокеру установлено")
+	client.Publish(backendStatusTopic, 1, true, `{"status":"online"}`)
 }
 
 func onMQTTConnectionLost(client mqtt.Client, err error) {
-	log.Printf("[MQTT] Потеряно подключение: %v\n", err)
+	logger("mqtt").Warn("Потеряно подключение к брокеру", "error", err)
 }
 
-func onMQTTMessage(client mqtt.Client, msg mqtt.Message) {
+func (a *App) subscribe(client mqtt.Client) {
+	for _, topic := range cfg.MQTT.Topics {
+		token := client.Subscribe(topic, byte(cfg.MQTT.QoS), a.onMQTTMessage)
+		if token.Wait() && token.Error() != nil {
+			logger("mqtt").Error("Ошибка подписки", "topic", topic, "error", token.Error())
+		}
+		a.setSubscribed(topic, token.Error() == nil)
+	}
+	logger("mqtt").Info("Подписка установлена", "topics", len(cfg.MQTT.Topics))
+}
+
+// unsubscribe отписывает клиента от тем бэкенда (при остановке).
+func (a *App) unsubscribe(client mqtt.Client) error {
+	a.setSubscribed("", false)
+	token := client.Unsubscribe(cfg.MQTT.Topics...)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("таймаут отписки")
+	}
+	return token.Error()
+}
+
+func (a *App) onMQTTMessage(client mqtt.Client, msg mqtt.Message) {
+	if !a.ingest.enter() {
+		logger("mqtt").Debug("Сообщение отброшено: идёт остановка", "topic", msg.Topic())
+		return
+	}
+	defer a.ingest.leave()
 	startTime := time.Now()
 	topic := msg.Topic()
+	log := logger("mqtt")
 	var sensorData map[string]interface{}
 
 	if err := json.Unmarshal(msg.Payload(), &sensorData); err != nil {
-		log.Printf("[MQTT] Ошибка парсинга JSON: %v\n", err)
+		log.Warn("Ошибка парсинга JSON", "topic", topic, "error", err)
 		influxWriteErrors.WithLabelValues("json_parse_error").Inc()
 		return
 	}
@@ -286,26 +429,53 @@ func onMQTTMessage(client mqtt.Client, msg mqtt.Message) {
 	// Вычислить sensor_id из топика
 	sensorID := strings.TrimPrefix(topic, "sensors/")
 
+	reading := SensorReading{Topic: topic, SensorID: sensorID, Payload: sensorData, Time: time.Now()}
+	reading.Value, reading.HasValue = numericValue(sensorData["value"])
+	ctx, span := tracer.Start(reading.logContext(), "mqtt process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("mqtt"),
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(topic),
+		))
+	defer span.End()
+	for _, handle := range a.Readings {
+		handle(reading)
+	}
+
 	// Одна point с полной цепочкой
-	point := influxdb2.NewPointWithMeasurement("sensor_data").
-		AddField("value", sensorData["value"]).
-		AddTag("topic", topic).
-		AddTag("sensor_id", sensorID).
-		SetTime(time.Now())
-
-	writeAPI := influxClient.WriteAPIBlocking(cfg.InfluxOrg, cfg.InfluxBucket)
-	if err := writeAPI.WritePoint(context.Background(), point); err != nil {
-		log.Printf("[InfluxDB] Ошибка записи: %v\n", err)
+	if err := a.Telemetry.Write(ctx, topic, sensorID, sensorData["value"], reading.Time); err != nil {
+		log.ErrorContext(ctx, "Ошибка записи в InfluxDB", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		mqttProcessingTime.WithLabelValues(topic, "error").Observe(time.Since(startTime).Seconds())
 		influxWriteErrors.WithLabelValues("write_failed").Inc()
 	} else {
 		mqttMessagesTotal.WithLabelValues(topic).Inc()
 		mqttProcessingTime.WithLabelValues(topic, "success").Observe(time.Since(startTime).Seconds())
-		log.Printf("[OK] Сообщение из %s (sensor_id: %s) за %.3f мс\n",
-			topic, sensorID, time.Since(startTime).Seconds()*1000)
+		// При высокой частоте сообщений успешные записи прореживаются.
+		if ok, skipped := ingestLogSampler.allow(startTime); ok {
+			log.InfoContext(ctx, "Сообщение обработано",
+				"duration_ms", float64(time.Since(startTime).Microseconds())/1000, "skipped", skipped)
+		}
 	}
 }
 
+// numericValue приводит поле value из JSON к числу: датчики движения
+// присылают true/false, остальные — числа.
+func numericValue(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case bool:
+		if val {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
 // ============ AUTHENTICATION FUNCTIONS ============
 
 func hashPassword(password string) (string, error) {
@@ -323,18 +493,43 @@ func generateToken(userID int, username string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id":  userID,
 		"username": username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"exp":      time.Now().Add(cfg.Auth.TokenTTL).Unix(),
 	})
 
-	secret := []byte(os.Getenv("JWT_SECRET")) // убедитесь, что переменная есть в .env
-	tokenString, err := token.SignedString(secret)
+	tokenString, err := token.SignedString([]byte(cfg.Auth.JWTSecret))
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-func registerUser(w http.ResponseWriter, r *http.Request) {
+// userFromRequest извлекает пользователя из заголовка Authorization: Bearer <jwt>.
+func userFromRequest(r *http.Request) (int, string, bool) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" || tokenString == r.Header.Get("Authorization") {
+		return 0, "", false
+	}
+
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		return []byte(cfg.Auth.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, "", false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", false
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, "", false
+	}
+	username, _ := claims["username"].(string)
+	return int(userID), username, true
+}
+
+func (a *App) registerUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -362,14 +557,9 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var userID int
-	err = psqlConn.QueryRow(
-		"INSERT INTO users (username, email, password, role) VALUES ($1, $2, $3, $4) RETURNING id",
-		req.Username, req.Email, hashedPassword, "user",
-	).Scan(&userID)
-
+	userID, err := a.Users.Create(r.Context(), req.Username, req.Email, hashedPassword, "user")
 	if err != nil {
-		log.Printf("Ошибка при регистрации: %v", err)
+		logger("auth").ErrorContext(r.Context(), "Ошибка при регистрации", "error", err)
 		http.Error(w, "Ошибка регистрации", http.StatusBadRequest)
 		return
 	}
@@ -401,7 +591,7 @@ func checkPassword(hashedPassword, plainPassword string) bool {
 	return err == nil
 }
 
-func loginUser(w http.ResponseWriter, r *http.Request) {
+func (a *App) loginUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -418,32 +608,25 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var userID int
-	var username, email, password, role string
-
-	err := psqlConn.QueryRow(
-		"SELECT id, username, email, password, role FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&userID, &username, &email, &password, &role)
-
+	user, err := a.Users.ByUsername(r.Context(), req.Username)
 	if err != nil {
 		http.Error(w, `{"message":"Неверные учетные данные"}`, http.StatusUnauthorized)
 		return
 	}
 
-	if !checkPassword(password, req.Password) {
+	if !checkPassword(user.PasswordHash, req.Password) {
 		http.Error(w, `{"message":"Неверные учетные данные"}`, http.StatusUnauthorized)
 		return
 	}
 
 	// ✅ ИСПРАВЛЕНО: Правильно принимать 2 значения
-	tokenString, _ := generateToken(userID, username)
+	tokenString, _ := generateToken(user.ID, user.Username)
 
 	response := AuthResponse{
-		ID:       userID,
-		Username: username,
-		Email:    email,
-		Role:     role,
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     user.Role,
 		Token:    tokenString,
 		Message:  "Вы успешно вошли",
 	}
@@ -455,53 +638,25 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 
 // ============ ADMIN FUNCTIONS ============
 
-func getAllUsers(w http.ResponseWriter, r *http.Request) {
+func (a *App) getAllUsers(w http.ResponseWriter, r *http.Request) {
 	role := r.Header.Get("X-User-Role")
 	if role != "admin" {
 		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
 		return
 	}
 
-	rows, err := psqlConn.Query("SELECT id, username, email, role, created_at FROM users")
+	users, err := a.Users.List(r.Context())
 	if err != nil {
+		logger("admin").ErrorContext(r.Context(), "Ошибка чтения пользователей", "error", err)
 		http.Error(w, `{"message":"Database error"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	// ✅ Используйте time.Time вместо string
-	type User struct {
-		ID        int       `json:"id"`
-		Username  string    `json:"username"`
-		Email     string    `json:"email"`
-		Role      string    `json:"role"`
-		CreatedAt time.Time `json:"created_at"` // ← Правильно!
-	}
-
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt); err != nil {
-			log.Printf("Ошибка сканирования пользователя: %v", err)
-			continue
-		}
-		users = append(users, user)
-	}
-
-	// ✅ Проверка ошибок после итерации
-	if err = rows.Err(); err != nil {
-		log.Printf("Ошибка при чтении рядов: %v", err)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if len(users) == 0 {
-		w.Write([]byte("[]"))
-		return
-	}
 	json.NewEncoder(w).Encode(users)
 }
 
-func deleteUser(w http.ResponseWriter, r *http.Request) {
+func (a *App) deleteUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 		return
@@ -513,26 +668,31 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.URL.Path[len("/api/admin/users/"):]
-
-	result, err := psqlConn.Exec("DELETE FROM users WHERE id = $1", userID)
+	userID, err := strconv.Atoi(r.URL.Path[len("/api/admin/users/"):])
 	if err != nil {
-		http.Error(w, `{"message":"Database error"}`, http.StatusInternalServerError)
+		http.Error(w, `{"message":"Invalid user id"}`, http.StatusBadRequest)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	user, err := a.Users.Delete(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
 		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, `{"message":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	a.Audit(r, "user.delete", "user", userID, map[string]string{
+		"username": user.Username, "email": user.Email, "role": user.Role,
+	}, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message":"User deleted successfully"}`))
 }
 
-func changeUserRole(w http.ResponseWriter, r *http.Request) {
+func (a *App) changeUserRole(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 		return
@@ -556,11 +716,18 @@ func changeUserRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := psqlConn.Exec("UPDATE users SET role = $1 WHERE id = $2", req.NewRole, req.UserID)
+	// Карточка работника следует за ролью (см. syncWorkerRecord).
+	oldRole, err := a.Users.SetRole(r.Context(), req.UserID, req.NewRole)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"message":"Database error"}`, http.StatusInternalServerError)
 		return
 	}
+	a.Audit(r, "user.role", "user", req.UserID,
+		map[string]string{"role": oldRole}, map[string]string{"role": req.NewRole})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -568,7 +735,7 @@ func changeUserRole(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func getAdminSensors(w http.ResponseWriter, r *http.Request) {
+func (a *App) getAdminSensors(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -583,74 +750,52 @@ func getAdminSensors(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// InfluxDB данные (если есть)
-	queryAPI := influxClient.QueryAPI(cfg.InfluxOrg)
-	query := `from(bucket: "sensors")
-        |> range(start: -24h)
-        |> filter(fn: (r) => r["_measurement"] == "sensor_data")
-        |> filter(fn: (r) => r["_field"] == "value")`
-
-	result, err := queryAPI.Query(context.Background(), query)
-	if err == nil {
-		for result.Next() {
-			rec := result.Record()
-			topic := fmt.Sprintf("%v", rec.ValueByKey("topic"))
-			var value float64
-			switch v := rec.Value().(type) {
-			case float64:
-				value = v
-			case int64:
-				value = float64(v)
-			default:
-				continue
-			}
-			unit := "value"
-			if strings.Contains(topic, "temperature") {
-				unit = "°C"
-			} else if strings.Contains(topic, "humidity") {
-				unit = "%"
-			}
-			sensors = append(sensors, AdminSensorData{
-				Topic: topic,
-				Value: value,
-				Unit:  unit,
-				Time:  rec.Time(),
-			})
-		}
+	samples, err := a.Telemetry.Recent(r.Context(), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		logger("telemetry").ErrorContext(r.Context(), "Ошибка запроса к InfluxDB", "error", err)
+	}
+	for _, s := range samples {
+		sensors = append(sensors, AdminSensorData{
+			Topic: s.Topic,
+			Value: s.Value,
+			Unit:  sensorUnit(s.Topic, "value"),
+			Time:  s.Time,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sensors) // ТОЛЬКО ОДИН!
 }
 
+// sensorUnit — единица измерения по топику или ID датчика.
+func sensorUnit(sensor, fallback string) string {
+	if strings.Contains(sensor, "temperature") {
+		return "°C"
+	} else if strings.Contains(sensor, "humidity") {
+		return "%"
+	}
+	return fallback
+}
+
 // ============ REST API HANDLERS - BUILDINGS ============
 
-func getBuildings(w http.ResponseWriter, r *http.Request) {
+func (a *App) getBuildings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	rows, err := psqlConn.Query("SELECT id, name FROM building")
+	buildings, err := a.Buildings.List(r.Context())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	defer rows.Close()
-	buildings := []Building{}
-	for rows.Next() {
-		var b Building
-		if err := rows.Scan(&b.ID, &b.Name); err != nil {
-			continue
-		}
-		buildings = append(buildings, b)
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(buildings)
 }
 
-func createBuilding(w http.ResponseWriter, r *http.Request) {
+func (a *App) createBuilding(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -662,27 +807,35 @@ func createBuilding(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := psqlConn.QueryRow(
-		"INSERT INTO building (name) VALUES ($1) RETURNING id",
-		building.Name).Scan(&building.ID)
+	if building.Timezone == "" {
+		building.Timezone = defaultTimezone
+	}
+	if _, err := time.LoadLocation(building.Timezone); err != nil {
+		http.Error(w, "Неизвестный часовой пояс", http.StatusBadRequest)
+		return
+	}
+
+	id, err := a.Buildings.Create(r.Context(), building)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
+	building.ID = id
+	a.Audit(r, "building.create", "building", building.ID, nil, building)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(building)
 }
 
-func updateBuilding(w http.ResponseWriter, r *http.Request) {
+func (a *App) updateBuilding(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	id := r.URL.Query().Get("id")
-	if id == "" {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
 		http.Error(w, "ID не указан", http.StatusBadRequest)
 		return
 	}
@@ -693,79 +846,83 @@ func updateBuilding(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := psqlConn.Exec(
-		"UPDATE building SET name = $1 WHERE id = $2",
-		building.Name, id)
+	if building.Timezone != "" {
+		if _, err := time.LoadLocation(building.Timezone); err != nil {
+			http.Error(w, "Неизвестный часовой пояс", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before, after, err := a.Buildings.Update(r.Context(), id, building)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
+	a.Audit(r, "building.update", "building", id, before, after)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
 }
 
-func deleteBuilding(w http.ResponseWriter, r *http.Request) {
+func (a *App) deleteBuilding(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	id := r.URL.Query().Get("id")
-	if id == "" {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
 		http.Error(w, "ID не указан", http.StatusBadRequest)
 		return
 	}
 
-	_, err := psqlConn.Exec("DELETE FROM building WHERE id = $1", id)
+	before, err := a.Buildings.Delete(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
+	a.Audit(r, "building.delete", "building", id, before, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // ============ REST API HANDLERS - ROOMS ============
 
-func getRooms(w http.ResponseWriter, r *http.Request) {
+func (a *App) getRooms(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	buildingID := r.URL.Query().Get("building_id")
-
-	var rows *sql.Rows
-	var err error
-
-	if buildingID != "" {
-		rows, err = psqlConn.Query("SELECT id, name, building_id FROM room WHERE building_id = $1", buildingID)
-	} else {
-		rows, err = psqlConn.Query("SELECT id, name, building_id FROM room")
+	var buildingID int
+	if v := r.URL.Query().Get("building_id"); v != "" {
+		var err error
+		if buildingID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "building_id должен быть числом", http.StatusBadRequest)
+			return
+		}
 	}
 
+	rooms, err := a.Buildings.Rooms(r.Context(), buildingID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	defer rows.Close()
-	rooms := []Room{}
-	for rows.Next() {
-		var rm Room
-		if err := rows.Scan(&rm.ID, &rm.Name, &rm.BuildingID); err != nil {
-			continue
-		}
-		rooms = append(rooms, rm)
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(rooms)
 }
 
-func createRoom(w http.ResponseWriter, r *http.Request) {
+func (a *App) createRoom(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -777,13 +934,12 @@ func createRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := psqlConn.QueryRow(
-		"INSERT INTO room (name, building_id) VALUES ($1, $2) RETURNING id",
-		room.Name, room.BuildingID).Scan(&room.ID)
+	id, err := a.Buildings.CreateRoom(r.Context(), room)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
+	room.ID = id
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -792,7 +948,7 @@ func createRoom(w http.ResponseWriter, r *http.Request) {
 
 // ============ REST API HANDLERS - DEVICES ============
 
-func createDevice(w http.ResponseWriter, r *http.Request) {
+func (a *App) createDevice(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
@@ -804,62 +960,47 @@ func createDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := psqlConn.QueryRow(
-		"INSERT INTO device (name, room_id) VALUES ($1, $2) RETURNING id",
-		d.Name, d.RoomID,
-	).Scan(&d.ID)
+	userID, plan, release, ok := a.DeviceQuota(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := a.Devices.Create(r.Context(), d, userID, plan)
+	release()
 	if err != nil {
-		log.Printf("Ошибка при вставке в БД: %v", err)
+		logger("devices").ErrorContext(r.Context(), "Ошибка при вставке в БД", "error", err)
 		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
 		return
 	}
+	d.ID = id
+	a.DeviceCreated(d)
+	a.Audit(r, "device.create", "device", d.ID, nil, d)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(d)
 }
 
-func getDevices(w http.ResponseWriter, r *http.Request) {
+func (a *App) getDevices(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Тестовые данные (не обращаться к БД)
-	devices := []Device{
-		{ID: 1, Name: "Кондиционер Кухня", RoomID: 1},
-		{ID: 2, Name: "Свет Спальня", RoomID: 2},
-		{ID: 3, Name: "Люстра Гостинаая", RoomID: 3},
-		{ID: 4, Name: "Обогреватель Ванная", RoomID: 4},
+	devices, err := a.Devices.List(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(devices)
 }
 
-// ============ HEALTH CHECK ============
-
-func getHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
-		return
-	}
-
-	okInflux, _ := influxClient.Ping(context.Background())
-	health := map[string]interface{}{
-		"status":    "ok",
-		"timestamp": time.Now(),
-		"mqtt":      mqttClient.IsConnected(),
-		"postgres":  psqlConn.Ping() == nil,
-		"influxdb":  okInflux,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(health)
-}
+// ============ SENSOR DATA ============
 
 // Получение последних данных сенсора из InfluxDB
-func getSensorData(w http.ResponseWriter, r *http.Request) {
+func (a *App) getSensorData(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -871,23 +1012,13 @@ func getSensorData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ✅ 1. ДОБАВИТЬ: Flux запрос
-	queryAPI := influxClient.QueryAPI(cfg.InfluxOrg)
-	query := fmt.Sprintf(`
-        from(bucket: "%s")
-        |> range(start: -24h)
-        |> filter(fn: (r) => r.sensor_id == "%s")
-        |> last()
-    `, cfg.InfluxBucket, sensorID)
-
-	result, err := queryAPI.Query(context.Background(), query)
+	sample, found, err := a.Telemetry.Latest(r.Context(), sensorID, time.Now().Add(-24*time.Hour))
 	if err != nil {
-		log.Printf("[InfluxDB] Error: %v", err)
+		logger("telemetry").ErrorContext(r.Context(), "Ошибка запроса к InfluxDB", "error", err)
 		http.Error(w, "InfluxDB error", http.StatusInternalServerError)
 		return
 	}
 
-	// ✅ 2. ДОБАВИТЬ: Структура ответа + статус
 	type SensorDataResponse struct {
 		SensorID  string    `json:"sensor_id"`
 		Value     float64   `json:"value"`
@@ -897,132 +1028,332 @@ func getSensorData(w http.ResponseWriter, r *http.Request) {
 		Status    string    `json:"status"`
 	}
 
-	var sensorData SensorDataResponse
-	sensorData.SensorID = sensorID
-
-	// ✅ 3. ДОБАВИТЬ: Обработка данных + статус
-	if result.Next() {
-		rec := result.Record()
-		sensorData.Field = rec.Field()
-		sensorData.Timestamp = rec.Time()
-
-		switch v := rec.Value().(type) {
-		case float64:
-			sensorData.Value = v
-		case int64:
-			sensorData.Value = float64(v)
-		}
+	sensorData := SensorDataResponse{SensorID: sensorID, Status: "offline"}
+	if found {
+		sensorData.Field = sample.Field
+		sensorData.Timestamp = sample.Time
+		sensorData.Value = sample.Value
+		sensorData.Unit = sensorUnit(sensorID, "")
 
-		// ✅ СТАТУС по времени (главное исправление!)
-		dataAge := time.Since(sensorData.Timestamp).Minutes()
-		if dataAge < 10 {
+		// Статус — из трекера доступности; возраст данных — только для
+		// источников, о которых трекер ещё не знает.
+		if online, known := a.SensorStatus(sensorID); known {
+			if online {
+				sensorData.Status = "online"
+			}
+		} else if time.Since(sensorData.Timestamp) < defaultLivenessTimeout {
 			sensorData.Status = "online"
-		} else {
-			sensorData.Status = "offline"
 		}
+	}
 
-		// Единицы измерения
-		if strings.Contains(sensorID, "temperature") {
-			sensorData.Unit = "°C"
-		} else if strings.Contains(sensorID, "humidity") {
-			sensorData.Unit = "%"
-		} else {
-			sensorData.Unit = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sensorData)
+}
+
+// getSensorHistory — ряд показаний датчика за период
+// (?sensor_id=, ?from=, ?to= в RFC3339, ?every= — окно агрегации).
+// Период ограничен окном хранения тарифа пользователя.
+func (a *App) getSensorHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sensorID := r.URL.Query().Get("sensor_id")
+	if sensorID == "" {
+		http.Error(w, "sensor_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
 		}
-	} else {
-		sensorData.Status = "offline"
-		sensorData.Value = 0
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	every := 5 * time.Minute
+	if v := r.URL.Query().Get("every"); v != "" {
+		if every, err = time.ParseDuration(v); err != nil || every < time.Minute {
+			http.Error(w, "every: длительность не меньше 1m", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "to должен быть позже from", http.StatusBadRequest)
+		return
+	}
+
+	from, ok := a.HistoryWindow(w, r, from, to)
+	if !ok {
+		return
+	}
+
+	points, err := a.Telemetry.History(r.Context(), sensorID, from, to, every)
+	if err != nil {
+		logger("telemetry").ErrorContext(r.Context(), "Ошибка запроса к InfluxDB", "error", err)
+		http.Error(w, "InfluxDB error", http.StatusInternalServerError)
+		return
 	}
 
-	// ✅ 4. Ответ JSON
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(sensorData)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sensor_id": sensorID,
+		"from":      from,
+		"to":        to,
+		"points":    points,
+	})
 }
 
 // ============ СЕРВЕРЫ ============
 
-func startMetricsServer(port string) {
-    http.Handle("/metrics", promhttp.Handler())
-    log.Printf("Метрики доступны на http://localhost:%s/metrics\n", port)
-    log.Fatal(http.ListenAndServe(":"+port, nil))
+// serve запускает сервер; Shutdown при остановке ошибкой не считается.
+func serve(server *http.Server) {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("Ошибка HTTP-сервера", "addr", server.Addr, "error", err)
+	}
 }
 
-func startAPIServer(port string) {
+func startAPIServer(app *App, addr string) *http.Server {
 	mux := http.NewServeMux()
-	handler := corsMiddleware(mux)
+	handler := apiHandler(mux)
 
-	// Аутентификация
-	mux.HandleFunc("/api/auth/register", registerUser)
-	mux.HandleFunc("/api/auth/login", loginUser)
+	// Аутентификация, пользователи, здания, комнаты, устройства, датчики
+	app.routes(mux)
 
-	// Админ-панель
-	mux.HandleFunc("/api/admin/users", getAllUsers)
-	mux.HandleFunc("/api/admin/users/", deleteUser)
-	mux.HandleFunc("/api/admin/users/role", changeUserRole)
-	mux.HandleFunc("/api/admin/sensors", getAdminSensors)
-	mux.HandleFunc("/api/sensors/data", getSensorData)
+	// Журнал аудита
+	mux.HandleFunc("/api/admin/audit", getAuditLog)
+	mux.HandleFunc("/api/admin/audit/verify", verifyAuditLog)
 
-	// Здания
-	mux.HandleFunc("/api/buildings", func(w http.ResponseWriter, r *http.Request) {
+	// Сцены и команды
+	mux.HandleFunc("/api/scenes", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			getBuildings(w, r)
+			getScenes(w, r)
 		case http.MethodPost:
-			createBuilding(w, r)
+			createScene(w, r)
+		case http.MethodDelete:
+			deleteScene(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/scenes/run", runSceneHandler)
+	mux.HandleFunc("/api/devices/command", sendDeviceCommand)
+	mux.HandleFunc("/api/devices/availability", getDeviceAvailability)
+	mux.HandleFunc("/api/devices/logs", getDeviceLogs)
+
+	// Расписания
+	mux.HandleFunc("/api/schedules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getSchedules(w, r)
+		case http.MethodPost:
+			createSchedule(w, r)
+		case http.MethodPut:
+			updateSchedule(w, r)
+		case http.MethodDelete:
+			deleteSchedule(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/schedules/upcoming", getUpcomingRuns)
+	mux.HandleFunc("/api/schedules/runs", getScheduleRuns)
+
+	// Режимы дома
+	mux.HandleFunc("/api/modes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getModes(w, r)
+		case http.MethodPost:
+			createMode(w, r)
+		case http.MethodDelete:
+			deleteMode(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/modes/switch", switchModeHandler)
+	mux.HandleFunc("/api/modes/history", getModeHistory)
+
+	// Правила автоматизации
+	mux.HandleFunc("/api/rules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getRules(w, r)
+		case http.MethodPost:
+			createRule(w, r)
+		case http.MethodPut:
+			updateRule(w, r)
+		case http.MethodDelete:
+			deleteRule(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Оповещения
+	mux.HandleFunc("/api/alerts", getAlerts)
+	mux.HandleFunc("/api/alerts/ack", acknowledgeAlert)
+	mux.HandleFunc("/api/alerts/silence", silenceAlertRule)
+	mux.HandleFunc("/api/alerts/rules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getAlertRules(w, r)
+		case http.MethodPost:
+			createAlertRule(w, r)
+		case http.MethodPut:
+			updateAlertRule(w, r)
+		case http.MethodDelete:
+			deleteAlertRule(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Энергопотребление
+	mux.HandleFunc("/api/energy/report", getEnergyReport)
+	mux.HandleFunc("/api/energy/usage", getEnergyUsage)
+	mux.HandleFunc("/api/energy/tariffs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getEnergyTariffs(w, r)
+		case http.MethodPost:
+			createEnergyTariff(w, r)
+		case http.MethodDelete:
+			deleteEnergyTariff(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/devices/power", setDevicePower)
+
+	// Присутствие
+	mux.HandleFunc("/api/presence", getPresence)
+	mux.HandleFunc("/api/presence/timeout", setVacancyTimeout)
+
+	// Термостаты
+	mux.HandleFunc("/api/thermostats", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getThermostats(w, r)
+		case http.MethodPost:
+			createThermostat(w, r)
 		case http.MethodPut:
-			updateBuilding(w, r)
+			updateThermostat(w, r)
 		case http.MethodDelete:
-			deleteBuilding(w, r)
+			deleteThermostat(w, r)
 		default:
 			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		}
 	})
 
-	// Комнаты
-	mux.HandleFunc("/api/rooms", func(w http.ResponseWriter, r *http.Request) {
+	// Микроклимат
+	mux.HandleFunc("/api/climate", getClimate)
+	mux.HandleFunc("/api/climate/history", getClimateHistory)
+	mux.HandleFunc("/api/climate/targets", setComfortTargets)
+
+	// Тарифы
+	mux.HandleFunc("/api/plans", getPlans)
+	mux.HandleFunc("/api/plans/usage", getPlanUsage)
+
+	// Биллинг
+	mux.HandleFunc("/api/billing/subscription", getSubscription)
+	mux.HandleFunc("/api/billing/plan", changePlanHandler)
+	mux.HandleFunc("/api/billing/ledger", getLedger)
+	mux.HandleFunc("/api/billing/credits", addBillingCredit)
+	mux.HandleFunc("/api/billing/invoices", getInvoices)
+	mux.HandleFunc("/api/billing/invoice", getInvoice)
+	mux.HandleFunc("/api/billing/invoice/pay", retryInvoicePayment)
+
+	// Работники
+	mux.HandleFunc("/api/workers", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			getRooms(w, r)
+			getWorkers(w, r)
 		case http.MethodPost:
-			createRoom(w, r)
+			createWorker(w, r)
+		case http.MethodPut:
+			updateWorker(w, r)
+		case http.MethodDelete:
+			deleteWorker(w, r)
 		default:
 			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		}
 	})
+	mux.HandleFunc("/api/workers/buildings", setWorkerBuildings)
+	mux.HandleFunc("/api/workers/shifts", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getWorkerShifts(w, r)
+		case http.MethodPost:
+			createWorkerShift(w, r)
+		case http.MethodDelete:
+			deleteWorkerShift(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/workers/available", getAvailableWorkers)
 
-	// Устройства
-	mux.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+	// Заявки на обслуживание
+	mux.HandleFunc("/api/tickets", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			getDevices(w, r)
+			getTickets(w, r)
 		case http.MethodPost:
-			createDevice(w, r)
+			createTicket(w, r)
 		default:
 			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		}
 	})
+	mux.HandleFunc("/api/tickets/detail", getTicket)
+	mux.HandleFunc("/api/tickets/my", getMyTickets)
+	mux.HandleFunc("/api/tickets/assign", assignTicket)
+	mux.HandleFunc("/api/tickets/status", changeTicketStatus)
+	mux.HandleFunc("/api/tickets/comments", addTicketComment)
+	mux.HandleFunc("/api/tickets/photos", uploadTicketPhoto)
+	mux.HandleFunc("/api/tickets/photo", getTicketPhoto)
 
-	// Health Check
-	mux.HandleFunc("/api/health", getHealth)
+	// Поток событий (WebSocket / SSE)
+	mux.HandleFunc("/api/stream", streamHandler)
 
-	log.Printf("REST API запущен на http://localhost:%s\n", port)
+	slog.Info("REST API запущен", "url", displayURL(addr))
 
 	server := &http.Server{
-		Addr:         ":" + port,
+		Addr:         addr,
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
 	}
+	server.RegisterOnShutdown(hub.closeAll)
 
-	log.Fatal(server.ListenAndServe())
+	go serve(server)
+	return server
 }
 
+// corsMiddleware разрешает запросы с Origin из http.cors_origins (CORS_ORIGINS):
+// "*" — с любого, иначе Origin запроса возвращается, только если он в списке.
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if !slices.Contains(cfg.HTTP.CORSOrigins, "*") {
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-Role") // ← ДОБАВИТЬ!
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-Role, X-Request-ID") // ← ДОБАВИТЬ!
 		w.Header().Set("X-Frame-Options", "ALLOWALL")
 
 		if r.Method == http.MethodOptions {
@@ -1032,3 +1363,17 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// allowedOrigin возвращает значение Access-Control-Allow-Origin для
+// Origin запроса или "", если он не разрешён.
+func allowedOrigin(origin string) string {
+	for _, allowed := range cfg.HTTP.CORSOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(origin, allowed) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ============ СЕРВЕР МЕТРИК ============
+//
+// Метрики отдаются отдельным сервером (METRICS_PORT) со своим mux — не
+// через http.DefaultServeMux, куда что угодно может зарегистрироваться
+// побочным импортом. Доступ можно закрыть:
+//   - METRICS_TOKEN — заголовок Authorization: Bearer <token>;
+//   - METRICS_USER / METRICS_PASSWORD — Basic-аутентификация.
+//
+// Если заданы оба способа, подходит любой. METRICS_PPROF=true добавляет
+// /debug/pprof/ (за той же аутентификацией).
+
+type metricsOptions struct {
+	Token    string
+	User     string
+	Password string
+	Pprof    bool
+}
+
+func (o metricsOptions) authRequired() bool {
+	return o.Token != "" || o.User != ""
+}
+
+// displayURL — адрес сервера для журнала.
+func displayURL(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func metricsHandler(opts metricsOptions) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if opts.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if !opts.authRequired() {
+		return mux
+	}
+	return metricsAuth(opts, mux)
+}
+
+func metricsAuth(opts metricsOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if opts.Token != "" {
+			if token, ok := strings.CutPrefix(auth, "Bearer "); ok && secureEqual(token, opts.Token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if opts.User != "" {
+			if user, password, ok := r.BasicAuth(); ok && secureEqual(user, opts.User) && secureEqual(password, opts.Password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		}
+		http.Error(w, "Требуется аутентификация", http.StatusUnauthorized)
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func startMetricsServer(addr string, opts metricsOptions) *http.Server {
+	slog.Info("Метрики доступны", "url", displayURL(addr)+"/metrics",
+		"auth", opts.authRequired(), "pprof", opts.Pprof)
+	if opts.Pprof && !opts.authRequired() {
+		slog.Warn("pprof открыт без аутентификации: задайте METRICS_TOKEN или METRICS_USER")
+	}
+	server := &http.Server{Addr: addr, Handler: metricsHandler(opts)}
+	go serve(server)
+	return server
+}
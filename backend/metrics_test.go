@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============ СЕРВЕР МЕТРИК ============
+
+func TestMetricsAuth(t *testing.T) {
+	get := func(h http.Handler, path string, auth func(*http.Request)) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != nil {
+			auth(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+
+	open := metricsHandler(metricsOptions{})
+	if code := get(open, "/metrics", nil); code != http.StatusOK {
+		t.Errorf("без настроек аутентификации: %d", code)
+	}
+	if code := get(open, "/debug/pprof/", nil); code != http.StatusNotFound {
+		t.Errorf("pprof без METRICS_PPROF: %d, ожидалось 404", code)
+	}
+
+	protected := metricsHandler(metricsOptions{Token: "s3cret", User: "prom", Password: "pw", Pprof: true})
+	for name, c := range map[string]struct {
+		auth func(*http.Request)
+		want int
+	}{
+		"без заголовка":    {nil, http.StatusUnauthorized},
+		"неверный токен":   {bearer("wrong"), http.StatusUnauthorized},
+		"токен":            {bearer("s3cret"), http.StatusOK},
+		"неверный пароль":  {basic("prom", "nope"), http.StatusUnauthorized},
+		"логин и пароль":   {basic("prom", "pw"), http.StatusOK},
+		"токен как пароль": {basic("prom", "s3cret"), http.StatusUnauthorized},
+	} {
+		if code := get(protected, "/metrics", c.auth); code != c.want {
+			t.Errorf("%s: %d, ожидалось %d", name, code, c.want)
+		}
+	}
+	if code := get(protected, "/debug/pprof/", bearer("s3cret")); code != http.StatusOK {
+		t.Errorf("pprof с токеном: %d", code)
+	}
+	if code := get(protected, "/debug/pprof/", nil); code != http.StatusUnauthorized {
+		t.Errorf("pprof без токена: %d, ожидалось 401", code)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============ МИГРАЦИИ СХЕМЫ ============
+//
+// Схема БД описана версионными миграциями в migrations/ (вшиты в бинарник):
+// NNNN_name.up.sql и NNNN_name.down.sql. Применённые версии хранятся в
+// schema_migrations. Каждая миграция выполняется в своей транзакции, а весь
+// прогон — под сессионным advisory-локом, так что несколько реплик,
+// стартующих одновременно, применяют миграции по очереди.
+// Демонстрационные данные лежат отдельно (seeds/demo.sql) и загружаются
+// только командой seed.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+//go:embed seeds/demo.sql
+var demoSeed string
+
+// migrationLockKey — ключ pg_advisory_lock на время прогона миграций.
+const migrationLockKey = 0x6d6967726174
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// loadMigrations читает вшитые миграции, упорядоченные по версии. У каждой
+// версии должны быть оба файла, up и down.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("миграция %s: имя должно быть NNNN_name.up.sql или NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("миграция %d: разные имена %q и %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	list := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("миграция %d_%s: нужны оба файла, up и down", mig.Version, mig.Name)
+		}
+		list = append(list, *mig)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// withMigrationLock выполняет fn на отдельном соединении под
+// pg_advisory_lock. Сессионный лок привязан к соединению, поэтому всё,
+// что делает fn, должно идти через conn.
+func withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := psqlConn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        )`); err != nil {
+		return fmt.Errorf("schema_migrations: %w", err)
+	}
+	return fn(ctx, conn)
+}
+
+func appliedMigrations(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}) ([]appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// hasStatements — есть ли в файле что-то кроме комментариев (down-миграция
+// может быть пустой, если откатывать нечего).
+func hasStatements(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMigration выполняет тело миграции и правку schema_migrations в
+// одной транзакции: либо применено всё, либо ничего.
+func applyMigration(ctx context.Context, conn *sql.Conn, body, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if hasStatements(body) {
+		if _, err := tx.ExecContext(ctx, body); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateUp применяет все ещё не применённые миграции по порядку.
+func migrateUp() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		done := map[int]bool{}
+		latest := 0
+		for _, a := range applied {
+			done[a.Version] = true
+			if a.Version > latest {
+				latest = a.Version
+			}
+		}
+		if n := len(migrations); n > 0 && latest > migrations[n-1].Version {
+			logger("migrate").Warn("В БД применена миграция новее известных этому бинарнику", "applied", latest, "known", migrations[n-1].Version)
+		}
+
+		count := 0
+		for _, m := range migrations {
+			if done[m.Version] {
+				continue
+			}
+			started := time.Now()
+			if err := applyMigration(ctx, conn, m.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name,
+			); err != nil {
+				return fmt.Errorf("миграция %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger("migrate").Info("Миграция применена", "version", m.Version, "name", m.Name, "duration_ms", time.Since(started).Milliseconds())
+			count++
+		}
+		if count == 0 {
+			logger("migrate").Info("Схема БД актуальна")
+		}
+		return nil
+	})
+}
+
+// migrateDown откатывает steps последних применённых миграций.
+func migrateDown(steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	known := map[int]migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	return withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			m, ok := known[applied[i].Version]
+			if !ok {
+				return fmt.Errorf("миграция %d: нет в этом бинарнике, откат невозможен", applied[i].Version)
+			}
+			if err := applyMigration(ctx, conn, m.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", m.Version,
+			); err != nil {
+				return fmt.Errorf("откат %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger("migrate").Info("Миграция откачена", "version", m.Version, "name", m.Name)
+		}
+		return nil
+	})
+}
+
+// migrateStatus печатает все известные и применённые миграции.
+func migrateStatus(out io.Writer) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(context.Background(), psqlConn)
+	if err != nil {
+		// До первого migrate up таблицы ещё нет — всё в ожидании.
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "42P01" {
+			return err
+		}
+	}
+	done := map[int]appliedMigration{}
+	for _, a := range applied {
+		done[a.Version] = a
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, m := range migrations {
+		if a, ok := done[m.Version]; ok {
+			fmt.Fprintf(tw, "%04d\t%s\tapplied\t%s\n", m.Version, m.Name, a.AppliedAt.Format(time.RFC3339))
+			delete(done, m.Version)
+		} else {
+			fmt.Fprintf(tw, "%04d\t%s\tpending\t\n", m.Version, m.Name)
+		}
+	}
+	for _, a := range applied {
+		if _, ok := done[a.Version]; ok {
+			fmt.Fprintf(tw, "%04d\t%s\tunknown\t%s\n", a.Version, a.Name, a.AppliedAt.Format(time.RFC3339))
+		}
+	}
+	return tw.Flush()
+}
+
+// seedDemoData загружает демонстрационные данные, если в БД ещё нет
+// пользователей.
+func seedDemoData() error {
+	var users int
+	if err := psqlConn.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		logger("migrate").Info("БД не пуста, демонстрационные данные не загружаются")
+		return nil
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(demoSeed); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger("migrate").Info("Демонстрационные данные загружены")
+	return nil
+}
+
+// ============ КОМАНДЫ ============
+
+const commandUsage = `Использование:
+  smart-home [флаги] [команда]
+  smart-home                   запустить сервер (миграции применяются при старте)
+  smart-home migrate status    список миграций и их состояние
+  smart-home migrate up        применить все новые миграции
+  smart-home migrate down [N]  откатить N последних миграций (по умолчанию 1)
+  smart-home seed              загрузить демонстрационные данные в пустую БД
+  smart-home simulate [-scenario файл.yaml] [-broker URL] [-interval 5s] [-seed N] [-duration D]
+                               публиковать показания симулированных устройств
+                               (без -scenario — устройства из PostgreSQL)
+  smart-home config print      действующая конфигурация (секреты скрыты) и её ошибки
+
+Флаги (до команды) перекрывают переменные окружения, те — файл -config:
+  -config файл.yaml            YAML-файл конфигурации (CONFIG_FILE)
+  -http.addr :8082, -mqtt.qos 1, ...
+                               любой параметр по пути в файле; полный
+                               список — smart-home -help`
+
+// runConfigCommand выполняет config print: печатает конфигурацию и
+// сообщает, с чем из неё сервер не запустится. БД для неё не нужна.
+func runConfigCommand(args []string) error {
+	if len(args) == 1 && args[0] == "print" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			return err
+		}
+		return cfg.Validate()
+	}
+	return fmt.Errorf("неизвестная команда %q\n%s", strings.Join(append([]string{"config"}, args...), " "), commandUsage)
+}
+
+// runCommand выполняет служебную команду из аргументов запуска.
+func runCommand(args []string) error {
+	switch {
+	case args[0] == "seed" && len(args) == 1:
+		if err := migrateUp(); err != nil {
+			return err
+		}
+		return seedDemoData()
+	case args[0] == "migrate" && len(args) == 2 && args[1] == "status":
+		return migrateStatus(os.Stdout)
+	case args[0] == "migrate" && len(args) == 2 && args[1] == "up":
+		return migrateUp()
+	case args[0] == "migrate" && len(args) >= 2 && len(args) <= 3 && args[1] == "down":
+		steps := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("migrate down: N должно быть положительным числом")
+			}
+			steps = n
+		}
+		return migrateDown(steps)
+	}
+	return fmt.Errorf("неизвестная команда %q\n%s", strings.Join(args, " "), commandUsage)
+}
@@ -0,0 +1,39 @@
+-- Drops the whole schema (in reverse dependency order).
+
+DROP TABLE IF EXISTS energy_tariffs CASCADE;
+DROP TABLE IF EXISTS comfort_targets CASCADE;
+DROP TABLE IF EXISTS thermostats CASCADE;
+DROP TABLE IF EXISTS audit_log CASCADE;
+DROP TABLE IF EXISTS billing_ledger CASCADE;
+DROP TABLE IF EXISTS invoices CASCADE;
+DROP TABLE IF EXISTS subscriptions CASCADE;
+DROP TABLE IF EXISTS ticket_photos CASCADE;
+DROP TABLE IF EXISTS ticket_comments CASCADE;
+DROP TABLE IF EXISTS maintenance_tickets CASCADE;
+DROP TABLE IF EXISTS alerts CASCADE;
+DROP TABLE IF EXISTS alert_rules CASCADE;
+DROP TABLE IF EXISTS automation_rules CASCADE;
+DROP TABLE IF EXISTS house_mode_history CASCADE;
+DROP TABLE IF EXISTS house_modes CASCADE;
+DROP TABLE IF EXISTS schedule_runs CASCADE;
+DROP TABLE IF EXISTS schedules CASCADE;
+DROP TABLE IF EXISTS scenes CASCADE;
+DROP TABLE IF EXISTS user_profile_history CASCADE;
+DROP TABLE IF EXISTS device_logs CASCADE;
+DROP TABLE IF EXISTS user_devices CASCADE;
+DROP TABLE IF EXISTS settings CASCADE;
+DROP TABLE IF EXISTS state CASCADE;
+DROP TABLE IF EXISTS actuators CASCADE;
+DROP TABLE IF EXISTS sensor CASCADE;
+DROP TABLE IF EXISTS in_data CASCADE;
+DROP TABLE IF EXISTS out_data CASCADE;
+DROP TABLE IF EXISTS variables CASCADE;
+DROP TABLE IF EXISTS controller CASCADE;
+DROP TABLE IF EXISTS device CASCADE;
+DROP TABLE IF EXISTS room CASCADE;
+DROP TABLE IF EXISTS building CASCADE;
+DROP TABLE IF EXISTS worker_shifts CASCADE;
+DROP TABLE IF EXISTS worker_buildings CASCADE;
+DROP TABLE IF EXISTS workers CASCADE;
+DROP TABLE IF EXISTS users CASCADE;
+DROP FUNCTION IF EXISTS audit_log_immutable();
@@ -0,0 +1,496 @@
+-- Initial schema of the Smart Home IoT System.
+-- Written so that it can also adopt a database created by the old
+-- init.sql / initTables: every object is created only if missing.
+
+SET search_path TO public;
+
+-- ============================================
+-- Create tables
+-- ============================================
+
+-- Users table
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL UNIQUE,
+    email VARCHAR(100) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    role VARCHAR(50) DEFAULT 'user',
+    house_status VARCHAR(50) DEFAULT 'День',
+    payment_type VARCHAR(50) DEFAULT 'Базовый',
+    floorplan_image TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Buildings table
+CREATE TABLE IF NOT EXISTS building (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    timezone VARCHAR(64) DEFAULT 'Europe/Moscow',
+    mode VARCHAR(50) NOT NULL DEFAULT 'day'
+);
+
+-- Rooms table
+CREATE TABLE IF NOT EXISTS room (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    vacancy_timeout_seconds INTEGER
+);
+
+-- Devices table
+CREATE TABLE IF NOT EXISTS device (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    room_id INTEGER REFERENCES room(id) ON DELETE CASCADE,
+    sensor_key VARCHAR(255) UNIQUE,
+    rated_power_w DOUBLE PRECISION
+);
+
+-- Controllers table
+CREATE TABLE IF NOT EXISTS controller (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    state VARCHAR(50),
+    device_id INTEGER NOT NULL REFERENCES device(id) ON DELETE CASCADE
+);
+
+-- Variables table
+CREATE TABLE IF NOT EXISTS variables (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    value VARCHAR(255),
+    controller_id INTEGER NOT NULL REFERENCES controller(id) ON DELETE CASCADE
+);
+
+-- Input data table
+CREATE TABLE IF NOT EXISTS in_data (
+    id SERIAL PRIMARY KEY,
+    number INTEGER NOT NULL,
+    variables_id INTEGER NOT NULL REFERENCES variables(id) ON DELETE CASCADE
+);
+
+-- Output data table
+CREATE TABLE IF NOT EXISTS out_data (
+    id SERIAL PRIMARY KEY,
+    variables_id INTEGER NOT NULL REFERENCES variables(id) ON DELETE CASCADE
+);
+
+-- Sensors table
+CREATE TABLE IF NOT EXISTS sensor (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    min_value DOUBLE PRECISION,
+    max_value DOUBLE PRECISION,
+    in_data_id INTEGER NOT NULL REFERENCES in_data(id) ON DELETE CASCADE
+);
+
+-- Actuators table
+CREATE TABLE IF NOT EXISTS actuators (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    min_value DOUBLE PRECISION,
+    max_value DOUBLE PRECISION,
+    out_data_id INTEGER NOT NULL REFERENCES out_data(id) ON DELETE CASCADE
+);
+
+-- Settings table
+CREATE TABLE IF NOT EXISTS settings (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    value TEXT,
+    controller_id INTEGER NOT NULL REFERENCES controller(id) ON DELETE CASCADE
+);
+
+-- State table
+CREATE TABLE IF NOT EXISTS state (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    value VARCHAR(255),
+    controller_id INTEGER NOT NULL REFERENCES controller(id) ON DELETE CASCADE
+);
+
+-- Workers table
+CREATE TABLE IF NOT EXISTS workers (
+    id SERIAL PRIMARY KEY,
+    full_name VARCHAR(255) NOT NULL,
+    "position" VARCHAR(100),
+    phone VARCHAR(20),
+    email VARCHAR(100) UNIQUE,
+    hired_at DATE,
+    user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE SET NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- User-Devices junction table
+CREATE TABLE IF NOT EXISTS user_devices (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id INTEGER REFERENCES device(id) ON DELETE CASCADE,
+    payment_type VARCHAR(50),
+    floorplan TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Device logs table
+CREATE TABLE IF NOT EXISTS device_logs (
+    id SERIAL PRIMARY KEY,
+    device_id INTEGER REFERENCES device(id) ON DELETE CASCADE,
+    action VARCHAR(100),
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    data TEXT
+);
+
+-- User profile history table
+CREATE TABLE IF NOT EXISTS user_profile_history (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    field_name VARCHAR(100) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Scenes table (named sets of device commands)
+CREATE TABLE IF NOT EXISTS scenes (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    actions JSONB NOT NULL DEFAULT '[]',
+    run_on_mode VARCHAR(50),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Schedules table (cron / fixed time / sunrise / sunset)
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    expression VARCHAR(100),
+    offset_minutes INTEGER DEFAULT 0,
+    device_id INTEGER REFERENCES device(id) ON DELETE CASCADE,
+    command VARCHAR(50),
+    value TEXT,
+    scene_id INTEGER REFERENCES scenes(id) ON DELETE CASCADE,
+    missed_policy VARCHAR(20) NOT NULL DEFAULT 'skip',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Schedule runs table (one row per occurrence, guarantees single execution)
+CREATE TABLE IF NOT EXISTS schedule_runs (
+    id SERIAL PRIMARY KEY,
+    schedule_id INTEGER NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    started_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMPTZ,
+    UNIQUE (schedule_id, scheduled_for)
+);
+
+-- Custom house modes (built-in day/night/away/vacation live in the backend)
+CREATE TABLE IF NOT EXISTS house_modes (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    code VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    UNIQUE (building_id, code)
+);
+
+-- House mode history table (audit of mode switches)
+CREATE TABLE IF NOT EXISTS house_mode_history (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    from_mode VARCHAR(50),
+    to_mode VARCHAR(50) NOT NULL,
+    changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    source VARCHAR(100) NOT NULL,
+    changed_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Automation rules table
+CREATE TABLE IF NOT EXISTS automation_rules (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    modes TEXT[] NOT NULL DEFAULT '{}',
+    mode_active BOOLEAN NOT NULL DEFAULT TRUE,
+    trigger JSONB NOT NULL,
+    conditions JSONB NOT NULL DEFAULT '[]',
+    device_id INTEGER REFERENCES device(id) ON DELETE CASCADE,
+    command VARCHAR(50),
+    value TEXT,
+    scene_id INTEGER REFERENCES scenes(id) ON DELETE CASCADE,
+    cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Alert rules table (threshold + duration + hysteresis)
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    sensor_id VARCHAR(255) NOT NULL,
+    operator VARCHAR(2) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    hysteresis DOUBLE PRECISION NOT NULL DEFAULT 0,
+    for_seconds INTEGER NOT NULL DEFAULT 0,
+    severity VARCHAR(20) NOT NULL DEFAULT 'warning',
+    channels JSONB NOT NULL DEFAULT '[]',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    silenced_until TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Alerts table (firing / resolved)
+CREATE TABLE IF NOT EXISTS alerts (
+    id SERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    state VARCHAR(20) NOT NULL,
+    value DOUBLE PRECISION,
+    started_at TIMESTAMPTZ NOT NULL,
+    resolved_at TIMESTAMPTZ,
+    acknowledged_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    acknowledged_at TIMESTAMPTZ
+);
+
+-- Worker-Buildings junction table
+CREATE TABLE IF NOT EXISTS worker_buildings (
+    worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    PRIMARY KEY (worker_id, building_id)
+);
+
+-- Worker shifts and unavailability
+CREATE TABLE IF NOT EXISTS worker_shifts (
+    id SERIAL PRIMARY KEY,
+    worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL DEFAULT 'shift',
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    note TEXT,
+    CHECK (ends_at > starts_at)
+);
+
+-- Maintenance tickets table
+CREATE TABLE IF NOT EXISTS maintenance_tickets (
+    id SERIAL PRIMARY KEY,
+    device_id INTEGER REFERENCES device(id) ON DELETE SET NULL,
+    building_id INTEGER REFERENCES building(id) ON DELETE SET NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    source VARCHAR(20) NOT NULL DEFAULT 'manual',
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    priority VARCHAR(20) NOT NULL DEFAULT 'normal',
+    worker_id INTEGER REFERENCES workers(id) ON DELETE SET NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sla_due_at TIMESTAMPTZ NOT NULL,
+    sla_breached BOOLEAN NOT NULL DEFAULT FALSE,
+    resolved_at TIMESTAMPTZ
+);
+
+-- Ticket comments table
+CREATE TABLE IF NOT EXISTS ticket_comments (
+    id SERIAL PRIMARY KEY,
+    ticket_id INTEGER NOT NULL REFERENCES maintenance_tickets(id) ON DELETE CASCADE,
+    author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Ticket photos table (files are kept in the blob store)
+CREATE TABLE IF NOT EXISTS ticket_photos (
+    id SERIAL PRIMARY KEY,
+    ticket_id INTEGER NOT NULL REFERENCES maintenance_tickets(id) ON DELETE CASCADE,
+    blob_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Subscriptions table (one per non-admin user)
+CREATE TABLE IF NOT EXISTS subscriptions (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    plan VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    plan_since TIMESTAMPTZ NOT NULL,
+    past_due_since TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Invoices table
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    number VARCHAR(32) NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    total BIGINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    provider_ref VARCHAR(100),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    paid_at TIMESTAMPTZ
+);
+
+-- Billing ledger (charges, credits, payments; amounts in kopecks)
+CREATE TABLE IF NOT EXISTS billing_ledger (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    amount BIGINT NOT NULL,
+    description TEXT NOT NULL,
+    plan VARCHAR(50),
+    period_from TIMESTAMPTZ NOT NULL,
+    period_to TIMESTAMPTZ NOT NULL,
+    invoice_id INTEGER REFERENCES invoices(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Energy tariffs (rates in RUB per kWh; building_id NULL = default tariff)
+CREATE TABLE IF NOT EXISTS energy_tariffs (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER REFERENCES building(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    valid_from DATE NOT NULL,
+    day_rate DOUBLE PRECISION NOT NULL,
+    night_rate DOUBLE PRECISION,
+    night_start TIME,
+    night_end TIME
+);
+
+-- Comfort target bands per room (rooms without a row use built-in defaults)
+CREATE TABLE IF NOT EXISTS comfort_targets (
+    room_id INTEGER PRIMARY KEY REFERENCES room(id) ON DELETE CASCADE,
+    temp_min DOUBLE PRECISION NOT NULL,
+    temp_max DOUBLE PRECISION NOT NULL,
+    humidity_min DOUBLE PRECISION NOT NULL,
+    humidity_max DOUBLE PRECISION NOT NULL,
+    mould_humidity DOUBLE PRECISION NOT NULL,
+    mould_hours DOUBLE PRECISION NOT NULL
+);
+
+-- Thermostats: temperature sensor + heating/cooling actuator control loop
+CREATE TABLE IF NOT EXISTS thermostats (
+    id SERIAL PRIMARY KEY,
+    building_id INTEGER NOT NULL REFERENCES building(id) ON DELETE CASCADE,
+    room_id INTEGER REFERENCES room(id) ON DELETE SET NULL,
+    name VARCHAR(100) NOT NULL,
+    sensor_id VARCHAR(255) NOT NULL,
+    device_id INTEGER NOT NULL REFERENCES device(id) ON DELETE CASCADE,
+    mode VARCHAR(10) NOT NULL,
+    algorithm VARCHAR(20) NOT NULL DEFAULT 'hysteresis',
+    setpoint DOUBLE PRECISION NOT NULL,
+    hysteresis DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    kp DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    ki DOUBLE PRECISION NOT NULL DEFAULT 0.0005,
+    kd DOUBLE PRECISION NOT NULL DEFAULT 0,
+    cycle_seconds INTEGER NOT NULL DEFAULT 600,
+    min_on_seconds INTEGER NOT NULL DEFAULT 180,
+    min_off_seconds INTEGER NOT NULL DEFAULT 180,
+    stale_seconds INTEGER NOT NULL DEFAULT 300,
+    failsafe VARCHAR(10) NOT NULL DEFAULT 'off',
+    schedule JSONB NOT NULL DEFAULT '[]',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- Audit log: append-only, hash-chained (actor_id has no FK so that
+-- deleting a user never has to touch existing entries)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL,
+    actor_id INTEGER,
+    actor VARCHAR(100) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50) NOT NULL,
+    target_id VARCHAR(100),
+    before JSONB,
+    after JSONB,
+    ip VARCHAR(64),
+    request_id VARCHAR(64),
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_immutable();
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_immutable();
+
+-- ============================================
+-- Columns added after the tables were first created. Databases set up by
+-- the old init.sql / initTables may lack them; on a fresh database these
+-- are no-ops.
+-- ============================================
+
+ALTER TABLE building
+    ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) DEFAULT 'Europe/Moscow',
+    ADD COLUMN IF NOT EXISTS mode VARCHAR(50) NOT NULL DEFAULT 'day';
+ALTER TABLE room ADD COLUMN IF NOT EXISTS vacancy_timeout_seconds INTEGER;
+ALTER TABLE device ADD COLUMN IF NOT EXISTS sensor_key VARCHAR(255) UNIQUE;
+ALTER TABLE device ADD COLUMN IF NOT EXISTS rated_power_w DOUBLE PRECISION;
+ALTER TABLE scenes ADD COLUMN IF NOT EXISTS run_on_mode VARCHAR(50);
+ALTER TABLE automation_rules ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE alert_rules ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Link existing workers to their worker accounts by e-mail
+UPDATE workers w SET user_id = u.id FROM users u
+    WHERE w.user_id IS NULL AND u.role = 'worker' AND u.email = w.email
+      AND NOT EXISTS (SELECT 1 FROM workers x WHERE x.user_id = u.id);
+
+-- ============================================
+-- Create indexes for better query performance
+-- ============================================
+
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+CREATE INDEX IF NOT EXISTS idx_room_building ON room(building_id);
+CREATE INDEX IF NOT EXISTS idx_device_room ON device(room_id);
+CREATE INDEX IF NOT EXISTS idx_controller_device ON controller(device_id);
+CREATE INDEX IF NOT EXISTS idx_variables_controller ON variables(controller_id);
+CREATE INDEX IF NOT EXISTS idx_user_devices_user ON user_devices(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_devices_device ON user_devices(device_id);
+CREATE INDEX IF NOT EXISTS idx_device_logs_device ON device_logs(device_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_device_logs_timestamp ON device_logs(timestamp);
+CREATE INDEX IF NOT EXISTS idx_user_profile_history_user ON user_profile_history(user_id);
+CREATE INDEX IF NOT EXISTS idx_scenes_building ON scenes(building_id);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run ON schedules(next_run_at) WHERE enabled;
+CREATE INDEX IF NOT EXISTS idx_house_mode_history_building ON house_mode_history(building_id, changed_at);
+CREATE INDEX IF NOT EXISTS idx_automation_rules_building ON automation_rules(building_id);
+CREATE INDEX IF NOT EXISTS idx_alerts_state ON alerts(state, rule_id);
+CREATE INDEX IF NOT EXISTS idx_worker_shifts_worker ON worker_shifts(worker_id, starts_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_user ON invoices(user_id, period_start);
+CREATE INDEX IF NOT EXISTS idx_billing_ledger_user ON billing_ledger(user_id, invoice_id);
+CREATE INDEX IF NOT EXISTS idx_tickets_status ON maintenance_tickets(status, worker_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tickets_auto_open ON maintenance_tickets(device_id, source) WHERE status <> 'resolved' AND source <> 'manual';
+CREATE INDEX IF NOT EXISTS idx_ticket_comments_ticket ON ticket_comments(ticket_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_photos_ticket ON ticket_photos(ticket_id);
@@ -0,0 +1,2 @@
+-- Nothing to undo: the relaxed columns and foreign keys are part of the
+-- schema defined in 0001, the old initTables variant is not restored.
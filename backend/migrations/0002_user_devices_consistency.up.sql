@@ -0,0 +1,27 @@
+-- user_devices used to have two definitions: init.sql (nullable device_id
+-- and payment_type, foreign keys, updated_at) and initTables (NOT NULL
+-- columns, no foreign keys, no updated_at). Bring databases created by
+-- initTables in line with the init.sql definition.
+
+ALTER TABLE user_devices
+    ALTER COLUMN device_id DROP NOT NULL,
+    ALTER COLUMN payment_type DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+-- Rows pointing at deleted users/devices would block the foreign keys
+DELETE FROM user_devices ud
+    WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = ud.user_id)
+       OR (ud.device_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM device d WHERE d.id = ud.device_id));
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'user_devices_user_id_fkey') THEN
+        ALTER TABLE user_devices ADD CONSTRAINT user_devices_user_id_fkey
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'user_devices_device_id_fkey') THEN
+        ALTER TABLE user_devices ADD CONSTRAINT user_devices_device_id_fkey
+            FOREIGN KEY (device_id) REFERENCES device(id) ON DELETE CASCADE;
+    END IF;
+END;
+$$;
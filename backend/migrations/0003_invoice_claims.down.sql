@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_invoices_unpaid;
+
+UPDATE invoices SET status = 'open' WHERE status = 'processing';
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS claimed_at;
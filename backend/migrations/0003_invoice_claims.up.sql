@@ -0,0 +1,6 @@
+-- payInvoice claims an invoice (status 'processing') before charging the
+-- provider so that concurrent attempts cannot charge it twice; claimed_at
+-- lets the billing cycle retry claims abandoned by a crashed process.
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_unpaid ON invoices(status) WHERE status IN ('open', 'processing');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_schedule_runs_lease;
+
+ALTER TABLE schedule_runs
+    DROP COLUMN IF EXISTS lease_owner,
+    DROP COLUMN IF EXISTS lease_until,
+    DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,14 @@
+-- Schedule runs are leased: the replica executing a run extends
+-- lease_until while it works, and a run whose lease expired (the process
+-- died mid-run) is re-claimed and executed again, up to a few attempts.
+ALTER TABLE schedule_runs
+    ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 1;
+
+-- Runs left 'running' by versions without leases cannot be re-executed
+-- safely this late.
+UPDATE schedule_runs SET status = 'interrupted', finished_at = NOW()
+    WHERE status = 'running';
+
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_lease ON schedule_runs(lease_until) WHERE status = 'running';
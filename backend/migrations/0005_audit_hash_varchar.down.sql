@@ -0,0 +1,3 @@
+ALTER TABLE audit_log
+    ALTER COLUMN prev_hash TYPE CHAR(64),
+    ALTER COLUMN hash TYPE CHAR(64);
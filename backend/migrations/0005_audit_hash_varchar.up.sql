@@ -0,0 +1,6 @@
+-- CHAR(64) pads the genesis entry's empty prev_hash with spaces, which
+-- breaks chain verification. Converting to VARCHAR strips the padding of
+-- existing rows; ALTER TYPE does not fire the append-only row triggers.
+ALTER TABLE audit_log
+    ALTER COLUMN prev_hash TYPE VARCHAR(64),
+    ALTER COLUMN hash TYPE VARCHAR(64);
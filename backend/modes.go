@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============ РЕЖИМЫ ДОМА ============
+//
+// Режим — свойство здания (building.mode). Встроенные режимы совпадают со
+// значениями users.house_status, которые показывает фронтенд; свои режимы
+// здания хранятся в house_modes. При переключении:
+//   1. в транзакции меняется building.mode, пишется house_mode_history,
+//      обновляется users.house_status владельцев устройств здания
+//      и пересчитывается mode_active у правил;
+//   2. публикуется retained-сообщение buildings/<id>/mode;
+//   3. запускаются сцены с run_on_mode = новый режим.
+
+type HouseMode struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	BuildingID *int   `json:"building_id,omitempty"`
+	Builtin    bool   `json:"builtin"`
+}
+
+type ModeChange struct {
+	ID         int       `json:"id"`
+	BuildingID int       `json:"building_id"`
+	FromMode   string    `json:"from_mode"`
+	ToMode     string    `json:"to_mode"`
+	ChangedBy  *int      `json:"changed_by,omitempty"`
+	Source     string    `json:"source"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+type SwitchModeRequest struct {
+	BuildingID int    `json:"building_id"`
+	Mode       string `json:"mode"`
+}
+
+var builtinModes = []HouseMode{
+	{Code: "day", Name: "День", Builtin: true},
+	{Code: "night", Name: "Ночь", Builtin: true},
+	{Code: "away", Name: "Вне дома", Builtin: true},
+	{Code: "vacation", Name: "Отпуск", Builtin: true},
+}
+
+func modeTopic(buildingID int) string {
+	return fmt.Sprintf("buildings/%d/mode", buildingID)
+}
+
+// listModes возвращает встроенные режимы и режимы, заведённые для здания.
+func listModes(buildingID int) ([]HouseMode, error) {
+	modes := append([]HouseMode{}, builtinModes...)
+
+	rows, err := psqlConn.Query("SELECT code, name, building_id FROM house_modes WHERE building_id = $1 ORDER BY id", buildingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m HouseMode
+		var bid int
+		if err := rows.Scan(&m.Code, &m.Name, &bid); err != nil {
+			continue
+		}
+		m.BuildingID = &bid
+		modes = append(modes, m)
+	}
+	return modes, rows.Err()
+}
+
+// findMode ищет режим по коду или по названию (как в users.house_status).
+func findMode(buildingID int, key string) (HouseMode, bool, error) {
+	modes, err := listModes(buildingID)
+	if err != nil {
+		return HouseMode{}, false, err
+	}
+	for _, m := range modes {
+		if m.Code == key || m.Name == key {
+			return m, true, nil
+		}
+	}
+	return HouseMode{}, false, nil
+}
+
+// switchMode переключает режим здания. changedBy может быть nil, если
+// переключение инициировано системой (расписание, правило).
+func switchMode(buildingID int, mode HouseMode, changedBy *int, source string) (string, error) {
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT COALESCE(mode, 'day') FROM building WHERE id = $1 FOR UPDATE", buildingID).Scan(&previous)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("UPDATE building SET mode = $1 WHERE id = $2", mode.Code, buildingID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO house_mode_history (building_id, from_mode, to_mode, changed_by, source) VALUES ($1, $2, $3, $4, $5)",
+		buildingID, previous, mode.Code, changedBy, source,
+	); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`UPDATE users SET house_status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT ud.user_id FROM user_devices ud
+			JOIN device d ON d.id = ud.device_id
+			JOIN room r ON r.id = d.room_id
+			WHERE r.building_id = $2
+		)`, mode.Name, buildingID); err != nil {
+		return "", err
+	}
+	if err := applyModeToRules(tx, buildingID, mode.Code); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	automation.invalidate()
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"building_id": buildingID,
+		"mode":        mode.Code,
+		"name":        mode.Name,
+		"previous":    previous,
+		"source":      source,
+		"timestamp":   time.Now().Unix(),
+	})
+	hub.publish(StreamEvent{Type: "mode", BuildingID: buildingID, Data: json.RawMessage(payload)})
+
+	token := mqttClient.Publish(modeTopic(buildingID), byte(cfg.MQTT.QoS), true, payload)
+	if !token.WaitTimeout(cfg.MQTT.PublishTimeout) || token.Error() != nil {
+		logger("modes").Error("Ошибка публикации режима", "building_id", buildingID, "error", token.Error())
+	}
+
+	rows, err := psqlConn.Query("SELECT id FROM scenes WHERE building_id = $1 AND run_on_mode = $2 ORDER BY id", buildingID, mode.Code)
+	if err != nil {
+		logger("modes").Error("Ошибка выборки сцен режима", "building_id", buildingID, "error", err)
+	} else {
+		var sceneIDs []int
+		for rows.Next() {
+			var id int
+			if rows.Scan(&id) == nil {
+				sceneIDs = append(sceneIDs, id)
+			}
+		}
+		rows.Close()
+		for _, id := range sceneIDs {
+			if err := runScene(context.Background(), id, "mode:"+mode.Code); err != nil {
+				logger("modes").Error("Ошибка сцены режима", "scene_id", id, "error", err)
+			}
+		}
+	}
+
+	logger("modes").Info("Смена режима здания", "building_id", buildingID, "from", previous, "to", mode.Code, "source", source)
+	return previous, nil
+}
+
+// ============ REST API HANDLERS - MODES ============
+
+func getModes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buildingID, err := strconv.Atoi(r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, "building_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	var current string
+	err = psqlConn.QueryRow("SELECT COALESCE(mode, 'day') FROM building WHERE id = $1", buildingID).Scan(&current)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	modes, err := listModes(buildingID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"building_id": buildingID,
+		"current":     current,
+		"modes":       modes,
+	})
+}
+
+func createMode(w http.ResponseWriter, r *http.Request) {
+	var m HouseMode
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if m.Code == "" || m.Name == "" || m.BuildingID == nil {
+		http.Error(w, "code, name и building_id обязательны", http.StatusBadRequest)
+		return
+	}
+	for _, b := range builtinModes {
+		if b.Code == m.Code || b.Name == m.Name {
+			http.Error(w, "Режим совпадает со встроенным", http.StatusConflict)
+			return
+		}
+	}
+
+	if _, err := psqlConn.Exec(
+		"INSERT INTO house_modes (building_id, code, name) VALUES ($1, $2, $3)",
+		*m.BuildingID, m.Code, m.Name,
+	); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(m)
+}
+
+func deleteMode(w http.ResponseWriter, r *http.Request) {
+	buildingID := r.URL.Query().Get("building_id")
+	code := r.URL.Query().Get("code")
+	if buildingID == "" || code == "" {
+		http.Error(w, "building_id и code обязательны", http.StatusBadRequest)
+		return
+	}
+
+	var inUse bool
+	psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM building WHERE id = $1 AND mode = $2)", buildingID, code).Scan(&inUse)
+	if inUse {
+		http.Error(w, "Нельзя удалить текущий режим здания", http.StatusConflict)
+		return
+	}
+
+	if _, err := psqlConn.Exec("DELETE FROM house_modes WHERE building_id = $1 AND code = $2", buildingID, code); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func switchModeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Переключение режима запускает сцены — только для пользователей
+	// с доступом к зданию.
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req SwitchModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if req.BuildingID == 0 || req.Mode == "" {
+		http.Error(w, "building_id и mode обязательны", http.StatusBadRequest)
+		return
+	}
+	if !user.requireBuilding(w, req.BuildingID) {
+		return
+	}
+
+	mode, ok, err := findMode(req.BuildingID, req.Mode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Неизвестный режим", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := switchMode(req.BuildingID, mode, &user.userID, "api")
+	if err == sql.ErrNoRows {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка переключения режима: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditRequest(r, "mode.switch", "building", req.BuildingID,
+		map[string]string{"mode": previous}, map[string]string{"mode": mode.Code})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"building_id": req.BuildingID,
+		"previous":    previous,
+		"current":     mode.Code,
+		"name":        mode.Name,
+	})
+}
+
+func getModeHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buildingID := r.URL.Query().Get("building_id")
+	if buildingID == "" {
+		http.Error(w, "building_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := psqlConn.Query(`SELECT id, building_id, from_mode, to_mode, changed_by, source, changed_at
+		FROM house_mode_history WHERE building_id = $1 ORDER BY changed_at DESC LIMIT 200`, buildingID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []ModeChange{}
+	for rows.Next() {
+		var c ModeChange
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.BuildingID, &c.FromMode, &c.ToMode, &changedBy, &c.Source, &c.ChangedAt); err != nil {
+			continue
+		}
+		if changedBy.Valid {
+			id := int(changedBy.Int64)
+			c.ChangedBy = &id
+		}
+		history = append(history, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ УВЕДОМЛЕНИЯ ============
+
+// AlertNotification — то, что получает канал уведомлений при срабатывании
+// или снятии оповещения.
+type AlertNotification struct {
+	AlertID    int        `json:"alert_id"`
+	RuleID     int        `json:"rule_id"`
+	RuleName   string     `json:"rule_name"`
+	BuildingID int        `json:"building_id"`
+	SensorID   string     `json:"sensor_id"`
+	State      string     `json:"state"`
+	Severity   string     `json:"severity"`
+	Value      float64    `json:"value"`
+	Operator   string     `json:"operator"`
+	Threshold  float64    `json:"threshold"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func (n AlertNotification) Subject() string {
+	if n.State == alertStateResolved {
+		return fmt.Sprintf("[RESOLVED] %s", n.RuleName)
+	}
+	return fmt.Sprintf("[%s] %s", strings.ToUpper(n.Severity), n.RuleName)
+}
+
+func (n AlertNotification) Text() string {
+	return fmt.Sprintf("%s: %s = %.2f (порог %s %.2f), здание %d, с %s",
+		n.Subject(), n.SensorID, n.Value, n.Operator, n.Threshold, n.BuildingID, n.StartedAt.Format(time.RFC3339))
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n AlertNotification) error
+}
+
+// NotificationChannel — канал из настроек правила оповещения.
+type NotificationChannel struct {
+	Type   string `json:"type"`             // webhook | email | log
+	Target string `json:"target,omitempty"` // URL или адрес почты
+}
+
+// ============ WEBHOOK ============
+
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (wn *WebhookNotifier) Notify(ctx context.Context, n AlertNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wn.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s ответил %d", wn.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// ============ EMAIL ============
+
+type Mailer interface {
+	Send(to []string, subject, body string) error
+}
+
+// SMTPMailer отправляет письма через обычный SMTP с PLAIN-авторизацией.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to []string, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	msg := "From: " + m.From + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		body + "\r\n"
+	return smtp.SendMail(m.Addr, auth, m.From, to, []byte(msg))
+}
+
+type EmailNotifier struct {
+	Mailer Mailer
+	To     []string
+}
+
+func (en *EmailNotifier) Notify(ctx context.Context, n AlertNotification) error {
+	if en.Mailer == nil {
+		return fmt.Errorf("почта не настроена (SMTP_ADDR)")
+	}
+	return en.Mailer.Send(en.To, n.Subject(), n.Text())
+}
+
+// ============ ЛОГ ============
+
+// LogNotifier пишет уведомления в лог и запоминает последние
+// logNotifierKeep из них — удобно для локальной отладки и тестов.
+type LogNotifier struct {
+	mu   sync.Mutex
+	sent []AlertNotification
+}
+
+const logNotifierKeep = 100
+
+func (ln *LogNotifier) Notify(ctx context.Context, n AlertNotification) error {
+	ln.mu.Lock()
+	ln.sent = append(ln.sent, n)
+	if len(ln.sent) > logNotifierKeep {
+		ln.sent = ln.sent[len(ln.sent)-logNotifierKeep:]
+	}
+	ln.mu.Unlock()
+	logger("notify").InfoContext(ctx, n.Text(), "alert_id", n.AlertID)
+	return nil
+}
+
+func (ln *LogNotifier) Sent() []AlertNotification {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	return append([]AlertNotification(nil), ln.sent...)
+}
+
+// ============ ВЫБОР КАНАЛА ============
+
+var (
+	alertMailer     Mailer
+	alertLogSink    = &LogNotifier{}
+	webhookClient   = &http.Client{Timeout: 10 * time.Second}
+	notifyTimeout   = 15 * time.Second
+	defaultChannels = []NotificationChannel{{Type: "log"}}
+)
+
+func initMailer() {
+	if cfg.SMTP.Addr != "" {
+		alertMailer = &SMTPMailer{
+			Addr:     cfg.SMTP.Addr,
+			Username: cfg.SMTP.User,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		}
+	}
+}
+
+func notifierFor(ch NotificationChannel) (Notifier, error) {
+	switch ch.Type {
+	case "webhook":
+		if ch.Target == "" {
+			return nil, fmt.Errorf("webhook без URL")
+		}
+		return &WebhookNotifier{URL: ch.Target, Client: webhookClient}, nil
+	case "email":
+		if ch.Target == "" {
+			return nil, fmt.Errorf("email без адреса")
+		}
+		return &EmailNotifier{Mailer: alertMailer, To: strings.Split(ch.Target, ",")}, nil
+	case "log":
+		return alertLogSink, nil
+	}
+	return nil, fmt.Errorf("неизвестный канал %q", ch.Type)
+}
+
+// dispatchNotification рассылает уведомление по всем каналам правила.
+// Ошибки каналов только логируются: недоступный webhook не должен мешать
+// почте и наоборот.
+func dispatchNotification(channels []NotificationChannel, n AlertNotification) {
+	if len(channels) == 0 {
+		channels = defaultChannels
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	for _, ch := range channels {
+		notifier, err := notifierFor(ch)
+		if err == nil {
+			err = notifier.Notify(ctx, n)
+		}
+		if err != nil {
+			logger("notify").Error("Ошибка отправки оповещения", "channel", ch.Type, "target", ch.Target, "alert_id", n.AlertID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ============ ТАРИФЫ ============
+//
+// Тариф пользователя хранится в users.payment_type ("Базовый" или
+// "Максимум"), user_devices.payment_type запоминает тариф, по которому
+// устройство было добавлено. Ограничения проверяются при создании
+// устройств, правил автоматизации и правил оповещений, а также при
+// запросах истории показаний; выгрузки (PDF счёта) доступны только на
+// тарифах с Export. Администраторы ограничениями не связаны.
+
+const defaultPlan = "Базовый"
+
+type Plan struct {
+	Code          string `json:"code"`
+	Name          string `json:"name"`
+	MonthlyPrice  int64  `json:"monthly_price"` // копейки
+	MaxDevices    int    `json:"max_devices"`
+	HistoryDays   int    `json:"history_days"`
+	MaxRules      int    `json:"max_rules"`
+	MaxAlertRules int    `json:"max_alert_rules"`
+	Export        bool   `json:"export"`
+	// APITokens пока только показывается в тарифе: выпуска API-токенов
+	// в сервисе нет, проверять его негде.
+	APITokens bool `json:"api_tokens"`
+}
+
+var plans = map[string]Plan{
+	"Базовый": {
+		Code:          "basic",
+		Name:          "Базовый",
+		MonthlyPrice:  29900,
+		MaxDevices:    5,
+		HistoryDays:   7,
+		MaxRules:      5,
+		MaxAlertRules: 5,
+	},
+	"Максимум": {
+		Code:          "max",
+		Name:          "Максимум",
+		MonthlyPrice:  99000,
+		MaxDevices:    50,
+		HistoryDays:   365,
+		MaxRules:      100,
+		MaxAlertRules: 100,
+		Export:        true,
+		APITokens:     true,
+	},
+}
+
+// PlanUsage — потребление пользователя относительно лимитов тарифа.
+type PlanUsage struct {
+	UserID     int  `json:"user_id"`
+	Plan       Plan `json:"plan"`
+	Devices    int  `json:"devices"`
+	Rules      int  `json:"rules"`
+	AlertRules int  `json:"alert_rules"`
+}
+
+// suspendedPlan действует, пока подписка не оплачена (см. billing.go):
+// уже созданное продолжает работать, добавлять новое нельзя.
+var suspendedPlan = Plan{Code: "suspended", Name: "Приостановлен", HistoryDays: 1}
+
+// planOf возвращает действующий тариф пользователя и признак
+// администратора. Неизвестное значение payment_type трактуется как
+// базовый тариф, неоплаченная подписка — как suspendedPlan.
+func planOf(userID int) (Plan, bool, error) {
+	var role, paymentType, status sql.NullString
+	var pastDueSince sql.NullTime
+	err := psqlConn.QueryRow(`SELECT u.role, u.payment_type, s.status, s.past_due_since
+		FROM users u LEFT JOIN subscriptions s ON s.user_id = u.id
+		WHERE u.id = $1`, userID).Scan(&role, &paymentType, &status, &pastDueSince)
+	if err != nil {
+		return Plan{}, false, err
+	}
+	plan, ok := plans[paymentType.String]
+	if !ok {
+		plan = plans[defaultPlan]
+	}
+	if status.Valid && !subscriptionActive(status.String, pastDueSince) {
+		plan = suspendedPlan
+	}
+	return plan, role.String == "admin", nil
+}
+
+func usageOf(userID int) (PlanUsage, error) {
+	plan, _, err := planOf(userID)
+	if err != nil {
+		return PlanUsage{}, err
+	}
+	u := PlanUsage{UserID: userID, Plan: plan}
+	err = psqlConn.QueryRow(`SELECT
+			(SELECT COUNT(DISTINCT device_id) FROM user_devices WHERE user_id = $1),
+			(SELECT COUNT(*) FROM automation_rules WHERE created_by = $1),
+			(SELECT COUNT(*) FROM alert_rules WHERE created_by = $1)`, userID,
+	).Scan(&u.Devices, &u.Rules, &u.AlertRules)
+	return u, err
+}
+
+// planRequest определяет пользователя запроса и его тариф. При ошибке
+// ответ уже отправлен клиенту.
+func planRequest(w http.ResponseWriter, r *http.Request) (int, Plan, bool, bool) {
+	userID, _, ok := userFromRequest(r)
+	if !ok {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return 0, Plan{}, false, false
+	}
+	plan, admin, err := planOf(userID)
+	if err != nil {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return 0, Plan{}, false, false
+	}
+	return userID, plan, admin, true
+}
+
+func planLimitExceeded(w http.ResponseWriter, plan Plan, limit string, max int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Превышен лимит тарифа «%s»: %s (не больше %d)", plan.Name, limit, max),
+		"plan":    plan.Name,
+		"limit":   limit,
+		"max":     max,
+	})
+}
+
+// planLockClass — первый ключ pg_advisory_lock(class, user_id) на время
+// «проверить лимит → создать объект».
+const planLockClass = 0x706c616e
+
+// lockPlanUser берёт сессионный advisory-лок пользователя на отдельном
+// соединении: параллельные запросы одного пользователя проверяют лимит
+// и вставляют по очереди. release снимает лок и возвращает соединение.
+func lockPlanUser(ctx context.Context, userID int) (func(), error) {
+	conn, err := psqlConn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1, $2)", planLockClass, userID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("advisory lock: %w", err)
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1, $2)", planLockClass, userID)
+		conn.Close()
+	}, nil
+}
+
+// checkPlanLimit проверяет, можно ли пользователю добавить ещё один объект
+// вида limit ("devices", "rules", "alert_rules"). При успехе вызывающий
+// держит лок пользователя до конца вставки и обязан вызвать release. При
+// отказе ответ уже отправлен клиенту.
+func checkPlanLimit(w http.ResponseWriter, r *http.Request, limit string) (int, func(), bool) {
+	userID, plan, admin, ok := planRequest(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+	if admin {
+		return userID, func() {}, true
+	}
+
+	release, err := lockPlanUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	usage, err := usageOf(userID)
+	if err != nil {
+		release()
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return 0, nil, false
+	}
+
+	used, max := 0, 0
+	switch limit {
+	case "devices":
+		used, max = usage.Devices, plan.MaxDevices
+	case "rules":
+		used, max = usage.Rules, plan.MaxRules
+	case "alert_rules":
+		used, max = usage.AlertRules, plan.MaxAlertRules
+	}
+	if used >= max {
+		release()
+		planLimitExceeded(w, plan, limit, max)
+		return 0, nil, false
+	}
+	return userID, release, true
+}
+
+// checkPlanFeature проверяет доступ к возможности тарифа ("export").
+func checkPlanFeature(w http.ResponseWriter, r *http.Request, feature string) (int, bool) {
+	userID, plan, admin, ok := planRequest(w, r)
+	if !ok {
+		return 0, false
+	}
+	allowed := admin || (feature == "export" && plan.Export) || (feature == "api_tokens" && plan.APITokens)
+	if !allowed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": fmt.Sprintf("Возможность недоступна на тарифе «%s»", plan.Name),
+			"plan":    plan.Name,
+			"feature": feature,
+		})
+		return 0, false
+	}
+	return userID, true
+}
+
+// historyWindow обрезает запрошенный период истории по окну хранения
+// тарифа. Возвращает false, если период целиком вне окна.
+func historyWindow(w http.ResponseWriter, r *http.Request, from, to time.Time) (time.Time, bool) {
+	_, plan, admin, ok := planRequest(w, r)
+	if !ok {
+		return from, false
+	}
+	if admin {
+		return from, true
+	}
+
+	earliest := time.Now().AddDate(0, 0, -plan.HistoryDays)
+	if to.Before(earliest) {
+		planLimitExceeded(w, plan, "history_days", plan.HistoryDays)
+		return from, false
+	}
+	if from.Before(earliest) {
+		from = earliest
+	}
+	return from, true
+}
+
+// ============ REST API HANDLERS - PLANS ============
+
+func getPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]Plan{plans["Базовый"], plans["Максимум"]})
+}
+
+// getPlanUsage — потребление вошедшего пользователя; администратор
+// получает список по всем пользователям или по ?user_id=.
+func getPlanUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, admin, ok := planRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !admin {
+		usage, err := usageOf(userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+		return
+	}
+
+	query, args := "SELECT id FROM users ORDER BY id", []interface{}{}
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		query, args = "SELECT id FROM users WHERE id = $1", []interface{}{v}
+	}
+	rows, err := psqlConn.Query(query, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	result := []PlanUsage{}
+	for _, id := range ids {
+		usage, err := usageOf(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		result = append(result, usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ ПРИСУТСТВИЕ ============
+//
+// Сообщения sensors/motion/... превращаются в занятость комнат. Комната
+// датчика берётся из справочника устройств (device.sensor_key,
+// …/device_<id>) или из соглашения …/room_<id> (…/room<id>, как в
+// scenarios/demo.yaml). Движение делает комнату занятой; комната
+// освобождается, если движения не было дольше
+// room.vacancy_timeout_seconds (по умолчанию PRESENCE_VACANCY_TIMEOUT).
+// Здание считается занятым ("кто-то дома"), пока занята хоть одна
+// комната. Каждое изменение публикуется в поток событий, retained-топики
+// buildings/<id>/occupancy и buildings/<id>/rooms/<room>/occupancy и
+// передаётся движку правил (триггер и условие type=occupancy).
+
+const (
+	defaultVacancyTimeout = 15 * time.Minute
+	presenceSweepInterval = 30 * time.Second
+	roomsRefresh          = time.Minute
+)
+
+type RoomOccupancy struct {
+	RoomID         int       `json:"room_id"`
+	Name           string    `json:"name"`
+	BuildingID     int       `json:"building_id"`
+	Occupied       bool      `json:"occupied"`
+	LastMotion     time.Time `json:"last_motion,omitempty"`
+	Since          time.Time `json:"since,omitempty"`
+	VacancyTimeout string    `json:"vacancy_timeout"`
+
+	timeout time.Duration
+}
+
+// OccupancyChange — переход комнаты (RoomID != 0) или здания (RoomID == 0).
+type OccupancyChange struct {
+	BuildingID int       `json:"building_id"`
+	RoomID     int       `json:"room_id,omitempty"`
+	Occupied   bool      `json:"occupied"`
+	Time       time.Time `json:"time"`
+}
+
+type presenceTracker struct {
+	mu             sync.Mutex
+	rooms          map[int]*RoomOccupancy
+	home           map[int]bool      // здание → кто-то дома
+	homeSince      map[int]time.Time // здание → с какого момента
+	defaultTimeout time.Duration
+}
+
+var presence = &presenceTracker{
+	rooms:          map[int]*RoomOccupancy{},
+	home:           map[int]bool{},
+	homeSince:      map[int]time.Time{},
+	defaultTimeout: defaultVacancyTimeout,
+}
+
+func initPresence() {
+	presence.defaultTimeout = cfg.Presence.VacancyTimeout
+
+	presence.refresh()
+	logger("presence").Info("Присутствие инициализировано", "default_timeout", presence.defaultTimeout)
+}
+
+// refresh подтягивает список комнат и их таймауты, сохраняя текущее
+// состояние занятости. Вызывается из фонового цикла (раз в roomsRefresh)
+// и после изменения таймаута; запрос к БД идёт без p.mu, который observe
+// берёт на каждом сообщении о движении.
+func (p *presenceTracker) refresh() {
+	rows, err := psqlConn.Query("SELECT id, name, building_id, vacancy_timeout_seconds FROM room")
+	if err != nil {
+		logger("presence").Error("Ошибка загрузки комнат", "error", err)
+		return
+	}
+	type roomRow struct {
+		id, buildingID int
+		name           string
+		seconds        sql.NullInt64
+	}
+	var loaded []roomRow
+	for rows.Next() {
+		var row roomRow
+		if rows.Scan(&row.id, &row.name, &row.buildingID, &row.seconds) == nil {
+			loaded = append(loaded, row)
+		}
+	}
+	rows.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, row := range loaded {
+		room, ok := p.rooms[row.id]
+		if !ok {
+			room = &RoomOccupancy{RoomID: row.id}
+			p.rooms[row.id] = room
+		}
+		room.Name, room.BuildingID = row.name, row.buildingID
+		room.timeout = p.defaultTimeout
+		if row.seconds.Valid && row.seconds.Int64 > 0 {
+			room.timeout = time.Duration(row.seconds.Int64) * time.Second
+		}
+		room.VacancyTimeout = room.timeout.String()
+	}
+}
+
+// roomForTopic определяет комнату датчика по топику.
+func roomForTopic(topic string) (int, bool) {
+	if info, ok := devices.resolve(topic); ok && info.RoomID != 0 {
+		return info.RoomID, true
+	}
+	last := topic[strings.LastIndex(topic, "/")+1:]
+	if rest, ok := strings.CutPrefix(last, "room"); ok {
+		if id, err := strconv.Atoi(strings.TrimPrefix(rest, "_")); err == nil {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// observe — обработчик показаний датчиков (см. readingHandlers).
+func (p *presenceTracker) observe(reading SensorReading) {
+	if !strings.HasPrefix(reading.Topic, "sensors/motion/") || !reading.HasValue || reading.Value <= 0 {
+		return
+	}
+	roomID, ok := roomForTopic(reading.Topic)
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	room, ok := p.rooms[roomID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	room.LastMotion = reading.Time
+	var changes []OccupancyChange
+	if !room.Occupied {
+		room.Occupied, room.Since = true, reading.Time
+		changes = append(changes, OccupancyChange{BuildingID: room.BuildingID, RoomID: roomID, Occupied: true, Time: reading.Time})
+		changes = append(changes, p.updateHomeLocked(room.BuildingID, reading.Time)...)
+	}
+	p.mu.Unlock()
+
+	p.announce(changes)
+}
+
+// updateHomeLocked пересчитывает "кто-то дома" для здания.
+func (p *presenceTracker) updateHomeLocked(buildingID int, at time.Time) []OccupancyChange {
+	anyone := false
+	for _, room := range p.rooms {
+		if room.BuildingID == buildingID && room.Occupied {
+			anyone = true
+			break
+		}
+	}
+	if p.home[buildingID] == anyone {
+		return nil
+	}
+	p.home[buildingID], p.homeSince[buildingID] = anyone, at
+	return []OccupancyChange{{BuildingID: buildingID, Occupied: anyone, Time: at}}
+}
+
+// sweep освобождает комнаты без движения дольше их таймаута.
+func (p *presenceTracker) sweep(now time.Time) {
+	var changes []OccupancyChange
+
+	p.mu.Lock()
+	buildings := map[int]bool{}
+	for _, room := range p.rooms {
+		if room.Occupied && now.Sub(room.LastMotion) > room.timeout {
+			room.Occupied, room.Since = false, now
+			changes = append(changes, OccupancyChange{BuildingID: room.BuildingID, RoomID: room.RoomID, Occupied: false, Time: now})
+			buildings[room.BuildingID] = true
+		}
+	}
+	for buildingID := range buildings {
+		changes = append(changes, p.updateHomeLocked(buildingID, now)...)
+	}
+	p.mu.Unlock()
+
+	p.announce(changes)
+}
+
+// startPresenceMonitor освобождает комнаты по таймауту и раз в
+// roomsRefresh перечитывает комнаты присутствия и микроклимата.
+func startPresenceMonitor(ctx context.Context) {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+	refresh := time.NewTicker(roomsRefresh)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			presence.sweep(now)
+		case <-refresh.C:
+			presence.refresh()
+			climate.refresh()
+		}
+	}
+}
+
+func occupancyTopic(c OccupancyChange) string {
+	if c.RoomID != 0 {
+		return fmt.Sprintf("buildings/%d/rooms/%d/occupancy", c.BuildingID, c.RoomID)
+	}
+	return fmt.Sprintf("buildings/%d/occupancy", c.BuildingID)
+}
+
+// announce рассылает изменения: поток событий, MQTT и правила.
+func (p *presenceTracker) announce(changes []OccupancyChange) {
+	for _, c := range changes {
+		if c.RoomID != 0 {
+			logger("presence").Info("Смена занятости комнаты", "room_id", c.RoomID, "building_id", c.BuildingID, "occupied", c.Occupied)
+		} else {
+			logger("presence").Info("Смена присутствия в здании", "building_id", c.BuildingID, "occupied", c.Occupied)
+		}
+
+		hub.publish(StreamEvent{Type: "occupancy", BuildingID: c.BuildingID, RoomID: c.RoomID, Data: c, Time: c.Time})
+
+		payload, _ := json.Marshal(c)
+		token := mqttClient.Publish(occupancyTopic(c), byte(cfg.MQTT.QoS), true, payload)
+		if !token.WaitTimeout(cfg.MQTT.PublishTimeout) || token.Error() != nil {
+			logger("presence").Error("Ошибка публикации", "topic", occupancyTopic(c), "error", token.Error())
+		}
+
+		automation.onOccupancy(c)
+	}
+}
+
+// roomOccupied и anyoneHome используются условиями правил.
+func (p *presenceTracker) roomOccupied(roomID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	room, ok := p.rooms[roomID]
+	return ok && room.Occupied
+}
+
+func (p *presenceTracker) anyoneHome(buildingID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.home[buildingID]
+}
+
+func (p *presenceTracker) snapshot(buildingID int) ([]RoomOccupancy, bool, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rooms := []RoomOccupancy{}
+	for _, room := range p.rooms {
+		if room.BuildingID == buildingID {
+			rooms = append(rooms, *room)
+		}
+	}
+	return rooms, p.home[buildingID], p.homeSince[buildingID]
+}
+
+// ============ REST API HANDLERS - PRESENCE ============
+
+// getPresence — занятость комнат здания (?building_id=).
+func getPresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buildingID, err := strconv.Atoi(r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, "building_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	rooms, home, since := presence.snapshot(buildingID)
+	resp := map[string]interface{}{
+		"building_id": buildingID,
+		"anyone_home": home,
+		"rooms":       rooms,
+	}
+	if !since.IsZero() {
+		resp["since"] = since
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setVacancyTimeout задаёт таймаут освобождения комнаты
+// (?room_id=, {"seconds": 600}; 0 — вернуть значение по умолчанию).
+func setVacancyTimeout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+	if err != nil {
+		http.Error(w, "room_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Seconds < 0 {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	res, err := psqlConn.Exec("UPDATE room SET vacancy_timeout_seconds = NULLIF($1, 0) WHERE id = $2", req.Seconds, roomID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Комната не найдена", http.StatusNotFound)
+		return
+	}
+
+	presence.refresh()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"room_id": roomID, "seconds": req.Seconds})
+}
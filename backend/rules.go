@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============ ПРАВИЛА АВТОМАТИЗАЦИИ ============
+//
+// Правило: триггер (порог по датчику) + условия + действие (команда
+// устройству или сцена). Правило срабатывает на переходе триггера из
+// "ложь" в "истина", а не на каждом сообщении. Список modes ограничивает
+// режимы дома, в которых правило действует: при смене режима mode_active
+// пересчитывается, а пользовательский флаг enabled не трогается.
+//
+// Триггер и условия бывают двух типов: sensor (порог по датчику) и
+// occupancy (занятость комнаты room_id или, без room_id, "кто-то дома"
+// в здании правила — см. presence.go).
+
+const rulesReloadInterval = 30 * time.Second
+
+type RuleTrigger struct {
+	Type      string  `json:"type"` // sensor | occupancy
+	SensorID  string  `json:"sensor_id,omitempty"`
+	Operator  string  `json:"operator,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	RoomID    *int    `json:"room_id,omitempty"`
+	Occupied  *bool   `json:"occupied,omitempty"`
+}
+
+type RuleCondition struct {
+	Type      string  `json:"type"` // sensor | occupancy
+	SensorID  string  `json:"sensor_id,omitempty"`
+	Operator  string  `json:"operator,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	RoomID    *int    `json:"room_id,omitempty"`
+	Occupied  *bool   `json:"occupied,omitempty"`
+}
+
+type Rule struct {
+	ID              int             `json:"id"`
+	BuildingID      int             `json:"building_id"`
+	Name            string          `json:"name"`
+	Enabled         bool            `json:"enabled"`
+	ModeActive      bool            `json:"mode_active"`
+	Modes           []string        `json:"modes"`
+	Trigger         RuleTrigger     `json:"trigger"`
+	Conditions      []RuleCondition `json:"conditions"`
+	DeviceID        *int            `json:"device_id,omitempty"`
+	Command         string          `json:"command,omitempty"`
+	Value           string          `json:"value,omitempty"`
+	SceneID         *int            `json:"scene_id,omitempty"`
+	CooldownSeconds int             `json:"cooldown_seconds"`
+}
+
+type ruleEngine struct {
+	mu        sync.Mutex
+	rules     []Rule
+	loadedAt  time.Time
+	triggered map[int]bool      // состояние триггера на прошлом сообщении
+	lastFired map[int]time.Time // для cooldown
+	latest    map[string]SensorReading
+}
+
+var automation = &ruleEngine{
+	triggered: map[int]bool{},
+	lastFired: map[int]time.Time{},
+	latest:    map[string]SensorReading{},
+}
+
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+func validOperator(operator string) bool {
+	switch operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" || r.BuildingID == 0 {
+		return fmt.Errorf("name и building_id обязательны")
+	}
+	switch r.Trigger.Type {
+	case "sensor":
+		if r.Trigger.SensorID == "" || !validOperator(r.Trigger.Operator) {
+			return fmt.Errorf("trigger: для type=sensor нужны sensor_id и operator (>, >=, <, <=, ==, !=)")
+		}
+	case "occupancy":
+		if r.Trigger.Occupied == nil {
+			return fmt.Errorf("trigger: для type=occupancy нужен occupied")
+		}
+	default:
+		return fmt.Errorf("trigger: type должен быть sensor или occupancy")
+	}
+	for _, c := range r.Conditions {
+		switch {
+		case c.Type == "sensor" && c.SensorID != "" && validOperator(c.Operator):
+		case c.Type == "occupancy" && c.Occupied != nil:
+		default:
+			return fmt.Errorf("condition: нужен type=sensor с sensor_id и operator или type=occupancy с occupied")
+		}
+	}
+	if (r.SceneID == nil) == (r.DeviceID == nil) {
+		return fmt.Errorf("нужно указать либо scene_id, либо device_id с command")
+	}
+	if r.DeviceID != nil && r.Command == "" {
+		return fmt.Errorf("command обязателен для device_id")
+	}
+	if r.Modes == nil {
+		r.Modes = []string{}
+	}
+	return nil
+}
+
+func (e *ruleEngine) invalidate() {
+	e.mu.Lock()
+	e.loadedAt = time.Time{}
+	e.mu.Unlock()
+}
+
+// evaluate — обработчик показаний датчиков (см. readingHandlers).
+func (e *ruleEngine) evaluate(reading SensorReading) {
+	if !reading.HasValue {
+		return
+	}
+
+	e.mu.Lock()
+	e.latest[reading.SensorID] = reading
+	e.reloadLocked()
+
+	var fire []Rule
+	for _, rule := range e.rules {
+		if rule.Trigger.Type != "sensor" || rule.Trigger.SensorID != reading.SensorID {
+			continue
+		}
+		now := compare(reading.Value, rule.Trigger.Operator, rule.Trigger.Threshold)
+		if e.shouldFireLocked(rule, now) {
+			fire = append(fire, rule)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, rule := range fire {
+		go executeRule(rule, reading)
+	}
+}
+
+// onOccupancy вызывается трекером присутствия при смене занятости
+// комнаты или здания.
+func (e *ruleEngine) onOccupancy(change OccupancyChange) {
+	e.mu.Lock()
+	e.reloadLocked()
+
+	var fire []Rule
+	for _, rule := range e.rules {
+		t := rule.Trigger
+		if t.Type != "occupancy" || rule.BuildingID != change.BuildingID {
+			continue
+		}
+		if (t.RoomID == nil) != (change.RoomID == 0) || (t.RoomID != nil && *t.RoomID != change.RoomID) {
+			continue
+		}
+		if e.shouldFireLocked(rule, change.Occupied == *t.Occupied) {
+			fire = append(fire, rule)
+		}
+	}
+	e.mu.Unlock()
+
+	sensorID := fmt.Sprintf("building_%d", change.BuildingID)
+	if change.RoomID != 0 {
+		sensorID = fmt.Sprintf("room_%d", change.RoomID)
+	}
+	reading := SensorReading{Topic: occupancyTopic(change), SensorID: sensorID, HasValue: true, Time: change.Time}
+	if change.Occupied {
+		reading.Value = 1
+	}
+	for _, rule := range fire {
+		go executeRule(rule, reading)
+	}
+}
+
+// reloadLocked перечитывает правила, если кэш устарел. Вызывается под e.mu.
+func (e *ruleEngine) reloadLocked() {
+	if time.Since(e.loadedAt) <= rulesReloadInterval {
+		return
+	}
+	loaded, err := loadRules("WHERE enabled AND mode_active")
+	if err != nil {
+		logger("rules").Error("Ошибка загрузки правил", "error", err)
+		return
+	}
+	e.rules = loaded
+	e.loadedAt = time.Now()
+}
+
+// shouldFireLocked запоминает состояние триггера и решает, срабатывает ли
+// правило: переход в "истина", выполненные условия и истёкший cooldown.
+func (e *ruleEngine) shouldFireLocked(rule Rule, now bool) bool {
+	was := e.triggered[rule.ID]
+	e.triggered[rule.ID] = now
+	if !now || was || !e.conditionsHold(rule) {
+		return false
+	}
+	cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+	if last, ok := e.lastFired[rule.ID]; ok && time.Since(last) < cooldown {
+		return false
+	}
+	e.lastFired[rule.ID] = time.Now()
+	return true
+}
+
+// conditionsHold вызывается под e.mu.
+func (e *ruleEngine) conditionsHold(rule Rule) bool {
+	for _, c := range rule.Conditions {
+		if c.Type == "occupancy" {
+			occupied := presence.anyoneHome(rule.BuildingID)
+			if c.RoomID != nil {
+				occupied = presence.roomOccupied(*c.RoomID)
+			}
+			if occupied != *c.Occupied {
+				return false
+			}
+			continue
+		}
+		latest, ok := e.latest[c.SensorID]
+		if !ok || !compare(latest.Value, c.Operator, c.Threshold) {
+			return false
+		}
+	}
+	return true
+}
+
+func executeRule(rule Rule, reading SensorReading) {
+	source := fmt.Sprintf("rule:%d", rule.ID)
+	var err error
+	if rule.SceneID != nil {
+		err = runScene(context.Background(), *rule.SceneID, source)
+	} else {
+		cmd := DeviceCommand{DeviceID: *rule.DeviceID, Command: rule.Command}
+		if rule.Value != "" {
+			cmd.Value = rule.Value
+		}
+		err = publishDeviceCommand(context.Background(), cmd, source)
+	}
+
+	if err != nil {
+		logger("rules").ErrorContext(reading.logContext(), "Ошибка действия правила", "rule_id", rule.ID, "rule", rule.Name, "error", err)
+		return
+	}
+	logger("rules").InfoContext(reading.logContext(), "Сработало правило", "rule_id", rule.ID, "rule", rule.Name, "value", reading.Value)
+}
+
+// applyModeToRules пересчитывает mode_active для правил здания. Правила
+// без списка режимов действуют всегда.
+func applyModeToRules(tx *sql.Tx, buildingID int, mode string) error {
+	_, err := tx.Exec(`UPDATE automation_rules
+		SET mode_active = (cardinality(modes) = 0 OR $2 = ANY(modes))
+		WHERE building_id = $1`, buildingID, mode)
+	return err
+}
+
+// ============ ХРАНЕНИЕ ============
+
+const ruleColumns = `id, building_id, name, enabled, mode_active, modes, trigger, conditions,
+	device_id, COALESCE(command, ''), COALESCE(value, ''), scene_id, cooldown_seconds`
+
+func scanRule(row interface{ Scan(...interface{}) error }) (Rule, error) {
+	var r Rule
+	var trigger, conditions []byte
+	var deviceID, sceneID sql.NullInt64
+	err := row.Scan(&r.ID, &r.BuildingID, &r.Name, &r.Enabled, &r.ModeActive, pq.Array(&r.Modes),
+		&trigger, &conditions, &deviceID, &r.Command, &r.Value, &sceneID, &r.CooldownSeconds)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(trigger, &r.Trigger); err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(conditions, &r.Conditions); err != nil {
+		return r, err
+	}
+	if deviceID.Valid {
+		id := int(deviceID.Int64)
+		r.DeviceID = &id
+	}
+	if sceneID.Valid {
+		id := int(sceneID.Int64)
+		r.SceneID = &id
+	}
+	if r.Modes == nil {
+		r.Modes = []string{}
+	}
+	return r, nil
+}
+
+func loadRules(where string, args ...interface{}) ([]Rule, error) {
+	rows, err := psqlConn.Query("SELECT "+ruleColumns+" FROM automation_rules "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			logger("rules").Warn("Пропущено правило", "error", err)
+			continue
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ============ REST API HANDLERS - RULES ============
+
+func getRules(w http.ResponseWriter, r *http.Request) {
+	var list []Rule
+	var err error
+	if buildingID := r.URL.Query().Get("building_id"); buildingID != "" {
+		list, err = loadRules("WHERE building_id = $1", buildingID)
+	} else {
+		list, err = loadRules("")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func createRule(w http.ResponseWriter, r *http.Request) {
+	rule := Rule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.Conditions == nil {
+		rule.Conditions = []RuleCondition{}
+	}
+	userID, release, ok := checkPlanLimit(w, r, "rules")
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Без проверки несуществующее здание дало бы 500 из внешнего ключа.
+	var exists bool
+	if err := psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM building WHERE id = $1)", rule.BuildingID).Scan(&exists); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Здание не найдено", http.StatusNotFound)
+		return
+	}
+
+	trigger, _ := json.Marshal(rule.Trigger)
+	conditions, _ := json.Marshal(rule.Conditions)
+	err := psqlConn.QueryRow(`INSERT INTO automation_rules
+		(building_id, name, enabled, modes, mode_active, trigger, conditions, device_id, command, value, scene_id, cooldown_seconds, created_by)
+		VALUES ($1, $2, $3, $4,
+			cardinality($4::text[]) = 0 OR (SELECT mode FROM building WHERE id = $1) = ANY($4::text[]),
+			$5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12)
+		RETURNING id, mode_active`,
+		rule.BuildingID, rule.Name, rule.Enabled, pq.Array(rule.Modes), trigger, conditions,
+		rule.DeviceID, rule.Command, rule.Value, rule.SceneID, rule.CooldownSeconds, userID,
+	).Scan(&rule.ID, &rule.ModeActive)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	automation.invalidate()
+	auditRequest(r, "rule.create", "rule", rule.ID, nil, rule)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func updateRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	res, err := psqlConn.Exec("UPDATE automation_rules SET enabled = $1 WHERE id = $2", req.Enabled, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Правило не найдено", http.StatusNotFound)
+		return
+	}
+	automation.invalidate()
+	auditRequest(r, "rule.update", "rule", id, nil, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func deleteRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	before, err := loadRules("WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := psqlConn.Exec("DELETE FROM automation_rules WHERE id = $1", id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	automation.invalidate()
+	if len(before) > 0 {
+		auditRequest(r, "rule.delete", "rule", id, before[0], nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
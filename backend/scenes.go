@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============ КОМАНДЫ УСТРОЙСТВАМ ============
+
+// DeviceCommand — одна команда исполнительному устройству. Публикуется в
+// controllers/command/<device_id>, ответ устройства приходит в controllers/status/#.
+type DeviceCommand struct {
+	DeviceID int         `json:"device_id"`
+	Command  string      `json:"command"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+type Scene struct {
+	ID         int             `json:"id"`
+	BuildingID int             `json:"building_id"`
+	Name       string          `json:"name"`
+	Actions    []DeviceCommand `json:"actions"`
+	RunOnMode  string          `json:"run_on_mode,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func commandTopic(deviceID int) string {
+	return fmt.Sprintf("controllers/command/%d", deviceID)
+}
+
+// publishDeviceCommand отправляет команду в MQTT и запоминает её как
+// текущее состояние контроллера устройства. source — кто инициировал команду
+// (api, schedule:<id>, scene:<id> и т.п.).
+// Команды из API попадают в журнал аудита в sendDeviceCommand (с
+// исполнителем и запросом), остальные источники — здесь.
+func publishDeviceCommand(ctx context.Context, cmd DeviceCommand, source string) (err error) {
+	if cmd.DeviceID <= 0 || cmd.Command == "" {
+		return fmt.Errorf("device_id и command обязательны")
+	}
+	topic := commandTopic(cmd.DeviceID)
+	ctx, span := tracer.Start(ctx, "mqtt send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("mqtt"),
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(topic),
+			attribute.Int("device_id", cmd.DeviceID),
+			attribute.String("command", cmd.Command),
+		))
+	defer func() { endSpan(span, err) }()
+	previous := controllerState(cmd.DeviceID)
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"device_id": cmd.DeviceID,
+		"command":   cmd.Command,
+		"value":     cmd.Value,
+		"source":    source,
+		"timestamp": time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	token := mqttClient.Publish(topic, byte(cfg.MQTT.QoS), false, payload)
+	if !token.WaitTimeout(cfg.MQTT.PublishTimeout) {
+		err = fmt.Errorf("таймаут публикации команды для устройства %d", cmd.DeviceID)
+	} else {
+		err = token.Error()
+	}
+	if err != nil {
+		logDeviceEvent(cmd.DeviceID, deviceEventError, map[string]interface{}{
+			"stage": "command", "command": cmd.Command, "value": cmd.Value, "source": source, "error": err.Error(),
+		})
+		return err
+	}
+
+	if _, err := psqlConn.ExecContext(ctx, "UPDATE controller SET state = $1 WHERE device_id = $2", cmd.Command, cmd.DeviceID); err != nil {
+		logger("commands").ErrorContext(ctx, "Ошибка обновления состояния контроллера", "device_id", cmd.DeviceID, "error", err)
+	}
+	energy.setState(cmd.DeviceID, cmd.Command)
+	logDeviceEvent(cmd.DeviceID, deviceEventCommand, map[string]interface{}{
+		"command": cmd.Command, "value": cmd.Value, "source": source, "previous": previous,
+	})
+	if source != "api" {
+		auditSystem(source, "device.command", "device", cmd.DeviceID,
+			map[string]string{"state": previous}, cmd)
+	}
+
+	logger("commands").Info("Команда отправлена", "source", source, "device_id", cmd.DeviceID, "command", cmd.Command, "payload", string(payload))
+	return nil
+}
+
+// controllerState — текущее состояние контроллера устройства ("" если нет).
+func controllerState(deviceID int) string {
+	var state sql.NullString
+	psqlConn.QueryRow("SELECT state FROM controller WHERE device_id = $1", deviceID).Scan(&state)
+	return state.String
+}
+
+// ============ СЦЕНЫ ============
+
+func loadScene(id int) (*Scene, error) {
+	var s Scene
+	var actions []byte
+	err := psqlConn.QueryRow(
+		"SELECT id, building_id, name, actions, COALESCE(run_on_mode, ''), created_at FROM scenes WHERE id = $1", id,
+	).Scan(&s.ID, &s.BuildingID, &s.Name, &actions, &s.RunOnMode, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(actions, &s.Actions); err != nil {
+		return nil, fmt.Errorf("сцена %d: неверный список действий: %w", id, err)
+	}
+	return &s, nil
+}
+
+// runScene выполняет все действия сцены. Ошибка одного действия не
+// останавливает остальные, но возвращается вызывающему.
+func runScene(ctx context.Context, id int, source string) error {
+	scene, err := loadScene(id)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, action := range scene.Actions {
+		if err := publishDeviceCommand(ctx, action, fmt.Sprintf("%s/scene:%d", source, scene.ID)); err != nil {
+			logger("scenes").Error("Ошибка действия сцены", "scene_id", scene.ID, "device_id", action.DeviceID, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func getScenes(w http.ResponseWriter, r *http.Request) {
+	buildingID := r.URL.Query().Get("building_id")
+
+	query := "SELECT id, building_id, name, actions, COALESCE(run_on_mode, ''), created_at FROM scenes"
+	args := []interface{}{}
+	if buildingID != "" {
+		query += " WHERE building_id = $1"
+		args = append(args, buildingID)
+	}
+	query += " ORDER BY id"
+
+	rows, err := psqlConn.Query(query, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	scenes := []Scene{}
+	for rows.Next() {
+		var s Scene
+		var actions []byte
+		if err := rows.Scan(&s.ID, &s.BuildingID, &s.Name, &actions, &s.RunOnMode, &s.CreatedAt); err != nil {
+			continue
+		}
+		json.Unmarshal(actions, &s.Actions)
+		scenes = append(scenes, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scenes)
+}
+
+func createScene(w http.ResponseWriter, r *http.Request) {
+	var s Scene
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	if s.Name == "" || s.BuildingID == 0 {
+		http.Error(w, "name и building_id обязательны", http.StatusBadRequest)
+		return
+	}
+	for _, a := range s.Actions {
+		if a.DeviceID <= 0 || a.Command == "" {
+			http.Error(w, "Каждое действие должно содержать device_id и command", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.Actions == nil {
+		s.Actions = []DeviceCommand{}
+	}
+
+	actions, _ := json.Marshal(s.Actions)
+	err := psqlConn.QueryRow(
+		"INSERT INTO scenes (building_id, name, actions, run_on_mode) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at",
+		s.BuildingID, s.Name, actions, s.RunOnMode,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func deleteScene(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := psqlConn.Exec("DELETE FROM scenes WHERE id = $1", id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func runSceneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var id int
+	if _, err := fmt.Sscan(r.URL.Query().Get("id"), &id); err != nil {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+	if !user.requireScene(w, id) {
+		return
+	}
+
+	err := runScene(r.Context(), id, "api")
+	auditRequest(r, "scene.run", "scene", id, nil, map[string]string{"error": errString(err)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка выполнения сцены: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "executed"})
+}
+
+func sendDeviceCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var cmd DeviceCommand
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if !user.requireDevice(w, cmd.DeviceID) {
+		return
+	}
+
+	previous := controllerState(cmd.DeviceID)
+	if err := publishDeviceCommand(r.Context(), cmd, "api"); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка отправки команды: %v", err), http.StatusBadRequest)
+		return
+	}
+	auditRequest(r, "device.command", "device", cmd.DeviceID, map[string]string{"state": previous}, cmd)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
+}
@@ -0,0 +1,910 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/lib/pq"
+	"github.com/robfig/cron/v3"
+)
+
+// ============ РАСПИСАНИЯ ============
+//
+// Расписание хранится в таблице schedules вместе с next_run_at. Раз в
+// schedulerTick планировщик забирает созревшие строки через
+// SELECT ... FOR UPDATE SKIP LOCKED, в той же транзакции вставляет запись
+// в schedule_runs (UNIQUE (schedule_id, scheduled_for)) и сдвигает
+// next_run_at. Действие выполняется только после коммита, поэтому два
+// экземпляра не выполнят одно срабатывание одновременно.
+//
+// Запуск арендуется: экземпляр, захвативший пачку запусков, продлевает
+// lease_until всех её запусков каждые scheduleRunLease/4, пока они ждут
+// очереди и выполняются. Если процесс упал посреди запуска, аренда
+// истекает, и любой экземпляр забирает запуск снова (не более
+// scheduleRunAttempts попыток, дальше — 'interrupted'). Завершение
+// записывается только владельцем аренды и только из статуса running,
+// поэтому выполненный запуск не повторяется. Команда может уйти повторно
+// лишь при падении между публикацией и записью статуса.
+
+const (
+	schedulerTick  = 15 * time.Second
+	schedulerGrace = time.Minute // опоздание, после которого запуск считается пропущенным
+	maxUpcoming    = 100
+
+	scheduleKindCron    = "cron"
+	scheduleKindAt      = "at"
+	scheduleKindSunrise = "sunrise"
+	scheduleKindSunset  = "sunset"
+
+	missedSkip    = "skip"
+	missedCatchUp = "catch_up"
+
+	defaultTimezone = "Europe/Moscow"
+
+	scheduleRunLease    = 2 * time.Minute
+	scheduleRunAttempts = 3
+)
+
+// schedulerInstance — владелец аренды запусков этого процесса.
+var schedulerInstance = func() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%x", host, os.Getpid(), suffix)
+}()
+
+type Schedule struct {
+	ID            int        `json:"id"`
+	BuildingID    int        `json:"building_id"`
+	Name          string     `json:"name"`
+	Kind          string     `json:"kind"`
+	Expression    string     `json:"expression,omitempty"`
+	OffsetMinutes int        `json:"offset_minutes,omitempty"`
+	DeviceID      *int       `json:"device_id,omitempty"`
+	Command       string     `json:"command,omitempty"`
+	Value         string     `json:"value,omitempty"`
+	SceneID       *int       `json:"scene_id,omitempty"`
+	MissedPolicy  string     `json:"missed_policy"`
+	Enabled       bool       `json:"enabled"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+}
+
+type ScheduleRun struct {
+	ID           int        `json:"id"`
+	ScheduleID   int        `json:"schedule_id"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+type UpcomingRun struct {
+	ScheduleID int       `json:"schedule_id"`
+	Name       string    `json:"name"`
+	BuildingID int       `json:"building_id"`
+	RunAt      time.Time `json:"run_at"`
+}
+
+// buildingLocation — то, что нужно расписанию от здания: часовой пояс и
+// координаты для восхода/заката.
+type buildingLocation struct {
+	Loc       *time.Location
+	Latitude  sql.NullFloat64
+	Longitude sql.NullFloat64
+}
+
+func loadBuildingLocation(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, buildingID int) (buildingLocation, error) {
+	var tz sql.NullString
+	var bl buildingLocation
+	err := q.QueryRow(
+		"SELECT latitude, longitude, timezone FROM building WHERE id = $1", buildingID,
+	).Scan(&bl.Latitude, &bl.Longitude, &tz)
+	if err != nil {
+		return bl, err
+	}
+
+	name := defaultTimezone
+	if tz.Valid && tz.String != "" {
+		name = tz.String
+	}
+	bl.Loc, err = time.LoadLocation(name)
+	if err != nil {
+		return bl, fmt.Errorf("неизвестный часовой пояс %q: %w", name, err)
+	}
+	return bl, nil
+}
+
+// validate проверяет расписание и заполняет значения по умолчанию.
+func (s *Schedule) validate() error {
+	if s.Name == "" || s.BuildingID == 0 {
+		return fmt.Errorf("name и building_id обязательны")
+	}
+	if (s.SceneID == nil) == (s.DeviceID == nil) {
+		return fmt.Errorf("нужно указать либо scene_id, либо device_id с command")
+	}
+	if s.DeviceID != nil && s.Command == "" {
+		return fmt.Errorf("command обязателен для device_id")
+	}
+	if s.MissedPolicy == "" {
+		s.MissedPolicy = missedSkip
+	}
+	if s.MissedPolicy != missedSkip && s.MissedPolicy != missedCatchUp {
+		return fmt.Errorf("missed_policy должен быть skip или catch_up")
+	}
+
+	switch s.Kind {
+	case scheduleKindCron:
+		if _, err := cron.ParseStandard(s.Expression); err != nil {
+			return fmt.Errorf("неверное cron-выражение: %w", err)
+		}
+	case scheduleKindAt:
+		if _, _, ok := parseClock(s.Expression); !ok {
+			if _, err := time.Parse(time.RFC3339, s.Expression); err != nil {
+				return fmt.Errorf("expression для at: HH:MM или RFC3339")
+			}
+		}
+	case scheduleKindSunrise, scheduleKindSunset:
+	default:
+		return fmt.Errorf("kind должен быть cron, at, sunrise или sunset")
+	}
+	return nil
+}
+
+func parseClock(expr string) (hour, minute int, ok bool) {
+	parts := strings.Split(expr, ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, 0, false
+	}
+	return h, m, true
+}
+
+// nextAfter возвращает первое срабатывание строго после after.
+// ok == false, если срабатываний больше не будет (разовое расписание
+// в прошлом или солнце не восходит/не заходит весь ближайший год).
+func (s *Schedule) nextAfter(after time.Time, bl buildingLocation) (time.Time, bool) {
+	local := after.In(bl.Loc)
+
+	switch s.Kind {
+	case scheduleKindCron:
+		sched, err := cron.ParseStandard(s.Expression)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next := sched.Next(local)
+		return next, !next.IsZero()
+
+	case scheduleKindAt:
+		if h, m, ok := parseClock(s.Expression); ok {
+			next := time.Date(local.Year(), local.Month(), local.Day(), h, m, 0, 0, bl.Loc)
+			for !next.After(after) {
+				next = time.Date(next.Year(), next.Month(), next.Day()+1, h, m, 0, 0, bl.Loc)
+			}
+			return next, true
+		}
+		at, err := time.Parse(time.RFC3339, s.Expression)
+		if err != nil || !at.After(after) {
+			return time.Time{}, false
+		}
+		return at.In(bl.Loc), true
+
+	case scheduleKindSunrise, scheduleKindSunset:
+		if !bl.Latitude.Valid || !bl.Longitude.Valid {
+			return time.Time{}, false
+		}
+		offset := time.Duration(s.OffsetMinutes) * time.Minute
+		// Начинаем с предыдущего дня: при большом отрицательном смещении
+		// событие "завтра" может оказаться ещё сегодня.
+		for i := -1; i <= 366; i++ {
+			day := time.Date(local.Year(), local.Month(), local.Day()+i, 12, 0, 0, 0, bl.Loc)
+			rise, set, ok := sunTimes(day, bl.Latitude.Float64, bl.Longitude.Float64)
+			if !ok {
+				continue
+			}
+			event := rise
+			if s.Kind == scheduleKindSunset {
+				event = set
+			}
+			if next := event.Add(offset); next.After(after) {
+				return next.In(bl.Loc), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// ============ ЦИКЛ ПЛАНИРОВЩИКА ============
+
+type claimedRun struct {
+	runID    int
+	schedule Schedule
+	at       time.Time
+}
+
+func startScheduler(ctx context.Context) {
+	logger("scheduler").Info("Планировщик расписаний запущен", "instance", schedulerInstance)
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		schedulerTickOnce(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func schedulerTickOnce(now time.Time) {
+	reclaimed, err := reclaimExpiredRuns(now)
+	if err != nil {
+		logger("scheduler").Error("Ошибка восстановления прерванных запусков", "error", err)
+	}
+	claimed, err := claimDueRuns(now)
+	if err != nil {
+		logger("scheduler").Error("Ошибка выборки расписаний", "error", err)
+	}
+
+	runs := append(reclaimed, claimed...)
+	if len(runs) == 0 {
+		return
+	}
+	leases := keepLeases(runs)
+	defer leases.stop()
+	for _, run := range runs {
+		executeRun(run)
+		leases.release(run.runID)
+	}
+}
+
+// runLeases продлевает аренду всех запусков пачки с момента захвата:
+// запуски выполняются по очереди, и без этого аренда последних в длинной
+// пачке истекла бы раньше, чем до них дойдёт очередь, а другой экземпляр
+// выполнил бы их повторно.
+type runLeases struct {
+	mu      sync.Mutex
+	pending map[int]bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func keepLeases(runs []claimedRun) *runLeases {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &runLeases{pending: make(map[int]bool, len(runs)), cancel: cancel, done: make(chan struct{})}
+	for _, run := range runs {
+		l.pending[run.runID] = true
+	}
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(scheduleRunLease / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.extend()
+			}
+		}
+	}()
+	return l
+}
+
+func (l *runLeases) extend() {
+	l.mu.Lock()
+	ids := make([]int64, 0, len(l.pending))
+	for id := range l.pending {
+		ids = append(ids, int64(id))
+	}
+	l.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	rows, err := psqlConn.Query(`UPDATE schedule_runs SET lease_until = $1
+		WHERE id = ANY($2) AND status = 'running' AND lease_owner = $3
+		RETURNING id`,
+		time.Now().Add(scheduleRunLease), pq.Array(ids), schedulerInstance)
+	if err != nil {
+		logger("scheduler").Error("Ошибка продления аренды запусков", "error", err)
+		return
+	}
+	defer rows.Close()
+	extended := make(map[int]bool, len(ids))
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			extended[id] = true
+		}
+	}
+	for _, id := range ids {
+		if !extended[int(id)] {
+			logger("scheduler").Warn("Аренда запуска потеряна", "run_id", id)
+		}
+	}
+}
+
+// release прекращает продление аренды завершённого запуска.
+func (l *runLeases) release(runID int) {
+	l.mu.Lock()
+	delete(l.pending, runID)
+	l.mu.Unlock()
+}
+
+func (l *runLeases) stop() {
+	l.cancel()
+	<-l.done
+}
+
+// executeRun выполняет арендованный запуск и записывает результат. Аренду
+// продлевает runLeases.
+func executeRun(run claimedRun) {
+	status, errText := "done", ""
+	if err := executeSchedule(run.schedule); err != nil {
+		status, errText = "failed", err.Error()
+		logger("scheduler").Error("Запуск расписания завершился ошибкой", "run_id", run.runID, "schedule_id", run.schedule.ID, "error", err)
+	} else {
+		logger("scheduler").Info("Выполнено расписание", "run_id", run.runID, "schedule_id", run.schedule.ID, "schedule", run.schedule.Name, "at", run.at)
+	}
+
+	if err := finishRun(run.runID, status, errText); err != nil {
+		logger("scheduler").Error("Ошибка сохранения статуса запуска", "run_id", run.runID, "error", err)
+	}
+}
+
+// finishRun записывает результат запуска. Запуск, аренду которого забрал
+// другой экземпляр, не перезаписывается.
+func finishRun(runID int, status, errText string) error {
+	res, err := psqlConn.Exec(`UPDATE schedule_runs SET status = $1, error = NULLIF($2, ''), finished_at = NOW(),
+			lease_until = NULL
+		WHERE id = $3 AND status = 'running' AND lease_owner = $4`,
+		status, errText, runID, schedulerInstance)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		logger("scheduler").Warn("Запуск уже завершён или передан другому экземпляру", "run_id", runID)
+	}
+	return nil
+}
+
+// reclaimExpiredRuns забирает запуски, аренда которых истекла: процесс,
+// выполнявший их, упал. После scheduleRunAttempts попыток запуск
+// помечается 'interrupted'.
+func reclaimExpiredRuns(now time.Time) ([]claimedRun, error) {
+	rows, err := psqlConn.Query(`WITH expired AS (
+			SELECT id FROM schedule_runs
+			WHERE status = 'running' AND lease_until < $1
+			ORDER BY id LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE schedule_runs r SET
+			attempts = r.attempts + 1,
+			lease_owner = $2,
+			lease_until = $3,
+			status = CASE WHEN r.attempts >= $4 THEN 'interrupted' ELSE 'running' END,
+			finished_at = CASE WHEN r.attempts >= $4 THEN NOW() END,
+			error = CASE WHEN r.attempts >= $4 THEN 'аренда истекла, попытки исчерпаны' END
+		FROM expired WHERE r.id = expired.id
+		RETURNING r.id, r.schedule_id, r.scheduled_for, r.status, r.attempts`,
+		now, schedulerInstance, now.Add(scheduleRunLease), scheduleRunAttempts)
+	if err != nil {
+		return nil, err
+	}
+	type expiredRun struct {
+		id, scheduleID, attempts int
+		at                       time.Time
+		status                   string
+	}
+	var expired []expiredRun
+	for rows.Next() {
+		var e expiredRun
+		if err := rows.Scan(&e.id, &e.scheduleID, &e.at, &e.status, &e.attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var reclaimed []claimedRun
+	for _, e := range expired {
+		if e.status != "running" {
+			logger("scheduler").Error("Запуск прерван: попытки исчерпаны", "run_id", e.id, "schedule_id", e.scheduleID)
+			continue
+		}
+		s, err := scanSchedule(psqlConn.QueryRow("SELECT "+scheduleColumns+" FROM schedules WHERE id = $1", e.scheduleID))
+		status, errText := "", ""
+		switch {
+		case err != nil:
+			status, errText = "failed", err.Error()
+		case !s.Enabled:
+			status, errText = "skipped", "расписание выключено"
+		}
+		if status != "" {
+			if err := finishRun(e.id, status, errText); err != nil {
+				logger("scheduler").Error("Ошибка сохранения статуса запуска", "run_id", e.id, "error", err)
+			}
+			continue
+		}
+		logger("scheduler").Warn("Повтор прерванного запуска", "run_id", e.id, "schedule_id", e.scheduleID, "attempt", e.attempts)
+		reclaimed = append(reclaimed, claimedRun{runID: e.id, schedule: s, at: e.at})
+	}
+	return reclaimed, nil
+}
+
+// claimDueRuns в одной транзакции резервирует запуски всех созревших
+// расписаний и переносит их next_run_at в будущее.
+func claimDueRuns(now time.Time) ([]claimedRun, error) {
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT `+scheduleColumns+` FROM schedules
+		WHERE enabled AND next_run_at IS NOT NULL AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT 100
+		FOR UPDATE SKIP LOCKED`, now)
+	if err != nil {
+		return nil, err
+	}
+	var due []Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []claimedRun
+	for _, s := range due {
+		bl, err := loadBuildingLocation(tx, s.BuildingID)
+		if err != nil {
+			logger("scheduler").Warn("Пропущено расписание", "schedule_id", s.ID, "error", err)
+			continue
+		}
+
+		// Все срабатывания, которые должны были произойти к текущему моменту.
+		occurrences := []time.Time{*s.NextRunAt}
+		for len(occurrences) < maxUpcoming {
+			next, ok := s.nextAfter(occurrences[len(occurrences)-1], bl)
+			if !ok || next.After(now) {
+				break
+			}
+			occurrences = append(occurrences, next)
+		}
+
+		for i, at := range occurrences {
+			late := now.Sub(at) > schedulerGrace
+			last := i == len(occurrences)-1
+			// skip: выполняется только срабатывание в пределах допуска.
+			// catch_up: пропущенные срабатывания сворачиваются в один запуск
+			// (последний), чтобы не дёргать устройство много раз подряд.
+			run := !late || (s.MissedPolicy == missedCatchUp && last)
+			status := "running"
+			if !run {
+				status = "skipped"
+			}
+
+			var runID int
+			err := tx.QueryRow(`INSERT INTO schedule_runs (schedule_id, scheduled_for, status, finished_at, lease_owner, lease_until)
+				VALUES ($1, $2, $3::varchar, CASE WHEN $3 = 'skipped' THEN NOW() END,
+					CASE WHEN $3 = 'running' THEN $4 END, CASE WHEN $3 = 'running' THEN $5::timestamptz END)
+				ON CONFLICT (schedule_id, scheduled_for) DO NOTHING
+				RETURNING id`, s.ID, at, status, schedulerInstance, now.Add(scheduleRunLease)).Scan(&runID)
+			if err == sql.ErrNoRows {
+				continue // уже выполнено другой репликой
+			}
+			if err != nil {
+				return nil, err
+			}
+			if run {
+				claimed = append(claimed, claimedRun{runID: runID, schedule: s, at: at})
+			}
+		}
+
+		next, ok := s.nextAfter(now, bl)
+		var nextRun interface{}
+		if ok {
+			nextRun = next
+		}
+		if _, err := tx.Exec(
+			"UPDATE schedules SET next_run_at = $1, last_run_at = $2, enabled = enabled AND $3 WHERE id = $4",
+			nextRun, occurrences[len(occurrences)-1], ok, s.ID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func executeSchedule(s Schedule) error {
+	source := fmt.Sprintf("schedule:%d", s.ID)
+	if s.SceneID != nil {
+		return runScene(context.Background(), *s.SceneID, source)
+	}
+
+	cmd := DeviceCommand{DeviceID: *s.DeviceID, Command: s.Command}
+	if s.Value != "" {
+		cmd.Value = s.Value
+	}
+	return publishDeviceCommand(context.Background(), cmd, source)
+}
+
+// ============ ХРАНЕНИЕ ============
+
+const scheduleColumns = `id, building_id, name, kind, COALESCE(expression, ''), COALESCE(offset_minutes, 0),
+	device_id, COALESCE(command, ''), COALESCE(value, ''), scene_id, missed_policy, enabled, next_run_at, last_run_at`
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (Schedule, error) {
+	var s Schedule
+	var deviceID, sceneID sql.NullInt64
+	var nextRun, lastRun sql.NullTime
+	err := row.Scan(&s.ID, &s.BuildingID, &s.Name, &s.Kind, &s.Expression, &s.OffsetMinutes,
+		&deviceID, &s.Command, &s.Value, &sceneID, &s.MissedPolicy, &s.Enabled, &nextRun, &lastRun)
+	if err != nil {
+		return s, err
+	}
+	if deviceID.Valid {
+		id := int(deviceID.Int64)
+		s.DeviceID = &id
+	}
+	if sceneID.Valid {
+		id := int(sceneID.Int64)
+		s.SceneID = &id
+	}
+	if nextRun.Valid {
+		s.NextRunAt = &nextRun.Time
+	}
+	if lastRun.Valid {
+		s.LastRunAt = &lastRun.Time
+	}
+	return s, nil
+}
+
+// listSchedules — расписания здания (или всех зданий, если buildingID
+// пуст), видимые пользователю.
+func listSchedules(user buildingUser, buildingID string) ([]Schedule, error) {
+	var allowed map[int]bool
+	if !user.admin {
+		var err error
+		if allowed, err = user.buildings(); err != nil {
+			return nil, err
+		}
+	}
+
+	query := "SELECT " + scheduleColumns + " FROM schedules"
+	args := []interface{}{}
+	if buildingID != "" {
+		query += " WHERE building_id = $1"
+		args = append(args, buildingID)
+	}
+	query += " ORDER BY id"
+
+	rows, err := psqlConn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil || (allowed != nil && !allowed[s.BuildingID]) {
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// ============ REST API HANDLERS - SCHEDULES ============
+
+// Все обработчики расписаний требуют входа; пользователь видит и меняет
+// только расписания своих зданий (см. buildingUser).
+
+// requireSchedule загружает расписание и проверяет доступ к его зданию.
+func requireSchedule(w http.ResponseWriter, user buildingUser, id string) (Schedule, bool) {
+	s, err := scanSchedule(psqlConn.QueryRow("SELECT "+scheduleColumns+" FROM schedules WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Расписание не найдено", http.StatusNotFound)
+		return s, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return s, false
+	}
+	return s, user.requireBuilding(w, s.BuildingID)
+}
+
+func getSchedules(w http.ResponseWriter, r *http.Request) {
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	schedules, err := listSchedules(user, r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func createSchedule(w http.ResponseWriter, r *http.Request) {
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var s Schedule
+	s.Enabled = true
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !user.requireBuilding(w, s.BuildingID) {
+		return
+	}
+	if s.DeviceID != nil && !user.requireDevice(w, *s.DeviceID) {
+		return
+	}
+	if s.SceneID != nil && !user.requireScene(w, *s.SceneID) {
+		return
+	}
+
+	bl, err := loadBuildingLocation(psqlConn, s.BuildingID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Здание %d: %v", s.BuildingID, err), http.StatusBadRequest)
+		return
+	}
+	next, ok := s.nextAfter(time.Now(), bl)
+	if !ok {
+		http.Error(w, "Расписание никогда не сработает (проверьте время и координаты здания)", http.StatusBadRequest)
+		return
+	}
+	s.NextRunAt = &next
+
+	err = psqlConn.QueryRow(`INSERT INTO schedules
+		(building_id, name, kind, expression, offset_minutes, device_id, command, value, scene_id, missed_policy, enabled, next_run_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, $12)
+		RETURNING id`,
+		s.BuildingID, s.Name, s.Kind, s.Expression, s.OffsetMinutes, s.DeviceID, s.Command, s.Value,
+		s.SceneID, s.MissedPolicy, s.Enabled, next,
+	).Scan(&s.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// updateSchedule включает/выключает расписание. При включении next_run_at
+// пересчитывается от текущего момента, чтобы не догонять время простоя.
+func updateSchedule(w http.ResponseWriter, r *http.Request) {
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := requireSchedule(w, user, id)
+	if !ok {
+		return
+	}
+
+	var nextRun interface{}
+	if req.Enabled {
+		bl, err := loadBuildingLocation(psqlConn, s.BuildingID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next, ok := s.nextAfter(time.Now(), bl)
+		if !ok {
+			http.Error(w, "Расписание никогда не сработает", http.StatusBadRequest)
+			return
+		}
+		nextRun = next
+	}
+
+	if _, err := psqlConn.Exec("UPDATE schedules SET enabled = $1, next_run_at = $2 WHERE id = $3", req.Enabled, nextRun, id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+	if _, ok := requireSchedule(w, user, id); !ok {
+		return
+	}
+
+	if _, err := psqlConn.Exec("DELETE FROM schedules WHERE id = $1", id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUpcomingRuns — ближайшие срабатывания включённых расписаний
+// (?building_id=, ?limit= до 100, ?hours= горизонт, по умолчанию 7 дней).
+func getUpcomingRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= maxUpcoming {
+		limit = v
+	}
+	horizon := 7 * 24 * time.Hour
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 {
+		horizon = time.Duration(v) * time.Hour
+	}
+
+	schedules, err := listSchedules(user, r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	until := now.Add(horizon)
+	locations := map[int]buildingLocation{}
+	upcoming := []UpcomingRun{}
+
+	for _, s := range schedules {
+		if !s.Enabled || s.NextRunAt == nil {
+			continue
+		}
+		bl, ok := locations[s.BuildingID]
+		if !ok {
+			if bl, err = loadBuildingLocation(psqlConn, s.BuildingID); err != nil {
+				continue
+			}
+			locations[s.BuildingID] = bl
+		}
+
+		at := *s.NextRunAt
+		for n := 0; n < limit && !at.After(until); n++ {
+			upcoming = append(upcoming, UpcomingRun{ScheduleID: s.ID, Name: s.Name, BuildingID: s.BuildingID, RunAt: at.In(bl.Loc)})
+			next, ok := s.nextAfter(at, bl)
+			if !ok {
+				break
+			}
+			at = next
+		}
+	}
+
+	sort.Slice(upcoming, func(i, j int) bool { return upcoming[i].RunAt.Before(upcoming[j].RunAt) })
+	if len(upcoming) > limit {
+		upcoming = upcoming[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upcoming)
+}
+
+func getScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := buildingUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	scheduleID := r.URL.Query().Get("schedule_id")
+	if scheduleID == "" {
+		http.Error(w, "schedule_id parameter required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := requireSchedule(w, user, scheduleID); !ok {
+		return
+	}
+
+	rows, err := psqlConn.Query(`SELECT id, schedule_id, scheduled_for, status, attempts, COALESCE(error, ''), started_at, finished_at
+		FROM schedule_runs WHERE schedule_id = $1 ORDER BY scheduled_for DESC LIMIT 100`, scheduleID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var run ScheduleRun
+		var finished sql.NullTime
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Status, &run.Attempts, &run.Error, &run.StartedAt, &finished); err != nil {
+			continue
+		}
+		if finished.Valid {
+			run.FinishedAt = &finished.Time
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// ============ РАСПИСАНИЯ ============
+
+// sunTolerance — допуск сверки с астрономическими таблицами: алгоритм NOAA
+// и рефракция у разных источников расходятся на пару минут.
+const sunTolerance = 3 * time.Minute
+
+func near(got, want time.Time) bool {
+	d := got.Sub(want)
+	return d > -sunTolerance && d < sunTolerance
+}
+
+func TestSunTimes(t *testing.T) {
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	for name, c := range map[string]struct {
+		day       time.Time
+		lat, lon  float64
+		rise, set time.Time
+		wantPolar bool
+	}{
+		"Москва, летнее солнцестояние":  {day: utc(6, 21, 9, 0), lat: 55.7558, lon: 37.6173, rise: utc(6, 21, 0, 44), set: utc(6, 21, 18, 18)},
+		"Москва, зимнее солнцестояние":  {day: utc(12, 21, 9, 0), lat: 55.7558, lon: 37.6173, rise: utc(12, 21, 5, 58), set: utc(12, 21, 12, 58)},
+		"Нью-Йорк, день перевода часов": {day: utc(3, 10, 17, 0), lat: 40.7128, lon: -74.0060, rise: utc(3, 10, 11, 15), set: utc(3, 10, 22, 57)},
+		"экватор, равноденствие":        {day: utc(3, 20, 12, 0), lat: 0, lon: 0, rise: utc(3, 20, 6, 4), set: utc(3, 20, 18, 11)},
+		// Восход в Сиднее по UTC приходится на предыдущие сутки.
+		"Сидней, зима":            {day: utc(6, 21, 2, 0), lat: -33.8688, lon: 151.2093, rise: utc(6, 20, 21, 0), set: utc(6, 21, 6, 54)},
+		"Мурманск, полярный день": {day: utc(6, 21, 9, 0), lat: 68.97, lon: 33.07, wantPolar: true},
+		"Мурманск, полярная ночь": {day: utc(12, 21, 9, 0), lat: 68.97, lon: 33.07, wantPolar: true},
+	} {
+		rise, set, ok := sunTimes(c.day, c.lat, c.lon)
+		if c.wantPolar {
+			if ok {
+				t.Errorf("%s: восход %s, закат %s; ожидалось отсутствие событий", name, rise, set)
+			}
+			continue
+		}
+		if !ok || !near(rise, c.rise) || !near(set, c.set) {
+			t.Errorf("%s: восход %s, закат %s (%v); ожидалось %s, %s", name, rise, set, ok, c.rise, c.set)
+		}
+	}
+}
+
+func TestNextAfter(t *testing.T) {
+	mustLoad := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc
+	}
+	msk, berlin := mustLoad("Europe/Moscow"), mustLoad("Europe/Berlin")
+	coords := func(loc *time.Location, lat, lon float64) buildingLocation {
+		return buildingLocation{Loc: loc, Latitude: sql.NullFloat64{Float64: lat, Valid: true}, Longitude: sql.NullFloat64{Float64: lon, Valid: true}}
+	}
+	moscow := coords(msk, 55.7558, 37.6173)
+	murmansk := coords(msk, 68.97, 33.07)
+
+	for name, c := range map[string]struct {
+		s      Schedule
+		bl     buildingLocation
+		after  time.Time
+		want   time.Time
+		wantOK bool
+	}{
+		"cron в часовом поясе здания": {
+			Schedule{Kind: scheduleKindCron, Expression: "0 7 * * *"}, moscow,
+			time.Date(2024, 5, 15, 8, 0, 0, 0, msk), time.Date(2024, 5, 16, 7, 0, 0, 0, msk), true,
+		},
+		"cron через переход на летнее время": {
+			Schedule{Kind: scheduleKindCron, Expression: "0 8 * * *"}, buildingLocation{Loc: berlin},
+			time.Date(2024, 3, 30, 9, 0, 0, 0, berlin), time.Date(2024, 3, 31, 6, 0, 0, 0, time.UTC), true,
+		},
+		"cron с ошибкой": {
+			Schedule{Kind: scheduleKindCron, Expression: "* *"}, moscow,
+			time.Date(2024, 5, 15, 8, 0, 0, 0, msk), time.Time{}, false,
+		},
+		"время суток сегодня": {
+			Schedule{Kind: scheduleKindAt, Expression: "08:00"}, buildingLocation{Loc: berlin},
+			time.Date(2024, 3, 30, 7, 0, 0, 0, berlin), time.Date(2024, 3, 30, 7, 0, 0, 0, time.UTC), true,
+		},
+		"время суток завтра после перевода часов": {
+			Schedule{Kind: scheduleKindAt, Expression: "08:00"}, buildingLocation{Loc: berlin},
+			time.Date(2024, 3, 30, 9, 0, 0, 0, berlin), time.Date(2024, 3, 31, 6, 0, 0, 0, time.UTC), true,
+		},
+		"время суток после перехода на зимнее время": {
+			Schedule{Kind: scheduleKindAt, Expression: "08:00"}, buildingLocation{Loc: berlin},
+			time.Date(2024, 10, 26, 9, 0, 0, 0, berlin), time.Date(2024, 10, 27, 7, 0, 0, 0, time.UTC), true,
+		},
+		"разовый запуск в будущем": {
+			Schedule{Kind: scheduleKindAt, Expression: "2024-06-01T10:00:00+03:00"}, moscow,
+			time.Date(2024, 5, 15, 8, 0, 0, 0, msk), time.Date(2024, 6, 1, 7, 0, 0, 0, time.UTC), true,
+		},
+		"разовый запуск в прошлом": {
+			Schedule{Kind: scheduleKindAt, Expression: "2024-05-01T10:00:00+03:00"}, moscow,
+			time.Date(2024, 5, 15, 8, 0, 0, 0, msk), time.Time{}, false,
+		},
+		"восход": {
+			Schedule{Kind: scheduleKindSunrise}, moscow,
+			time.Date(2024, 6, 20, 12, 0, 0, 0, msk), time.Date(2024, 6, 21, 0, 44, 0, 0, time.UTC), true,
+		},
+		"закат за 30 минут": {
+			Schedule{Kind: scheduleKindSunset, OffsetMinutes: -30}, moscow,
+			time.Date(2024, 6, 21, 12, 0, 0, 0, msk), time.Date(2024, 6, 21, 17, 48, 0, 0, time.UTC), true,
+		},
+		// Восход 22 июня в 00:44 UTC минус 6 часов — ещё 21 июня.
+		"восход с большим отрицательным смещением": {
+			Schedule{Kind: scheduleKindSunrise, OffsetMinutes: -360}, moscow,
+			time.Date(2024, 6, 21, 12, 0, 0, 0, msk), time.Date(2024, 6, 21, 18, 44, 0, 0, time.UTC), true,
+		},
+		"восход без координат": {
+			Schedule{Kind: scheduleKindSunrise}, buildingLocation{Loc: msk},
+			time.Date(2024, 6, 21, 12, 0, 0, 0, msk), time.Time{}, false,
+		},
+	} {
+		got, ok := c.s.nextAfter(c.after, c.bl)
+		if ok != c.wantOK || (ok && !near(got, c.want)) {
+			t.Errorf("%s: %s (%v), ожидалось %s (%v)", name, got, ok, c.want, c.wantOK)
+		}
+		if ok && got.Location() != c.bl.Loc {
+			t.Errorf("%s: время в поясе %s, ожидалось %s", name, got.Location(), c.bl.Loc)
+		}
+	}
+
+	// Полярный день и полярная ночь: восхода нет неделями, ближайший —
+	// после их окончания.
+	for name, c := range map[string]struct {
+		after    time.Time
+		from, to time.Time
+	}{
+		"полярный день": {time.Date(2024, 6, 1, 12, 0, 0, 0, msk), time.Date(2024, 7, 15, 0, 0, 0, 0, msk), time.Date(2024, 7, 31, 0, 0, 0, 0, msk)},
+		"полярная ночь": {time.Date(2024, 12, 21, 12, 0, 0, 0, msk), time.Date(2025, 1, 5, 0, 0, 0, 0, msk), time.Date(2025, 1, 20, 0, 0, 0, 0, msk)},
+	} {
+		got, ok := (&Schedule{Kind: scheduleKindSunrise}).nextAfter(c.after, murmansk)
+		if !ok || got.Before(c.from) || got.After(c.to) {
+			t.Errorf("%s: восход %s (%v), ожидался между %s и %s", name, got, ok, c.from, c.to)
+		}
+	}
+
+	// 02:30 в ночь перехода на летнее время не существует: запуск всё равно
+	// происходит один раз в эти сутки, а следующий — в обычные 02:30.
+	daily := &Schedule{Kind: scheduleKindAt, Expression: "02:30"}
+	berlinOnly := buildingLocation{Loc: berlin}
+	first, ok := daily.nextAfter(time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), berlinOnly)
+	if !ok || first.Day() != 31 {
+		t.Fatalf("запуск в день перевода часов: %s (%v)", first, ok)
+	}
+	second, ok := daily.nextAfter(first, berlinOnly)
+	if want := time.Date(2024, 4, 1, 2, 30, 0, 0, berlin); !ok || !second.Equal(want) {
+		t.Errorf("следующий запуск после %s: %s, ожидалось %s", first, second, want)
+	}
+}
@@ -0,0 +1,61 @@
+-- Demo data for local development: `smart-home seed`.
+-- Applied only to an empty database (no users).
+
+-- Insert test users
+INSERT INTO users (username, email, password, role, house_status, payment_type) VALUES 
+('admin', 'admin@test.com', 'admin123', 'admin', 'День', 'Максимум'),
+('user1', 'user1@test.com', 'password123', 'user', 'День', 'Базовый'),
+('worker1', 'worker@test.com', 'worker123', 'worker', 'День', 'Базовый');
+
+-- Insert test building
+INSERT INTO building (name, latitude, longitude, timezone) VALUES ('Квартира', 55.7558, 37.6173, 'Europe/Moscow');
+
+-- Insert test rooms
+INSERT INTO room (name, building_id) VALUES 
+('Гостиная', 1),
+('Спальня', 1),
+('Кухня', 1);
+
+-- Insert test devices
+INSERT INTO device (name, room_id, rated_power_w) VALUES 
+('Люстра', 1, 100),
+('Кондиционер', 1, 2000),
+('Светильник', 2, 40),
+('Плита', 3, 3500);
+
+-- Insert test controllers
+INSERT INTO controller (name, state, device_id) VALUES 
+('Контроллер 1', 'off', 1),
+('Контроллер 2', 'off', 2),
+('Контроллер 3', 'off', 3),
+('Контроллер 4', 'off', 4);
+
+-- Insert default energy tariff (day/night)
+INSERT INTO energy_tariffs (building_id, name, valid_from, day_rate, night_rate, night_start, night_end) VALUES 
+(NULL, 'Двухтарифный', '2025-01-01', 7.53, 3.04, '23:00', '07:00');
+
+-- Insert test thermostat (air conditioner in the living room)
+INSERT INTO thermostats (building_id, room_id, name, sensor_id, device_id, mode, setpoint, schedule) VALUES 
+(1, 1, 'Кондиционер гостиной', 'temperature/room1', 2, 'cool', 25,
+ '[{"days":[1,2,3,4,5],"at":"08:00","setpoint":26},{"days":[1,2,3,4,5],"at":"18:00","setpoint":24},{"days":[6,7],"at":"09:00","setpoint":24}]');
+
+-- Insert test workers
+INSERT INTO workers (full_name, "position", phone, email, hired_at, user_id) VALUES 
+('Иван Петров', 'Инженер', '+7-999-123-45-67', 'ivan@company.com', '2023-01-15', 3),
+('Мария Сидорова', 'Техник', '+7-999-234-56-78', 'maria@company.com', '2023-02-20', NULL);
+
+-- Insert worker-buildings relationships
+INSERT INTO worker_buildings (worker_id, building_id) VALUES 
+(1, 1),
+(2, 1);
+
+-- Insert user-devices relationships
+INSERT INTO user_devices (user_id, device_id, payment_type) VALUES 
+(1, 1, 'Максимум'),
+(1, 2, 'Максимум'),
+(1, 3, 'Максимум'),
+(1, 4, 'Максимум'),
+(2, 1, 'Базовый'),
+(2, 3, 'Базовый'),
+(3, 2, 'Базовый'),
+(3, 4, 'Базовый');
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"gopkg.in/yaml.v3"
+)
+
+// ============ СИМУЛЯТОР УСТРОЙСТВ ============
+//
+// Команда simulate публикует в MQTT показания датчиков и отвечает на команды
+// исполнительных устройств — для dev-окружений без реального оборудования.
+// Дерево устройств берётся из файла сценария (YAML, см. scenarios/demo.yaml)
+// или, если файл не указан, из PostgreSQL: датчики — устройства с
+// device.sensor_key, исполнительные устройства — устройства с контроллером.
+//
+// Модели показаний:
+//   - temperature: суточная кривая base ± amplitude (максимум в 15:00);
+//   - humidity: base минус amplitude %/°C отклонения температуры той же
+//     комнаты (temperature/<комната>) — влажность падает, когда теплеет;
+//   - motion: серии срабатываний, rate серий в час (ночью реже);
+//   - power: base ± amplitude по суточной кривой, Вт;
+//   - energy: счётчик кВт·ч нарастающим итогом при средней мощности base;
+//   - прочие: base ± amplitude по суточной кривой.
+// Исполнительное устройство отвечает на controllers/command/<id> в
+// controllers/status/<id> состоянием, мощностью и показанием счётчика и
+// повторяет статус каждый интервал.
+//
+// Неисправности (faults, для всего сценария или отдельного устройства):
+// dropout — вероятность за интервал замолчать на dropout_for; spike —
+// вероятность выброса на ±spike_size; clock_skew — сдвиг поля timestamp
+// (у каждого устройства свой, в пределах ±clock_skew); malformed —
+// вероятность отправить обрезанный JSON.
+
+const (
+	simDefaultInterval = 5 * time.Second
+	simDefaultBroker   = "tcp://localhost:1883"
+	simPublishTimeout  = 5 * time.Second
+)
+
+type simFaults struct {
+	Dropout    float64       `yaml:"dropout"`
+	DropoutFor time.Duration `yaml:"dropout_for"`
+	Spike      float64       `yaml:"spike"`
+	SpikeSize  float64       `yaml:"spike_size"`
+	ClockSkew  time.Duration `yaml:"clock_skew"`
+	Malformed  float64       `yaml:"malformed"`
+}
+
+type simSensor struct {
+	// ID — sensor_id, топик sensors/<id>; тип — первый сегмент.
+	ID        string     `yaml:"id"`
+	Base      *float64   `yaml:"base"`
+	Amplitude *float64   `yaml:"amplitude"`
+	Noise     *float64   `yaml:"noise"`
+	Rate      float64    `yaml:"rate"`
+	Faults    *simFaults `yaml:"faults"`
+}
+
+type simActuator struct {
+	DeviceID int        `yaml:"device_id"`
+	PowerW   float64    `yaml:"power_w"`
+	State    string     `yaml:"state"`
+	Faults   *simFaults `yaml:"faults"`
+}
+
+type simScenario struct {
+	Broker    string        `yaml:"broker"`
+	Interval  time.Duration `yaml:"interval"`
+	Seed      int64         `yaml:"seed"`
+	Faults    simFaults     `yaml:"faults"`
+	Sensors   []simSensor   `yaml:"sensors"`
+	Actuators []simActuator `yaml:"actuators"`
+}
+
+// simModel — параметры модели по умолчанию для типа датчика.
+type simModel struct {
+	base, amplitude, noise, spikeSize float64
+}
+
+var simModels = map[string]simModel{
+	"temperature": {base: 21, amplitude: 2, noise: 0.2, spikeSize: 15},
+	"humidity":    {base: 45, amplitude: 3, noise: 1, spikeSize: 30},
+	"motion":      {},
+	"power":       {base: 150, amplitude: 100, noise: 10, spikeSize: 2000},
+	"energy":      {base: 300},
+}
+
+var simDefaultModel = simModel{base: 50, amplitude: 10, noise: 1, spikeSize: 50}
+
+// loadScenario читает сценарий из YAML-файла.
+func loadScenario(path string) (simScenario, error) {
+	var sc simScenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return sc, fmt.Errorf("сценарий %s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// scenarioFromDB собирает сценарий из таблиц device и controller.
+func scenarioFromDB() (simScenario, error) {
+	var sc simScenario
+	rows, err := psqlConn.Query(`SELECT d.id, COALESCE(d.sensor_key, ''), COALESCE(d.rated_power_w, 0),
+			EXISTS (SELECT 1 FROM controller c WHERE c.device_id = d.id),
+			COALESCE((SELECT c.state FROM controller c WHERE c.device_id = d.id ORDER BY c.id LIMIT 1), '')
+		FROM device d ORDER BY d.id`)
+	if err != nil {
+		return sc, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id            int
+			key, state    string
+			ratedW        float64
+			hasController bool
+		)
+		if err := rows.Scan(&id, &key, &ratedW, &hasController, &state); err != nil {
+			return sc, err
+		}
+		if key != "" {
+			sc.Sensors = append(sc.Sensors, simSensor{ID: key})
+		}
+		if hasController {
+			sc.Actuators = append(sc.Actuators, simActuator{DeviceID: id, PowerW: ratedW, State: state})
+		}
+	}
+	return sc, rows.Err()
+}
+
+func (sc simScenario) validate() error {
+	if len(sc.Sensors) == 0 && len(sc.Actuators) == 0 {
+		return fmt.Errorf("в сценарии нет ни датчиков, ни исполнительных устройств")
+	}
+	seen := map[string]bool{}
+	for _, s := range sc.Sensors {
+		if s.ID == "" || strings.HasPrefix(s.ID, "/") || strings.ContainsAny(s.ID, "+#") {
+			return fmt.Errorf("неверный id датчика %q", s.ID)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("датчик %q указан дважды", s.ID)
+		}
+		seen[s.ID] = true
+	}
+	actuators := map[int]bool{}
+	for _, a := range sc.Actuators {
+		if a.DeviceID <= 0 {
+			return fmt.Errorf("device_id исполнительного устройства должен быть положительным")
+		}
+		if actuators[a.DeviceID] {
+			return fmt.Errorf("исполнительное устройство %d указано дважды", a.DeviceID)
+		}
+		actuators[a.DeviceID] = true
+	}
+	return nil
+}
+
+// ============ СОСТОЯНИЕ СИМУЛЯЦИИ ============
+
+// simSource — общее для датчиков и исполнительных устройств: неисправности.
+type simSource struct {
+	faults      simFaults
+	skew        time.Duration
+	silentUntil time.Time
+}
+
+type simSensorState struct {
+	simSource
+	id, kind                          string
+	base, amplitude, noise, spikeSize float64
+	rate                              float64
+	last                              float64
+	burstLeft                         int
+	paired                            *simSensorState
+}
+
+type simActuatorState struct {
+	simSource
+	deviceID int
+	powerW   float64
+	state    string
+	value    interface{}
+	meter    float64
+	updated  time.Time
+}
+
+type simulator struct {
+	interval time.Duration
+	client   mqtt.Client
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	sensors   []*simSensorState
+	actuators map[int]*simActuatorState
+}
+
+func newSimulator(sc simScenario) *simulator {
+	seed := sc.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sim := &simulator{
+		interval:  sc.Interval,
+		rng:       rand.New(rand.NewSource(seed)),
+		actuators: map[int]*simActuatorState{},
+	}
+	if sim.interval <= 0 {
+		sim.interval = simDefaultInterval
+	}
+
+	byID := map[string]*simSensorState{}
+	for _, s := range sc.Sensors {
+		kind := s.ID
+		if i := strings.Index(kind, "/"); i >= 0 {
+			kind = kind[:i]
+		}
+		model, ok := simModels[kind]
+		if !ok {
+			model = simDefaultModel
+		}
+		st := &simSensorState{id: s.ID, kind: kind, rate: s.Rate,
+			base: model.base, amplitude: model.amplitude, noise: model.noise, spikeSize: model.spikeSize}
+		if s.Base != nil {
+			st.base = *s.Base
+		}
+		if s.Amplitude != nil {
+			st.amplitude = *s.Amplitude
+		}
+		if s.Noise != nil {
+			st.noise = *s.Noise
+		}
+		if kind == "motion" && st.rate <= 0 {
+			st.rate = 6
+		}
+		st.simSource = sim.source(sc.Faults, s.Faults)
+		if st.faults.SpikeSize > 0 {
+			st.spikeSize = st.faults.SpikeSize
+		}
+		byID[s.ID] = st
+		sim.sensors = append(sim.sensors, st)
+	}
+	for _, st := range sim.sensors {
+		if st.kind == "humidity" {
+			st.paired = byID["temperature"+strings.TrimPrefix(st.id, "humidity")]
+		}
+	}
+	// Влажность считается после температуры своей комнаты.
+	sort.SliceStable(sim.sensors, func(i, j int) bool {
+		return sim.sensors[i].kind != "humidity" && sim.sensors[j].kind == "humidity"
+	})
+
+	for _, a := range sc.Actuators {
+		state := a.State
+		if state == "" {
+			state = "off"
+		}
+		sim.actuators[a.DeviceID] = &simActuatorState{
+			simSource: sim.source(sc.Faults, a.Faults),
+			deviceID:  a.DeviceID, powerW: a.PowerW, state: state,
+		}
+	}
+	return sim
+}
+
+// source выбирает неисправности устройства (свои или общие) и сдвиг часов.
+func (sim *simulator) source(common simFaults, own *simFaults) simSource {
+	src := simSource{faults: common}
+	if own != nil {
+		src.faults = *own
+	}
+	if src.faults.DropoutFor <= 0 {
+		src.faults.DropoutFor = 3 * sim.interval
+	}
+	if skew := src.faults.ClockSkew; skew > 0 {
+		src.skew = time.Duration((sim.rng.Float64()*2 - 1) * float64(skew))
+	}
+	return src
+}
+
+// diurnal — суточная кривая от -1 (03:00) до 1 (15:00).
+func diurnal(now time.Time) float64 {
+	hours := float64(now.Hour()) + float64(now.Minute())/60
+	return math.Cos(2 * math.Pi * (hours - 15) / 24)
+}
+
+// silent решает, молчит ли источник в этом интервале (dropout).
+// Вызывается под sim.mu.
+func (sim *simulator) silent(src *simSource, name string, now time.Time) bool {
+	if now.Before(src.silentUntil) {
+		return true
+	}
+	if src.faults.Dropout > 0 && sim.rng.Float64() < src.faults.Dropout {
+		src.silentUntil = now.Add(src.faults.DropoutFor)
+		logger("simulate").Info("Обрыв связи", "source", name, "for", src.faults.DropoutFor)
+		return true
+	}
+	return false
+}
+
+// payload сериализует сообщение: timestamp со сдвигом часов источника,
+// с вероятностью malformed — обрезанный JSON. Вызывается под sim.mu.
+func (sim *simulator) payload(src *simSource, name string, fields map[string]interface{}, now time.Time) []byte {
+	fields["timestamp"] = now.Add(src.skew).Unix()
+	data, _ := json.Marshal(fields)
+	if src.faults.Malformed > 0 && sim.rng.Float64() < src.faults.Malformed {
+		logger("simulate").Info("Испорченный JSON", "source", name)
+		return data[:len(data)/2]
+	}
+	return data
+}
+
+// sensorValue вычисляет следующее значение датчика. ok == false — в этом
+// интервале датчик ничего не отправляет. Вызывается под sim.mu.
+func (sim *simulator) sensorValue(s *simSensorState, now time.Time) (value interface{}, ok bool) {
+	noise := sim.rng.NormFloat64() * s.noise
+	var v float64
+	switch s.kind {
+	case "motion":
+		if s.burstLeft > 0 {
+			s.burstLeft--
+			return true, true
+		}
+		activity := 1.0
+		if now.Hour() < 6 {
+			activity = 0.2
+		}
+		if sim.rng.Float64() < s.rate*activity*sim.interval.Hours() {
+			s.burstLeft = sim.rng.Intn(4)
+			return true, true
+		}
+		return false, true
+	case "humidity":
+		// Без датчика температуры в комнате — по типовой суточной кривой.
+		delta := diurnal(now) * simModels["temperature"].amplitude
+		if s.paired != nil {
+			delta = s.paired.last - s.paired.base
+		}
+		v = math.Min(100, math.Max(0, s.base-s.amplitude*delta+noise))
+	case "energy":
+		s.last += math.Max(0, s.base+noise) * sim.interval.Hours() / 1000
+		v = s.last
+	case "power":
+		v = math.Max(0, s.base+s.amplitude*diurnal(now)+noise)
+	default:
+		v = s.base + s.amplitude*diurnal(now) + noise
+	}
+	if s.kind != "energy" {
+		s.last = v
+	}
+	if s.faults.Spike > 0 && sim.rng.Float64() < s.faults.Spike {
+		sign := 1.0
+		if sim.rng.Intn(2) == 0 {
+			sign = -1
+		}
+		v += sign * s.spikeSize
+		logger("simulate").Info("Выброс", "sensor_id", s.id, "value", v)
+	}
+	return math.Round(v*100) / 100, true
+}
+
+// accrue добавляет к счётчику потребление с прошлого статуса.
+// Вызывается под sim.mu.
+func (a *simActuatorState) accrue(now time.Time) {
+	if on, _ := isOnState(a.state); on && !a.updated.IsZero() {
+		a.meter += a.powerW * now.Sub(a.updated).Hours() / 1000
+	}
+	a.updated = now
+}
+
+// status — статус исполнительного устройства для controllers/status/<id>.
+// Вызывается под sim.mu.
+func (a *simActuatorState) status(now time.Time) map[string]interface{} {
+	a.accrue(now)
+	power := 0.0
+	if on, _ := isOnState(a.state); on {
+		power = a.powerW
+	}
+	fields := map[string]interface{}{
+		"status": "online",
+		"state":  a.state,
+		"power":  power,
+		"energy": math.Round(a.meter*1000) / 1000,
+	}
+	if a.value != nil {
+		fields["value"] = a.value
+	}
+	return fields
+}
+
+type simMessage struct {
+	topic   string
+	payload []byte
+}
+
+// tick готовит сообщения всех устройств за один интервал.
+func (sim *simulator) tick(now time.Time) []simMessage {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	var out []simMessage
+	for _, s := range sim.sensors {
+		value, ok := sim.sensorValue(s, now)
+		if !ok || sim.silent(&s.simSource, s.id, now) {
+			continue
+		}
+		out = append(out, simMessage{"sensors/" + s.id,
+			sim.payload(&s.simSource, s.id, map[string]interface{}{"sensor_id": s.id, "value": value}, now)})
+	}
+	for _, id := range sim.actuatorIDs() {
+		a := sim.actuators[id]
+		name := fmt.Sprintf("устройство %d", id)
+		fields := a.status(now)
+		if sim.silent(&a.simSource, name, now) {
+			continue
+		}
+		out = append(out, simMessage{fmt.Sprintf("controllers/status/%d", id), sim.payload(&a.simSource, name, fields, now)})
+	}
+	return out
+}
+
+func (sim *simulator) actuatorIDs() []int {
+	ids := make([]int, 0, len(sim.actuators))
+	for id := range sim.actuators {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// command применяет команду к исполнительному устройству и возвращает ответ
+// для controllers/status/<id>; ok == false — ответа не будет.
+func (sim *simulator) command(deviceID int, data []byte, now time.Time) (simMessage, bool) {
+	var cmd DeviceCommand
+	if err := json.Unmarshal(data, &cmd); err != nil || cmd.Command == "" {
+		logger("simulate").Warn("Неверная команда", "device_id", deviceID, "payload", string(data))
+		return simMessage{}, false
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	a, ok := sim.actuators[deviceID]
+	if !ok {
+		return simMessage{}, false
+	}
+	name := fmt.Sprintf("устройство %d", deviceID)
+	if sim.silent(&a.simSource, name, now) {
+		return simMessage{}, false
+	}
+	// Потребление до команды считается по прежнему состоянию.
+	a.accrue(now)
+	if _, isSwitch := isOnState(cmd.Command); isSwitch {
+		a.state = cmd.Command
+	}
+	if cmd.Value != nil {
+		a.value = cmd.Value
+	}
+	fields := a.status(now)
+	logger("simulate").Info("Команда", "device_id", deviceID, "command", cmd.Command)
+	return simMessage{fmt.Sprintf("controllers/status/%d", deviceID), sim.payload(&a.simSource, name, fields, now)}, true
+}
+
+// ============ ЗАПУСК ============
+
+func (sim *simulator) publish(messages []simMessage) {
+	for _, m := range messages {
+		token := sim.client.Publish(m.topic, 1, false, m.payload)
+		if !token.WaitTimeout(simPublishTimeout) {
+			logger("simulate").Warn("Таймаут публикации", "topic", m.topic)
+		} else if err := token.Error(); err != nil {
+			logger("simulate").Error("Ошибка публикации", "topic", m.topic, "error", err)
+		}
+	}
+}
+
+// subscribe подписывает симулятор на команды его исполнительных устройств.
+// Вызывается при каждом подключении, как App.subscribe.
+func (sim *simulator) subscribe(client mqtt.Client) {
+	sim.mu.Lock()
+	ids := sim.actuatorIDs()
+	sim.mu.Unlock()
+	for _, id := range ids {
+		id := id
+		handler := func(_ mqtt.Client, msg mqtt.Message) {
+			if reply, ok := sim.command(id, msg.Payload(), time.Now()); ok {
+				go sim.publish([]simMessage{reply})
+			}
+		}
+		if token := client.Subscribe(commandTopic(id), 1, handler); token.Wait() && token.Error() != nil {
+			logger("simulate").Error("Ошибка подписки на команды", "device_id", id, "error", token.Error())
+		}
+	}
+}
+
+// simulate подключается к брокеру и публикует показания, пока не отменён ctx.
+func simulate(ctx context.Context, sc simScenario, broker string) error {
+	if err := sc.validate(); err != nil {
+		return err
+	}
+	sim := newSimulator(sc)
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(fmt.Sprintf("smart-home-simulator-%d", os.Getpid()))
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		logger("simulate").Info("Подключение к брокеру установлено", "broker", broker)
+		sim.subscribe(client)
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		logger("simulate").Warn("Потеряно подключение к брокеру", "error", err)
+	})
+	sim.client = mqtt.NewClient(opts)
+	if token := sim.client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("подключение к MQTT: %w", token.Error())
+	}
+	defer sim.client.Disconnect(250)
+
+	logger("simulate").Info("Симулятор запущен",
+		"sensors", len(sim.sensors), "actuators", len(sim.actuators), "interval", sim.interval)
+
+	ticker := time.NewTicker(sim.interval)
+	defer ticker.Stop()
+	sim.publish(sim.tick(time.Now()))
+	for {
+		select {
+		case <-ctx.Done():
+			// Исполнительные устройства уходят так же, как по Last Will.
+			var offline []simMessage
+			for _, id := range sim.actuatorIDs() {
+				offline = append(offline, simMessage{fmt.Sprintf("controllers/status/%d", id), []byte(`{"status":"offline"}`)})
+			}
+			sim.publish(offline)
+			logger("simulate").Info("Симулятор остановлен")
+			return nil
+		case now := <-ticker.C:
+			sim.publish(sim.tick(now))
+		}
+	}
+}
+
+// runSimulate — команда simulate: разбирает флаги и запускает симулятор
+// до Ctrl+C / SIGTERM (или на время -duration).
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	scenarioPath := fs.String("scenario", "", "YAML-файл сценария (по умолчанию — устройства из PostgreSQL)")
+	broker := fs.String("broker", "", "адрес MQTT-брокера (по умолчанию — из сценария или MQTT_BROKER)")
+	interval := fs.Duration("interval", 0, "интервал публикации")
+	seed := fs.Int64("seed", 0, "зерно генератора случайных чисел (0 — случайное)")
+	duration := fs.Duration("duration", 0, "время работы (0 — до остановки)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("simulate: лишние аргументы %v\n%s", fs.Args(), commandUsage)
+	}
+
+	var sc simScenario
+	var err error
+	if *scenarioPath != "" {
+		sc, err = loadScenario(*scenarioPath)
+	} else {
+		if cfg.Postgres.URL == "" {
+			return fmt.Errorf("simulate: укажите -scenario или DATABASE_URL")
+		}
+		initPostgres(cfg.Postgres.URL)
+		sc, err = scenarioFromDB()
+		psqlConn.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if *interval > 0 {
+		sc.Interval = *interval
+	}
+	if *seed != 0 {
+		sc.Seed = *seed
+	}
+	address := *broker
+	for _, candidate := range []string{sc.Broker, cfg.MQTT.Broker, simDefaultBroker} {
+		if address == "" {
+			address = candidate
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	return simulate(ctx, sc, address)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// ============ СИМУЛЯТОР УСТРОЙСТВ ============
+
+func float(v float64) *float64 { return &v }
+
+func TestSimulatedHumidityFollowsTemperature(t *testing.T) {
+	sim := newSimulator(simScenario{Seed: 1, Sensors: []simSensor{
+		{ID: "humidity/room1", Noise: float(0)},
+		{ID: "temperature/room1", Base: float(20), Amplitude: float(5), Noise: float(0)},
+	}})
+
+	values := func(now time.Time) (temperature, humidity float64) {
+		for _, m := range sim.tick(now) {
+			var fields struct {
+				Value float64 `json:"value"`
+			}
+			if err := json.Unmarshal(m.payload, &fields); err != nil {
+				t.Fatalf("%s: %v", m.topic, err)
+			}
+			switch m.topic {
+			case "sensors/temperature/room1":
+				temperature = fields.Value
+			case "sensors/humidity/room1":
+				humidity = fields.Value
+			}
+		}
+		return temperature, humidity
+	}
+
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.Local)
+	nightT, nightH := values(day.Add(3 * time.Hour))
+	noonT, noonH := values(day.Add(15 * time.Hour))
+	if nightT != 15 || noonT != 25 {
+		t.Errorf("температура ночью %v, днём %v; ожидалось 15 и 25", nightT, noonT)
+	}
+	if nightH != 60 || noonH != 30 {
+		t.Errorf("влажность ночью %v, днём %v; ожидалось 60 и 30", nightH, noonH)
+	}
+}
+
+func TestSimulatedFaults(t *testing.T) {
+	now := time.Now()
+	sim := newSimulator(simScenario{Seed: 1, Sensors: []simSensor{
+		{ID: "temperature/broken", Faults: &simFaults{Malformed: 1}},
+		{ID: "temperature/silent", Faults: &simFaults{Dropout: 1, DropoutFor: time.Minute}},
+		{ID: "temperature/skewed", Faults: &simFaults{ClockSkew: time.Hour}},
+	}})
+
+	messages := sim.tick(now)
+	if len(messages) != 2 {
+		t.Fatalf("отправлено %d сообщений, ожидалось 2 (без замолчавшего датчика)", len(messages))
+	}
+	for _, m := range messages {
+		var fields map[string]interface{}
+		err := json.Unmarshal(m.payload, &fields)
+		switch m.topic {
+		case "sensors/temperature/broken":
+			if err == nil {
+				t.Errorf("ожидался испорченный JSON, получено %s", m.payload)
+			}
+		case "sensors/temperature/skewed":
+			if err != nil {
+				t.Fatalf("%s: %v", m.topic, err)
+			}
+			skew := time.Duration(fields["timestamp"].(float64)-float64(now.Unix())) * time.Second
+			if skew == 0 || skew < -time.Hour || skew > time.Hour {
+				t.Errorf("сдвиг часов %s, ожидалось в пределах ±1ч", skew)
+			}
+		}
+	}
+	// Замолчавший датчик молчит весь dropout_for.
+	if n := len(sim.tick(now.Add(30 * time.Second))); n != 2 {
+		t.Errorf("через 30 с отправлено %d сообщений, ожидалось 2", n)
+	}
+}
+
+func TestSimulatorAgainstBackend(t *testing.T) {
+	h := newHarness(t)
+
+	var mu sync.Mutex
+	var statuses []map[string]interface{}
+	err := h.broker.srv.Subscribe("controllers/status/7", 1, func(_ *mqttserver.Client, _ packets.Subscription, pk packets.Packet) {
+		var fields map[string]interface{}
+		if json.Unmarshal(pk.Payload, &fields) == nil {
+			mu.Lock()
+			statuses = append(statuses, fields)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatalf("подписка на статус: %v", err)
+	}
+	lastState := func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(statuses) == 0 {
+			return nil
+		}
+		return statuses[len(statuses)-1]["state"]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- simulate(ctx, simScenario{
+			Interval:  50 * time.Millisecond,
+			Sensors:   []simSensor{{ID: "temperature/room1"}, {ID: "humidity/room1"}, {ID: "motion/hall"}},
+			Actuators: []simActuator{{DeviceID: 7, PowerW: 1000}},
+		}, h.broker.url())
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("симулятор: %v", err)
+		}
+	})
+
+	eventually(t, "показания всех датчиков", func() bool {
+		seen := map[string]bool{}
+		for _, r := range h.received() {
+			seen[r.SensorID] = true
+		}
+		return seen["temperature/room1"] && seen["humidity/room1"] && seen["motion/hall"]
+	})
+	eventually(t, "статус исполнительного устройства", func() bool { return lastState() == "off" })
+
+	// Команда уходит тем же путём, что и из publishDeviceCommand.
+	eventually(t, "подписка симулятора на команды", func() bool { return h.broker.subscribed(commandTopic(7)) })
+	h.broker.publish(t, commandTopic(7), `{"device_id":7,"command":"on","source":"api"}`)
+	eventually(t, "ответ на команду", func() bool { return lastState() == "on" })
+
+	mu.Lock()
+	last := statuses[len(statuses)-1]
+	mu.Unlock()
+	if last["power"] != 1000.0 || last["status"] != "online" {
+		t.Errorf("статус после включения: %v", last)
+	}
+
+	for _, r := range h.received() {
+		if strings.HasPrefix(r.SensorID, "temperature/") && (r.Value < 10 || r.Value > 35) {
+			t.Errorf("неправдоподобная температура %v", r.Value)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============ ПОТОК СОБЫТИЙ (WebSocket / SSE) ============
+//
+// /api/stream отдаёт события в реальном времени: WebSocket, если клиент
+// просит Upgrade, иначе Server-Sent Events. Клиент подписывается на
+// здания, комнаты или устройства; подписка пересекается с правами
+// пользователя (администратор видит всё, остальные — здания, где у них
+// есть устройства в user_devices). У каждого клиента свой буфер: если он
+// заполнен, событие отбрасывается, а клиент, пропустивший подряд больше
+// streamMaxDropped событий, отключается.
+
+const (
+	streamBuffer     = 64
+	streamMaxDropped = 256
+	streamPing       = 30 * time.Second
+	streamWriteWait  = 10 * time.Second
+)
+
+type StreamEvent struct {
+	Type       string      `json:"type"` // reading | state | alert | availability | mode | ticket | occupancy | climate
+	BuildingID int         `json:"building_id,omitempty"`
+	RoomID     int         `json:"room_id,omitempty"`
+	DeviceID   int         `json:"device_id,omitempty"`
+	SensorID   string      `json:"sensor_id,omitempty"`
+	Data       interface{} `json:"data"`
+	Time       time.Time   `json:"time"`
+}
+
+type StreamSubscription struct {
+	Buildings []int `json:"buildings"`
+	Rooms     []int `json:"rooms"`
+	Devices   []int `json:"devices"`
+}
+
+type streamClient struct {
+	userID  int
+	admin   bool
+	allowed map[int]bool // здания, доступные пользователю
+
+	mu        sync.Mutex
+	buildings map[int]bool
+	rooms     map[int]bool
+	devices   map[int]bool
+
+	dropped int  // подряд отброшенных событий, под mu
+	slow    bool // отключён за переполнение буфера, под mu
+
+	send chan StreamEvent
+	done chan struct{}
+	once sync.Once
+}
+
+type streamHub struct {
+	mu      sync.RWMutex
+	clients map[*streamClient]struct{}
+}
+
+var hub = &streamHub{clients: map[*streamClient]struct{}{}}
+
+func toSet(ids []int) map[int]bool {
+	set := map[int]bool{}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// subscribe заменяет подписку клиента. Здания, к которым нет доступа,
+// дают ошибку, а не молча отфильтровываются.
+func (c *streamClient) subscribe(sub StreamSubscription) error {
+	if !c.admin {
+		for _, id := range sub.Buildings {
+			if !c.allowed[id] {
+				return fmt.Errorf("нет доступа к зданию %d", id)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.buildings, c.rooms, c.devices = toSet(sub.Buildings), toSet(sub.Rooms), toSet(sub.Devices)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *streamClient) wants(e StreamEvent) bool {
+	if !c.admin && !c.allowed[e.BuildingID] {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buildings) == 0 && len(c.rooms) == 0 && len(c.devices) == 0 {
+		return true
+	}
+	return c.buildings[e.BuildingID] ||
+		(e.RoomID != 0 && c.rooms[e.RoomID]) ||
+		(e.DeviceID != 0 && c.devices[e.DeviceID])
+}
+
+func (c *streamClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (h *streamHub) add(c *streamClient) {
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *streamHub) remove(c *streamClient) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.close()
+}
+
+// closeAll завершает все потоки (при остановке сервера: иначе открытые
+// SSE-соединения не дали бы HTTP-серверу дождаться конца запросов).
+func (h *streamHub) closeAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		c.close()
+	}
+}
+
+// publish раздаёт событие подписанным клиентам, никогда не блокируясь.
+func (h *streamHub) publish(e StreamEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if !c.wants(e) {
+			continue
+		}
+		select {
+		case c.send <- e:
+			c.mu.Lock()
+			c.dropped = 0
+			c.mu.Unlock()
+		default:
+			c.mu.Lock()
+			c.dropped++
+			slow := c.dropped > streamMaxDropped && !c.slow
+			if slow {
+				c.slow = true
+			}
+			c.mu.Unlock()
+			if slow {
+				logger("stream").Warn("Клиент не успевает читать, отключаем", "user_id", c.userID)
+				c.close()
+			}
+		}
+	}
+}
+
+// onReading — обработчик показаний датчиков (см. readingHandlers).
+func (h *streamHub) onReading(reading SensorReading) {
+	info, _ := devices.resolve(reading.Topic)
+
+	e := StreamEvent{
+		Type:       "reading",
+		BuildingID: info.BuildingID,
+		RoomID:     info.RoomID,
+		DeviceID:   info.ID,
+		SensorID:   reading.SensorID,
+		Data:       reading.Payload,
+		Time:       reading.Time,
+	}
+	if strings.HasPrefix(reading.Topic, "controllers/status/") {
+		e.Type = "state"
+		e.SensorID = ""
+	}
+	h.publish(e)
+}
+
+// ============ ПОДКЛЮЧЕНИЕ КЛИЕНТА ============
+
+// newStreamClient проверяет токен (заголовок Authorization или ?token=,
+// так как браузерный WebSocket не умеет заголовки) и загружает права.
+func newStreamClient(r *http.Request) (*streamClient, error) {
+	if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	userID, _, ok := userFromRequest(r)
+	if !ok {
+		return nil, fmt.Errorf("требуется авторизация")
+	}
+
+	var role string
+	if err := psqlConn.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role); err != nil {
+		return nil, fmt.Errorf("пользователь не найден")
+	}
+
+	c := &streamClient{
+		userID:  userID,
+		admin:   role == "admin",
+		allowed: map[int]bool{},
+		send:    make(chan StreamEvent, streamBuffer),
+		done:    make(chan struct{}),
+	}
+
+	if !c.admin {
+		rows, err := psqlConn.Query(`SELECT DISTINCT r.building_id FROM user_devices ud
+			JOIN device d ON d.id = ud.device_id
+			JOIN room r ON r.id = d.room_id
+			WHERE ud.user_id = $1`, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if rows.Scan(&id) == nil {
+				c.allowed[id] = true
+			}
+		}
+	}
+	return c, nil
+}
+
+func parseIDList(v string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("неверный ID %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func subscriptionFromQuery(r *http.Request) (StreamSubscription, error) {
+	var sub StreamSubscription
+	var err error
+	if sub.Buildings, err = parseIDList(r.URL.Query().Get("buildings")); err != nil {
+		return sub, err
+	}
+	if sub.Rooms, err = parseIDList(r.URL.Query().Get("rooms")); err != nil {
+		return sub, err
+	}
+	sub.Devices, err = parseIDList(r.URL.Query().Get("devices"))
+	return sub, err
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Origin проверяется по тому же списку http.cors_origins, что и у
+	// REST API (allowedOrigin): страница чужого сайта поток не откроет.
+	// Клиенты вне браузера Origin не присылают.
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowedOrigin(origin) != ""
+	},
+}
+
+// streamHandler — /api/stream?buildings=1,2&rooms=3&devices=4&token=...
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := newStreamClient(r)
+	if err != nil {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	sub, err := subscriptionFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := client.subscribe(sub); err != nil {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return
+	}
+
+	// Поток живёт дольше WriteTimeout сервера.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveWebSocket(w, r, client)
+		return
+	}
+	serveSSE(w, r, client)
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, client *streamClient) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	hub.add(client)
+	defer hub.remove(client)
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e := <-client.send:
+			payload, _ := json.Marshal(e)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveWebSocket: клиент может прислать {"action":"subscribe", ...},
+// чтобы сменить подписку без переподключения.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, client *streamClient) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger("stream").WarnContext(r.Context(), "Ошибка WebSocket upgrade", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	hub.add(client)
+	defer hub.remove(client)
+
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(2 * streamPing))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamPing))
+	})
+
+	go func() {
+		defer client.close()
+		for {
+			var msg struct {
+				Action string `json:"action"`
+				StreamSubscription
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Action != "subscribe" {
+				continue
+			}
+			reply := map[string]interface{}{"type": "subscribed"}
+			if err := client.subscribe(msg.StreamSubscription); err != nil {
+				reply = map[string]interface{}{"type": "error", "message": err.Error()}
+			}
+			// Ответ уходит через общий канал, чтобы писал только один поток.
+			select {
+			case client.send <- StreamEvent{Type: reply["type"].(string), Data: reply, Time: time.Now()}:
+			default:
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-client.done:
+			client.mu.Lock()
+			slow := client.slow
+			client.mu.Unlock()
+			if slow {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer"),
+					time.Now().Add(streamWriteWait))
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// ============ ВОСХОД / ЗАКАТ ============
+//
+// Расчёт по упрощённому алгоритму NOAA ("sunrise equation"), точность —
+// около минуты, чего для расписаний достаточно. Внешние сервисы не нужны.
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	degToRad        = math.Pi / 180
+)
+
+func toJulian(t time.Time) float64 {
+	return float64(t.Unix())/86400.0 + julianUnixEpoch
+}
+
+func fromJulian(j float64) time.Time {
+	return time.Unix(int64(math.Round((j-julianUnixEpoch)*86400)), 0).UTC()
+}
+
+// sunTimes возвращает моменты восхода и заката для календарного дня day
+// (берутся только год/месяц/число в его часовом поясе) в точке lat/lon.
+// ok == false для полярного дня или полярной ночи.
+func sunTimes(day time.Time, lat, lon float64) (sunrise, sunset time.Time, ok bool) {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(toJulian(noon) - julian2000)
+
+	meanNoon := n - lon/360
+	m := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	c := 1.9148*math.Sin(m*degToRad) + 0.02*math.Sin(2*m*degToRad) + 0.0003*math.Sin(3*m*degToRad)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := julian2000 + meanNoon + 0.0053*math.Sin(m*degToRad) - 0.0069*math.Sin(2*lambda*degToRad)
+
+	sinDecl := math.Sin(lambda*degToRad) * math.Sin(23.4397*degToRad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHour := (math.Sin(-0.833*degToRad) - math.Sin(lat*degToRad)*sinDecl) / (math.Cos(lat*degToRad) * cosDecl)
+	if cosHour < -1 || cosHour > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	hour := math.Acos(cosHour) / degToRad
+	return fromJulian(transit - hour/360), fromJulian(transit + hour/360), true
+}
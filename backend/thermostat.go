@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ ТЕРМОСТАТЫ ============
+//
+// Термостат связывает датчик температуры (sensor_id из топика, например
+// "temperature/kitchen") с исполнительным устройством: обогревателем
+// (mode=heat) или кондиционером (mode=cool). Раз в thermostatTick контур
+// выбирает уставку по расписанию и решает, включить ли устройство:
+//   - hysteresis — включение ниже уставки − hysteresis, выключение выше
+//     уставки + hysteresis (для cool наоборот);
+//   - pid — выход ПИД-регулятора 0..1 превращается в долю включения на
+//     окне cycle_seconds (широтно-импульсное управление).
+// Переключения не чаще min_on_seconds/min_off_seconds. Если показаний нет
+// дольше stale_seconds, действует failsafe: off, on или hold (оставить
+// как есть). Состояние контуров отдаётся в API и в метрики thermostat_*.
+
+const (
+	thermostatTick   = 10 * time.Second
+	thermostatReload = 30 * time.Second
+)
+
+// SetpointEntry — уставка, действующая с At по дням Days (1 — понедельник
+// … 7 — воскресенье) до следующей записи.
+type SetpointEntry struct {
+	Days     []int   `json:"days"`
+	At       string  `json:"at"` // HH:MM, местное время здания
+	Setpoint float64 `json:"setpoint"`
+}
+
+type Thermostat struct {
+	ID            int             `json:"id"`
+	BuildingID    int             `json:"building_id"`
+	RoomID        *int            `json:"room_id,omitempty"`
+	Name          string          `json:"name"`
+	SensorID      string          `json:"sensor_id"`
+	DeviceID      int             `json:"device_id"`
+	Mode          string          `json:"mode"`      // heat | cool
+	Algorithm     string          `json:"algorithm"` // hysteresis | pid
+	Setpoint      float64         `json:"setpoint"`  // когда расписание пусто
+	Hysteresis    float64         `json:"hysteresis"`
+	Kp            float64         `json:"kp"`
+	Ki            float64         `json:"ki"`
+	Kd            float64         `json:"kd"`
+	CycleSeconds  int             `json:"cycle_seconds"`
+	MinOnSeconds  int             `json:"min_on_seconds"`
+	MinOffSeconds int             `json:"min_off_seconds"`
+	StaleSeconds  int             `json:"stale_seconds"`
+	Failsafe      string          `json:"failsafe"` // off | on | hold
+	Schedule      []SetpointEntry `json:"schedule"`
+	Enabled       bool            `json:"enabled"`
+	State         *LoopState      `json:"state,omitempty"`
+}
+
+// LoopState — текущее состояние контура.
+type LoopState struct {
+	Temperature *float64   `json:"temperature,omitempty"`
+	ReadingAt   *time.Time `json:"reading_at,omitempty"`
+	Setpoint    float64    `json:"setpoint"`
+	Output      float64    `json:"output"` // 0..1
+	ActuatorOn  bool       `json:"actuator_on"`
+	LastSwitch  *time.Time `json:"last_switch,omitempty"`
+	Failsafe    bool       `json:"failsafe"`
+
+	integral    float64
+	prevTemp    float64
+	hasPrev     bool
+	lastStep    time.Time
+	cycleStart  time.Time
+	cycleOutput float64
+}
+
+type thermostatLoop struct {
+	cfg   Thermostat
+	loc   *time.Location
+	state LoopState
+}
+
+type thermostatController struct {
+	mu       sync.Mutex
+	loops    map[int]*thermostatLoop
+	readings map[string]SensorReading
+	loadedAt time.Time
+	version  int // растёт при каждом invalidate
+	// released — выключения отпущенных устройств, не дошедшие до брокера;
+	// повторяются на следующем шаге.
+	released []thermostatSwitch
+}
+
+var thermostats = &thermostatController{
+	loops:    map[int]*thermostatLoop{},
+	readings: map[string]SensorReading{},
+}
+
+func (t *Thermostat) validate() error {
+	if t.Name == "" || t.BuildingID == 0 || t.SensorID == "" || t.DeviceID == 0 {
+		return fmt.Errorf("name, building_id, sensor_id и device_id обязательны")
+	}
+	if t.Mode != "heat" && t.Mode != "cool" {
+		return fmt.Errorf("mode: heat или cool")
+	}
+	switch t.Algorithm {
+	case "hysteresis":
+		if t.Hysteresis < 0 {
+			return fmt.Errorf("hysteresis не может быть отрицательным")
+		}
+	case "pid":
+		if t.Kp < 0 || t.Ki < 0 || t.Kd < 0 || t.CycleSeconds < 60 {
+			return fmt.Errorf("pid: коэффициенты не отрицательны, cycle_seconds не меньше 60")
+		}
+	default:
+		return fmt.Errorf("algorithm: hysteresis или pid")
+	}
+	if t.Failsafe != "off" && t.Failsafe != "on" && t.Failsafe != "hold" {
+		return fmt.Errorf("failsafe: off, on или hold")
+	}
+	if t.MinOnSeconds < 0 || t.MinOffSeconds < 0 || t.StaleSeconds <= 0 {
+		return fmt.Errorf("min_on_seconds, min_off_seconds не отрицательны, stale_seconds больше нуля")
+	}
+	for _, e := range t.Schedule {
+		if _, _, ok := parseClock(e.At); !ok || len(e.Days) == 0 {
+			return fmt.Errorf("schedule: нужны days и at в формате HH:MM")
+		}
+		for _, d := range e.Days {
+			if d < 1 || d > 7 {
+				return fmt.Errorf("schedule: дни недели 1–7")
+			}
+		}
+	}
+	if t.Schedule == nil {
+		t.Schedule = []SetpointEntry{}
+	}
+	return nil
+}
+
+// activeSetpoint возвращает уставку по расписанию: последнюю запись,
+// начавшуюся не позже now, в пределах недели назад.
+func (t *Thermostat) activeSetpoint(now time.Time, loc *time.Location) float64 {
+	local := now.In(loc)
+	nowMinutes := local.Hour()*60 + local.Minute()
+	for back := 0; back <= 7; back++ {
+		day := local.AddDate(0, 0, -back)
+		weekday := int(day.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		best, found := -1, false
+		var setpoint float64
+		for _, e := range t.Schedule {
+			h, m, _ := parseClock(e.At)
+			minutes := h*60 + m
+			if (back == 0 && minutes > nowMinutes) || minutes <= best || !containsInt(e.Days, weekday) {
+				continue
+			}
+			best, found, setpoint = minutes, true, e.Setpoint
+		}
+		if found {
+			return setpoint
+		}
+	}
+	return t.Setpoint
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ============ КОНТУР УПРАВЛЕНИЯ ============
+
+// observe — обработчик показаний датчиков (см. readingHandlers).
+func (c *thermostatController) observe(reading SensorReading) {
+	if !reading.HasValue || !strings.HasPrefix(reading.Topic, "sensors/temperature/") {
+		return
+	}
+	c.mu.Lock()
+	c.readings[reading.SensorID] = reading
+	c.mu.Unlock()
+}
+
+func (c *thermostatController) invalidate() {
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.version++
+	c.mu.Unlock()
+}
+
+// thermostatConfig — настройки, прочитанные из БД без c.mu: observe
+// берёт тот же мьютекс на каждом показании температуры.
+type thermostatConfig struct {
+	list      []Thermostat
+	actuators map[int]bool           // device_id → включено по controller.state
+	locations map[int]*time.Location // building_id → часовой пояс
+}
+
+func loadThermostatConfig() (*thermostatConfig, error) {
+	list, err := loadThermostats("WHERE enabled")
+	if err != nil {
+		return nil, err
+	}
+	loaded := &thermostatConfig{list: list, actuators: map[int]bool{}, locations: map[int]*time.Location{}}
+	for _, t := range list {
+		if _, ok := loaded.actuators[t.DeviceID]; !ok {
+			var state sql.NullString
+			psqlConn.QueryRow("SELECT state FROM controller WHERE device_id = $1", t.DeviceID).Scan(&state)
+			loaded.actuators[t.DeviceID], _ = isOnState(state.String)
+		}
+		if _, ok := loaded.locations[t.BuildingID]; !ok {
+			loaded.locations[t.BuildingID] = time.UTC
+			if bl, err := loadBuildingLocation(psqlConn, t.BuildingID); err == nil {
+				loaded.locations[t.BuildingID] = bl.Loc
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// applyLocked подменяет настройки прочитанными в loadThermostatConfig,
+// сохраняя состояние контуров. Устройства, которыми термостат больше не
+// управляет (термостат удалён, выключен или привязан к другому
+// устройству) и которые он оставил включёнными, возвращаются для
+// выключения: иначе обогреватель остался бы работать без контроля.
+func (c *thermostatController) applyLocked(loaded *thermostatConfig, version int) []thermostatSwitch {
+	var released []thermostatSwitch
+	loops := map[int]*thermostatLoop{}
+	for _, t := range loaded.list {
+		loop, ok := c.loops[t.ID]
+		if !ok || loop.cfg.DeviceID != t.DeviceID {
+			if ok && loop.state.ActuatorOn {
+				released = append(released, thermostatSwitch{t.ID, loop.cfg.DeviceID, false})
+			}
+			loop = &thermostatLoop{}
+			loop.state.ActuatorOn = loaded.actuators[t.DeviceID]
+		}
+		if loop.cfg.Algorithm != t.Algorithm {
+			loop.state.integral, loop.state.cycleStart = 0, time.Time{}
+		}
+		loop.cfg = t
+		loop.loc = loaded.locations[t.BuildingID]
+		loops[t.ID] = loop
+	}
+	for id, loop := range c.loops {
+		if _, ok := loops[id]; !ok {
+			deleteThermostatMetrics(id)
+			if loop.state.ActuatorOn {
+				released = append(released, thermostatSwitch{id, loop.cfg.DeviceID, false})
+			}
+		}
+	}
+	c.loops = loops
+	// Изменение во время загрузки не должно потеряться: перечитаем на
+	// следующем шаге.
+	if c.version == version {
+		c.loadedAt = time.Now()
+	}
+	return released
+}
+
+type thermostatSwitch struct {
+	thermostatID int
+	deviceID     int
+	on           bool
+}
+
+// step выполняет один шаг всех контуров. Настройки читаются из БД, а
+// команды отправляются без c.mu.
+func (c *thermostatController) step(now time.Time) {
+	c.mu.Lock()
+	stale, version := time.Since(c.loadedAt) > thermostatReload, c.version
+	c.mu.Unlock()
+	var loaded *thermostatConfig
+	if stale {
+		var err error
+		if loaded, err = loadThermostatConfig(); err != nil {
+			logger("thermostat").Error("Ошибка загрузки термостатов", "error", err)
+		}
+	}
+
+	c.mu.Lock()
+	switches := c.released
+	c.released = nil
+	if loaded != nil {
+		switches = append(switches, c.applyLocked(loaded, version)...)
+	}
+	for id, loop := range c.loops {
+		if demand, ok := loop.decide(c.readings[loop.cfg.SensorID], now); ok && demand != loop.state.ActuatorOn {
+			switches = append(switches, thermostatSwitch{id, loop.cfg.DeviceID, demand})
+		}
+		loop.exportMetrics()
+	}
+	c.mu.Unlock()
+
+	for _, s := range switches {
+		command := "off"
+		if s.on {
+			command = "on"
+		}
+		err := publishDeviceCommand(context.Background(), DeviceCommand{DeviceID: s.deviceID, Command: command}, fmt.Sprintf("thermostat:%d", s.thermostatID))
+		c.mu.Lock()
+		loop, ok := c.loops[s.thermostatID]
+		owned := ok && loop.cfg.DeviceID == s.deviceID
+		if err != nil {
+			logger("thermostat").Error("Ошибка команды термостата", "thermostat_id", s.thermostatID, "device_id", s.deviceID, "error", err)
+			if !owned {
+				c.released = append(c.released, s)
+			}
+		} else if owned {
+			switched := now
+			loop.state.ActuatorOn, loop.state.LastSwitch = s.on, &switched
+			loop.exportMetrics()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// decide возвращает желаемое состояние устройства с учётом минимальных
+// времён цикла; ok == false — оставить как есть.
+func (l *thermostatLoop) decide(reading SensorReading, now time.Time) (bool, bool) {
+	cfg, st := &l.cfg, &l.state
+	st.Setpoint = cfg.activeSetpoint(now, l.loc)
+
+	stale := reading.Time.IsZero() || now.Sub(reading.Time) > time.Duration(cfg.StaleSeconds)*time.Second
+	if !reading.Time.IsZero() {
+		temp, at := reading.Value, reading.Time
+		st.Temperature, st.ReadingAt = &temp, &at
+	}
+
+	var demand bool
+	if stale {
+		if !st.Failsafe {
+			logger("thermostat").Warn("Нет показаний, включён failsafe", "thermostat_id", cfg.ID, "thermostat", cfg.Name, "sensor_id", cfg.SensorID, "failsafe", cfg.Failsafe)
+		}
+		st.Failsafe, st.hasPrev, st.cycleStart = true, false, time.Time{}
+		switch cfg.Failsafe {
+		case "on":
+			demand, st.Output = true, 1
+		case "off":
+			demand, st.Output = false, 0
+		default:
+			return false, false
+		}
+	} else {
+		if st.Failsafe {
+			logger("thermostat").Info("Показания восстановлены", "thermostat_id", cfg.ID, "thermostat", cfg.Name, "sensor_id", cfg.SensorID)
+		}
+		st.Failsafe = false
+		if cfg.Algorithm == "pid" {
+			demand = l.pid(reading.Value, now)
+		} else {
+			var ok bool
+			if demand, ok = l.hysteresis(reading.Value); !ok {
+				return false, false
+			}
+		}
+	}
+
+	if demand != st.ActuatorOn && st.LastSwitch != nil {
+		minimum := cfg.MinOffSeconds
+		if st.ActuatorOn {
+			minimum = cfg.MinOnSeconds
+		}
+		if now.Sub(*st.LastSwitch) < time.Duration(minimum)*time.Second {
+			return false, false
+		}
+	}
+	return demand, true
+}
+
+// hysteresis: ok == false внутри зоны нечувствительности.
+func (l *thermostatLoop) hysteresis(temp float64) (bool, bool) {
+	sp, h := l.state.Setpoint, l.cfg.Hysteresis
+	low, high := temp < sp-h, temp > sp+h
+	if l.cfg.Mode == "cool" {
+		low, high = high, low
+	}
+	switch {
+	case low:
+		l.state.Output = 1
+		return true, true
+	case high:
+		l.state.Output = 0
+		return false, true
+	}
+	return false, false
+}
+
+// pid — ПИД с дифференцированием по измерению и ограничением интеграла
+// диапазоном выхода; выход фиксируется на начало каждого окна цикла.
+func (l *thermostatLoop) pid(temp float64, now time.Time) bool {
+	cfg, st := &l.cfg, &l.state
+	sign := 1.0
+	if cfg.Mode == "cool" {
+		sign = -1
+	}
+
+	dt := thermostatTick.Seconds()
+	if !st.lastStep.IsZero() {
+		dt = math.Min(now.Sub(st.lastStep).Seconds(), 3*thermostatTick.Seconds())
+	}
+	st.lastStep = now
+
+	e := sign * (st.Setpoint - temp)
+	derivative := 0.0
+	if st.hasPrev && dt > 0 {
+		derivative = -sign * (temp - st.prevTemp) / dt
+	}
+	st.prevTemp, st.hasPrev = temp, true
+	st.integral = clamp01(st.integral + cfg.Ki*e*dt)
+	st.Output = round2(clamp01(cfg.Kp*e + st.integral + cfg.Kd*derivative))
+
+	cycle := time.Duration(cfg.CycleSeconds) * time.Second
+	if st.cycleStart.IsZero() || now.Sub(st.cycleStart) >= cycle {
+		st.cycleStart, st.cycleOutput = now, st.Output
+	}
+	return now.Sub(st.cycleStart) < time.Duration(st.cycleOutput*float64(cycle))
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func (l *thermostatLoop) exportMetrics() {
+	id := strconv.Itoa(l.cfg.ID)
+	st := l.state
+	thermostatSetpoint.WithLabelValues(id).Set(st.Setpoint)
+	thermostatOutput.WithLabelValues(id).Set(st.Output)
+	thermostatActuatorOn.WithLabelValues(id).Set(boolGauge(st.ActuatorOn))
+	thermostatFailsafe.WithLabelValues(id).Set(boolGauge(st.Failsafe))
+	if st.Temperature != nil {
+		thermostatTemperature.WithLabelValues(id).Set(*st.Temperature)
+	}
+}
+
+func deleteThermostatMetrics(thermostatID int) {
+	id := strconv.Itoa(thermostatID)
+	thermostatSetpoint.DeleteLabelValues(id)
+	thermostatOutput.DeleteLabelValues(id)
+	thermostatActuatorOn.DeleteLabelValues(id)
+	thermostatFailsafe.DeleteLabelValues(id)
+	thermostatTemperature.DeleteLabelValues(id)
+}
+
+func boolGauge(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func startThermostats(ctx context.Context) {
+	ticker := time.NewTicker(thermostatTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			thermostats.step(now)
+		}
+	}
+}
+
+// stateOf возвращает копию состояния контура, если он запущен.
+func (c *thermostatController) stateOf(id int) *LoopState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loop, ok := c.loops[id]
+	if !ok {
+		return nil
+	}
+	st := loop.state
+	return &st
+}
+
+// ============ ХРАНЕНИЕ ============
+
+const thermostatColumns = `id, building_id, room_id, name, sensor_id, device_id, mode, algorithm,
+	setpoint, hysteresis, kp, ki, kd, cycle_seconds, min_on_seconds, min_off_seconds,
+	stale_seconds, failsafe, schedule, enabled`
+
+func scanThermostat(row interface{ Scan(...interface{}) error }) (Thermostat, error) {
+	var t Thermostat
+	var roomID sql.NullInt64
+	var schedule []byte
+	err := row.Scan(&t.ID, &t.BuildingID, &roomID, &t.Name, &t.SensorID, &t.DeviceID, &t.Mode, &t.Algorithm,
+		&t.Setpoint, &t.Hysteresis, &t.Kp, &t.Ki, &t.Kd, &t.CycleSeconds, &t.MinOnSeconds, &t.MinOffSeconds,
+		&t.StaleSeconds, &t.Failsafe, &schedule, &t.Enabled)
+	if err != nil {
+		return t, err
+	}
+	t.RoomID = nullIntPtr(roomID)
+	if err := json.Unmarshal(schedule, &t.Schedule); err != nil {
+		return t, err
+	}
+	if t.Schedule == nil {
+		t.Schedule = []SetpointEntry{}
+	}
+	return t, nil
+}
+
+func loadThermostats(where string, args ...interface{}) ([]Thermostat, error) {
+	rows, err := psqlConn.Query("SELECT "+thermostatColumns+" FROM thermostats "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Thermostat{}
+	for rows.Next() {
+		t, err := scanThermostat(rows)
+		if err != nil {
+			logger("thermostat").Warn("Пропущен термостат", "error", err)
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// ============ REST API HANDLERS - THERMOSTATS ============
+
+func getThermostats(w http.ResponseWriter, r *http.Request) {
+	var list []Thermostat
+	var err error
+	if buildingID := r.URL.Query().Get("building_id"); buildingID != "" {
+		list, err = loadThermostats("WHERE building_id = $1", buildingID)
+	} else {
+		list, err = loadThermostats("")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i := range list {
+		list[i].State = thermostats.stateOf(list[i].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func decodeThermostat(w http.ResponseWriter, r *http.Request) (Thermostat, bool) {
+	t := Thermostat{
+		Algorithm:     "hysteresis",
+		Setpoint:      21,
+		Hysteresis:    0.5,
+		Kp:            0.5,
+		Ki:            0.0005,
+		CycleSeconds:  600,
+		MinOnSeconds:  180,
+		MinOffSeconds: 180,
+		StaleSeconds:  300,
+		Failsafe:      "off",
+		Enabled:       true,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return t, false
+	}
+	if err := t.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return t, false
+	}
+	return t, true
+}
+
+func createThermostat(w http.ResponseWriter, r *http.Request) {
+	t, ok := decodeThermostat(w, r)
+	if !ok {
+		return
+	}
+
+	schedule, _ := json.Marshal(t.Schedule)
+	err := psqlConn.QueryRow(`INSERT INTO thermostats
+		(building_id, room_id, name, sensor_id, device_id, mode, algorithm, setpoint, hysteresis, kp, ki, kd,
+		 cycle_seconds, min_on_seconds, min_off_seconds, stale_seconds, failsafe, schedule, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		RETURNING id`,
+		t.BuildingID, t.RoomID, t.Name, t.SensorID, t.DeviceID, t.Mode, t.Algorithm, t.Setpoint, t.Hysteresis,
+		t.Kp, t.Ki, t.Kd, t.CycleSeconds, t.MinOnSeconds, t.MinOffSeconds, t.StaleSeconds, t.Failsafe,
+		schedule, t.Enabled,
+	).Scan(&t.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	thermostats.invalidate()
+	auditRequest(r, "thermostat.create", "thermostat", t.ID, nil, t)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func updateThermostat(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+	t, ok := decodeThermostat(w, r)
+	if !ok {
+		return
+	}
+	before, err := loadThermostats("WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	schedule, _ := json.Marshal(t.Schedule)
+	err = psqlConn.QueryRow(`UPDATE thermostats SET
+		building_id = $1, room_id = $2, name = $3, sensor_id = $4, device_id = $5, mode = $6, algorithm = $7,
+		setpoint = $8, hysteresis = $9, kp = $10, ki = $11, kd = $12, cycle_seconds = $13,
+		min_on_seconds = $14, min_off_seconds = $15, stale_seconds = $16, failsafe = $17,
+		schedule = $18, enabled = $19
+		WHERE id = $20 RETURNING id`,
+		t.BuildingID, t.RoomID, t.Name, t.SensorID, t.DeviceID, t.Mode, t.Algorithm, t.Setpoint, t.Hysteresis,
+		t.Kp, t.Ki, t.Kd, t.CycleSeconds, t.MinOnSeconds, t.MinOffSeconds, t.StaleSeconds, t.Failsafe,
+		schedule, t.Enabled, id,
+	).Scan(&t.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Термостат не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	thermostats.invalidate()
+	if len(before) > 0 {
+		auditRequest(r, "thermostat.update", "thermostat", t.ID, before[0], t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func deleteThermostat(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	before, err := loadThermostats("WHERE id = $1", id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := psqlConn.Exec("DELETE FROM thermostats WHERE id = $1", id); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	thermostats.invalidate()
+	if len(before) > 0 {
+		auditRequest(r, "thermostat.delete", "thermostat", id, before[0], nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
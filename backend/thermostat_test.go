@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ============ ТЕРМОСТАТЫ ============
+
+func TestActiveSetpoint(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*3600)
+	th := &Thermostat{
+		Setpoint: 19,
+		Schedule: []SetpointEntry{
+			{Days: []int{1, 2, 3, 4, 5}, At: "07:00", Setpoint: 21},
+			{Days: []int{1, 2, 3, 4, 5}, At: "23:00", Setpoint: 17},
+			{Days: []int{6, 7}, At: "09:00", Setpoint: 22},
+		},
+	}
+	// 2024-05-15 — среда.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, msk).UTC()
+	}
+	for name, c := range map[string]struct {
+		now  time.Time
+		want float64
+	}{
+		"утро среды":                   {at(15, 8, 0), 21},
+		"ровно в момент записи":        {at(15, 7, 0), 21},
+		"ночь — запись прошлого дня":   {at(15, 6, 59), 17},
+		"суббота до первой записи":     {at(18, 8, 0), 17},
+		"суббота":                      {at(18, 10, 0), 22},
+		"понедельник до первой записи": {at(20, 6, 0), 22},
+		"06:30 MSK — это 03:30 UTC":    {at(15, 6, 30), 17},
+	} {
+		if got := th.activeSetpoint(c.now, msk); got != c.want {
+			t.Errorf("%s: %v, ожидалось %v", name, got, c.want)
+		}
+	}
+
+	empty := &Thermostat{Setpoint: 19, Schedule: []SetpointEntry{}}
+	if got := empty.activeSetpoint(at(15, 8, 0), msk); got != 19 {
+		t.Errorf("без расписания: %v, ожидалось 19", got)
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	for _, c := range []struct {
+		mode       string
+		temp       float64
+		demand, ok bool
+	}{
+		{"heat", 19.4, true, true},
+		{"heat", 20.6, false, true},
+		{"heat", 20.4, false, false},
+		{"heat", 19.5, false, false},
+		{"cool", 20.6, true, true},
+		{"cool", 19.4, false, true},
+		{"cool", 20, false, false},
+	} {
+		loop := &thermostatLoop{cfg: Thermostat{Mode: c.mode, Hysteresis: 0.5}, state: LoopState{Setpoint: 20}}
+		demand, ok := loop.hysteresis(c.temp)
+		if demand != c.demand || ok != c.ok {
+			t.Errorf("%s %.1f°: %v, %v; ожидалось %v, %v", c.mode, c.temp, demand, ok, c.demand, c.ok)
+		}
+	}
+}
+
+func TestPID(t *testing.T) {
+	start := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	newLoop := func(mode string) *thermostatLoop {
+		return &thermostatLoop{
+			cfg:   Thermostat{Mode: mode, Algorithm: "pid", Kp: 1, CycleSeconds: 600},
+			state: LoopState{Setpoint: 20},
+		}
+	}
+
+	// Ошибка 0.5° при Kp=1 — выход 0.5: первые 300 с окна включено.
+	loop := newLoop("heat")
+	for _, c := range []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{290 * time.Second, true},
+		{310 * time.Second, false},
+		{590 * time.Second, false},
+		{600 * time.Second, true}, // новое окно
+	} {
+		if got := loop.pid(19.5, start.Add(c.offset)); got != c.want {
+			t.Errorf("heat через %s: %v, ожидалось %v", c.offset, got, c.want)
+		}
+	}
+	if loop.state.Output != 0.5 {
+		t.Errorf("выход %v, ожидалось 0.5", loop.state.Output)
+	}
+
+	cool := newLoop("cool")
+	if !cool.pid(20.5, start) || cool.state.Output != 0.5 {
+		t.Errorf("cool выше уставки: выход %v", cool.state.Output)
+	}
+	if cool.pid(19, start.Add(time.Hour)) {
+		t.Error("cool ниже уставки включён")
+	}
+
+	// Интеграл ограничен диапазоном выхода.
+	windup := newLoop("heat")
+	windup.cfg.Kp, windup.cfg.Ki = 0, 1
+	for i := 0; i < 100; i++ {
+		windup.pid(10, start.Add(time.Duration(i)*thermostatTick))
+	}
+	if windup.state.integral != 1 || windup.state.Output != 1 {
+		t.Errorf("интеграл %v, выход %v; ожидалось 1", windup.state.integral, windup.state.Output)
+	}
+}
+
+func TestThermostatDecide(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	fresh := SensorReading{Value: 18, HasValue: true, Time: now.Add(-time.Minute)}
+	stale := SensorReading{Value: 18, HasValue: true, Time: now.Add(-time.Hour)}
+	newLoop := func(failsafe string) *thermostatLoop {
+		return &thermostatLoop{
+			cfg: Thermostat{Mode: "heat", Algorithm: "hysteresis", Setpoint: 20, Hysteresis: 0.5,
+				StaleSeconds: 600, MinOnSeconds: 300, MinOffSeconds: 300, Failsafe: failsafe},
+			loc: time.UTC,
+		}
+	}
+
+	if demand, ok := newLoop("off").decide(fresh, now); !demand || !ok {
+		t.Errorf("холодно: %v, %v; ожидалось включить", demand, ok)
+	}
+
+	for failsafe, want := range map[string]struct{ demand, ok bool }{
+		"on":   {true, true},
+		"off":  {false, true},
+		"hold": {false, false},
+	} {
+		loop := newLoop(failsafe)
+		demand, ok := loop.decide(stale, now)
+		if demand != want.demand || ok != want.ok || !loop.state.Failsafe {
+			t.Errorf("failsafe=%s: %v, %v (failsafe %v)", failsafe, demand, ok, loop.state.Failsafe)
+		}
+	}
+	if demand, ok := newLoop("on").decide(SensorReading{}, now); !demand || !ok {
+		t.Error("без показаний вообще failsafe=on не сработал")
+	}
+
+	// Выключенное устройство не включается раньше min_off_seconds.
+	loop := newLoop("off")
+	switched := now.Add(-time.Minute)
+	loop.state.LastSwitch = &switched
+	if _, ok := loop.decide(fresh, now); ok {
+		t.Error("переключение раньше min_off_seconds")
+	}
+	if demand, ok := loop.decide(fresh, now.Add(5*time.Minute)); !demand || !ok {
+		t.Error("после min_off_seconds не включилось")
+	}
+
+	// Показания восстановились — failsafe снимается.
+	loop = newLoop("off")
+	loop.decide(stale, now)
+	loop.decide(fresh, now)
+	if loop.state.Failsafe || loop.state.Temperature == nil || *loop.state.Temperature != 18 {
+		t.Errorf("после восстановления: %+v", loop.state)
+	}
+}
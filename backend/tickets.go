@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ ЗАЯВКИ НА ОБСЛУЖИВАНИЕ ============
+//
+// Заявка привязана к устройству и проходит состояния
+// open → assigned → in_progress → resolved. Заявки открываются вручную
+// или автоматически: при потере связи с устройством (см. liveness) и при
+// показаниях вне диапазона sensor.min_value..max_value. На устройство
+// может быть не больше одной незакрытой автоматической заявки каждого
+// типа (частичный уникальный индекс). Срок реакции (SLA) зависит от
+// приоритета; просроченные заявки помечаются фоновой проверкой.
+
+const (
+	ticketOpen       = "open"
+	ticketAssigned   = "assigned"
+	ticketInProgress = "in_progress"
+	ticketResolved   = "resolved"
+
+	ticketSourceManual     = "manual"
+	ticketSourceOffline    = "offline"
+	ticketSourceOutOfRange = "out_of_range"
+
+	slaCheckInterval    = time.Minute
+	autoTicketThrottle  = 5 * time.Minute
+	sensorRangesRefresh = time.Minute
+	maxPhotoSize        = 10 << 20
+)
+
+var ticketTransitions = map[string][]string{
+	ticketOpen:       {ticketAssigned, ticketResolved},
+	ticketAssigned:   {ticketInProgress, ticketOpen, ticketResolved},
+	ticketInProgress: {ticketResolved, ticketAssigned},
+	ticketResolved:   {ticketOpen},
+}
+
+// photoTypes — допустимые типы фото (по содержимому файла) и их расширения.
+var photoTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+var slaByPriority = map[string]time.Duration{
+	"critical": 4 * time.Hour,
+	"high":     24 * time.Hour,
+	"normal":   72 * time.Hour,
+	"low":      7 * 24 * time.Hour,
+}
+
+type Ticket struct {
+	ID          int             `json:"id"`
+	DeviceID    *int            `json:"device_id,omitempty"`
+	BuildingID  *int            `json:"building_id,omitempty"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Source      string          `json:"source"`
+	Status      string          `json:"status"`
+	Priority    string          `json:"priority"`
+	WorkerID    *int            `json:"worker_id,omitempty"`
+	CreatedBy   *int            `json:"created_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	SLADueAt    time.Time       `json:"sla_due_at"`
+	SLABreached bool            `json:"sla_breached"`
+	ResolvedAt  *time.Time      `json:"resolved_at,omitempty"`
+	Comments    []TicketComment `json:"comments,omitempty"`
+	Photos      []TicketPhoto   `json:"photos,omitempty"`
+}
+
+type TicketComment struct {
+	ID        int       `json:"id"`
+	TicketID  int       `json:"ticket_id"`
+	AuthorID  *int      `json:"author_id,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TicketPhoto struct {
+	ID          int       `json:"id"`
+	TicketID    int       `json:"ticket_id"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func canTransition(from, to string) bool {
+	for _, s := range ticketTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ============ АВТОМАТИЧЕСКИЕ ЗАЯВКИ ============
+
+type sensorRange struct {
+	Type string
+	Min  sql.NullFloat64
+	Max  sql.NullFloat64
+}
+
+type autoTickets struct {
+	mu       sync.Mutex
+	lastTry  map[string]time.Time
+	ranges   map[int][]sensorRange
+	rangesAt time.Time
+}
+
+var ticketAutomation = &autoTickets{lastTry: map[string]time.Time{}, ranges: map[int][]sensorRange{}}
+
+// loadRangesLocked читает допустимые диапазоны датчиков устройства:
+// device → controller → variables → in_data → sensor.
+func (a *autoTickets) loadRangesLocked() {
+	rows, err := psqlConn.Query(`SELECT c.device_id, s.type, s.min_value, s.max_value
+		FROM sensor s
+		JOIN in_data i ON i.id = s.in_data_id
+		JOIN variables v ON v.id = i.variables_id
+		JOIN controller c ON c.id = v.controller_id`)
+	if err != nil {
+		logger("tickets").Error("Ошибка загрузки диапазонов датчиков", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	ranges := map[int][]sensorRange{}
+	for rows.Next() {
+		var deviceID int
+		var r sensorRange
+		if rows.Scan(&deviceID, &r.Type, &r.Min, &r.Max) == nil {
+			ranges[deviceID] = append(ranges[deviceID], r)
+		}
+	}
+	a.ranges, a.rangesAt = ranges, time.Now()
+}
+
+// throttled не даёт дёргать БД на каждом сообщении неисправного датчика.
+func (a *autoTickets) throttled(deviceID int, source string) bool {
+	key := fmt.Sprintf("%d/%s", deviceID, source)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Since(a.lastTry[key]) < autoTicketThrottle {
+		return true
+	}
+	a.lastTry[key] = time.Now()
+	return false
+}
+
+// checkRange — обработчик показаний датчиков (см. readingHandlers).
+func (a *autoTickets) checkRange(reading SensorReading) {
+	if !reading.HasValue {
+		return
+	}
+	info, ok := devices.resolve(reading.Topic)
+	if !ok {
+		return
+	}
+	_, sensorType := livenessKey(reading.Topic)
+
+	a.mu.Lock()
+	if time.Since(a.rangesAt) > sensorRangesRefresh {
+		a.loadRangesLocked()
+	}
+	ranges := a.ranges[info.ID]
+	a.mu.Unlock()
+
+	for _, r := range ranges {
+		if r.Type != sensorType {
+			continue
+		}
+		below := r.Min.Valid && reading.Value < r.Min.Float64
+		above := r.Max.Valid && reading.Value > r.Max.Float64
+		if !below && !above {
+			continue
+		}
+		if a.throttled(info.ID, ticketSourceOutOfRange) {
+			return
+		}
+		openAutoTicket(info, ticketSourceOutOfRange, "high",
+			fmt.Sprintf("%s: показание вне диапазона", info.Name),
+			fmt.Sprintf("%s = %.2f, допустимо %v..%v", reading.SensorID, reading.Value, nullFloat(r.Min), nullFloat(r.Max)))
+		return
+	}
+}
+
+func nullFloat(v sql.NullFloat64) interface{} {
+	if v.Valid {
+		return v.Float64
+	}
+	return "—"
+}
+
+// onDeviceOffline вызывается трекером доступности при переходе в offline.
+func (a *autoTickets) onDeviceOffline(entry DeviceAvailability) {
+	if entry.DeviceID == 0 || a.throttled(entry.DeviceID, ticketSourceOffline) {
+		return
+	}
+	info, ok := devices.get(entry.DeviceID)
+	if !ok {
+		return
+	}
+	openAutoTicket(info, ticketSourceOffline, "normal",
+		fmt.Sprintf("%s: нет связи", info.Name),
+		fmt.Sprintf("Источник %s молчит с %s", entry.Key, entry.LastSeen.Format(time.RFC3339)))
+}
+
+func openAutoTicket(info DeviceInfo, source, priority, title, description string) {
+	var id int
+	err := psqlConn.QueryRow(`INSERT INTO maintenance_tickets
+		(device_id, building_id, title, description, source, status, priority, sla_due_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (device_id, source) WHERE status <> 'resolved' AND source <> 'manual' DO NOTHING
+		RETURNING id`,
+		info.ID, info.BuildingID, title, description, source, ticketOpen, priority,
+		time.Now().Add(slaByPriority[priority]),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return // уже есть незакрытая заявка
+	}
+	if err != nil {
+		logger("tickets").Error("Ошибка автоматического открытия заявки", "source", source, "device_id", info.ID, "error", err)
+		return
+	}
+
+	logger("tickets").Info("Открыта заявка", "ticket_id", id, "title", title)
+	hub.publish(StreamEvent{Type: "ticket", BuildingID: info.BuildingID, RoomID: info.RoomID, DeviceID: info.ID,
+		Data: map[string]interface{}{"ticket_id": id, "status": ticketOpen, "source": source, "title": title}})
+}
+
+// startSLAMonitor раз в минуту помечает просроченные заявки.
+func startSLAMonitor(ctx context.Context) {
+	ticker := time.NewTicker(slaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkSLA()
+		}
+	}
+}
+
+func checkSLA() {
+	rows, err := psqlConn.Query(`UPDATE maintenance_tickets SET sla_breached = TRUE
+		WHERE status <> $1 AND NOT sla_breached AND sla_due_at < NOW()
+		RETURNING id, COALESCE(building_id, 0), title`, ticketResolved)
+	if err != nil {
+		logger("tickets").Error("Ошибка проверки SLA", "error", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, buildingID int
+		var title string
+		if rows.Scan(&id, &buildingID, &title) != nil {
+			continue
+		}
+		logger("tickets").Warn("Просрочен SLA заявки", "ticket_id", id, "title", title)
+		hub.publish(StreamEvent{Type: "ticket", BuildingID: buildingID,
+			Data: map[string]interface{}{"ticket_id": id, "sla_breached": true, "title": title}})
+	}
+}
+
+// ============ ХРАНЕНИЕ ============
+
+const ticketColumns = `id, device_id, building_id, title, COALESCE(description, ''), source, status, priority,
+	worker_id, created_by, created_at, updated_at, sla_due_at, sla_breached, resolved_at`
+
+func scanTicket(row interface{ Scan(...interface{}) error }) (Ticket, error) {
+	var t Ticket
+	var deviceID, buildingID, workerID, createdBy sql.NullInt64
+	var resolved sql.NullTime
+	err := row.Scan(&t.ID, &deviceID, &buildingID, &t.Title, &t.Description, &t.Source, &t.Status, &t.Priority,
+		&workerID, &createdBy, &t.CreatedAt, &t.UpdatedAt, &t.SLADueAt, &t.SLABreached, &resolved)
+	if err != nil {
+		return t, err
+	}
+	t.DeviceID = nullIntPtr(deviceID)
+	t.BuildingID = nullIntPtr(buildingID)
+	t.WorkerID = nullIntPtr(workerID)
+	t.CreatedBy = nullIntPtr(createdBy)
+	if resolved.Valid {
+		t.ResolvedAt = &resolved.Time
+	}
+	return t, nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	id := int(v.Int64)
+	return &id
+}
+
+func queryTickets(where string, args ...interface{}) ([]Ticket, error) {
+	rows, err := psqlConn.Query("SELECT "+ticketColumns+" FROM maintenance_tickets "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Ticket{}
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// ============ ДОСТУП К ЗАЯВКАМ ============
+
+// ticketActor — вошедший пользователь и его права на заявки. Администратор
+// видит все заявки; остальные — созданные ими, назначенные им как
+// работнику и заявки по зданиям, где у них есть устройства (как в потоке
+// событий, см. newStreamClient).
+type ticketActor struct {
+	userID   int
+	admin    bool
+	workerID int // 0, если пользователь не работник
+}
+
+// ticketActorFromRequest проверяет токен и загружает права. При ошибке
+// ответ уже отправлен.
+func ticketActorFromRequest(w http.ResponseWriter, r *http.Request) (ticketActor, bool) {
+	userID, _, ok := userFromRequest(r)
+	if !ok {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return ticketActor{}, false
+	}
+
+	a := ticketActor{userID: userID}
+	var role string
+	if err := psqlConn.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role); err != nil {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return ticketActor{}, false
+	}
+	a.admin = role == "admin"
+
+	workerID, err := workerForUser(userID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return ticketActor{}, false
+	}
+	a.workerID = workerID
+	return a, true
+}
+
+// visibleCond дописывает к args параметры и возвращает условие видимости
+// заявки для WHERE (колонки maintenance_tickets без псевдонима).
+func (a ticketActor) visibleCond(args []interface{}) (string, []interface{}) {
+	if a.admin {
+		return "TRUE", args
+	}
+	args = append(args, a.userID, a.workerID)
+	user, worker := len(args)-1, len(args)
+	return fmt.Sprintf(`(created_by = $%[1]d OR worker_id = $%[2]d OR building_id IN (
+		SELECT r.building_id FROM user_devices ud
+		JOIN device d ON d.id = ud.device_id
+		JOIN room r ON r.id = d.room_id
+		WHERE ud.user_id = $%[1]d))`, user, worker), args
+}
+
+func (a ticketActor) canSee(ticketID int) (bool, error) {
+	cond, args := a.visibleCond([]interface{}{ticketID})
+	var visible bool
+	err := psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM maintenance_tickets WHERE id = $1 AND "+cond+")", args...).Scan(&visible)
+	return visible, err
+}
+
+// canUseBuilding — видит ли пользователь заявки здания (та же область,
+// что в visibleCond).
+func (a ticketActor) canUseBuilding(buildingID int) (bool, error) {
+	if a.admin {
+		return true, nil
+	}
+	var ok bool
+	err := psqlConn.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_devices ud
+		JOIN device d ON d.id = ud.device_id
+		JOIN room r ON r.id = d.room_id
+		WHERE ud.user_id = $1 AND r.building_id = $2)`, a.userID, buildingID).Scan(&ok)
+	return ok, err
+}
+
+// canUseDevice — привязано ли устройство к пользователю.
+func (a ticketActor) canUseDevice(deviceID int) (bool, error) {
+	if a.admin {
+		return true, nil
+	}
+	var ok bool
+	err := psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM user_devices WHERE user_id = $1 AND device_id = $2)",
+		a.userID, deviceID).Scan(&ok)
+	return ok, err
+}
+
+// requireTicket отвечает 404, если заявки нет или она не видна
+// пользователю: о чужих заявках не сообщаем даже факт существования.
+func (a ticketActor) requireTicket(w http.ResponseWriter, ticketID int) bool {
+	visible, err := a.canSee(ticketID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !visible {
+		http.Error(w, "Заявка не найдена", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ============ REST API HANDLERS - TICKETS ============
+
+// getTickets — список заявок (?status=, ?building_id=, ?device_id=, ?worker_id=).
+func getTickets(w http.ResponseWriter, r *http.Request) {
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	cond, args := actor.visibleCond(nil)
+	conds := []string{cond}
+	for _, f := range []string{"status", "building_id", "device_id", "worker_id"} {
+		if v := q.Get(f); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("%s = $%d", f, len(args)))
+		}
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+
+	list, err := queryTickets(where+" ORDER BY created_at DESC LIMIT 500", args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// createTicket — ручная заявка. Устройство и здание из тела должны быть
+// доступны пользователю.
+func createTicket(w http.ResponseWriter, r *http.Request) {
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var t Ticket
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if t.Title == "" {
+		http.Error(w, "title обязателен", http.StatusBadRequest)
+		return
+	}
+	if t.Priority == "" {
+		t.Priority = "normal"
+	}
+	sla, ok := slaByPriority[t.Priority]
+	if !ok {
+		http.Error(w, "priority: low, normal, high или critical", http.StatusBadRequest)
+		return
+	}
+	if t.DeviceID != nil {
+		info, ok := devices.get(*t.DeviceID)
+		allowed, err := actor.canUseDevice(*t.DeviceID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok || !allowed {
+			http.Error(w, "Устройство не найдено", http.StatusBadRequest)
+			return
+		}
+		if info.BuildingID != 0 {
+			t.BuildingID = &info.BuildingID
+		}
+	}
+	if t.BuildingID != nil {
+		allowed, err := actor.canUseBuilding(*t.BuildingID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var exists bool
+		if allowed {
+			psqlConn.QueryRow("SELECT EXISTS (SELECT 1 FROM building WHERE id = $1)", *t.BuildingID).Scan(&exists)
+		}
+		if !exists {
+			http.Error(w, "Здание не найдено", http.StatusBadRequest)
+			return
+		}
+	}
+
+	t.Source, t.Status = ticketSourceManual, ticketOpen
+	t.CreatedBy = &actor.userID
+
+	row := psqlConn.QueryRow(`INSERT INTO maintenance_tickets
+		(device_id, building_id, title, description, source, status, priority, created_by, sla_due_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NOW() + $9 * INTERVAL '1 second')
+		RETURNING `+ticketColumns,
+		t.DeviceID, t.BuildingID, t.Title, t.Description, t.Source, t.Status, t.Priority, t.CreatedBy, sla.Seconds())
+	created, err := scanTicket(row)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getTicket — заявка с комментариями и фото (?id=).
+func getTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	cond, args := actor.visibleCond([]interface{}{id})
+	t, err := scanTicket(psqlConn.QueryRow("SELECT "+ticketColumns+" FROM maintenance_tickets WHERE id = $1 AND "+cond, args...))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Заявка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	t.Comments = []TicketComment{}
+	rows, err := psqlConn.Query("SELECT id, ticket_id, author_id, body, created_at FROM ticket_comments WHERE ticket_id = $1 ORDER BY created_at", id)
+	if err == nil {
+		for rows.Next() {
+			var c TicketComment
+			var author sql.NullInt64
+			if rows.Scan(&c.ID, &c.TicketID, &author, &c.Body, &c.CreatedAt) == nil {
+				c.AuthorID = nullIntPtr(author)
+				t.Comments = append(t.Comments, c)
+			}
+		}
+		rows.Close()
+	}
+
+	t.Photos = []TicketPhoto{}
+	rows, err = psqlConn.Query("SELECT id, ticket_id, content_type, size, created_at FROM ticket_photos WHERE ticket_id = $1 ORDER BY created_at", id)
+	if err == nil {
+		for rows.Next() {
+			var p TicketPhoto
+			if rows.Scan(&p.ID, &p.TicketID, &p.ContentType, &p.Size, &p.CreatedAt) == nil {
+				p.URL = fmt.Sprintf("/api/tickets/photo?id=%d", p.ID)
+				t.Photos = append(t.Photos, p)
+			}
+		}
+		rows.Close()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// assignTicket назначает заявку работнику (только администратор).
+func assignTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !actor.admin {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		TicketID int `json:"ticket_id"`
+		WorkerID int `json:"worker_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TicketID == 0 || req.WorkerID == 0 {
+		http.Error(w, "ticket_id и worker_id обязательны", http.StatusBadRequest)
+		return
+	}
+
+	var active bool
+	if err := psqlConn.QueryRow("SELECT active FROM workers WHERE id = $1", req.WorkerID).Scan(&active); err != nil || !active {
+		http.Error(w, "Работник не найден или неактивен", http.StatusBadRequest)
+		return
+	}
+
+	res, err := psqlConn.Exec(`UPDATE maintenance_tickets SET worker_id = $1, status = $2, updated_at = NOW()
+		WHERE id = $3 AND status IN ($4, $2)`,
+		req.WorkerID, ticketAssigned, req.TicketID, ticketOpen)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Заявка не найдена или уже в работе", http.StatusConflict)
+		return
+	}
+	auditRequest(r, "ticket.assign", "ticket", req.TicketID, nil, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": ticketAssigned})
+}
+
+// changeTicketStatus переводит заявку в новое состояние по ticketTransitions.
+// Менять статус могут администратор и назначенный на заявку работник.
+// Повторно открытая заявка получает новый срок SLA.
+func changeTicketStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TicketID int    `json:"ticket_id"`
+		Status   string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TicketID == 0 {
+		http.Error(w, "ticket_id и status обязательны", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var current, priority string
+	var workerID sql.NullInt64
+	err = tx.QueryRow("SELECT status, priority, worker_id FROM maintenance_tickets WHERE id = $1 FOR UPDATE", req.TicketID).Scan(&current, &priority, &workerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Заявка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !actor.admin && (actor.workerID == 0 || !workerID.Valid || int(workerID.Int64) != actor.workerID) {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return
+	}
+	if !canTransition(current, req.Status) {
+		http.Error(w, fmt.Sprintf("Переход %s → %s невозможен", current, req.Status), http.StatusConflict)
+		return
+	}
+	if (req.Status == ticketAssigned || req.Status == ticketInProgress) && !workerID.Valid {
+		http.Error(w, "Сначала назначьте работника", http.StatusConflict)
+		return
+	}
+
+	reopened := current == ticketResolved && req.Status == ticketOpen
+	sla, ok := slaByPriority[priority]
+	if !ok {
+		sla = slaByPriority["normal"]
+	}
+	_, err = tx.Exec(`UPDATE maintenance_tickets SET status = $1, updated_at = NOW(),
+		resolved_at = CASE WHEN $1 = 'resolved' THEN NOW() END,
+		worker_id = CASE WHEN $1 = 'open' THEN NULL ELSE worker_id END,
+		sla_due_at = CASE WHEN $3 THEN NOW() + $4 * INTERVAL '1 second' ELSE sla_due_at END,
+		sla_breached = CASE WHEN $3 THEN FALSE ELSE sla_breached END
+		WHERE id = $2`, req.Status, req.TicketID, reopened, sla.Seconds())
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"previous": current, "status": req.Status})
+}
+
+func addTicketComment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var c TicketComment
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil || c.TicketID == 0 || strings.TrimSpace(c.Body) == "" {
+		http.Error(w, "ticket_id и body обязательны", http.StatusBadRequest)
+		return
+	}
+	if !actor.requireTicket(w, c.TicketID) {
+		return
+	}
+	c.AuthorID = &actor.userID
+
+	err := psqlConn.QueryRow(
+		"INSERT INTO ticket_comments (ticket_id, author_id, body) VALUES ($1, $2, $3) RETURNING id, created_at",
+		c.TicketID, c.AuthorID, c.Body,
+	).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	psqlConn.Exec("UPDATE maintenance_tickets SET updated_at = NOW() WHERE id = $1", c.TicketID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// uploadTicketPhoto принимает multipart-поле "photo" (?ticket_id=). Тип
+// определяется по содержимому, а не по заголовку клиента: допускаются
+// только PNG, JPEG и WebP.
+func uploadTicketPhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ticketID, err := strconv.Atoi(r.URL.Query().Get("ticket_id"))
+	if err != nil {
+		http.Error(w, "ticket_id parameter required", http.StatusBadRequest)
+		return
+	}
+	if !actor.requireTicket(w, ticketID) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoSize+1<<20)
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		http.Error(w, "Ожидается multipart-поле photo (до 10 МБ)", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		http.Error(w, "Пустой или повреждённый файл", http.StatusBadRequest)
+		return
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	ext, ok := photoTypes[contentType]
+	if !ok {
+		http.Error(w, "Допускаются только изображения PNG, JPEG и WebP", http.StatusBadRequest)
+		return
+	}
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := fmt.Sprintf("tickets/%d/%s%s", ticketID, hex.EncodeToString(suffix), ext)
+
+	size, err := blobs.Put(r.Context(), key, io.LimitReader(io.MultiReader(bytes.NewReader(head), file), maxPhotoSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка сохранения файла: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	p := TicketPhoto{TicketID: ticketID, ContentType: contentType, Size: size}
+	err = psqlConn.QueryRow(`INSERT INTO ticket_photos (ticket_id, blob_key, content_type, size, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		ticketID, key, contentType, size, actor.userID).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		blobs.Delete(r.Context(), key)
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	p.URL = fmt.Sprintf("/api/tickets/photo?id=%d", p.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// getTicketPhoto отдаёт фото заявки (?id=). Токен принимается и в ?token=:
+// браузер не передаёт заголовок Authorization для <img src>.
+func getTicketPhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	photoID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id parameter required", http.StatusBadRequest)
+		return
+	}
+	var ticketID int
+	var key, contentType string
+	err = psqlConn.QueryRow("SELECT ticket_id, blob_key, content_type FROM ticket_photos WHERE id = $1",
+		photoID).Scan(&ticketID, &key, &contentType)
+	if err != nil {
+		http.Error(w, "Фото не найдено", http.StatusNotFound)
+		return
+	}
+	visible, err := actor.canSee(ticketID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		http.Error(w, "Фото не найдено", http.StatusNotFound)
+		return
+	}
+
+	blob, err := blobs.Get(r.Context(), key)
+	if err == errBlobNotFound {
+		http.Error(w, "Фото не найдено", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка чтения файла: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	// Фото, загруженные до проверки содержимого, могут иметь любой тип:
+	// такие отдаём как вложение, чтобы браузер их не исполнял.
+	disposition := "inline"
+	ext, ok := photoTypes[contentType]
+	if !ok {
+		contentType, ext, disposition = "application/octet-stream", "", "attachment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="photo-%d%s"`, disposition, photoID, ext))
+	io.Copy(w, blob)
+}
+
+// getMyTickets — очередь вошедшего работника: назначенные ему незакрытые
+// заявки, ближайший срок SLA первым.
+func getMyTickets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, ok := userFromRequest(r)
+	if !ok {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	workerID, err := workerForUser(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"Пользователь не является работником"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	list, err := queryTickets("WHERE worker_id = $1 AND status <> $2 ORDER BY sla_due_at", workerID, ticketResolved)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
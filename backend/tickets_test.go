@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============ ЗАЯВКИ ============
+
+func TestTicketsRequireAuth(t *testing.T) {
+	for _, c := range []struct {
+		handler      http.HandlerFunc
+		method, path string
+	}{
+		{getTickets, http.MethodGet, "/api/tickets"},
+		{createTicket, http.MethodPost, "/api/tickets"},
+		{assignTicket, http.MethodPost, "/api/tickets/assign"},
+		{getTicket, http.MethodGet, "/api/tickets/detail?id=1"},
+		{changeTicketStatus, http.MethodPost, "/api/tickets/status"},
+		{addTicketComment, http.MethodPost, "/api/tickets/comments"},
+		{uploadTicketPhoto, http.MethodPost, "/api/tickets/photos?ticket_id=1"},
+		{getTicketPhoto, http.MethodGet, "/api/tickets/photo?id=1"},
+	} {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("X-User-Role", "admin")
+		w := httptest.NewRecorder()
+		c.handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s без токена: %d, ожидалось 401", c.method, c.path, w.Code)
+		}
+	}
+}
+
+// ticketRequest вызывает обработчик заявок от имени пользователя.
+func ticketRequest(t *testing.T, handler http.HandlerFunc, method, path string, userID int, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	token, err := generateToken(userID, fmt.Sprint("user", userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// createTicketUser заводит пользователя с ролью role.
+func createTicketUser(t *testing.T, name, role string) int {
+	t.Helper()
+	var id int
+	if err := psqlConn.QueryRow(`INSERT INTO users (username, email, password, role)
+		VALUES ($1, $1 || '@example.com', 'x', $2) RETURNING id`, name, role).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestTicketStatusAccessAndReopen(t *testing.T) {
+	testDB(t)
+	user := func(name, role string) int { return createTicketUser(t, name, role) }
+	worker := func(userID int) int {
+		var id int
+		if err := psqlConn.QueryRow(`INSERT INTO workers (full_name, email, hired_at, user_id, active)
+			SELECT username, email, CURRENT_DATE, id, TRUE FROM users WHERE id = $1 RETURNING id`, userID).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	admin, stranger := user("admin", "admin"), user("stranger", "user")
+	assignedUser, otherUser := user("assigned", "worker"), user("other", "worker")
+	assigned := worker(assignedUser)
+	worker(otherUser)
+
+	// Решённая заявка с давно истёкшим SLA.
+	var ticketID int
+	if err := psqlConn.QueryRow(`INSERT INTO maintenance_tickets
+		(title, source, status, priority, worker_id, sla_due_at, sla_breached, resolved_at)
+		VALUES ('Течь', 'manual', 'resolved', 'high', $1, NOW() - INTERVAL '3 days', TRUE, NOW())
+		RETURNING id`, assigned).Scan(&ticketID); err != nil {
+		t.Fatal(err)
+	}
+	reopen := map[string]interface{}{"ticket_id": ticketID, "status": ticketOpen}
+
+	if w := ticketRequest(t, getTicket, http.MethodGet, fmt.Sprintf("/api/tickets/detail?id=%d", ticketID), stranger, nil); w.Code != http.StatusNotFound {
+		t.Errorf("чужая заявка: %d, ожидалось 404", w.Code)
+	}
+	for name, userID := range map[string]int{"посторонний": stranger, "другой работник": otherUser} {
+		if w := ticketRequest(t, changeTicketStatus, http.MethodPost, "/api/tickets/status", userID, reopen); w.Code != http.StatusForbidden {
+			t.Errorf("%s меняет статус: %d, ожидалось 403", name, w.Code)
+		}
+	}
+	if w := ticketRequest(t, getTicket, http.MethodGet, fmt.Sprintf("/api/tickets/detail?id=%d", ticketID), assignedUser, nil); w.Code != http.StatusOK {
+		t.Errorf("назначенный работник не видит заявку: %d", w.Code)
+	}
+	if w := ticketRequest(t, changeTicketStatus, http.MethodPost, "/api/tickets/status", admin, reopen); w.Code != http.StatusOK {
+		t.Fatalf("повторное открытие: %d %s", w.Code, w.Body)
+	}
+
+	var due time.Time
+	var breached bool
+	psqlConn.QueryRow("SELECT sla_due_at, sla_breached FROM maintenance_tickets WHERE id = $1", ticketID).Scan(&due, &breached)
+	if breached || time.Until(due) < 23*time.Hour {
+		t.Errorf("SLA после повторного открытия: срок %s, просрочен %v", due, breached)
+	}
+}
+
+func TestCreateTicketChecksBuilding(t *testing.T) {
+	testDB(t)
+	admin, stranger := createTicketUser(t, "admin", "admin"), createTicketUser(t, "stranger", "user")
+	var buildingID int
+	if err := psqlConn.QueryRow("INSERT INTO building (name) VALUES ('Офис') RETURNING id").Scan(&buildingID); err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{"title": "Не работает свет", "building_id": buildingID}
+	if w := ticketRequest(t, createTicket, http.MethodPost, "/api/tickets", stranger, body); w.Code != http.StatusBadRequest {
+		t.Errorf("заявка по чужому зданию: %d, ожидалось 400", w.Code)
+	}
+	if w := ticketRequest(t, createTicket, http.MethodPost, "/api/tickets", admin, body); w.Code != http.StatusCreated {
+		t.Errorf("заявка администратора: %d %s", w.Code, w.Body)
+	}
+	body["building_id"] = buildingID + 1000
+	if w := ticketRequest(t, createTicket, http.MethodPost, "/api/tickets", admin, body); w.Code != http.StatusBadRequest {
+		t.Errorf("несуществующее здание: %d, ожидалось 400", w.Code)
+	}
+}
+
+func TestTicketPhotoContentType(t *testing.T) {
+	testDB(t)
+	saved := blobs
+	blobs = &FSBlobStore{Root: t.TempDir()}
+	t.Cleanup(func() { blobs = saved })
+
+	admin := createTicketUser(t, "admin", "admin")
+	token, err := generateToken(admin, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ticketID int
+	if err := psqlConn.QueryRow(`INSERT INTO maintenance_tickets (title, source, status, priority, sla_due_at)
+		VALUES ('Течь', 'manual', 'open', 'normal', NOW() + INTERVAL '1 day') RETURNING id`).Scan(&ticketID); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(declared string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {`form-data; name="photo"; filename="photo.png"`},
+			"Content-Type":        {declared},
+		})
+		part.Write(data)
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/tickets/photos?ticket_id=%d", ticketID), &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		uploadTicketPhoto(w, req)
+		return w
+	}
+
+	// HTML, выданный клиентом за картинку, не принимается.
+	if w := upload("image/png", []byte("<html><script>alert(1)</script></html>")); w.Code != http.StatusBadRequest {
+		t.Errorf("HTML под видом PNG: %d, ожидалось 400", w.Code)
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+	w := upload("application/octet-stream", png)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("загрузка PNG: %d %s", w.Code, w.Body)
+	}
+	var photo TicketPhoto
+	json.NewDecoder(w.Body).Decode(&photo)
+	if photo.ContentType != "image/png" {
+		t.Errorf("тип фото %q, ожидался image/png", photo.ContentType)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, photo.URL+"&token="+token, nil)
+	w = httptest.NewRecorder()
+	getTicketPhoto(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Content-Type-Options") != "nosniff" ||
+		!strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline;") {
+		t.Errorf("отдача фото: %d, заголовки %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ============ ТРАССИРОВКА ============
+//
+// OpenTelemetry, по умолчанию выключена. Включается, если задан
+// OTEL_EXPORTER_OTLP_ENDPOINT (или OTEL_EXPORTER_OTLP_TRACES_ENDPOINT), и
+// не задан OTEL_SDK_DISABLED=true; спаны уходят по OTLP/HTTP. Остальное —
+// стандартными переменными OTel: OTEL_SERVICE_NAME, OTEL_TRACES_SAMPLER,
+// OTEL_EXPORTER_OTLP_HEADERS и т.д.
+//
+// Спаны: HTTP-запрос (instrumentMiddleware, с учётом входящего traceparent),
+// обработка сообщения MQTT, запросы к PostgreSQL внутри них (otelsql,
+// только с контекстом запроса), запросы к InfluxDB (internal/store/influx)
+// и публикация команд в MQTT. Пока трассировка выключена, tracer — no-op.
+
+const defaultServiceName = "smart-home-backend"
+
+var tracer = otel.Tracer("smart-home")
+
+// tracerProvider — nil, если трассировка выключена.
+var tracerProvider *sdktrace.TracerProvider
+
+func tracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+func initTracing(ctx context.Context) error {
+	if !tracingEnabled() {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("экспортёр OTLP: %w", err)
+	}
+	// OTEL_SERVICE_NAME и OTEL_RESOURCE_ATTRIBUTES переопределяют имя по умолчанию.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return fmt.Errorf("ресурс OTel: %w", err)
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logger("tracing").Info("Трассировка включена")
+	return nil
+}
+
+// shutdownTracing отправляет накопленные спаны (шаг остановки).
+func shutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// hasSpan — в контексте есть активный спан (запросы к БД вне HTTP-запроса
+// или сообщения не трассируются, иначе каждый фоновый запрос стал бы
+// отдельной трассой).
+func hasSpan(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// endSpan завершает спан, отмечая ошибку.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============ РАБОТНИКИ ============
+//
+// Запись в workers — карточка сотрудника сервиса. Если у сотрудника есть
+// учётная запись с ролью worker, карточка связана с ней через user_id.
+// Смена роли в changeUserRole поддерживает связь: назначение роли worker
+// создаёт или активирует карточку, снятие — деактивирует её и возвращает
+// незакрытые заявки работника в очередь. Работник обслуживает здания из
+// worker_buildings; его рабочее время и недоступность — в worker_shifts.
+
+const (
+	shiftWork        = "shift"
+	shiftUnavailable = "unavailable"
+)
+
+type Worker struct {
+	ID        int     `json:"id"`
+	FullName  string  `json:"full_name"`
+	Position  string  `json:"position,omitempty"`
+	Phone     string  `json:"phone,omitempty"`
+	Email     string  `json:"email,omitempty"`
+	HiredAt   *string `json:"hired_at,omitempty"` // YYYY-MM-DD
+	UserID    *int    `json:"user_id,omitempty"`
+	Active    bool    `json:"active"`
+	Buildings []int   `json:"buildings"`
+}
+
+type WorkerShift struct {
+	ID       int       `json:"id"`
+	WorkerID int       `json:"worker_id"`
+	Kind     string    `json:"kind"` // shift | unavailable
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Note     string    `json:"note,omitempty"`
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-User-Role") != "admin" {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireStaff пропускает администраторов и работников (роль берётся из
+// БД): карточки и смены содержат телефоны и адреса сотрудников.
+func requireStaff(w http.ResponseWriter, r *http.Request) bool {
+	actor, ok := ticketActorFromRequest(w, r)
+	if !ok {
+		return false
+	}
+	if !actor.admin && actor.workerID == 0 {
+		http.Error(w, `{"message":"Access denied"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// syncWorkerRecord приводит карточку работника в соответствие с ролью
+// пользователя. Вызывается в транзакции смены роли.
+func syncWorkerRecord(tx *sql.Tx, userID int, role string) error {
+	if role != "worker" {
+		var workerID int
+		err := tx.QueryRow("UPDATE workers SET active = FALSE WHERE user_id = $1 RETURNING id", userID).Scan(&workerID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE maintenance_tickets SET worker_id = NULL, status = $1, updated_at = NOW()
+			WHERE worker_id = $2 AND status <> $3`, ticketOpen, workerID, ticketResolved)
+		return err
+	}
+
+	// Сначала ищем уже связанную карточку, затем свободную с тем же email.
+	res, err := tx.Exec(`UPDATE workers SET user_id = $1, active = TRUE
+		WHERE id = (
+			SELECT w.id FROM workers w, users u
+			WHERE u.id = $1 AND (w.user_id = u.id OR (w.user_id IS NULL AND w.email = u.email))
+			ORDER BY w.user_id NULLS LAST
+			LIMIT 1
+		)`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO workers (full_name, email, hired_at, user_id, active)
+		SELECT username, email, CURRENT_DATE, id, TRUE FROM users WHERE id = $1`, userID)
+	return err
+}
+
+// workerForUser находит активную карточку работника вошедшего пользователя.
+func workerForUser(userID int) (int, error) {
+	var workerID int
+	err := psqlConn.QueryRow("SELECT id FROM workers WHERE user_id = $1 AND active", userID).Scan(&workerID)
+	return workerID, err
+}
+
+func loadWorkerBuildings() (map[int][]int, error) {
+	rows, err := psqlConn.Query("SELECT worker_id, building_id FROM worker_buildings ORDER BY building_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int][]int{}
+	for rows.Next() {
+		var workerID, buildingID int
+		if rows.Scan(&workerID, &buildingID) == nil {
+			result[workerID] = append(result[workerID], buildingID)
+		}
+	}
+	return result, rows.Err()
+}
+
+// ============ REST API HANDLERS - WORKERS ============
+
+// getWorkers — список работников (?building_id=, ?active=true).
+func getWorkers(w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+
+	query := `SELECT id, full_name, COALESCE("position", ''), COALESCE(phone, ''), COALESCE(email, ''),
+		TO_CHAR(hired_at, 'YYYY-MM-DD'), user_id, active FROM workers WHERE TRUE`
+	var args []interface{}
+	if b := r.URL.Query().Get("building_id"); b != "" {
+		args = append(args, b)
+		query += fmt.Sprintf(" AND id IN (SELECT worker_id FROM worker_buildings WHERE building_id = $%d)", len(args))
+	}
+	if r.URL.Query().Get("active") == "true" {
+		query += " AND active"
+	}
+
+	rows, err := psqlConn.Query(query+" ORDER BY full_name", args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	buildings, err := loadWorkerBuildings()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	workers := []Worker{}
+	for rows.Next() {
+		var wk Worker
+		var hired sql.NullString
+		var userID sql.NullInt64
+		if err := rows.Scan(&wk.ID, &wk.FullName, &wk.Position, &wk.Phone, &wk.Email, &hired, &userID, &wk.Active); err != nil {
+			continue
+		}
+		if hired.Valid {
+			wk.HiredAt = &hired.String
+		}
+		wk.UserID = nullIntPtr(userID)
+		wk.Buildings = buildings[wk.ID]
+		if wk.Buildings == nil {
+			wk.Buildings = []int{}
+		}
+		workers = append(workers, wk)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workers)
+}
+
+func createWorker(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var wk Worker
+	if err := json.NewDecoder(r.Body).Decode(&wk); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if wk.FullName == "" {
+		http.Error(w, "full_name обязателен", http.StatusBadRequest)
+		return
+	}
+
+	if wk.UserID != nil {
+		var role string
+		if err := psqlConn.QueryRow("SELECT role FROM users WHERE id = $1", *wk.UserID).Scan(&role); err != nil {
+			http.Error(w, "Пользователь не найден", http.StatusBadRequest)
+			return
+		}
+		if role != "worker" {
+			http.Error(w, "Связать можно только пользователя с ролью worker", http.StatusBadRequest)
+			return
+		}
+	}
+
+	wk.Active = true
+	err := psqlConn.QueryRow(`INSERT INTO workers (full_name, "position", phone, email, hired_at, user_id, active)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6, TRUE) RETURNING id`,
+		wk.FullName, wk.Position, wk.Phone, wk.Email, wk.HiredAt, wk.UserID,
+	).Scan(&wk.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	wk.Buildings = []int{}
+	auditRequest(r, "worker.create", "worker", wk.ID, nil, wk)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(wk)
+}
+
+func updateWorker(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var wk Worker
+	if err := json.NewDecoder(r.Body).Decode(&wk); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if wk.FullName == "" {
+		http.Error(w, "full_name обязателен", http.StatusBadRequest)
+		return
+	}
+
+	// Связь с учётной записью и активность меняются только через роль пользователя.
+	res, err := psqlConn.Exec(`UPDATE workers SET full_name = $1, "position" = NULLIF($2, ''),
+		phone = NULLIF($3, ''), email = NULLIF($4, ''), hired_at = $5 WHERE id = $6`,
+		wk.FullName, wk.Position, wk.Phone, wk.Email, wk.HiredAt, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Работник не найден", http.StatusNotFound)
+		return
+	}
+	auditRequest(r, "worker.update", "worker", id, nil, wk)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func deleteWorker(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var linked bool
+	psqlConn.QueryRow("SELECT user_id IS NOT NULL AND active FROM workers WHERE id = $1", id).Scan(&linked)
+	if linked {
+		http.Error(w, "Работник связан с учётной записью — сначала смените роль пользователя", http.StatusConflict)
+		return
+	}
+
+	var fullName string
+	err := psqlConn.QueryRow("DELETE FROM workers WHERE id = $1 RETURNING full_name", id).Scan(&fullName)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Работник не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditRequest(r, "worker.delete", "worker", id, map[string]string{"full_name": fullName}, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setWorkerBuildings заменяет список зданий работника.
+func setWorkerBuildings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		WorkerID  int   `json:"worker_id"`
+		Buildings []int `json:"buildings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WorkerID == 0 {
+		http.Error(w, "worker_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := psqlConn.Begin()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM worker_buildings WHERE worker_id = $1", req.WorkerID); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, b := range req.Buildings {
+		if _, err := tx.Exec("INSERT INTO worker_buildings (worker_id, building_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", req.WorkerID, b); err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditRequest(r, "worker.buildings", "worker", req.WorkerID, nil, req.Buildings)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// getWorkerShifts — календарь работника (?worker_id=, ?from=, ?to= в RFC3339,
+// по умолчанию ближайшие 14 дней).
+func getWorkerShifts(w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+
+	workerID := r.URL.Query().Get("worker_id")
+	if workerID == "" {
+		http.Error(w, "worker_id parameter required", http.StatusBadRequest)
+		return
+	}
+
+	from, to := time.Now(), time.Now().Add(14*24*time.Hour)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "from: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "to: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+
+	rows, err := psqlConn.Query(`SELECT id, worker_id, kind, starts_at, ends_at, COALESCE(note, '')
+		FROM worker_shifts WHERE worker_id = $1 AND ends_at > $2 AND starts_at < $3 ORDER BY starts_at`,
+		workerID, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	shifts := []WorkerShift{}
+	for rows.Next() {
+		var s WorkerShift
+		if rows.Scan(&s.ID, &s.WorkerID, &s.Kind, &s.StartsAt, &s.EndsAt, &s.Note) == nil {
+			shifts = append(shifts, s)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shifts)
+}
+
+func createWorkerShift(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var s WorkerShift
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		return
+	}
+	if s.Kind == "" {
+		s.Kind = shiftWork
+	}
+	if s.Kind != shiftWork && s.Kind != shiftUnavailable {
+		http.Error(w, "kind: shift или unavailable", http.StatusBadRequest)
+		return
+	}
+	if s.WorkerID == 0 || !s.EndsAt.After(s.StartsAt) {
+		http.Error(w, "worker_id обязателен, ends_at должен быть позже starts_at", http.StatusBadRequest)
+		return
+	}
+
+	// Смены одного работника не пересекаются; недоступность может
+	// накладываться на смену и перекрывает её.
+	if s.Kind == shiftWork {
+		var overlap bool
+		psqlConn.QueryRow(`SELECT EXISTS (SELECT 1 FROM worker_shifts
+			WHERE worker_id = $1 AND kind = $2 AND ends_at > $3 AND starts_at < $4)`,
+			s.WorkerID, shiftWork, s.StartsAt, s.EndsAt).Scan(&overlap)
+		if overlap {
+			http.Error(w, "Смена пересекается с существующей", http.StatusConflict)
+			return
+		}
+	}
+
+	err := psqlConn.QueryRow(`INSERT INTO worker_shifts (worker_id, kind, starts_at, ends_at, note)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING id`,
+		s.WorkerID, s.Kind, s.StartsAt, s.EndsAt, s.Note).Scan(&s.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusBadRequest)
+		return
+	}
+	auditRequest(r, "worker_shift.create", "worker_shift", s.ID, nil, s)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func deleteWorkerShift(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID не указан", http.StatusBadRequest)
+		return
+	}
+
+	var before WorkerShift
+	var note sql.NullString
+	err := psqlConn.QueryRow(`DELETE FROM worker_shifts WHERE id = $1
+		RETURNING id, worker_id, kind, starts_at, ends_at, note`, id).
+		Scan(&before.ID, &before.WorkerID, &before.Kind, &before.StartsAt, &before.EndsAt, &note)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Смена не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	before.Note = note.String
+	auditRequest(r, "worker_shift.delete", "worker_shift", id, before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getAvailableWorkers — кто из работников здания на смене в момент ?at=
+// (по умолчанию сейчас) и не отмечен недоступным.
+func getAvailableWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireStaff(w, r) {
+		return
+	}
+
+	buildingID, err := strconv.Atoi(r.URL.Query().Get("building_id"))
+	if err != nil {
+		http.Error(w, "building_id parameter required", http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "at: ожидается RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := psqlConn.Query(`SELECT w.id, w.full_name, COALESCE(w.phone, ''),
+			(SELECT COUNT(*) FROM maintenance_tickets t WHERE t.worker_id = w.id AND t.status <> $4)
+		FROM workers w
+		JOIN worker_buildings wb ON wb.worker_id = w.id AND wb.building_id = $1
+		WHERE w.active
+		  AND EXISTS (SELECT 1 FROM worker_shifts s WHERE s.worker_id = w.id AND s.kind = $2 AND $3 >= s.starts_at AND $3 < s.ends_at)
+		  AND NOT EXISTS (SELECT 1 FROM worker_shifts s WHERE s.worker_id = w.id AND s.kind = $5 AND $3 >= s.starts_at AND $3 < s.ends_at)
+		ORDER BY 4, w.full_name`,
+		buildingID, shiftWork, at, ticketResolved, shiftUnavailable)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка БД: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type availableWorker struct {
+		ID          int    `json:"id"`
+		FullName    string `json:"full_name"`
+		Phone       string `json:"phone,omitempty"`
+		OpenTickets int    `json:"open_tickets"`
+	}
+	result := []availableWorker{}
+	for rows.Next() {
+		var a availableWorker
+		if rows.Scan(&a.ID, &a.FullName, &a.Phone, &a.OpenTickets) == nil {
+			result = append(result, a)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============ РАБОТНИКИ ============
+
+// Карточки, смены и доступные работники содержат контакты сотрудников.
+var workerContactHandlers = []struct {
+	handler http.HandlerFunc
+	path    string
+}{
+	{getWorkers, "/api/workers"},
+	{getWorkerShifts, "/api/workers/shifts?worker_id=1"},
+	{getAvailableWorkers, "/api/workers/available?building_id=1"},
+}
+
+func TestWorkerContactsRequireAuth(t *testing.T) {
+	for _, c := range workerContactHandlers {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set("X-User-Role", "admin")
+		w := httptest.NewRecorder()
+		c.handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s без токена: %d, ожидалось 401", c.path, w.Code)
+		}
+	}
+}
+
+func TestWorkerContactsHiddenFromUsers(t *testing.T) {
+	testDB(t)
+	stranger := createTicketUser(t, "stranger", "user")
+	for _, c := range workerContactHandlers {
+		if w := ticketRequest(t, c.handler, http.MethodGet, c.path, stranger, nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s обычным пользователем: %d, ожидалось 403", c.path, w.Code)
+		}
+	}
+}
@@ -355,7 +355,8 @@ async function loadDashboard() {
         console.log('📊 Загрузка дашборда...');
         const response = await fetch(`${API_URL}/health`);
 
-        if (!response.ok) {
+        // 503 — сервис недоступен, но отчёт о зависимостях всё равно приходит
+        if (!response.ok && response.status !== 503) {
             throw new Error(`HTTP ${response.status}`);
         }
 